@@ -0,0 +1,312 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/stefpo/sedi/conv"
+)
+
+// Aggregate is an aggregate function computed over the rows of each group of a GroupBy.
+// Func is count, sum, avg, min or max. Column is "*" for count(*), the count of the rows.
+// Distinct only counts or sums each value once. As is the name of the result column,
+// by default the function followed by the column: "sum_amount", "count".
+type Aggregate struct {
+	Func     string
+	Column   string
+	Distinct bool
+	As       string
+}
+
+// ParseAggregate reads an aggregate written like in SQL: "count(*)", "sum(amount) as total",
+// "count(distinct customer)"
+func ParseAggregate(s string) (Aggregate, error) {
+	var a Aggregate
+	expr := strings.TrimSpace(s)
+	if i := strings.LastIndex(strings.ToLower(expr), " as "); i > 0 && !strings.Contains(expr[i:], ")") {
+		a.As = strings.Trim(strings.TrimSpace(expr[i+4:]), "[]`\"")
+		expr = strings.TrimSpace(expr[:i])
+	}
+	open := strings.Index(expr, "(")
+	if open < 0 || !strings.HasSuffix(expr, ")") {
+		return a, errors.New("ParseAggregate: invalid aggregate " + s)
+	}
+	a.Func = strings.ToLower(strings.TrimSpace(expr[:open]))
+	a.Column = strings.TrimSpace(expr[open+1 : len(expr)-1])
+	if words := strings.Fields(a.Column); len(words) == 2 && strings.EqualFold(words[0], "distinct") {
+		a.Distinct, a.Column = true, words[1]
+	}
+	a.Column = strings.Trim(a.Column, "[]`\"")
+	return a, a.check()
+}
+
+func (a Aggregate) check() error {
+	switch a.Func {
+	case "count", "sum", "avg", "min", "max":
+	default:
+		return errors.New("Aggregate: unknown function " + a.Func)
+	}
+	if a.Column == "" || a.Column == "*" && (a.Func != "count" || a.Distinct) {
+		return errors.New("Aggregate: invalid column for " + a.Func + "(" + a.Column + ")")
+	}
+	return nil
+}
+
+// name returns the name of the result column
+func (a Aggregate) name() string {
+	switch {
+	case a.As != "":
+		return a.As
+	case a.Column == "*":
+		return a.Func
+	}
+	return a.Func + "_" + a.Column
+}
+
+// aggregateState accumulates the values of a group for an aggregate
+type aggregateState struct {
+	count  int64
+	isum   int64
+	fsum   float64
+	float  bool // A value was not an integer or the integer sum overflowed: the sum is fsum + isum
+	min    interface{}
+	max    interface{}
+	values map[string]bool // For distinct
+}
+
+// add accumulates a value. numeric is set for sum and avg, whose values must be numbers
+// or strings holding a number.
+func (s *aggregateState) add(v interface{}, numeric bool) error {
+	if numeric {
+		switch x := v.(type) {
+		case uint, uint64:
+			if u := conv.ToUint64(v); u > math.MaxInt64 {
+				s.float = true
+				s.fsum += float64(u)
+			} else {
+				s.addInt(int64(u))
+			}
+		case int, int8, int16, int32, int64, uint8, uint16, uint32:
+			s.addInt(conv.ToInt64(v))
+		case float32, float64:
+			s.float = true
+			s.fsum += conv.ToFloat64(v)
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+				s.addInt(i)
+			} else if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+				s.float = true
+				s.fsum += f
+			} else {
+				return errors.New("not a number: " + x)
+			}
+		default:
+			return fmt.Errorf("not a number: %v", v)
+		}
+	}
+	s.count++
+	if s.min == nil {
+		s.min, s.max = v, v
+		return nil
+	}
+	if cmp, ok := compareValues(v, s.min); ok && cmp < 0 {
+		s.min = v
+	}
+	if cmp, ok := compareValues(v, s.max); ok && cmp > 0 {
+		s.max = v
+	}
+	return nil
+}
+
+// addInt adds an integer to isum, or to fsum when isum would overflow
+func (s *aggregateState) addInt(i int64) {
+	if r := s.isum + i; (i > 0 && r < s.isum) || (i < 0 && r > s.isum) {
+		s.float = true
+		s.fsum += float64(i)
+		return
+	}
+	s.isum += i
+}
+
+func (s *aggregateState) sum() float64 {
+	return s.fsum + float64(s.isum)
+}
+
+// aggregateGroup is a group of rows of a GroupBy
+type aggregateGroup struct {
+	row    *DataRow // First row of the group, for the key values
+	states []aggregateState
+}
+
+// GroupBy groups the rows of the table on the values of some columns and computes aggregates
+// over each group, see DataView.GroupBy
+func (dt *DataTable) GroupBy(keys []string, aggregates ...string) (DataTable, error) {
+	return dt.View().GroupBy(keys, aggregates...)
+}
+
+// GroupBy groups the rows of the view on the values of some columns and computes aggregates
+// over each group. It returns a table with the key columns and a column per aggregate, and a
+// row per group in the order the groups are first met. Without keys, it returns a single row.
+//
+//	totals, err := dt.GroupBy([]string{"country"}, "count(*)", "sum(amount) as total", "avg(amount)")
+//
+// NULLs are handled like in SQL: they make a group of their own, count(*) counts them but
+// the other aggregates ignore them, and sum, avg, min and max are NULL over no values.
+// sum and avg read the strings holding a number, and fail on the other values.
+func (dv *DataView) GroupBy(keys []string, aggregates ...string) (DataTable, error) {
+	aggs := make([]Aggregate, len(aggregates))
+	for i, s := range aggregates {
+		a, err := ParseAggregate(s)
+		if err != nil {
+			return DataTable{}, err
+		}
+		aggs[i] = a
+	}
+	return dv.Aggregate(keys, aggs...)
+}
+
+// Aggregate is GroupBy with the aggregates given as structures
+func (dv *DataView) Aggregate(keys []string, aggs ...Aggregate) (DataTable, error) {
+	var ret DataTable
+	if dv.err != nil {
+		return ret, dv.err
+	}
+	dt := dv.Table
+	kcols := make([]int, len(keys))
+	for i, k := range keys {
+		if kcols[i] = dt.ColumnIndex(k); kcols[i] < 0 {
+			return ret, errors.New("GroupBy: unknown column " + k)
+		}
+	}
+	acols := make([]int, len(aggs))
+	for i, a := range aggs {
+		if err := a.check(); err != nil {
+			return ret, err
+		}
+		acols[i] = -1
+		if a.Column != "*" {
+			if acols[i] = dt.ColumnIndex(a.Column); acols[i] < 0 {
+				return ret, errors.New("GroupBy: unknown column " + a.Column)
+			}
+		}
+	}
+
+	// Group the rows
+	groups := []*aggregateGroup{}
+	index := map[string]*aggregateGroup{}
+	newGroup := func(dr *DataRow) *aggregateGroup {
+		g := &aggregateGroup{row: dr, states: make([]aggregateState, len(aggs))}
+		groups = append(groups, g)
+		return g
+	}
+	if len(keys) == 0 {
+		newGroup(nil)
+	}
+	for _, r := range dv.rows {
+		dr := &dt.Rows[r]
+		var g *aggregateGroup
+		if len(keys) == 0 {
+			g = groups[0]
+		} else {
+			key := rowKey(dr, kcols)
+			if g = index[key]; g == nil {
+				g = newGroup(dr)
+				index[key] = g
+			}
+		}
+		for i, a := range aggs {
+			s := &g.states[i]
+			if acols[i] < 0 {
+				s.count++
+				continue
+			}
+			v := dr.items[acols[i]]
+			if v == nil {
+				continue
+			}
+			if a.Distinct {
+				if s.values == nil {
+					s.values = map[string]bool{}
+				}
+				if k := valueKey(v); s.values[k] {
+					continue
+				} else {
+					s.values[k] = true
+				}
+			}
+			if err := s.add(v, a.Func == "sum" || a.Func == "avg"); err != nil {
+				return DataTable{}, errors.New("GroupBy: " + a.Func + "(" + a.Column + "): " + err.Error())
+			}
+		}
+	}
+
+	// Make the result table
+	ret.Clear()
+	for _, c := range kcols {
+		ret.Columns = append(ret.Columns, dt.Columns[c])
+	}
+	for i, a := range aggs {
+		col := DataColumn{Name: a.name(), GoType: TypeFloat64}
+		switch a.Func {
+		case "count":
+			col.GoType = TypeInt64
+		case "min", "max":
+			col = dt.Columns[acols[i]]
+			col.Name = a.name()
+		case "sum":
+			if t := dt.Columns[acols[i]].GoType; (t == TypeInt64 || t == "") && !anyFloat(groups, i) {
+				col.GoType = TypeInt64
+			}
+		}
+		col.Nullable = a.Func != "count"
+		ret.Columns = append(ret.Columns, col)
+	}
+	ret.initColumns()
+	for _, g := range groups {
+		dr := ret.NewRow()
+		for i, c := range kcols {
+			dr.items[i] = g.row.items[c]
+		}
+		for i, a := range aggs {
+			s := &g.states[i]
+			var v interface{}
+			switch {
+			case a.Func == "count":
+				v = s.count
+			case s.count == 0:
+			case a.Func == "min":
+				v = s.min
+			case a.Func == "max":
+				v = s.max
+			case a.Func == "avg":
+				v = s.sum() / float64(s.count)
+			case ret.Columns[len(kcols)+i].GoType == TypeInt64:
+				v = s.isum
+			default:
+				v = s.sum()
+			}
+			dr.items[len(kcols)+i] = v
+		}
+		dr.state = RowUnchanged
+		ret.AddRow(dr)
+	}
+	return ret, nil
+}
+
+// anyFloat tells if an aggregate summed a value that is not an integer in any group
+func anyFloat(groups []*aggregateGroup, i int) bool {
+	for _, g := range groups {
+		if g.states[i].float {
+			return true
+		}
+	}
+	return false
+}
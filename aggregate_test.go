@@ -0,0 +1,204 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sales returns a table of sales, with NULL values and numbers written as text
+func sales() *DataTable {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "country", GoType: TypeString},
+		{Name: "customer", GoType: TypeString},
+		{Name: "amount", GoType: TypeFloat64},
+		{Name: "qty", GoType: TypeInt64},
+		{Name: "note", GoType: TypeString},
+	}}
+	rows := [][]interface{}{
+		{"FR", "a", 10.5, int64(1), "3"},
+		{"FR", "b", 4.5, int64(2), nil},
+		{"US", "a", nil, int64(3), "x"},
+		{nil, "c", 5.0, nil, " 2.5 "},
+		{"FR", "a", 10.5, int64(4), nil},
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+// sameValues compares the values of a table with the expected rows
+func sameValues(t *testing.T, dt DataTable, want [][]interface{}) {
+	t.Helper()
+	if len(dt.Rows) != len(want) {
+		t.Fatalf("%d rows, want %d", len(dt.Rows), len(want))
+	}
+	for r, w := range want {
+		if !reflect.DeepEqual(dt.Rows[r].items, w) {
+			t.Errorf("row %d: got %#v, want %#v", r, dt.Rows[r].items, w)
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	got, err := sales().GroupBy([]string{"country"}, "count(*)", "count(amount)", "count(distinct customer)",
+		"sum(qty) as q", "avg(amount)", "min(customer)", "max(qty)", "sum(amount)", "sum(distinct amount) as d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := "country,count,count_amount,count_customer,q,avg_amount,min_customer,max_qty,sum_amount,d"
+	if strings.Join(got.ColumnNames(), ",") != names {
+		t.Errorf("columns %v", got.ColumnNames())
+	}
+	types := []string{TypeString, TypeInt64, TypeInt64, TypeInt64, TypeInt64, TypeFloat64, TypeString, TypeInt64, TypeFloat64, TypeFloat64}
+	for c, w := range types {
+		if got.Columns[c].GoType != w {
+			t.Errorf("column %s is %s, want %s", got.Columns[c].Name, got.Columns[c].GoType, w)
+		}
+	}
+	sameValues(t, got, [][]interface{}{
+		{"FR", int64(3), int64(3), int64(2), int64(7), 8.5, "a", int64(4), 25.5, 15.0},
+		{"US", int64(1), int64(0), int64(1), int64(3), nil, "a", int64(3), nil, nil},
+		{nil, int64(1), int64(1), int64(1), nil, 5.0, "c", nil, 5.0, 5.0},
+	})
+	if got.Rows[0].State() != RowUnchanged || got.ColumnIndex("q") != 4 {
+		t.Error("result not usable")
+	}
+
+	// Without keys, a single row, even over no rows
+	got, err = sales().GroupBy(nil, "count(*)", "sum(qty)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{int64(5), int64(10)}})
+	got, err = sales().View().Filter("country = 'XX'").GroupBy(nil, "count(*)", "sum(qty)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{int64(0), nil}})
+
+	// Several keys
+	got, err = sales().GroupBy([]string{"country", "customer"}, "count(*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{"FR", "a", int64(2)}, {"FR", "b", int64(1)}, {"US", "a", int64(1)}, {nil, "c", int64(1)}})
+}
+
+func TestGroupByText(t *testing.T) {
+	// sum and avg read the strings holding a number
+	got, err := sales().View().Filter("note <> 'x'").GroupBy(nil, "sum(note)", "avg(note)", "min(note)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{5.5, 2.75, " 2.5 "}})
+
+	// and fail on the other values
+	if _, err = sales().GroupBy(nil, "sum(note)"); err == nil || !strings.Contains(err.Error(), "sum(note)") {
+		t.Errorf("sum of text: %v", err)
+	}
+	if _, err = sales().GroupBy([]string{"country"}, "avg(customer)"); err == nil {
+		t.Error("avg of text")
+	}
+	// min, max and count do not need numbers
+	if _, err = sales().GroupBy(nil, "min(note)", "max(customer)", "count(distinct note)"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGroupBySumOverflow(t *testing.T) {
+	dt := &DataTable{Columns: []DataColumn{{Name: "g", GoType: TypeString}, {Name: "n", GoType: TypeInt64}}}
+	for _, x := range []struct {
+		g string
+		n int64
+	}{{"a", math.MaxInt64 - 1}, {"a", 2}, {"b", 1}, {"b", 2}, {"c", math.MinInt64}, {"c", -1}} {
+		dr := dt.NewRow()
+		dr.items[0], dr.items[1] = x.g, x.n
+		dt.AddRow(dr)
+	}
+	got, err := dt.GroupBy([]string{"g"}, "sum(n)", "avg(n)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sums that overflow int64 are computed with floats, for the whole column
+	if got.Columns[1].GoType != TypeFloat64 {
+		t.Errorf("sum column is %s", got.Columns[1].GoType)
+	}
+	sameValues(t, got, [][]interface{}{
+		{"a", float64(math.MaxInt64) + 1, (float64(math.MaxInt64) + 1) / 2},
+		{"b", 3.0, 1.5},
+		{"c", float64(math.MinInt64) - 1, (float64(math.MinInt64) - 1) / 2},
+	})
+
+	u := &DataTable{Columns: []DataColumn{{Name: "n"}}}
+	for _, v := range []interface{}{uint64(math.MaxUint64), uint64(1)} {
+		dr := u.NewRow()
+		dr.items[0] = v
+		u.AddRow(dr)
+	}
+	if got, err = u.GroupBy(nil, "sum(n)"); err != nil || got.Rows[0].items[0] != float64(math.MaxUint64)+1 {
+		t.Errorf("uint64 sum %v, %v", got.Rows[0].items, err)
+	}
+}
+
+func TestGroupByErrors(t *testing.T) {
+	dt := sales()
+	for _, x := range []struct {
+		keys []string
+		agg  string
+	}{
+		{[]string{"nope"}, "count(*)"},
+		{nil, "sum(nope)"},
+		{nil, "median(qty)"},
+		{nil, "sum(*)"},
+		{nil, "count"},
+	} {
+		if _, err := dt.GroupBy(x.keys, x.agg); err == nil {
+			t.Errorf("%v %s: no error", x.keys, x.agg)
+		}
+	}
+	if _, err := dt.View().Filter("nope = 1").GroupBy(nil, "count(*)"); err == nil {
+		t.Error("view error lost")
+	}
+	if _, err := dt.View().Aggregate(nil, Aggregate{Func: "count", Column: "*", Distinct: true}); err == nil {
+		t.Error("count(distinct *)")
+	}
+}
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Aggregate
+	}{
+		{"count(*)", Aggregate{Func: "count", Column: "*"}},
+		{" SUM( [unit price] ) AS [total] ", Aggregate{Func: "sum", Column: "unit price", As: "total"}},
+		{"count(DISTINCT customer) as n", Aggregate{Func: "count", Column: "customer", Distinct: true, As: "n"}},
+		{"max(`as`)", Aggregate{Func: "max", Column: "as"}},
+	}
+	for _, x := range tests {
+		a, err := ParseAggregate(x.s)
+		if err != nil {
+			t.Errorf("%s: %v", x.s, err)
+		} else if a != x.want {
+			t.Errorf("%s: got %+v, want %+v", x.s, a, x.want)
+		}
+	}
+	for _, s := range []string{"", "sum", "sum(x", "median(x)", "sum()", "avg(*)", "count(distinct *)"} {
+		if _, err := ParseAggregate(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	if (Aggregate{Func: "sum", Column: "x"}).name() != "sum_x" || (Aggregate{Func: "count", Column: "*"}).name() != "count" {
+		t.Error("default names")
+	}
+}
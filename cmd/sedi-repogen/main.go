@@ -0,0 +1,342 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Command sedi-repogen generates repositories persisting structures without reflection.
+//
+// The generated repositories implement sedi.SQLMapperCRUD. Their statements are the
+// ones of sedi.TableDefFromStruct, written with the quoting and placeholders of the
+// dialect of the driver; arguments are bound by the dialect of the connection and rows
+// are read with code specific to each structure. Typical use:
+//
+//	//go:generate sedi-repogen -type ContactInfo,GroupInfo -driver sqlite3
+//
+// The driver is sqlite3, mysql or postgres, and the connection given to the
+// repositories must be opened by a mapper or sedi.Open with the same driver.
+//
+// The output is written to <first type>_repository.go unless -out is given.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/stefpo/sedi"
+
+	_ "github.com/stefpo/sedi/mapper/mysql"
+	_ "github.com/stefpo/sedi/mapper/postgres"
+	_ "github.com/stefpo/sedi/mapper/sqlite3"
+)
+
+var errUsage = errors.New("usage")
+
+func main() {
+	if err := run(); err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "sedi-repogen:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	types := flag.String("type", "", "Comma separated list of structure names")
+	driver := flag.String("driver", "sqlite3", "SQL driver: sqlite3, mysql or postgres")
+	out := flag.String("out", "", "Output file")
+	dir := flag.String("dir", ".", "Directory of the package declaring the structures")
+	flag.Parse()
+
+	tl := []string{}
+	for _, t := range strings.Split(*types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tl = append(tl, t)
+		}
+	}
+	d, ok := sedi.LookupDialect(*driver)
+	if len(tl) == 0 || !ok {
+		return errUsage
+	}
+
+	pkg, tds, err := parseStructs(*dir, tl, d)
+	if err != nil {
+		return err
+	}
+	src, err := generate(pkg, d, tds)
+	if err != nil {
+		return err
+	}
+	fn := *out
+	if fn == "" {
+		fn = filepath.Join(*dir, sedi.DBName(tl[0])+"_repository.go")
+	}
+	return os.WriteFile(fn, src, 0644)
+}
+
+// parseStructs reads the package in dir and returns the TableDef of the requested structures,
+// quoted for dialect d
+func parseStructs(dir string, types []string, d sedi.Dialect) (string, []sedi.TableDef, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	found := make(map[string]*ast.StructType)
+	pkgName := ""
+	for _, e := range entries {
+		fn := e.Name()
+		if e.IsDir() || !strings.HasSuffix(fn, ".go") || strings.HasSuffix(fn, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, fn), nil, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		pkgName = f.Name.Name
+		ast.Inspect(f, func(n ast.Node) bool {
+			if ts, ok := n.(*ast.TypeSpec); ok {
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					found[ts.Name.Name] = st
+				}
+			}
+			return true
+		})
+	}
+
+	tds := []sedi.TableDef{}
+	for _, t := range types {
+		st, ok := found[t]
+		if !ok {
+			return "", nil, fmt.Errorf("structure %s not found in %s", t, dir)
+		}
+		fields := sedi.FieldDefs{}
+		for _, f := range st.Fields.List {
+			tn, err := typeName(f.Type)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %s", t, err.Error())
+			}
+			tag := ""
+			if f.Tag != nil {
+				tag, _ = strconv.Unquote(f.Tag.Value)
+			}
+			if len(f.Names) == 0 {
+				return "", nil, fmt.Errorf("%s: embedded fields are not supported", t)
+			}
+			for _, n := range f.Names {
+				if ast.IsExported(n.Name) {
+					fields = append(fields, sedi.NewFieldDef(n.Name, tn, reflect.StructTag(tag)))
+				}
+			}
+		}
+		td := sedi.TableDefFromFields(t, fields, d.Quote)
+		if err := td.Validate(); err != nil {
+			return "", nil, err
+		}
+		tds = append(tds, td)
+	}
+	return pkgName, tds, nil
+}
+
+// typeName returns the name reflect would give to a field type
+func typeName(e ast.Expr) (string, error) {
+	switch x := e.(type) {
+	case *ast.Ident:
+		switch x.Name {
+		case "byte":
+			return "uint8", nil
+		case "rune":
+			return "int32", nil
+		case "string", "bool", "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+			return x.Name, nil
+		}
+	case *ast.SelectorExpr:
+		if p, ok := x.X.(*ast.Ident); ok && p.Name == "time" && x.Sel.Name == "Time" {
+			return "Time", nil
+		}
+	}
+	var b bytes.Buffer
+	format.Node(&b, token.NewFileSet(), e)
+	return "", errors.New("unsupported field type " + b.String())
+}
+
+func lowerFirst(s string) string {
+	rs := []rune(s)
+	rs[0] = unicode.ToLower(rs[0])
+	return string(rs)
+}
+
+type generator struct {
+	b       bytes.Buffer
+	dialect sedi.Dialect
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.b, format, args...)
+}
+
+func generate(pkg string, d sedi.Dialect, tds []sedi.TableDef) ([]byte, error) {
+	g := &generator{dialect: d, imports: map[string]bool{"errors": true, "github.com/stefpo/sedi": true}}
+	var body bytes.Buffer
+	for i := range tds {
+		g.b.Reset()
+		g.writeRepository(&tds[i])
+		body.Write(g.b.Bytes())
+	}
+
+	g.b.Reset()
+	g.printf("// Code generated by sedi-repogen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n")
+	g.printf("\t\"errors\"\n\n")
+	for _, i := range []string{"github.com/stefpo/sedi", "github.com/stefpo/sedi/conv"} {
+		if g.imports[i] {
+			g.printf("\t%q\n", i)
+		}
+	}
+	g.printf(")\n\n")
+	g.b.Write(body.Bytes())
+	return format.Source(g.b.Bytes())
+}
+
+func (g *generator) writeRepository(td *sedi.TableDef) {
+	t := td.Name
+	r := t + "Repository"
+	v := lowerFirst(t)
+	driver := g.dialect.DriverName()
+	pk := td.Fields[td.PkIx]
+	insertSQL := td.InsertStatement
+	returning := false
+	if rt, ok := g.dialect.(sedi.Returning); ok && pk.AutoIncrement && isInteger(pk.GoTypeName) {
+		insertSQL += " " + rt.ReturningSQL(*td)
+		returning = true
+	}
+
+	g.printf("// %s implements sedi.SQLMapperCRUD for *%s without reflection.\n", r, t)
+	g.printf("// Its statements are written for %s, the connection must use the %s dialect.\n", driver, driver)
+	g.printf("type %s struct {\n\tConn *sedi.Conn\n}\n\n", r)
+	g.printf("// New%s creates a %s working on an open connection\n", r, r)
+	g.printf("func New%s(cn *sedi.Conn) *%s {\n\treturn &%s{Conn: cn}\n}\n\n", r, r, r)
+
+	stmts := []struct{ name, sql string }{
+		{"Select", td.SelectStatement}, {"Insert", insertSQL},
+		{"Update", td.UpdateStatement}, {"Delete", td.DeleteStatement}}
+	fields := make([][]int, len(stmts))
+	g.printf("const (\n")
+	for i, st := range stmts {
+		var sql string
+		sql, fields[i] = sedi.CompileStatement(g.dialect, td, st.sql)
+		g.printf("\t%s%sStatement = %q\n", v, st.name, sql)
+	}
+	g.printf(")\n\n")
+
+	g.printf("var (\n")
+	g.printf("\t%sFields = sedi.FieldDefs{\n", v)
+	for _, fd := range td.Fields {
+		g.printf("\t\tsedi.NewFieldDef(%q, %q, %q),\n", fd.Name, fd.GoTypeName, fd.GoTag)
+	}
+	g.printf("\t}\n")
+	for i, st := range stmts {
+		g.printf("\t%s%sFields = %#v\n", v, st.name, fields[i])
+	}
+	g.printf(")\n\n")
+
+	g.printf("// %sArgs returns the fields of x at positions fields, bound by dialect d\n", v)
+	g.printf("func %sArgs(d sedi.Dialect, x *%s, fields []int) []interface{} {\n", v, t)
+	g.printf("\targs := make([]interface{}, len(fields))\n")
+	g.printf("\tfor i, f := range fields {\n\t\tvar a interface{}\n\t\tswitch f {\n")
+	for i, fd := range td.Fields {
+		g.printf("\t\tcase %d:\n\t\t\ta = x.%s\n", i, fd.Name)
+	}
+	g.printf("\t\t}\n\t\targs[i] = d.BindValue(%sFields[f], a)\n\t}\n\treturn args\n}\n\n", v)
+
+	g.printf("func %sFill(x *%s, dr *sedi.DataRow) {\n", v, t)
+	for _, fd := range td.Fields {
+		g.writeFill(fd)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// cast checks the structure and the dialect of the connection\n")
+	g.printf("func (me *%s) cast(st interface{}) (*%s, sedi.Dialect, error) {\n", r, t)
+	g.printf("\tx, ok := st.(*%s)\n\tif !ok || x == nil {\n", t)
+	g.printf("\t\treturn nil, nil, errors.New(\"%s: expected a *%s\")\n\t}\n", r, t)
+	g.printf("\td := me.Conn.Dialect()\n\tif d == nil || d.DriverName() != %q {\n", driver)
+	g.printf("\t\treturn nil, nil, errors.New(\"%s: the connection must use the %s dialect\")\n\t}\n", r, driver)
+	g.printf("\treturn x, d, nil\n}\n\n")
+
+	g.printf("// Insert inserts a *%s in the database\n", t)
+	g.printf("func (me *%s) Insert(st interface{}) error {\n", r)
+	g.printf("\tx, d, e := me.cast(st)\n\tif e != nil {\n\t\treturn e\n\t}\n")
+	args := fmt.Sprintf("%sArgs(d, x, %s%%sFields)...", v, v)
+	switch {
+	case returning:
+		g.imports["github.com/stefpo/sedi/conv"] = true
+		g.printf("\tdr, e := me.Conn.GetSingleRowArgs(%sInsertStatement, "+args+")\n", v, "Insert")
+		g.printf("\tif e == nil {\n\t\tx.%s = %s(conv.ToInt64(dr.Items()[0]))\n\t}\n\treturn e\n}\n\n", pk.Name, pk.GoTypeName)
+	case pk.AutoIncrement && isInteger(pk.GoTypeName):
+		g.printf("\tresult, e := me.Conn.ExecArgs(%sInsertStatement, "+args+")\n", v, "Insert")
+		g.printf("\tif e != nil {\n\t\treturn e\n\t}\n")
+		g.printf("\tid, e := result.LastInsertId()\n")
+		g.printf("\tif e == nil {\n\t\tx.%s = %s(id)\n\t}\n\treturn e\n}\n\n", pk.Name, pk.GoTypeName)
+	default:
+		g.printf("\t_, e = me.Conn.ExecArgs(%sInsertStatement, "+args+")\n\treturn e\n}\n\n", v, "Insert")
+	}
+
+	g.printf("// Read reads a *%s from the database using its primary key\n", t)
+	g.printf("func (me *%s) Read(st interface{}) error {\n", r)
+	g.printf("\tx, d, e := me.cast(st)\n\tif e != nil {\n\t\treturn e\n\t}\n")
+	g.printf("\tdr, e := me.Conn.GetSingleRowArgs(%sSelectStatement, "+args+")\n", v, "Select")
+	g.printf("\tif e == nil {\n\t\t%sFill(x, &dr)\n\t}\n\treturn e\n}\n\n", v)
+
+	for _, op := range []string{"Update", "Delete"} {
+		g.printf("// %s %ss a *%s in the database\n", op, strings.ToLower(op), t)
+		g.printf("func (me *%s) %s(st interface{}) error {\n", r, op)
+		g.printf("\tx, d, e := me.cast(st)\n\tif e != nil {\n\t\treturn e\n\t}\n")
+		g.printf("\t_, e = me.Conn.ExecArgs(%s%sStatement, "+args+")\n\treturn e\n}\n\n", v, op, op)
+	}
+}
+
+func isInteger(tn string) bool {
+	return strings.HasPrefix(tn, "int") || strings.HasPrefix(tn, "uint")
+}
+
+// writeFill writes the conversion of a column to a field, as DataRow.FillStruct does
+func (g *generator) writeFill(fd sedi.FieldDef) {
+	g.imports["github.com/stefpo/sedi/conv"] = true
+	item := fmt.Sprintf("dr.ItemSingle(%q)", fd.Name)
+	f := "x." + fd.Name
+	switch fd.GoTypeName {
+	case "string":
+		g.printf("\t%s = conv.ToString(%s)\n", f, item)
+	case "bool":
+		g.printf("\t%s = conv.ToBool(%s)\n", f, item)
+	case "int64":
+		g.printf("\t%s = conv.ToInt64(%s)\n", f, item)
+	case "int", "int8", "int16", "int32":
+		g.printf("\t%s = %s(conv.ToInt64(%s))\n", f, fd.GoTypeName, item)
+	case "uint64":
+		g.printf("\t%s = conv.ToUint64(%s)\n", f, item)
+	case "uint", "uint8", "uint16", "uint32":
+		g.printf("\t%s = %s(conv.ToUint64(%s))\n", f, fd.GoTypeName, item)
+	case "float64":
+		g.printf("\t%s = conv.ToFloat64(%s)\n", f, item)
+	case "float32":
+		g.printf("\t%s = float32(conv.ToFloat64(%s))\n", f, item)
+	case "Time":
+		g.printf("\t%s = conv.ToTime(%s)\n", f, item)
+	}
+}
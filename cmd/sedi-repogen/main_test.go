@@ -0,0 +1,196 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+const testSource = `package model
+
+import "time"
+
+type ContactInfo struct {
+	Id       int64 ` + "`autoincrement:\"y\"`" + `
+	Name     string
+	NameFull string ` + "`size:\"100\"`" + `
+	Born     time.Time
+	Active   bool
+	private  int
+}
+
+type GroupInfo struct {
+	Code string ` + "`primaryKey:\"y\" size:\"8\"`" + `
+	Rate float32
+}
+`
+
+func generateFor(t *testing.T, driver string, types ...string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "model.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := sedi.LookupDialect(driver)
+	if !ok {
+		t.Fatalf("%s not registered", driver)
+	}
+	pkg, tds, err := parseStructs(dir, types, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(pkg, d, tds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+func contains(t *testing.T, src string, parts ...string) {
+	t.Helper()
+	for _, p := range parts {
+		if !strings.Contains(src, p) {
+			t.Errorf("missing %q in\n%s", p, src)
+		}
+	}
+}
+
+func TestSqlite3(t *testing.T) {
+	src := generateFor(t, "sqlite3", "ContactInfo", "GroupInfo")
+	contains(t, src,
+		"package model\n",
+		"contactInfoSelectStatement = \"select `id` AS `Id`, `name` AS `Name`, `name_full` AS `NameFull`, `born` AS `Born`, `active` AS `Active` from `contact_info` where `id` = ?\"",
+		"contactInfoUpdateFields = []int{1, 2, 3, 4, 0}",
+		"sedi.NewFieldDef(\"NameFull\", \"string\", \"size:\\\"100\\\"\")",
+		"args[i] = d.BindValue(contactInfoFields[f], a)",
+		"d.DriverName() != \"sqlite3\"",
+		"result, e := me.Conn.ExecArgs(contactInfoInsertStatement, contactInfoArgs(d, x, contactInfoInsertFields)...)",
+		"id, e := result.LastInsertId()\n\tif e == nil {\n\t\tx.Id = int64(id)\n\t}\n\treturn e\n",
+		"dr, e := me.Conn.GetSingleRowArgs(contactInfoSelectStatement, contactInfoArgs(d, x, contactInfoSelectFields)...)",
+		"x.Born = conv.ToTime(dr.ItemSingle(\"Born\"))",
+		"type GroupInfoRepository struct",
+		"_, e = me.Conn.ExecArgs(groupInfoInsertStatement, groupInfoArgs(d, x, groupInfoInsertFields)...)",
+		"x.Rate = float32(conv.ToFloat64(dr.ItemSingle(\"Rate\")))")
+	for _, s := range []string{"SqlParm", "@Name", "private", "strings.Replace"} {
+		if strings.Contains(src, s) {
+			t.Errorf("%q in\n%s", s, src)
+		}
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	src := generateFor(t, "postgres", "ContactInfo")
+	contains(t, src,
+		"contactInfoDeleteStatement = \"delete from \\\"contact_info\\\" where \\\"id\\\" = $1\"",
+		"update \\\"contact_info\\\" set \\\"name\\\" = $1, \\\"name_full\\\" = $2, \\\"born\\\" = $3, \\\"active\\\" = $4 where \\\"id\\\" = $5",
+		"returning \\\"id\\\"",
+		"dr, e := me.Conn.GetSingleRowArgs(contactInfoInsertStatement, contactInfoArgs(d, x, contactInfoInsertFields)...)",
+		"x.Id = int64(conv.ToInt64(dr.Items()[0]))",
+		"d.DriverName() != \"postgres\"")
+}
+
+// testProgram uses the repositories generated for testSource on the memory driver
+const testProgram = `package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/stefpo/sedi"
+	_ "github.com/stefpo/sedi/mapper/memory"
+)
+
+func check(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	m, err := sedi.Open("memory://repogen")
+	check(err)
+	check(m.AddPersistence(&ContactInfo{}).AddPersistence(&GroupInfo{}).UpdateModel())
+	contacts := NewContactInfoRepository(m.Connection())
+	check(contacts.Insert(&ContactInfo{Name: "first"}))
+	c := ContactInfo{Name: "a", NameFull: "A a", Born: time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC), Active: true}
+	check(contacts.Insert(&c))
+	got := ContactInfo{Id: c.Id}
+	check(contacts.Read(&got))
+	if c.Id != 2 || got.Name != c.Name || got.NameFull != c.NameFull || !got.Born.Equal(c.Born) || !got.Active {
+		fmt.Printf("inserted %+v, read %+v\n", c, got)
+		os.Exit(1)
+	}
+	groups := NewGroupInfoRepository(m.Connection())
+	check(groups.Insert(&GroupInfo{Code: "g", Rate: 1.5}))
+	g := GroupInfo{Code: "g"}
+	check(groups.Read(&g))
+	if g.Rate != 1.5 {
+		fmt.Printf("read %+v\n", g)
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
+`
+
+// TestGeneratedCode compiles the repositories generated for the memory driver and runs them
+func TestGeneratedCode(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("needs the go command")
+	}
+	// The program is built in the module, which provides the sedi packages
+	dir, err := os.MkdirTemp(".", "generated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, _ := sedi.LookupDialect(memory.DriverName)
+	err = os.WriteFile(filepath.Join(dir, "model.go"), []byte(strings.Replace(testSource, "package model", "package main", 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, tds, err := parseStructs(dir, []string{"ContactInfo", "GroupInfo"}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(pkg, d, tds)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "repository.go"), src, 0644)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "main.go"), []byte(testProgram), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(gobin, "run", "./"+filepath.Base(dir)).CombinedOutput()
+	if err != nil || string(out) != "ok\n" {
+		t.Errorf("%v: %s\n%s", err, out, src)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\ntype A struct {\n\tId int64\n\tTags []string\n}\n\ntype B struct {\n\tId int64\n}\n"), 0644)
+	d, _ := sedi.LookupDialect("mysql")
+	if _, _, err := parseStructs(dir, []string{"A"}, d); err == nil || !strings.Contains(err.Error(), "[]string") {
+		t.Errorf("got %v", err)
+	}
+	if _, _, err := parseStructs(dir, []string{"C"}, d); err == nil {
+		t.Error("C found")
+	}
+	if _, tds, err := parseStructs(dir, []string{"B"}, d); err != nil || tds[0].DeleteStatement != "delete from `b` where `id` = @Id" {
+		t.Errorf("got %+v, %v", tds, err)
+	}
+}
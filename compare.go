@@ -0,0 +1,173 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefpo/sedi/conv"
+)
+
+// isNumber tells if a value is a Go number
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+// compareValues compares two values that are not NULL, like SQL does: numbers by value,
+// times by date, strings with strings. A string compared with a number or a time is converted.
+// ok is false when the values cannot be compared.
+func compareValues(a interface{}, b interface{}) (cmp int, ok bool) {
+	switch x := a.(type) {
+	case string:
+		switch y := b.(type) {
+		case string:
+			return strings.Compare(x, y), true
+		case []byte:
+			return strings.Compare(x, string(y)), true
+		case time.Time:
+			if t, err := parseTime(x); err == nil {
+				return compareTimes(t, y), true
+			}
+			return 0, false
+		}
+		if isNumber(b) {
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return 0, false
+			}
+			return compareNumbers(f, b), true
+		}
+	case []byte:
+		switch y := b.(type) {
+		case []byte:
+			return bytes.Compare(x, y), true
+		case string:
+			return strings.Compare(string(x), y), true
+		}
+	case time.Time:
+		switch y := b.(type) {
+		case time.Time:
+			return compareTimes(x, y), true
+		case string:
+			if t, err := parseTime(y); err == nil {
+				return compareTimes(x, t), true
+			}
+		}
+	case bool:
+		if y, isBool := b.(bool); isBool {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	default:
+		if !isNumber(a) {
+			return 0, false
+		}
+		if isNumber(b) {
+			return compareNumbers(a, b), true
+		}
+		if _, isString := b.(string); isString {
+			if c, ok := compareValues(b, a); ok {
+				return -c, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers compares two numbers, exactly when both are integers
+func compareNumbers(a interface{}, b interface{}) int {
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	f, g := conv.ToFloat64(a), conv.ToFloat64(b)
+	switch {
+	case f < g:
+		return -1
+	case f > g:
+		return 1
+	}
+	return 0
+}
+
+// equalValues tells if two values are both NULL or compare equal
+func equalValues(a interface{}, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	cmp, ok := compareValues(a, b)
+	return ok && cmp == 0
+}
+
+// valueKey returns a text identifying a value, equal for the values that compare equal:
+// int64(1) and float64(1) have the same key. It is used to find distinct values and groups.
+// Strings keep their own keys: "1" and "01" are distinct; joins convert them, see joinKey.
+func valueKey(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "\x00"
+	case string:
+		return "s" + x
+	case []byte:
+		return "s" + string(x)
+	case bool:
+		return "b" + strconv.FormatBool(x)
+	case time.Time:
+		return "t" + x.UTC().Format(time.RFC3339Nano)
+	case int64:
+		return "n" + strconv.FormatInt(x, 10)
+	}
+	if isNumber(v) {
+		f := conv.ToFloat64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return "n" + strconv.FormatInt(int64(f), 10)
+		}
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return "?" + conv.ToString(v)
+}
+
+// rowKey returns the key of the values of some columns of a row
+func rowKey(dr *DataRow, cols []int) string {
+	var b strings.Builder
+	for i, c := range cols {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(valueKey(dr.items[c]))
+	}
+	return b.String()
+}
@@ -0,0 +1,240 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of a connection. It can be read from JSON
+// (LoadConfig) and from environment variables (LoadEnv).
+//
+// Pragmas and Session are applied to every connection opened by the pool:
+// Pragmas are SQLite pragmas ("journal_mode": "WAL", "synchronous": "NORMAL",
+// "foreign_keys": "on", "cache_size": "-20000"), Session are MySQL or PostgreSQL
+// session variables ("sql_mode": "'STRICT_ALL_TABLES'"). Values are written
+// to the SQL statements as given.
+type Config struct {
+	URL             string            `json:"url"` // Url given to Open
+	MaxOpenConns    int               `json:"maxOpenConns"`
+	MaxIdleConns    int               `json:"maxIdleConns"`
+	ConnMaxLifetime Duration          `json:"connMaxLifetime"`
+	ConnMaxIdleTime Duration          `json:"connMaxIdleTime"`
+	SleepTime       Duration          `json:"sleepTime"` // Pause after each statement
+	Pragmas         map[string]string `json:"pragmas"`
+	Session         map[string]string `json:"session"`
+	Replicas        []string          `json:"replicas"`       // Urls of the read replicas, same scheme as URL
+	ReplicaPolicy   string            `json:"replicaPolicy"`  // round-robin (default) or least-loaded
+	ReadYourWrites  Duration          `json:"readYourWrites"` // See ReplicaOptions
+}
+
+// Duration is a time.Duration written in JSON as a string ("30s", "5m")
+// or as a number of seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n float64
+		if err = json.Unmarshal(b, &n); err != nil {
+			return errors.New("Duration: expected a string or a number of seconds")
+		}
+		*d = Duration(n * float64(time.Second))
+		return nil
+	}
+	x, err := parseDuration(s)
+	*d = Duration(x)
+	return err
+}
+
+// parseDuration parses a duration, a number without unit is a number of seconds
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// LoadConfig reads a JSON configuration file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &cfg)
+	}
+	if err != nil {
+		return cfg, errors.New("LoadConfig:" + err.Error())
+	}
+	return cfg, nil
+}
+
+// LoadEnv overrides the configuration with the environment variables starting with prefix:
+// URL, MAX_OPEN_CONNS, MAX_IDLE_CONNS, CONN_MAX_LIFETIME, CONN_MAX_IDLE_TIME, SLEEP_TIME,
+// REPLICAS (comma separated), REPLICA_POLICY, READ_YOUR_WRITES, PRAGMA_<name> and SESSION_<name>. With prefix "SEDI_", SEDI_PRAGMA_JOURNAL_MODE=WAL
+// sets the journal_mode pragma.
+func (cfg *Config) LoadEnv(prefix string) error {
+	for _, kv := range os.Environ() {
+		p := strings.Index(kv, "=")
+		if p < 0 || !strings.HasPrefix(kv[:p], prefix) {
+			continue
+		}
+		name, value := kv[len(prefix):p], kv[p+1:]
+		var err error
+		switch {
+		case name == "URL":
+			cfg.URL = value
+		case name == "MAX_OPEN_CONNS":
+			cfg.MaxOpenConns, err = strconv.Atoi(value)
+		case name == "MAX_IDLE_CONNS":
+			cfg.MaxIdleConns, err = strconv.Atoi(value)
+		case name == "CONN_MAX_LIFETIME":
+			err = setDuration(&cfg.ConnMaxLifetime, value)
+		case name == "CONN_MAX_IDLE_TIME":
+			err = setDuration(&cfg.ConnMaxIdleTime, value)
+		case name == "SLEEP_TIME":
+			err = setDuration(&cfg.SleepTime, value)
+		case name == "REPLICAS":
+			cfg.Replicas = nil
+			for _, r := range strings.Split(value, ",") {
+				if r = strings.TrimSpace(r); r != "" {
+					cfg.Replicas = append(cfg.Replicas, r)
+				}
+			}
+		case name == "REPLICA_POLICY":
+			cfg.ReplicaPolicy = value
+		case name == "READ_YOUR_WRITES":
+			err = setDuration(&cfg.ReadYourWrites, value)
+		case strings.HasPrefix(name, "PRAGMA_"):
+			if cfg.Pragmas == nil {
+				cfg.Pragmas = make(map[string]string)
+			}
+			cfg.Pragmas[strings.ToLower(name[7:])] = value
+		case strings.HasPrefix(name, "SESSION_"):
+			if cfg.Session == nil {
+				cfg.Session = make(map[string]string)
+			}
+			cfg.Session[strings.ToLower(name[8:])] = value
+		}
+		if err != nil {
+			return errors.New("LoadEnv:" + prefix + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func setDuration(d *Duration, s string) error {
+	x, err := parseDuration(s)
+	*d = Duration(x)
+	return err
+}
+
+// applyPool sets the pool limits of a database
+func (cfg Config) applyPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+	}
+}
+
+// initConnector opens the connections of a pool and runs the init statements on each of them
+type initConnector struct {
+	drv  driver.Driver
+	dsn  string
+	init []string
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range c.init {
+		if err = execDriver(ctx, cn, s); err != nil {
+			cn.Close()
+			return nil, errors.New(s + ": " + err.Error())
+		}
+	}
+	return cn, nil
+}
+
+func (c *initConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// execDriver runs a statement on a driver connection
+func execDriver(ctx context.Context, cn driver.Conn, s string) error {
+	if x, ok := cn.(driver.ExecerContext); ok {
+		_, err := x.ExecContext(ctx, s, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	st, err := cn.Prepare(s)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	_, err = st.Exec(nil)
+	return err
+}
+
+// OpenConnectionConfig opens a connection for a dialect. The statements returned by the
+// ConnectionInitializer of the dialect are run on every connection opened by the pool.
+func OpenConnectionConfig(d Dialect, dsn string, cfg Config) (Conn, error) {
+	var conn Conn
+	conn.DisallowConcurency = true
+	conn.health = &health{}
+	conn.driver = d.DriverName()
+	conn.dialect = d
+	conn.SleepTime = time.Duration(cfg.SleepTime)
+	conn.open = func(dsn string) (*sql.DB, error) {
+		return openPool(d, dsn, cfg)
+	}
+
+	db, err := conn.open(dsn)
+	if err == nil {
+		conn.DB = db
+		err = d.OnOpen(&conn)
+	}
+	return conn, err
+}
+
+// openPool opens a database running the init statements of the dialect on each connection
+func openPool(d Dialect, dsn string, cfg Config) (*sql.DB, error) {
+	db, err := sql.Open(d.DriverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if ci, ok := d.(ConnectionInitializer); ok {
+		if init := ci.InitSQL(cfg); len(init) > 0 {
+			drv := db.Driver()
+			db.Close()
+			db = sql.OpenDB(&initConnector{drv: drv, dsn: dsn, init: init})
+		}
+	}
+	cfg.applyPool(db)
+	return db, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	var x struct{ A, B, C Duration }
+	if err := json.Unmarshal([]byte(`{"A": "1m30s", "B": 2.5, "C": "10"}`), &x); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(x.A) != 90*time.Second || time.Duration(x.B) != 2500*time.Millisecond || time.Duration(x.C) != 10*time.Second {
+		t.Errorf("got %+v", x)
+	}
+	if b, _ := json.Marshal(x.A); string(b) != `"1m30s"` {
+		t.Errorf("marshal %s", b)
+	}
+	for _, bad := range []string{`{"A": true}`, `{"A": "soon"}`} {
+		if err := json.Unmarshal([]byte(bad), &x); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "db.json")
+	os.WriteFile(fn, []byte(`{"url": "sqlite3://app.db", "maxOpenConns": 4, "connMaxLifetime": "5m",
+		"pragmas": {"journal_mode": "WAL"}, "replicas": ["sqlite3://r.db"], "readYourWrites": 2}`), 0644)
+	cfg, err := LoadConfig(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{URL: "sqlite3://app.db", MaxOpenConns: 4, ConnMaxLifetime: Duration(5 * time.Minute),
+		Pragmas: map[string]string{"journal_mode": "WAL"}, Replicas: []string{"sqlite3://r.db"},
+		ReadYourWrites: Duration(2 * time.Second)}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v", cfg)
+	}
+	if _, err = LoadConfig(filepath.Join(t.TempDir(), "none.json")); err == nil {
+		t.Error("missing file")
+	}
+	os.WriteFile(fn, []byte(`{"url": 3}`), 0644)
+	if _, err = LoadConfig(fn); err == nil {
+		t.Error("invalid file")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("SEDITEST_URL", "mysql://u@h/db")
+	t.Setenv("SEDITEST_MAX_OPEN_CONNS", "8")
+	t.Setenv("SEDITEST_CONN_MAX_IDLE_TIME", "30s")
+	t.Setenv("SEDITEST_REPLICAS", "mysql://r1/db, mysql://r2/db,")
+	t.Setenv("SEDITEST_REPLICA_POLICY", "least-loaded")
+	t.Setenv("SEDITEST_PRAGMA_JOURNAL_MODE", "WAL")
+	t.Setenv("SEDITEST_SESSION_SQL_MODE", "'STRICT_ALL_TABLES'")
+	t.Setenv("OTHER_URL", "x")
+	cfg := Config{URL: "sqlite3://a.db", MaxIdleConns: 2}
+	if err := cfg.LoadEnv("SEDITEST_"); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{URL: "mysql://u@h/db", MaxOpenConns: 8, MaxIdleConns: 2, ConnMaxIdleTime: Duration(30 * time.Second),
+		Replicas: []string{"mysql://r1/db", "mysql://r2/db"}, ReplicaPolicy: "least-loaded",
+		Pragmas: map[string]string{"journal_mode": "WAL"}, Session: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'"}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v", cfg)
+	}
+	t.Setenv("SEDITEST_MAX_IDLE_CONNS", "many")
+	if err := cfg.LoadEnv("SEDITEST_"); err == nil {
+		t.Error("invalid number accepted")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -31,10 +32,20 @@ type Conn struct {
 	DB                 *sql.DB
 	rowsAffected       int64
 	driver             string
+	dialect            Dialect
 	SleepTime          time.Duration
 	DisallowConcurency bool
+	health             *health
+	open               func(dsn string) (*sql.DB, error) // Opens the primary or a replica
+	replicas           replicaRef
 }
 
+// ErrNoData is returned by GetSingleRow when the query returns no row
+var ErrNoData = errors.New("No data")
+
+// ErrNoConnection is returned when a connection is used before being opened, or after being closed
+var ErrNoConnection = errors.New("No connection")
+
 type SqlParm string
 
 // SQLParms is a map of SQL parameters
@@ -50,7 +61,11 @@ func OpenConnection(driver string, connString string) (Conn, error) {
 	var conn Conn
 	var err error
 	conn.DisallowConcurency = true // As a general rule, do not allow multiple go routines
-	conn.DB, err = sql.Open(driver, connString)
+	conn.health = &health{}
+	conn.open = func(dsn string) (*sql.DB, error) {
+		return sql.Open(driver, dsn)
+	}
+	conn.DB, err = conn.open(connString)
 	conn.driver = driver
 	if err != nil && LogErrors {
 		log.Print(err.Error())
@@ -58,8 +73,18 @@ func OpenConnection(driver string, connString string) (Conn, error) {
 	return conn, err
 }
 
-// Close closes the underlying SQL connection
+// Driver returns the name of the SQL driver used by the connection
+func (cn *Conn) Driver() string {
+	return cn.driver
+}
+
+// Close stops the monitor and closes the underlying SQL connection
 func (cn *Conn) Close() {
+	if cn == nil || cn.DB == nil {
+		return
+	}
+	cn.StopMonitor()
+	cn.closeReplicas()
 	cn.DB.Close()
 }
 
@@ -69,44 +94,55 @@ func (cn *Conn) sleep() {
 	}
 }
 
-// GetDataTable executes a SELECT statement and returns the result in a datatable
-func (cn *Conn) GetDataTable(query string, parms SQLParms) (DataTable, error) {
-	var dt DataTable
-	var err error
-	SQL := query
-	if parms != nil {
-		SQL = insertParameters(SQL, parms)
-	}
-	if LogAll {
-		log.Print(SQL)
+// SetDialect sets the dialect used to format parameters
+func (cn *Conn) SetDialect(d Dialect) {
+	cn.dialect = d
+}
+
+// Dialect returns the dialect of the connection, nil if none was set
+func (cn *Conn) Dialect() Dialect {
+	return cn.dialect
+}
+
+// quote quotes a name with the dialect of the connection, if any
+func (cn *Conn) quote(name string) string {
+	if cn.dialect != nil {
+		return cn.dialect.Quote(name)
 	}
-	if cn.DisallowConcurency {
-		defer lockWrite.Unlock()
-		lockWrite.Lock()
+	return name
+}
+
+// placeholder returns the placeholder of the nth statement argument, "?" without dialect
+func (cn *Conn) placeholder(n int) string {
+	if cn.dialect != nil {
+		return cn.dialect.Placeholder(n)
 	}
-	if rows, err := cn.DB.Query(SQL); err == nil {
-		dt.Fill(rows, -1)
-		cn.sleep()
-		err = nil
-	} else {
+	return "?"
+}
 
-		if LogErrors {
-			log.Print(err.Error())
-		}
-		err = errors.New("GetDataTable:" + err.Error())
+func (cn *Conn) prepare(query string, parms SQLParms) string {
+	if parms != nil {
+		return cn.insertParameters(query, parms)
 	}
-	return dt, err
+	return query
 }
 
-func (cn *Conn) GetSingleRow(query string, parms SQLParms) (DataRow, error) {
+// query runs a SELECT statement, in transaction tx if not nil, on a replica when the connection has some otherwise,
+// and returns at most maxrows rows (all rows if maxrows < 0)
+func (cn *Conn) query(tx *sql.Tx, fname string, SQL string, args []interface{}, maxrows int) (DataTable, error) {
 	var dt DataTable
-	var err error
-	var dr DataRow
 	dt.Clear()
+	err := cn.cursor(tx, fname, SQL, args, func(rows *sql.Rows) error {
+		return dt.Fill(rows, maxrows)
+	})
+	return dt, err
+}
 
-	SQL := query
-	if parms != nil {
-		SQL = insertParameters(SQL, parms)
+// cursor runs a SELECT statement, in transaction tx if not nil, on a replica when the connection has some otherwise,
+// and reads its rows with read. The rows are closed after read.
+func (cn *Conn) cursor(tx *sql.Tx, fname string, SQL string, args []interface{}, read func(rows *sql.Rows) error) error {
+	if cn == nil || cn.DB == nil {
+		return errors.New(fname + ":" + ErrNoConnection.Error())
 	}
 	if LogAll {
 		log.Print(SQL)
@@ -115,24 +151,68 @@ func (cn *Conn) GetSingleRow(query string, parms SQLParms) (DataRow, error) {
 		defer lockWrite.Unlock()
 		lockWrite.Lock()
 	}
-	if rows, err := cn.DB.Query(SQL); err == nil {
-		dt.Fill(rows, 1)
-		if len(dt.Rows) > 0 {
-			dr = dt.Rows[0]
-			err = nil
-		} else {
-			err = errors.New("No data")
+	if !isRead(SQL) {
+		cn.replicas.wrote() // A statement returning rows, like insert ... returning
+	}
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.Query(SQL, args...)
+	} else {
+		db, rs := cn.reader(SQL)
+		defer rs.release()
+		rows, err = db.Query(SQL, args...)
+		if err != nil && rs != nil && rs.failed(db) {
+			rows, err = cn.DB.Query(SQL, args...) // The replica is down, use the primary
 		}
+	}
+	if err == nil {
+		err = read(rows)
+		rows.Close()
 		cn.sleep()
-	} else {
+	}
+	if err != nil {
 		if LogErrors {
 			log.Print(err.Error())
 		}
-		err = errors.New("GetSingleRow:" + err.Error())
+		err = errors.New(fname + ":" + err.Error())
+	}
+	return err
+}
+
+// GetDataTable executes a SELECT statement and returns the result in a datatable
+func (cn *Conn) GetDataTable(query string, parms SQLParms) (DataTable, error) {
+	return cn.query(nil, "GetDataTable", cn.prepare(query, parms), nil, -1)
+}
+
+// GetDataTableArgs executes a SELECT statement using the placeholders of the driver
+func (cn *Conn) GetDataTableArgs(query string, args ...interface{}) (DataTable, error) {
+	return cn.query(nil, "GetDataTable", query, args, -1)
+}
+
+func (cn *Conn) singleRow(tx *sql.Tx, SQL string, args []interface{}) (DataRow, error) {
+	var dr DataRow
+	dt, err := cn.query(tx, "GetSingleRow", SQL, args, 1)
+	if err == nil {
+		if len(dt.Rows) > 0 {
+			dr = dt.Rows[0]
+		} else {
+			err = ErrNoData
+		}
 	}
 	return dr, err
 }
 
+// GetSingleRow returns the first row of a SELECT statement, ErrNoData if there is none
+func (cn *Conn) GetSingleRow(query string, parms SQLParms) (DataRow, error) {
+	return cn.singleRow(nil, cn.prepare(query, parms), nil)
+}
+
+// GetSingleRowArgs returns the first row of a SELECT statement using the placeholders of the driver
+func (cn *Conn) GetSingleRowArgs(query string, args ...interface{}) (DataRow, error) {
+	return cn.singleRow(nil, query, args)
+}
+
 func (cn *Conn) ReadStruct(table string, id int64, output interface{}) error {
 	var err error
 	qry := "select * from " + table + " where id = @id"
@@ -146,71 +226,17 @@ func (cn *Conn) ReadStruct(table string, id int64, output interface{}) error {
 }
 
 func (cn *Conn) GetScalar(query string, parms SQLParms) (interface{}, error) {
-	var dt DataTable
 	var ret interface{}
-	var err error
-
-	dt.Clear()
-
-	SQL := query
-	if parms != nil {
-		SQL = insertParameters(SQL, parms)
-	}
-	if LogAll {
-		log.Print(SQL)
-	}
-	if cn.DisallowConcurency {
-		defer lockWrite.Unlock()
-		lockWrite.Lock()
-	}
-	if rows, err := cn.DB.Query(SQL); err == nil {
-		dt.Fill(rows, 1)
-		if len(dt.Rows) > 0 {
-			ret = dt.Rows[0].Items()[0]
-		} else {
-			ret = nil
-		}
-	} else {
-		if LogErrors {
-			log.Print(err.Error())
-		}
-		err = errors.New("GetScalar:" + err.Error())
+	dt, err := cn.query(nil, "GetScalar", cn.prepare(query, parms), nil, 1)
+	if err == nil && len(dt.Rows) > 0 {
+		ret = dt.Rows[0].Items()[0]
 	}
 	return ret, err
 }
 
 func (cn *Conn) Exists(query string, parms SQLParms) (bool, error) {
-	var dt DataTable
-	var ret bool
-	var err error
-	dt.Clear()
-
-	SQL := query
-	if parms != nil {
-		SQL = insertParameters(SQL, parms)
-	}
-	if LogAll {
-		log.Print(SQL)
-	}
-	if cn.DisallowConcurency {
-		defer lockWrite.Unlock()
-		lockWrite.Lock()
-	}
-	if rows, err := cn.DB.Query(SQL); err == nil {
-		dt.Fill(rows, 1)
-		if len(dt.Rows) > 0 {
-			ret = true
-		} else {
-			ret = false
-		}
-
-	} else {
-		if LogErrors {
-			log.Print(err.Error())
-		}
-		err = errors.New("Exists:" + err.Error())
-	}
-	return ret, err
+	dt, err := cn.query(nil, "Exists", cn.prepare(query, parms), nil, 1)
+	return err == nil && len(dt.Rows) > 0, err
 }
 
 func (cn *Conn) ExecNoResult(query string, parms SQLParms) (err error) {
@@ -218,13 +244,11 @@ func (cn *Conn) ExecNoResult(query string, parms SQLParms) (err error) {
 	return err
 }
 
-func (cn *Conn) Exec(query string, parms SQLParms) (sql.Result, error) {
-	var err error
+// exec runs a statement on the primary, in transaction tx if not nil
+func (cn *Conn) exec(tx *sql.Tx, SQL string, args []interface{}) (sql.Result, error) {
 	var ret sql.Result
-	SQL := query
-
-	if parms != nil {
-		SQL = insertParameters(SQL, parms)
+	if cn == nil || cn.DB == nil {
+		return ret, errors.New("Exec:" + ErrNoConnection.Error())
 	}
 	if LogAll {
 		log.Print(SQL)
@@ -235,7 +259,15 @@ func (cn *Conn) Exec(query string, parms SQLParms) (sql.Result, error) {
 		lockRead.Lock()
 		lockWrite.Lock()
 	}
-	if result, err := cn.DB.Exec(SQL); err == nil {
+	cn.replicas.wrote()
+	var result sql.Result
+	var err error
+	if tx != nil {
+		result, err = tx.Exec(SQL, args...)
+	} else {
+		result, err = cn.DB.Exec(SQL, args...)
+	}
+	if err == nil {
 		if x, e := result.RowsAffected(); e == nil {
 			cn.rowsAffected = x
 		} else {
@@ -254,19 +286,39 @@ func (cn *Conn) Exec(query string, parms SQLParms) (sql.Result, error) {
 	return ret, err
 }
 
+func (cn *Conn) Exec(query string, parms SQLParms) (sql.Result, error) {
+	return cn.exec(nil, cn.prepare(query, parms), nil)
+}
+
+// ExecArgs executes a statement using the placeholders of the driver
+func (cn *Conn) ExecArgs(query string, args ...interface{}) (sql.Result, error) {
+	return cn.exec(nil, query, args)
+}
+
 func (cn *Conn) RowsAffected() int64 { return cn.rowsAffected }
 
-func insertParameters(query string, parms SQLParms) string {
+// insertParameters replaces the parameters of a query by their literal value,
+// longest names first so that @Name does not replace the beginning of @NameFull
+func (cn *Conn) insertParameters(query string, parms SQLParms) string {
+	names := make([]string, 0, len(parms))
+	for p := range parms {
+		names = append(names, p)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
 	res := query
 	var po string
-	for p := range parms {
+	for _, p := range names {
 		parm := parms[p]
 
 		switch parm.(type) {
 		case nil:
 			po = "null"
 		case time.Time:
-			po = "date('" + parm.(time.Time).Format("2006-01-02 15:04:05") + "')"
+			if cn != nil && cn.dialect != nil {
+				po = cn.dialect.DateLiteral(parm.(time.Time))
+			} else {
+				po = "date('" + parm.(time.Time).Format("2006-01-02 15:04:05") + "')"
+			}
 			break
 		case SqlParm:
 			po = string(parm.(SqlParm))
@@ -286,11 +338,11 @@ func escapeParameter(parm string) string {
 	return strings.Replace(parm, "'", "''", -1)
 }
 
+// IsNull returns defaultvalue if x is nil, x otherwise.
+// The typed getters of DataRow (GetStringOr, GetInt64Or...) also convert the value.
 func IsNull(x interface{}, defaultvalue interface{}) interface{} {
-	switch x.(type) {
-	case nil:
-		return defaultvalue
-	default:
+	if x == nil {
 		return defaultvalue
 	}
+	return x
 }
@@ -0,0 +1,553 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CSVOptions sets the format of the CSV files read and written by DataTable and Conn.LoadCSV.
+// The zero value is a comma separated UTF-8 file with a header line.
+type CSVOptions struct {
+	Comma      rune   // Field delimiter, ',' by default
+	QuoteAll   bool   // Write all the fields between quotes, not only those that need it
+	NoHeader   bool   // No header line. ReadCSV names the columns Column1, Column2...
+	Encoding   string // "utf-8" (default), "utf-8-bom", "latin1" (ISO-8859-1) or "windows-1252"
+	Null       string // Text of NULL values, "" by default: empty fields are read as NULL
+	TimeFormat string // Layout of times, time.RFC3339Nano by default. The usual database formats are also read.
+	// InferTypes lets ReadCSV find the type of each column from its values (int64, float64,
+	// bool, time.Time or string). Otherwise values are read as strings.
+	InferTypes bool
+	// Columns gives the names and types of the columns read by ReadCSV,
+	// overriding the header line and InferTypes
+	Columns []DataColumn
+}
+
+func (opt *CSVOptions) comma() rune {
+	if opt.Comma == 0 {
+		return ','
+	}
+	return opt.Comma
+}
+
+func (opt *CSVOptions) timeFormat() string {
+	if opt.TimeFormat == "" {
+		return time.RFC3339Nano
+	}
+	return opt.TimeFormat
+}
+
+// WriteCSV writes the rows of the datatable as CSV. Times are written with opt.TimeFormat,
+// []byte values in base64 and deleted rows are skipped.
+func (dt *DataTable) WriteCSV(w io.Writer, opt CSVOptions) error {
+	ew, err := newEncodingWriter(w, opt.Encoding)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(ew)
+	fields := make([]string, len(dt.Columns))
+	if !opt.NoHeader {
+		writeCSVLine(bw, dt.ColumnNames(), &opt)
+	}
+	for r := range dt.Rows {
+		if dt.Rows[r].state == RowDeleted {
+			continue
+		}
+		for c := range dt.Columns {
+			fields[c] = csvString(dt.Rows[r].items[c], &opt)
+		}
+		writeCSVLine(bw, fields, &opt)
+	}
+	if err = bw.Flush(); err == nil {
+		err = ew.Flush()
+	}
+	return err
+}
+
+// SaveToCSV saves the datatable to a CSV file
+func (dt *DataTable) SaveToCSV(filename string, opt CSVOptions) error {
+	return writeFile(filename, func(w io.Writer) error { return dt.WriteCSV(w, opt) })
+}
+
+func writeCSVLine(w *bufio.Writer, fields []string, opt *CSVOptions) {
+	comma := opt.comma()
+	for i, f := range fields {
+		if i > 0 {
+			w.WriteRune(comma)
+		}
+		if opt.QuoteAll || f != "" && (strings.ContainsAny(f, "\"\r\n") || strings.ContainsRune(f, comma) || f[0] == ' ' || f[len(f)-1] == ' ') {
+			w.WriteByte('"')
+			w.WriteString(strings.Replace(f, "\"", "\"\"", -1))
+			w.WriteByte('"')
+		} else {
+			w.WriteString(f)
+		}
+	}
+	w.WriteString("\r\n")
+}
+
+// csvString formats a value for a CSV file
+func csvString(v interface{}, opt *CSVOptions) string {
+	switch x := v.(type) {
+	case nil:
+		return opt.Null
+	case string:
+		return x
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		if a := math.Abs(x); a == 0 || a >= 1e-6 && a < 1e21 {
+			return strconv.FormatFloat(x, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(opt.timeFormat())
+	}
+	return fmt.Sprint(v)
+}
+
+// ReadCSV fills the datatable with the content of a CSV file. The values are converted
+// to the types of opt.Columns, or to the inferred types with opt.InferTypes.
+func (dt *DataTable) ReadCSV(r io.Reader, opt CSVOptions) error {
+	dt.Clear()
+	cr, err := newCSVReader(r, &opt)
+	if err != nil {
+		return err
+	}
+	records, lines := [][]string{}, []int{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.New("ReadCSV:" + err.Error())
+		}
+		line, _ := cr.FieldPos(0)
+		records, lines = append(records, record), append(lines, line)
+	}
+	var header []string
+	if !opt.NoHeader && len(records) > 0 {
+		header, records, lines = records[0], records[1:], lines[1:]
+	}
+	switch {
+	case opt.Columns != nil:
+		dt.Columns = append([]DataColumn{}, opt.Columns...)
+	case header != nil:
+		for _, h := range header {
+			dt.Columns = append(dt.Columns, DataColumn{Name: h, Nullable: true})
+		}
+	case len(records) > 0:
+		for i := range records[0] {
+			dt.Columns = append(dt.Columns, DataColumn{Name: "Column" + strconv.Itoa(i+1), Nullable: true})
+		}
+	}
+	for r := range records {
+		if len(records[r]) != len(dt.Columns) {
+			return fmt.Errorf("ReadCSV: line %d has %d fields for %d columns", lines[r], len(records[r]), len(dt.Columns))
+		}
+	}
+	if opt.Columns == nil {
+		for c := range dt.Columns {
+			dt.Columns[c].GoType = TypeString
+			if opt.InferTypes {
+				dt.Columns[c].GoType = inferCSVType(records, c, &opt)
+			}
+		}
+	}
+	dt.initColumns()
+	for r := range records {
+		dr := dt.NewRow()
+		for c := range dt.Columns {
+			v, err := parseCSV(records[r][c], dt.Columns[c].GoType, &opt)
+			if err != nil {
+				return fmt.Errorf("ReadCSV: line %d, column %s: %s", lines[r], dt.Columns[c].Name, err.Error())
+			}
+			dr.items[c] = v
+		}
+		dr.state = RowUnchanged
+		dt.AddRow(dr)
+	}
+	return nil
+}
+
+// LoadFromCSV fills the datatable from a CSV file
+func (dt *DataTable) LoadFromCSV(filename string, opt CSVOptions) error {
+	return readFile(filename, func(r io.Reader) error { return dt.ReadCSV(r, opt) })
+}
+
+func newCSVReader(r io.Reader, opt *CSVOptions) (*csv.Reader, error) {
+	dr, err := newEncodingReader(r, opt.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(dr)
+	cr.Comma = opt.comma()
+	cr.FieldsPerRecord = -1
+	return cr, nil
+}
+
+// inferCSVType returns the narrowest type matching all the values of a column
+func inferCSVType(records [][]string, c int, opt *CSVOptions) string {
+	found := false
+	for _, typ := range []string{TypeInt64, TypeFloat64, TypeBool, TypeTime} {
+		ok := true
+		for r := range records {
+			if records[r][c] == opt.Null {
+				continue
+			}
+			found = true
+			if _, err := parseCSV(records[r][c], typ, opt); err != nil {
+				ok = false
+				break
+			}
+		}
+		if !found {
+			break // Only NULL values
+		}
+		if ok {
+			return typ
+		}
+	}
+	return TypeString
+}
+
+// parseCSV converts a CSV field to a value of a DataTable type
+func parseCSV(s string, typ string, opt *CSVOptions) (interface{}, error) {
+	if s == opt.Null {
+		return nil, nil
+	}
+	switch typ {
+	case TypeInt64:
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case TypeFloat64:
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case TypeBool:
+		return strconv.ParseBool(strings.TrimSpace(s))
+	case TypeTime:
+		if t, err := time.Parse(opt.timeFormat(), strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+		return parseTime(s)
+	case TypeBytes:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return s, nil
+}
+
+// LoadCSV inserts the rows of a CSV file in the table of td, in one transaction, and returns
+// the number of rows inserted. The header line names the columns, by SQL name or field name
+// of td; with opt.NoHeader, the fields are in the order of td. Empty auto-increment keys are
+// generated by the database. Use the TableDefs of a mapper to load a registered table:
+//
+//	n, err := cn.LoadCSV(f, mapper.TableDefs.BySQLName("contact"), sedi.CSVOptions{})
+func (cn *Conn) LoadCSV(r io.Reader, td *TableDef, opt CSVOptions) (int64, error) {
+	if cn == nil || cn.DB == nil {
+		return 0, errors.New("LoadCSV:" + ErrNoConnection.Error())
+	}
+	if td == nil {
+		return 0, errors.New("LoadCSV: table not registered")
+	}
+	cr, err := newCSVReader(r, &opt)
+	if err != nil {
+		return 0, err
+	}
+	fields := []int{}
+	if opt.NoHeader {
+		for i := range td.Fields {
+			fields = append(fields, i)
+		}
+	} else {
+		header, err := cr.Read()
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, errors.New("LoadCSV:" + err.Error())
+		}
+		for _, h := range header {
+			fi := -1
+			for i := range td.Fields {
+				if strings.EqualFold(td.Fields[i].SQLName, h) || strings.EqualFold(td.Fields[i].Name, h) {
+					fi = i
+				}
+			}
+			if fi < 0 {
+				return 0, errors.New("LoadCSV: no column " + h + " in table " + td.SQLName)
+			}
+			fields = append(fields, fi)
+		}
+	}
+	if cn.DisallowConcurency {
+		defer lockWrite.Unlock()
+		defer lockRead.Unlock()
+		lockRead.Lock()
+		lockWrite.Lock()
+	}
+	// Conn.Begin runs the load on the primary and marks the write for read-your-writes
+	tx, err := cn.Begin()
+	if err != nil {
+		return 0, errors.New("LoadCSV:" + err.Error())
+	}
+	n, err := cn.loadCSV(tx.SQLTx(), cr, td, fields, &opt)
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		if LogErrors {
+			log.Print(err.Error())
+		}
+		return 0, errors.New("LoadCSV:" + err.Error())
+	}
+	cn.rowsAffected = n
+	return n, nil
+}
+
+func (cn *Conn) loadCSV(tx *sql.Tx, cr *csv.Reader, td *TableDef, fields []int, opt *CSVOptions) (int64, error) {
+	// Statements with and without the generated key, by position of the key in fields
+	stmts := map[int]*sql.Stmt{}
+	defer func() {
+		for _, s := range stmts {
+			s.Close()
+		}
+	}()
+	var n int64
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(fields) {
+			return n, fmt.Errorf("line %d has %d fields for %d columns", line, len(record), len(fields))
+		}
+		skip := -1
+		args := []interface{}{}
+		for i, fi := range fields {
+			fd := td.Fields[fi]
+			if fd.AutoIncrement && record[i] == opt.Null {
+				skip = i
+				continue
+			}
+			v, err := fieldValue(record[i], fd, opt)
+			if err != nil {
+				return n, fmt.Errorf("line %d, column %s: %s", line, fd.SQLName, err.Error())
+			}
+			if cn.dialect != nil {
+				v = cn.dialect.BindValue(fd, v)
+			}
+			args = append(args, v)
+		}
+		s, ok := stmts[skip]
+		if !ok {
+			cols, parms := []string{}, []string{}
+			for i, fi := range fields {
+				if i != skip {
+					cols = append(cols, cn.quote(td.Fields[fi].SQLName))
+					parms = append(parms, cn.placeholder(len(parms)+1))
+				}
+			}
+			SQL := "insert into " + cn.quote(td.SQLName) + " (" + strings.Join(cols, ", ") + ") values (" + strings.Join(parms, ", ") + ")"
+			if LogAll {
+				log.Print(SQL)
+			}
+			if s, err = tx.Prepare(SQL); err != nil {
+				return n, err
+			}
+			stmts[skip] = s
+		}
+		if _, err = s.Exec(args...); err != nil {
+			return n, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		n++
+	}
+}
+
+// fieldValue converts a CSV field to the Go type of a structure field
+func fieldValue(s string, fd FieldDef, opt *CSVOptions) (interface{}, error) {
+	if s == opt.Null && fd.GoTypeName != "string" {
+		return nil, nil
+	}
+	switch fd.GoTypeName {
+	case "int", "int8", "int16", "int32", "int64":
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	case "float32", "float64":
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case "bool":
+		return strconv.ParseBool(strings.TrimSpace(s))
+	case "Time":
+		return parseCSV(s, TypeTime, opt)
+	}
+	return s, nil
+}
+
+// windows1252 holds the characters of the bytes 0x80 to 0x9F of windows-1252
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ'}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func checkEncoding(encoding string) (string, error) {
+	switch e := strings.ToLower(strings.Replace(encoding, "_", "-", -1)); e {
+	case "", "utf-8", "utf8":
+		return "utf-8", nil
+	case "utf-8-bom", "utf8-bom":
+		return "utf-8-bom", nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return "latin1", nil
+	case "windows-1252", "cp1252":
+		return "windows-1252", nil
+	}
+	return "", errors.New("sedi: unsupported encoding " + encoding)
+}
+
+// newEncodingReader returns a reader converting r to UTF-8, without byte order mark
+func newEncodingReader(r io.Reader, encoding string) (io.Reader, error) {
+	e, err := checkEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	if e == "utf-8" || e == "utf-8-bom" {
+		if b, err := br.Peek(3); err == nil && bytes.Equal(b, utf8BOM) {
+			br.Discard(3)
+		}
+		return br, nil
+	}
+	return &encodingReader{r: br, encoding: e}, nil
+}
+
+// encodingReader converts latin1 or windows-1252 text to UTF-8 as it is read
+type encodingReader struct {
+	r        io.Reader
+	encoding string
+	err      error  // Error of the last read of r, returned once pending is empty
+	in, out  []byte // Bytes read from r, and their conversion
+	pending  []byte // Converted text not returned yet
+}
+
+func (er *encodingReader) Read(p []byte) (int, error) {
+	for len(er.pending) == 0 {
+		if er.err != nil {
+			return 0, er.err
+		}
+		if er.in == nil {
+			er.in = make([]byte, 4096)
+		}
+		var n int
+		n, er.err = er.r.Read(er.in)
+		er.out = er.out[:0]
+		var buf [utf8.UTFMax]byte
+		for _, b := range er.in[:n] {
+			r := rune(b)
+			if b >= 0x80 && b < 0xA0 && er.encoding == "windows-1252" {
+				r = windows1252[b-0x80]
+			}
+			er.out = append(er.out, buf[:utf8.EncodeRune(buf[:], r)]...)
+		}
+		er.pending = er.out
+	}
+	n := copy(p, er.pending)
+	er.pending = er.pending[n:]
+	return n, nil
+}
+
+// encodingWriter converts UTF-8 text to an encoding
+type encodingWriter struct {
+	w        io.Writer
+	encoding string
+	bom      bool   // The byte order mark must be written
+	partial  []byte // Start of a rune split between two writes
+}
+
+func newEncodingWriter(w io.Writer, encoding string) (*encodingWriter, error) {
+	e, err := checkEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &encodingWriter{w: w, encoding: e, bom: e == "utf-8-bom"}, nil
+}
+
+func (ew *encodingWriter) Write(p []byte) (int, error) {
+	if ew.bom {
+		ew.bom = false
+		if _, err := ew.w.Write(utf8BOM); err != nil {
+			return 0, err
+		}
+	}
+	if ew.encoding == "utf-8" || ew.encoding == "utf-8-bom" {
+		return ew.w.Write(p)
+	}
+	b := append(ew.partial, p...)
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		if !utf8.FullRune(b) {
+			break
+		}
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		out = append(out, ew.encodeRune(r))
+	}
+	ew.partial = append([]byte{}, b...)
+	if _, err := ew.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// encodeRune returns the byte of a character, '?' when the encoding does not have it
+func (ew *encodingWriter) encodeRune(r rune) byte {
+	if ew.encoding == "windows-1252" {
+		if r >= 0x80 && r < 0xA0 {
+			return '?'
+		}
+		for i, x := range windows1252 {
+			if x == r && (r < 0x80 || r >= 0xA0) {
+				return byte(0x80 + i)
+			}
+		}
+	}
+	if r < 0x100 {
+		return byte(r)
+	}
+	return '?'
+}
+
+// Flush writes an incomplete character left by the last write
+func (ew *encodingWriter) Flush() error {
+	if ew.bom {
+		ew.Write(nil)
+	}
+	if len(ew.partial) > 0 {
+		ew.partial = nil
+		_, err := ew.w.Write([]byte{'?'})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// csvTable returns a table whose values all have the type of their column
+func csvTable() *DataTable {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "s", GoType: TypeString},
+		{Name: "i", GoType: TypeInt64},
+		{Name: "f", GoType: TypeFloat64},
+		{Name: "b", GoType: TypeBool},
+		{Name: "t", GoType: TypeTime},
+		{Name: "y", GoType: TypeBytes},
+	}}
+	rows := [][]interface{}{
+		{"a, \"b\"\nc", int64(-12), 1e-9, true, time.Date(2017, 3, 4, 5, 6, 7, 123, time.UTC), []byte{0, 255}},
+		{" é€ ", int64(1) << 62, 1e21, false, time.Date(2017, 3, 4, 5, 6, 7, 0, time.FixedZone("X", 3600)), []byte("x")},
+		{nil, nil, nil, nil, nil, nil},
+		{"", int64(0), 0.5, true, time.Time{}, []byte{}},
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	for _, opt := range []CSVOptions{
+		{Null: "NULL"},
+		{Null: "\\N", Comma: ';', QuoteAll: true, Encoding: "utf-8-bom"},
+		{Null: "NULL", Comma: '\t', NoHeader: true, TimeFormat: "2006-01-02 15:04:05.999999999Z07:00"},
+	} {
+		dt := csvTable()
+		var b bytes.Buffer
+		if err := dt.WriteCSV(&b, opt); err != nil {
+			t.Fatal(err)
+		}
+		if opt.Encoding == "utf-8-bom" && !bytes.HasPrefix(b.Bytes(), utf8BOM) {
+			t.Error("no byte order mark")
+		}
+		var got DataTable
+		opt.Columns = dt.Columns
+		if err := got.ReadCSV(&b, opt); err != nil {
+			t.Fatalf("%+v: %v", opt, err)
+		}
+		sameRows(t, &got, dt)
+	}
+}
+
+func TestCSVNull(t *testing.T) {
+	// With the default options, empty strings are read as NULL
+	dt := csvTable()
+	var b bytes.Buffer
+	dt.WriteCSV(&b, CSVOptions{})
+	var got DataTable
+	if err := got.ReadCSV(&b, CSVOptions{Columns: dt.Columns}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rows[3].items[0] != nil {
+		t.Errorf("empty string read as %#v", got.Rows[3].items[0])
+	}
+}
+
+func TestCSVFormat(t *testing.T) {
+	dt := csvTable()
+	var b bytes.Buffer
+	dt.WriteCSV(&b, CSVOptions{Null: "NULL"})
+	lines := strings.Split(b.String(), "\r\n")
+	want := []string{
+		"s,i,f,b,t,y",
+		"\"a, \"\"b\"\"\nc\",-12,1e-09,true,2017-03-04T05:06:07.000000123Z,AP8=",
+		"\" é€ \",4611686018427387904,1e+21,false,2017-03-04T05:06:07+01:00,eA==",
+		"NULL,NULL,NULL,NULL,NULL,NULL",
+		",0,0.5,true,0001-01-01T00:00:00Z,",
+		"",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestCSVInferTypes(t *testing.T) {
+	src := "id,rate,ok,day,name,empty\n1,1.5,true,2017-03-04,x,\n2,2,FALSE,2017-03-05 10:00:00,12,\n"
+	var dt DataTable
+	if err := dt.ReadCSV(strings.NewReader(src), CSVOptions{InferTypes: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{TypeInt64, TypeFloat64, TypeBool, TypeTime, TypeString, TypeString}
+	for c, w := range want {
+		if dt.Columns[c].GoType != w {
+			t.Errorf("column %s is %s, want %s", dt.Columns[c].Name, dt.Columns[c].GoType, w)
+		}
+	}
+	if dt.Rows[1].items[1] != 2.0 || dt.Rows[1].items[2] != false || dt.Rows[1].items[4] != "12" || dt.Rows[0].items[5] != nil {
+		t.Errorf("row 1: %#v", dt.Rows[1].items)
+	}
+
+	// Without InferTypes, values are strings; without header, columns are numbered
+	if err := dt.ReadCSV(strings.NewReader(src), CSVOptions{NoHeader: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dt.Rows) != 3 || dt.Columns[0].Name != "Column1" || dt.Rows[1].items[0] != "1" {
+		t.Errorf("no header: %v %#v", dt.ColumnNames(), dt.Rows[1].items)
+	}
+}
+
+func TestCSVEncodings(t *testing.T) {
+	dt := &DataTable{Columns: []DataColumn{{Name: "s", GoType: TypeString}}}
+	dr := dt.NewRow()
+	dr.items[0] = "é€ŒÿĀ"
+	dt.AddRow(dr)
+	tests := []struct {
+		encoding string
+		bytes    string
+		read     string
+	}{
+		{"latin1", "\xe9??\xff?", "é??ÿ?"},
+		{"windows-1252", "\xe9\x80\x8c\xff?", "é€Œÿ?"},
+	}
+	for _, x := range tests {
+		var b bytes.Buffer
+		if err := dt.WriteCSV(&b, CSVOptions{Encoding: x.encoding, NoHeader: true}); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(b.String(), "\r\n"); got != x.bytes {
+			t.Errorf("%s: wrote %q, want %q", x.encoding, got, x.bytes)
+		}
+		var got DataTable
+		if err := got.ReadCSV(&b, CSVOptions{Encoding: x.encoding, NoHeader: true}); err != nil {
+			t.Fatal(err)
+		}
+		if got.Rows[0].items[0] != x.read {
+			t.Errorf("%s: read %q, want %q", x.encoding, got.Rows[0].items[0], x.read)
+		}
+	}
+
+	// A UTF-8 byte order mark is skipped
+	var got DataTable
+	if err := got.ReadCSV(strings.NewReader("\xef\xbb\xbfname\né\n"), CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Columns[0].Name != "name" || got.Rows[0].items[0] != "é" {
+		t.Errorf("bom: %v %#v", got.ColumnNames(), got.Rows[0].items)
+	}
+	if err := got.ReadCSV(strings.NewReader("a"), CSVOptions{Encoding: "ebcdic"}); err == nil {
+		t.Error("unknown encoding")
+	}
+}
+
+func TestCSVErrors(t *testing.T) {
+	var dt DataTable
+	err := dt.ReadCSV(strings.NewReader("a,b\n1,2\n3\n"), CSVOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("field count: %v", err)
+	}
+	err = dt.ReadCSV(strings.NewReader("a\nx\n"), CSVOptions{Columns: []DataColumn{{Name: "a", GoType: TypeInt64}}})
+	if err == nil || !strings.Contains(err.Error(), "column a") {
+		t.Errorf("conversion: %v", err)
+	}
+	if err = dt.ReadCSV(strings.NewReader("a\n\"x\n"), CSVOptions{}); err == nil {
+		t.Error("unterminated quote")
+	}
+}
+
+func TestCSVEncodingErrors(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	for _, encoding := range []string{"latin1", "windows-1252"} {
+		for i := 0; i < 20; i++ {
+			var dt DataTable
+			err := dt.ReadCSV(strings.NewReader("a\n\xe9\"x\n"+strings.Repeat("\xe9\n", 5000)), CSVOptions{Encoding: encoding})
+			if err == nil {
+				t.Fatalf("%s: bare quote accepted", encoding)
+			}
+		}
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d go routines left after the errors", n-goroutines)
+	}
+
+	// Reads larger than the buffer of the conversion
+	var dt DataTable
+	in := "a\n" + strings.Repeat("\x80\xe9\n", 5000)
+	if err := dt.ReadCSV(strings.NewReader(in), CSVOptions{Encoding: "windows-1252"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dt.Rows) != 5000 || dt.Rows[4999].items[0] != "€é" {
+		t.Errorf("%d rows, last %q", len(dt.Rows), dt.Rows[len(dt.Rows)-1].items[0])
+	}
+}
+
+func TestCSVFile(t *testing.T) {
+	dt := csvTable()
+	fn := filepath.Join(t.TempDir(), "table.csv")
+	opt := CSVOptions{Null: "NULL", Encoding: "windows-1252"}
+	if err := dt.SaveToCSV(fn, opt); err != nil {
+		t.Fatal(err)
+	}
+	var got DataTable
+	opt.Columns = dt.Columns
+	if err := got.LoadFromCSV(fn, opt); err != nil {
+		t.Fatal(err)
+	}
+	sameRows(t, &got, dt)
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrConcurrency is returned by DataAdapter.Update when a row was changed or deleted
+// in the database since it was read
+var ErrConcurrency = errors.New("Row was changed by another user")
+
+// DataAdapter saves the changes of a DataTable to a database table
+type DataAdapter struct {
+	Conn  *Conn
+	Table string
+	// Key holds the primary key columns. Updates and deletes find the row by the original
+	// values of these columns and check that the other columns still have their original
+	// values, binary and float columns excepted: these do not compare reliably.
+	// Without key, the row is found by all its other columns.
+	Key []string
+	// LastWriterWins disables the check of the columns that are not part of the key:
+	// changes made in the database since the row was read are overwritten.
+	LastWriterWins bool
+	// Identity is the column generated by the database on insert, "" if none.
+	// It is left out of insert statements when NULL and set to the generated value.
+	Identity string
+}
+
+// NewDataAdapter returns a DataAdapter for a table with the given primary key columns
+func NewDataAdapter(cn *Conn, table string, key ...string) *DataAdapter {
+	return &DataAdapter{Conn: cn, Table: table, Key: key}
+}
+
+// Update inserts, updates and deletes the rows of dt according to their state, in the order
+// of the rows, in one transaction. When all the changes are saved, the rows are accepted;
+// after an error none is saved and the rows keep their state. A row changed in the database
+// since it was read (optimistic concurrency) stops the update with ErrConcurrency.
+func (da *DataAdapter) Update(dt *DataTable) error {
+	if da.Conn == nil || da.Conn.DB == nil {
+		return errors.New("DataAdapter:" + ErrNoConnection.Error())
+	}
+	tx, err := da.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	identity := -1
+	if da.Identity != "" {
+		identity = dt.ColumnIndex(da.Identity)
+	}
+	ids := map[int]interface{}{} // Generated identity of the inserted rows, set after the commit
+	for i := range dt.Rows {
+		dr := &dt.Rows[i]
+		switch dr.State() {
+		case RowAdded:
+			var id interface{}
+			if id, err = da.insert(tx, dt, dr, identity); id != nil {
+				ids[i] = id
+			}
+		case RowModified:
+			err = da.update(tx, dt, dr)
+		case RowDeleted:
+			err = da.delete(tx, dt, dr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		dt.Rows[i].items[identity] = id
+	}
+	dt.AcceptChanges()
+	return nil
+}
+
+func (da *DataAdapter) quote(name string) string {
+	return da.Conn.quote(name)
+}
+
+func (da *DataAdapter) placeholder(n int) string {
+	return da.Conn.placeholder(n)
+}
+
+// bind converts a value of column c to a statement argument with the dialect of the connection
+func (da *DataAdapter) bind(c *DataColumn, v interface{}) interface{} {
+	d := da.Conn.Dialect()
+	if d == nil {
+		return v
+	}
+	goType := c.GoType
+	if goType == TypeTime {
+		goType = "Time"
+	}
+	return d.BindValue(FieldDef{Name: c.Name, SQLName: c.Name, GoTypeName: goType}, v)
+}
+
+// insert inserts a row and returns the value generated for the identity column, nil if none
+func (da *DataAdapter) insert(tx *Tx, dt *DataTable, dr *DataRow, identity int) (interface{}, error) {
+	cols, parms, args := []string{}, []string{}, []interface{}{}
+	for c := range dt.Columns {
+		if c == identity && dr.items[c] == nil {
+			continue
+		}
+		cols = append(cols, da.quote(dt.Columns[c].Name))
+		args = append(args, da.bind(&dt.Columns[c], dr.items[c]))
+		parms = append(parms, da.placeholder(len(args)))
+	}
+	SQL := "insert into " + da.quote(da.Table) + " (" + strings.Join(cols, ", ") + ") values (" + strings.Join(parms, ", ") + ")"
+	if identity < 0 || dr.items[identity] != nil {
+		_, err := tx.ExecArgs(SQL, args...)
+		return nil, err
+	}
+	if r, ok := da.Conn.Dialect().(Returning); ok {
+		td := TableDef{SQLName: da.Table, Fields: FieldDefs{{Name: da.Identity, SQLName: da.Identity, PrimaryKey: true, AutoIncrement: true}}}
+		row, err := tx.GetSingleRowArgs(SQL+" "+r.ReturningSQL(td), args...)
+		if err != nil {
+			return nil, err
+		}
+		return dt.Columns[identity].convert(row.items[0]), nil
+	}
+	result, err := tx.ExecArgs(SQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (da *DataAdapter) update(tx *Tx, dt *DataTable, dr *DataRow) error {
+	set, args := []string{}, []interface{}{}
+	for c := range dt.Columns {
+		if !sameValue(dr.items[c], dr.original[c]) {
+			args = append(args, da.bind(&dt.Columns[c], dr.items[c]))
+			set = append(set, da.quote(dt.Columns[c].Name)+" = "+da.placeholder(len(args)))
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	where, args, err := da.where(dt, dr, args)
+	if err != nil {
+		return err
+	}
+	return da.exec(tx, "update "+da.quote(da.Table)+" set "+strings.Join(set, ", ")+where, args)
+}
+
+func (da *DataAdapter) delete(tx *Tx, dt *DataTable, dr *DataRow) error {
+	where, args, err := da.where(dt, dr, nil)
+	if err != nil {
+		return err
+	}
+	return da.exec(tx, "delete from "+da.quote(da.Table)+where, args)
+}
+
+// where returns the condition finding a row by its original values
+func (da *DataAdapter) where(dt *DataTable, dr *DataRow, args []interface{}) (string, []interface{}, error) {
+	cond := []string{}
+	for c := range dt.Columns {
+		t := dt.Columns[c].GoType
+		if !da.isKey(dt.Columns[c].Name) && (t == TypeBytes || t == TypeFloat64 || da.LastWriterWins && len(da.Key) > 0) {
+			continue
+		}
+		v := da.bind(&dt.Columns[c], dr.original[c])
+		if v == nil {
+			cond = append(cond, da.quote(dt.Columns[c].Name)+" is null")
+			continue
+		}
+		args = append(args, v)
+		cond = append(cond, da.quote(dt.Columns[c].Name)+" = "+da.placeholder(len(args)))
+	}
+	if len(cond) == 0 {
+		return "", nil, errors.New("DataAdapter: no column can find the rows of " + da.Table + ", set its Key")
+	}
+	return " where " + strings.Join(cond, " and "), args, nil
+}
+
+func (da *DataAdapter) isKey(name string) bool {
+	for _, k := range da.Key {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs an update or a delete, ErrConcurrency if it did not find the row.
+// The driver must count the rows found by an update even when their values do not
+// change: MySQL counts the rows changed unless clientFoundRows is set, which sedi.Open does.
+func (da *DataAdapter) exec(tx *Tx, SQL string, args []interface{}) error {
+	result, err := tx.ExecArgs(SQL, args...)
+	if err != nil {
+		return err
+	}
+	if n, e := result.RowsAffected(); e == nil && n == 0 {
+		return fmt.Errorf("DataAdapter: %w (%s)", ErrConcurrency, SQL)
+	}
+	return nil
+}
+
+// sameValue tells if two values of a DataRow are equal. Times are equal when they are
+// the same instant, whatever their location.
+func sameValue(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	case []byte:
+		y, ok := b.([]byte)
+		return ok && bytes.Equal(x, y)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+// openCustomers opens a database in memory with two customers, and reads them in a table
+func openCustomers(t *testing.T) (*sedi.Conn, *sedi.DataTable) {
+	sedi.LogErrors = false
+	memory.Drop("dataadapter_test")
+	m, err := sedi.Open("memory://dataadapter_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := m.Connection()
+	t.Cleanup(func() {
+		m.CloseConnection()
+		memory.Drop("dataadapter_test")
+	})
+	for _, s := range []string{
+		"create table customer (id integer primary key autoincrement, name varchar(20) not null, since datetime)",
+		"insert into customer (name, since) values ('acme', '2017-03-04 05:06:07'), ('bolt', null)"} {
+		if err = cn.ExecNoResult(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dt, err := cn.GetDataTable("select id, name, since from customer order by id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cn, &dt
+}
+
+func names(t *testing.T, cn *sedi.Conn) string {
+	t.Helper()
+	dt, err := cn.GetDataTable("select name from customer order by id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ""
+	for i := range dt.Rows {
+		s += dt.Rows[i].GetStringOr("name", "?") + " "
+	}
+	return s
+}
+
+func TestRowStates(t *testing.T) {
+	_, dt := openCustomers(t)
+	if dt.HasChanges() || dt.Rows[0].State() != sedi.RowUnchanged {
+		t.Fatal("changes after fill")
+	}
+	if err := dt.Rows[0].Set("name", "ACME"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dt.Rows[0].Original("name"); v != "acme" || dt.Rows[0].State() != sedi.RowModified {
+		t.Errorf("original %v, state %v", v, dt.Rows[0].State())
+	}
+	dr := dt.NewRow()
+	if dr.State() != sedi.RowAdded {
+		t.Errorf("new row %v", dr.State())
+	}
+	if _, err := dr.Original("name"); err == nil {
+		t.Error("added row with original value")
+	}
+	dt.AddRow(dr)
+	dt.DeleteRow(1)
+	if dt.Rows[1].State() != sedi.RowDeleted || dt.Rows[1].Set("name", "x") == nil {
+		t.Error("deleted row")
+	}
+	dt.DeleteRow(2) // An added row is removed at once
+	if len(dt.Rows) != 2 || !dt.HasChanges() {
+		t.Fatalf("%d rows", len(dt.Rows))
+	}
+	dt.RejectChanges()
+	if dt.HasChanges() || dt.Rows[0].GetStringOr("name", "") != "acme" || dt.Rows[1].State() != sedi.RowUnchanged {
+		t.Error("RejectChanges")
+	}
+	dt.DeleteRow(0)
+	dt.AcceptChanges()
+	if len(dt.Rows) != 1 || dt.Rows[0].GetStringOr("name", "") != "bolt" {
+		t.Error("AcceptChanges")
+	}
+	if sedi.RowModified.String() != "Modified" {
+		t.Error(sedi.RowModified.String())
+	}
+}
+
+func TestDataAdapter(t *testing.T) {
+	cn, dt := openCustomers(t)
+	da := sedi.NewDataAdapter(cn, "customer", "id")
+	da.Identity = "id"
+
+	dt.Rows[0].Set("name", "ACME")
+	dt.DeleteRow(1)
+	dr := dt.NewRow()
+	dr.Set("name", "cork")
+	dr.Set("since", time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	dt.AddRow(dr)
+	if err := da.Update(dt); err != nil {
+		t.Fatal(err)
+	}
+	if s := names(t, cn); s != "ACME cork " {
+		t.Errorf("database has %s", s)
+	}
+	if len(dt.Rows) != 2 || dt.HasChanges() || dt.Rows[1].GetInt64Or("id", 0) != 3 {
+		t.Errorf("table after update: %d rows, id %v", len(dt.Rows), dt.Rows[1].Items())
+	}
+
+	// Times are found by their original value, whatever their location
+	tm, _ := dt.Rows[1].GetTime("since")
+	dt.Rows[1].Set("since", tm.In(time.FixedZone("X", 3600)))
+	dt.Rows[1].Set("name", "Cork")
+	if err := da.Update(dt); err != nil {
+		t.Fatal(err)
+	}
+	if s := names(t, cn); s != "ACME Cork " {
+		t.Errorf("database has %s", s)
+	}
+}
+
+func TestDataAdapterConcurrency(t *testing.T) {
+	cn, dt := openCustomers(t)
+	da := sedi.NewDataAdapter(cn, "customer", "id")
+	if err := cn.ExecNoResult("update customer set name = 'other' where id = 2", nil); err != nil {
+		t.Fatal(err)
+	}
+	dt.Rows[0].Set("name", "ACME")
+	dt.Rows[1].Set("name", "BOLT")
+	err := da.Update(dt)
+	if !errors.Is(err, sedi.ErrConcurrency) {
+		t.Fatalf("got %v", err)
+	}
+	// Nothing was saved and the rows keep their changes
+	if s := names(t, cn); s != "acme other " {
+		t.Errorf("database has %s", s)
+	}
+	if dt.Rows[0].State() != sedi.RowModified || dt.Rows[1].State() != sedi.RowModified {
+		t.Error("rows accepted after the error")
+	}
+
+	// The last writer wins without check of the other columns
+	da.LastWriterWins = true
+	if err = da.Update(dt); err != nil {
+		t.Fatal(err)
+	}
+	if s := names(t, cn); s != "ACME BOLT " {
+		t.Errorf("database has %s", s)
+	}
+
+	if err = sedi.NewDataAdapter(nil, "customer").Update(dt); err == nil {
+		t.Error("update without connection")
+	}
+}
+
+func TestDataAdapterRowCopies(t *testing.T) {
+	cn, dt := openCustomers(t)
+	for _, r := range dt.Rows {
+		r.Set("name", "x"+r.GetStringOr("name", ""))
+	}
+	if err := sedi.NewDataAdapter(cn, "customer", "ID").Update(dt); err != nil {
+		t.Fatal(err)
+	}
+	if s := names(t, cn); s != "xacme xbolt " {
+		t.Errorf("database has %s", s)
+	}
+}
+
+func TestDataAdapterFloats(t *testing.T) {
+	cn, _ := openCustomers(t)
+	for _, s := range []string{
+		"create table item (code varchar(10), price real)",
+		"insert into item (code, price) values ('a', 0.1), ('b', 0.2)"} {
+		if err := cn.ExecNoResult(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dt, err := cn.GetDataTable("select code, price from item order by code", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Float columns are left out of the condition, as REAL values may not read back exactly
+	if err = cn.ExecNoResult("update item set price = 0.30000000000000004 where code = 'b'", nil); err != nil {
+		t.Fatal(err)
+	}
+	dt.Rows[1].Set("price", 0.3)
+	dt.DeleteRow(0)
+	if err = sedi.NewDataAdapter(cn, "item").Update(&dt); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := cn.GetScalar("select count(*) from item where price = 0.3", nil); n != int64(1) {
+		t.Errorf("%v rows updated", n)
+	}
+
+	only, err := cn.GetDataTable("select price from item", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	only.Rows[0].Set("price", 1.5)
+	if err = sedi.NewDataAdapter(cn, "item").Update(&only); err == nil || errors.Is(err, sedi.ErrConcurrency) {
+		t.Errorf("row found without condition: %v", err)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefpo/sedi/conv"
+)
+
+// Go types of the values stored in a DataTable
+const (
+	TypeString  = "string"
+	TypeInt64   = "int64"
+	TypeFloat64 = "float64"
+	TypeBool    = "bool"
+	TypeTime    = "time.Time"
+	TypeBytes   = "[]byte"
+)
+
+// DataColumn describes a column of a DataTable
+type DataColumn struct {
+	Name      string `json:"name"`
+	DBType    string `json:"dbType,omitempty"` // Type name reported by the database (INTEGER, VARCHAR...)
+	GoType    string `json:"goType,omitempty"` // Type of the values (TypeString, TypeInt64...), "" when unknown
+	Nullable  bool   `json:"nullable"`         // True when the driver does not tell
+	Length    int64  `json:"length,omitempty"` // Length of text and binary columns, 0 when unknown
+	Precision int64  `json:"precision,omitempty"`
+	Scale     int64  `json:"scale,omitempty"`
+}
+
+// NewDataColumn returns the column described by a sql.ColumnType
+func NewDataColumn(ct *sql.ColumnType) DataColumn {
+	c := DataColumn{Name: ct.Name(), DBType: ct.DatabaseTypeName(), Nullable: true}
+	c.GoType = goTypeOf(ct.ScanType())
+	if n, ok := ct.Nullable(); ok {
+		c.Nullable = n
+	}
+	if l, ok := ct.Length(); ok {
+		c.Length = l
+	}
+	if p, s, ok := ct.DecimalSize(); ok {
+		c.Precision, c.Scale = p, s
+	}
+	if c.GoType == "" || c.GoType == TypeBytes {
+		c.GoType = goTypeOfDBType(c.DBType, c.GoType)
+	}
+	return c
+}
+
+var (
+	typeTime     = reflect.TypeOf(time.Time{})
+	typeNullTime = reflect.TypeOf(sql.NullTime{})
+	typeNullInt  = []reflect.Type{reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}),
+		reflect.TypeOf(sql.NullInt16{}), reflect.TypeOf(sql.NullByte{})}
+	typeNullFloat  = reflect.TypeOf(sql.NullFloat64{})
+	typeNullBool   = reflect.TypeOf(sql.NullBool{})
+	typeNullString = reflect.TypeOf(sql.NullString{})
+)
+
+// goTypeOf returns the DataTable type of the values of a driver scan type
+func goTypeOf(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case typeTime, typeNullTime:
+		return TypeTime
+	case typeNullFloat:
+		return TypeFloat64
+	case typeNullBool:
+		return TypeBool
+	case typeNullString:
+		return TypeString
+	}
+	for _, x := range typeNullInt {
+		if t == x {
+			return TypeInt64
+		}
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeInt64
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat64
+	case reflect.Bool:
+		return TypeBool
+	case reflect.String:
+		return TypeString
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return TypeBytes
+		}
+	}
+	return ""
+}
+
+// goTypeOfDBType completes the type given by the driver with the database type name:
+// drivers often return text columns as []byte
+func goTypeOfDBType(dbType string, goType string) string {
+	t := strings.ToLower(dbType)
+	switch {
+	case t == "":
+		return goType
+	case strings.Contains(t, "blob"), strings.Contains(t, "binary"), t == "bytea", t == "bit":
+		return TypeBytes
+	case strings.Contains(t, "char"), strings.Contains(t, "text"), strings.Contains(t, "json"),
+		t == "enum", t == "set", t == "uuid":
+		return TypeString
+	case strings.Contains(t, "bool"):
+		return TypeBool
+	case isIntegerType(t):
+		return TypeInt64
+	case strings.Contains(t, "float"), strings.Contains(t, "double"), t == "real":
+		return TypeFloat64
+	case strings.Contains(t, "decimal"), strings.Contains(t, "numeric"):
+		return TypeString // Kept as text to avoid rounding
+	case strings.Contains(t, "date"), strings.Contains(t, "time"):
+		return TypeTime
+	}
+	return goType
+}
+
+// integerTypes are the integer types of the databases, without size nor sign
+var integerTypes = map[string]bool{"int": true, "integer": true, "bigint": true, "smallint": true,
+	"tinyint": true, "mediumint": true, "int2": true, "int4": true, "int8": true, "serial": true,
+	"bigserial": true, "smallserial": true, "serial2": true, "serial4": true, "serial8": true, "year": true}
+
+// isIntegerType tells if a lower case database type holds integers, "int(11) unsigned" for instance
+func isIntegerType(t string) bool {
+	if p, q := strings.Index(t, "("), strings.Index(t, ")"); p >= 0 && q > p {
+		t = t[:p] + t[q+1:]
+	}
+	t = strings.TrimSpace(strings.Replace(strings.Replace(t, "unsigned", "", 1), "zerofill", "", 1))
+	return integerTypes[t]
+}
+
+// convert converts a value read from the driver to the type of the column.
+// Values that cannot be converted are kept, []byte becomes string unless the column holds bytes.
+func (c *DataColumn) convert(v interface{}) interface{} {
+	b, isBytes := v.([]byte)
+	if !isBytes {
+		if c.GoType == TypeInt64 {
+			switch x := v.(type) {
+			case int, int8, int16, int32, uint8, uint16, uint32:
+				return conv.ToInt64(x)
+			case uint, uint64:
+				// Kept above math.MaxInt64, which int64 cannot hold
+				if u := conv.ToUint64(x); u <= math.MaxInt64 {
+					return int64(u)
+				}
+			}
+		}
+		if c.GoType == TypeFloat64 {
+			if x, ok := v.(float32); ok {
+				return float64(x)
+			}
+		}
+		return v
+	}
+	if c.GoType == TypeBytes {
+		return b
+	}
+	s := string(b)
+	switch c.GoType {
+	case TypeInt64:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case TypeFloat64:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case TypeBool:
+		if x, err := strconv.ParseBool(s); err == nil {
+			return x
+		}
+	case TypeTime:
+		if t, err := parseTime(s); err == nil {
+			return t
+		}
+	}
+	return s
+}
+
+var timeLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999 -0700 MST", "2006-01-02", "15:04:05"}
+
+// parseTime parses the time formats used by the databases
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, l := range timeLayouts {
+		var t time.Time
+		if t, err = time.Parse(l, strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// valueType returns the DataTable type of a value, "" for other types
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return TypeString
+	case int64:
+		return TypeInt64
+	case float64:
+		return TypeFloat64
+	case bool:
+		return TypeBool
+	case time.Time:
+		return TypeTime
+	case []byte:
+		return TypeBytes
+	}
+	return ""
+}
+
+// jsonHint is a value written with its type, see JSONVersion
+type jsonHint struct {
+	Type  string          `json:"$type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonValue returns the JSON representation of a value of the column
+func (c *DataColumn) jsonValue(v interface{}) interface{} {
+	t := valueType(v)
+	if t == "" {
+		return v
+	}
+	enc := v
+	special := false
+	switch x := v.(type) {
+	case time.Time:
+		enc = x.Format(time.RFC3339Nano)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			enc, special = strconv.FormatFloat(x, 'g', -1, 64), true
+		}
+	}
+	// Strings, booleans and integers are read back as such in columns without type
+	if !special && (t == c.GoType || c.GoType == "" && (t == TypeString || t == TypeBool || t == TypeInt64)) {
+		return enc
+	}
+	b, _ := json.Marshal(enc)
+	return jsonHint{Type: t, Value: b}
+}
+
+// fromJSON decodes a value of the column. typed tells if the value was written with
+// the types of the columns; otherwise values that do not match the column are kept as they are.
+func (c *DataColumn) fromJSON(raw json.RawMessage, typed bool) (interface{}, error) {
+	raw = bytes.TrimSpace(raw)
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	if typed && len(raw) > 0 && raw[0] == '{' {
+		var h jsonHint
+		if e := json.Unmarshal(raw, &h); e != nil || h.Type == "" {
+			return nil, errors.New("invalid typed value " + string(raw))
+		}
+		return decodeJSON(h.Value, h.Type)
+	}
+	v, e := decodeJSON(raw, c.GoType)
+	if e != nil && !typed {
+		return decodeJSON(raw, "")
+	}
+	return v, e
+}
+
+// decodeJSON decodes a value of the given type, any type if typ is ""
+func decodeJSON(raw json.RawMessage, typ string) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v interface{}
+	if e := d.Decode(&v); e != nil {
+		return nil, e
+	}
+	if v == nil {
+		return nil, nil
+	}
+	n, isNumber := v.(json.Number)
+	str, isString := v.(string)
+	switch typ {
+	case "":
+		if isNumber {
+			if i, e := n.Int64(); e == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+		return v, nil
+	case TypeInt64:
+		if isNumber {
+			return n.Int64()
+		}
+	case TypeFloat64:
+		if isNumber {
+			return n.Float64()
+		}
+		if isString {
+			return strconv.ParseFloat(str, 64)
+		}
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case TypeString:
+		if isString {
+			return str, nil
+		}
+	case TypeTime:
+		if isString {
+			if t, e := time.Parse(time.RFC3339Nano, str); e == nil {
+				return t, nil
+			}
+			return parseTime(str)
+		}
+	case TypeBytes:
+		if isString {
+			var b []byte
+			e := json.Unmarshal(raw, &b)
+			return b, e
+		}
+	default:
+		return nil, errors.New("unknown type " + typ)
+	}
+	return nil, errors.New("invalid " + typ + " value " + string(raw))
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"database/sql"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGoTypeOf(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{int32(0), TypeInt64},
+		{uint8(0), TypeInt64},
+		{sql.NullInt32{}, TypeInt64},
+		{float32(0), TypeFloat64},
+		{sql.NullFloat64{}, TypeFloat64},
+		{sql.NullBool{}, TypeBool},
+		{"", TypeString},
+		{sql.NullString{}, TypeString},
+		{time.Time{}, TypeTime},
+		{&time.Time{}, TypeTime},
+		{sql.NullTime{}, TypeTime},
+		{[]byte{}, TypeBytes},
+		{sql.RawBytes{}, TypeBytes},
+		{struct{}{}, ""},
+		{[]int{}, ""},
+	}
+	for _, x := range tests {
+		if got := goTypeOf(reflect.TypeOf(x.v)); got != x.want {
+			t.Errorf("goTypeOf(%T) = %q, want %q", x.v, got, x.want)
+		}
+	}
+	if got := goTypeOf(nil); got != "" {
+		t.Errorf("goTypeOf(nil) = %q", got)
+	}
+}
+
+func TestGoTypeOfDBType(t *testing.T) {
+	tests := []struct{ dbType, goType, want string }{
+		{"VARCHAR", TypeBytes, TypeString},
+		{"TEXT", "", TypeString},
+		{"JSONB", TypeBytes, TypeString},
+		{"BLOB", TypeBytes, TypeBytes},
+		{"BYTEA", "", TypeBytes},
+		{"BIGINT", TypeBytes, TypeInt64},
+		{"UNSIGNED BIGINT", "", TypeInt64},
+		{"int(11) unsigned", TypeBytes, TypeInt64},
+		{"INT4", "", TypeInt64},
+		{"BIGSERIAL", "", TypeInt64},
+		{"INTERVAL", TypeBytes, TypeBytes},
+		{"POINT", TypeBytes, TypeBytes},
+		{"YEAR", "", TypeInt64},
+		{"DOUBLE", "", TypeFloat64},
+		{"DECIMAL", TypeBytes, TypeString},
+		{"BOOLEAN", "", TypeBool},
+		{"DATETIME", TypeBytes, TypeTime},
+		{"", TypeBytes, TypeBytes},
+		{"GEOMETRY", TypeBytes, TypeBytes},
+	}
+	for _, x := range tests {
+		if got := goTypeOfDBType(x.dbType, x.goType); got != x.want {
+			t.Errorf("goTypeOfDBType(%s, %s) = %q, want %q", x.dbType, x.goType, got, x.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ts := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		goType string
+		v      interface{}
+		want   interface{}
+	}{
+		{TypeInt64, []byte("12"), int64(12)},
+		{TypeInt64, int32(12), int64(12)},
+		{TypeInt64, uint64(12), int64(12)},
+		{TypeInt64, []byte("x"), "x"}, // Kept when it cannot be converted
+		{TypeInt64, uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{TypeFloat64, []byte("1.5"), 1.5},
+		{TypeFloat64, float32(1.5), 1.5},
+		{TypeBool, []byte("true"), true},
+		{TypeTime, []byte("2017-03-04 05:06:07"), ts},
+		{TypeString, []byte("abc"), "abc"},
+		{"", []byte("abc"), "abc"},
+		{TypeString, int64(3), int64(3)},
+		{TypeInt64, nil, nil},
+	}
+	for _, x := range tests {
+		c := DataColumn{GoType: x.goType}
+		if got := c.convert(x.v); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("convert(%#v) to %s = %#v, want %#v", x.v, x.goType, got, x.want)
+		}
+	}
+	c := DataColumn{GoType: TypeBytes}
+	if got, ok := c.convert([]byte("ab")).([]byte); !ok || string(got) != "ab" {
+		t.Errorf("bytes converted to %#v", got)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for _, s := range []string{"2017-03-04 05:06:07", "2017-03-04T05:06:07Z", "2017-03-04 05:06:07+00:00", " 2017-03-04 05:06:07.000 "} {
+		if tm, err := parseTime(s); err != nil || !tm.Equal(time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)) {
+			t.Errorf("parseTime(%q) = %v, %v", s, tm, err)
+		}
+	}
+	if tm, err := parseTime("2017-03-04"); err != nil || tm.Day() != 4 {
+		t.Errorf("date: %v, %v", tm, err)
+	}
+	if _, err := parseTime("yesterday"); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNull is returned by the DataRow getters when the value is NULL
+var ErrNull = errors.New("Null value")
+
+// The getters of DataRow take the column by name (string) or by position (int).
+// They return an error when the column does not exist, when the value is NULL
+// (ErrNull) or when it cannot be converted. The ...Or variants return a default
+// value instead.
+
+// index returns the position of a column given by name or position
+func (dr *DataRow) index(col interface{}) (int, error) {
+	ix := -1
+	switch c := col.(type) {
+	case string:
+		if dr.dt != nil {
+			ix = dr.dt.ColumnIndex(c)
+		}
+	case int:
+		ix = c
+	default:
+		return -1, fmt.Errorf("DataRow: invalid column %v", col)
+	}
+	if ix < 0 || ix >= len(dr.items) {
+		return -1, fmt.Errorf("DataRow: column %v not found", col)
+	}
+	return ix, nil
+}
+
+// Value returns the value of a column
+func (dr *DataRow) Value(col interface{}) (interface{}, error) {
+	ix, err := dr.index(col)
+	if err != nil {
+		return nil, err
+	}
+	return dr.items[ix], nil
+}
+
+// IsNull tells if the value of a column is NULL. It is false if the column does not exist.
+func (dr *DataRow) IsNull(col interface{}) bool {
+	v, err := dr.Value(col)
+	return err == nil && v == nil
+}
+
+// value returns the value of a column, an error if it is NULL
+func (dr *DataRow) value(col interface{}) (interface{}, error) {
+	v, err := dr.Value(col)
+	if err == nil && v == nil {
+		err = ErrNull
+	}
+	return v, err
+}
+
+func convError(col interface{}, v interface{}, typ string) error {
+	return fmt.Errorf("DataRow: cannot convert column %v (%T %v) to %s", col, v, v, typ)
+}
+
+// GetString returns the value of a column as a string. Numbers, booleans and times are formatted.
+func (dr *DataRow) GetString(col interface{}) (string, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return "", err
+	}
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case []byte:
+		return string(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return x.String(), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// GetInt64 returns the value of a column as an int64. Floats must have no fractional part.
+func (dr *DataRow) GetInt64(col interface{}) (int64, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return 0, err
+	}
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case int:
+		return int64(x), nil
+	case int8:
+		return int64(x), nil
+	case int16:
+		return int64(x), nil
+	case int32:
+		return int64(x), nil
+	case uint8:
+		return int64(x), nil
+	case uint16:
+		return int64(x), nil
+	case uint32:
+		return int64(x), nil
+	case uint:
+		if uint64(x) <= math.MaxInt64 {
+			return int64(x), nil
+		}
+	case uint64:
+		if x <= math.MaxInt64 {
+			return int64(x), nil
+		}
+	case float32:
+		if f := float64(x); f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), nil
+		}
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<63 {
+			return int64(x), nil
+		}
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case string, []byte:
+		if i, e := strconv.ParseInt(strings.TrimSpace(fmt.Sprintf("%s", x)), 10, 64); e == nil {
+			return i, nil
+		}
+	}
+	return 0, convError(col, v, TypeInt64)
+}
+
+// GetFloat64 returns the value of a column as a float64
+func (dr *DataRow) GetFloat64(col interface{}) (float64, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return 0, err
+	}
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case float32:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case int8:
+		return float64(x), nil
+	case int16:
+		return float64(x), nil
+	case int32:
+		return float64(x), nil
+	case uint:
+		return float64(x), nil
+	case uint8:
+		return float64(x), nil
+	case uint16:
+		return float64(x), nil
+	case uint32:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	case string, []byte:
+		if f, e := strconv.ParseFloat(strings.TrimSpace(fmt.Sprintf("%s", x)), 64); e == nil {
+			return f, nil
+		}
+	}
+	return 0, convError(col, v, TypeFloat64)
+}
+
+// GetBool returns the value of a column as a bool. Numbers are true when not 0,
+// strings are parsed with strconv.ParseBool.
+func (dr *DataRow) GetBool(col interface{}) (bool, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return false, err
+	}
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case int64:
+		return x != 0, nil
+	case int:
+		return x != 0, nil
+	case float64:
+		return x != 0, nil
+	case string, []byte:
+		if b, e := strconv.ParseBool(strings.TrimSpace(fmt.Sprintf("%s", x))); e == nil {
+			return b, nil
+		}
+	}
+	return false, convError(col, v, TypeBool)
+}
+
+// GetTime returns the value of a column as a time.Time. Strings are parsed with the
+// formats used by the databases.
+func (dr *DataRow) GetTime(col interface{}) (time.Time, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string, []byte:
+		if t, e := parseTime(fmt.Sprintf("%s", x)); e == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, convError(col, v, TypeTime)
+}
+
+// GetBytes returns the value of a column as a []byte. Strings are converted.
+func (dr *DataRow) GetBytes(col interface{}) ([]byte, error) {
+	v, err := dr.value(col)
+	if err != nil {
+		return nil, err
+	}
+	switch x := v.(type) {
+	case []byte:
+		return x, nil
+	case string:
+		return []byte(x), nil
+	}
+	return nil, convError(col, v, TypeBytes)
+}
+
+// GetStringOr returns the value of a column as a string, def if it is NULL or invalid
+func (dr *DataRow) GetStringOr(col interface{}, def string) string {
+	if v, err := dr.GetString(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetInt64Or returns the value of a column as an int64, def if it is NULL or invalid
+func (dr *DataRow) GetInt64Or(col interface{}, def int64) int64 {
+	if v, err := dr.GetInt64(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetFloat64Or returns the value of a column as a float64, def if it is NULL or invalid
+func (dr *DataRow) GetFloat64Or(col interface{}, def float64) float64 {
+	if v, err := dr.GetFloat64(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetBoolOr returns the value of a column as a bool, def if it is NULL or invalid
+func (dr *DataRow) GetBoolOr(col interface{}, def bool) bool {
+	if v, err := dr.GetBool(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetTimeOr returns the value of a column as a time.Time, def if it is NULL or invalid
+func (dr *DataRow) GetTimeOr(col interface{}, def time.Time) time.Time {
+	if v, err := dr.GetTime(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetBytesOr returns the value of a column as a []byte, def if it is NULL or invalid
+func (dr *DataRow) GetBytesOr(col interface{}, def []byte) []byte {
+	if v, err := dr.GetBytes(col); err == nil {
+		return v
+	}
+	return def
+}
+
+// RowState tells how a DataRow was changed since it was read from the database
+type RowState int
+
+const (
+	RowUnchanged RowState = iota // Read from the database and not changed
+	RowAdded                     // Created with NewRow
+	RowModified                  // Changed with Set
+	RowDeleted                   // Deleted with DataTable.DeleteRow
+)
+
+func (s RowState) String() string {
+	switch s {
+	case RowUnchanged:
+		return "Unchanged"
+	case RowAdded:
+		return "Added"
+	case RowModified:
+		return "Modified"
+	case RowDeleted:
+		return "Deleted"
+	}
+	return "RowState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// State returns the state of the row
+func (dr *DataRow) State() RowState {
+	if dr.rowTrack == nil {
+		return RowUnchanged
+	}
+	return dr.state
+}
+
+// tracking creates the change tracking of a row not created by DataTable.NewRow,
+// which is unchanged
+func (dr *DataRow) tracking() *rowTrack {
+	if dr.rowTrack == nil {
+		dr.rowTrack = &rowTrack{state: RowUnchanged}
+	}
+	return dr.rowTrack
+}
+
+// Set changes the value of a column. The value is converted to the type of the column
+// like the values read from the database. The first change of an unchanged row keeps
+// its original values and makes it RowModified.
+func (dr *DataRow) Set(col interface{}, v interface{}) error {
+	ix, err := dr.index(col)
+	if err != nil {
+		return err
+	}
+	if dr.State() == RowDeleted {
+		return fmt.Errorf("DataRow: cannot set column %v of a deleted row", col)
+	}
+	if dr.dt != nil && ix < len(dr.dt.Columns) {
+		v = dr.dt.Columns[ix].convert(v)
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.Round(0) // Without monotonic clock reading, like the values read from the database
+	}
+	if tr := dr.tracking(); tr.state == RowUnchanged {
+		tr.original = append([]interface{}{}, dr.items...)
+		tr.state = RowModified
+	}
+	dr.items[ix] = v
+	return nil
+}
+
+// Original returns the value of a column as read from the database: the value before
+// the first change of a modified or deleted row, the current value of an unchanged row.
+// Added rows have no original value.
+func (dr *DataRow) Original(col interface{}) (interface{}, error) {
+	ix, err := dr.index(col)
+	if err != nil {
+		return nil, err
+	}
+	switch dr.State() {
+	case RowAdded:
+		return nil, fmt.Errorf("DataRow: added row has no original value")
+	case RowModified, RowDeleted:
+		return dr.original[ix], nil
+	}
+	return dr.items[ix], nil
+}
+
+// accept makes the current values of the row its original values
+func (dr *DataRow) accept() {
+	tr := dr.tracking()
+	tr.state = RowUnchanged
+	tr.original = nil
+}
+
+// DeleteRow deletes the row at position i. An added row is removed from the table,
+// other rows are marked RowDeleted and removed by AcceptChanges.
+func (dt *DataTable) DeleteRow(i int) error {
+	if i < 0 || i >= len(dt.Rows) {
+		return fmt.Errorf("DataTable: row %d not found", i)
+	}
+	tr := dt.Rows[i].tracking()
+	switch tr.state {
+	case RowAdded:
+		dt.Rows = append(dt.Rows[:i], dt.Rows[i+1:]...)
+	case RowUnchanged:
+		tr.original = append([]interface{}{}, dt.Rows[i].items...)
+		tr.state = RowDeleted
+	case RowModified:
+		tr.state = RowDeleted
+	}
+	return nil
+}
+
+// HasChanges tells if rows were added, modified or deleted
+func (dt *DataTable) HasChanges() bool {
+	for i := range dt.Rows {
+		if dt.Rows[i].State() != RowUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptChanges removes the deleted rows and marks the other rows unchanged
+func (dt *DataTable) AcceptChanges() {
+	rows := dt.Rows[:0]
+	for _, dr := range dt.Rows {
+		if dr.State() != RowDeleted {
+			dr.accept()
+			rows = append(rows, dr)
+		}
+	}
+	dt.Rows = rows
+}
+
+// RejectChanges removes the added rows and restores the original values of the other rows
+func (dt *DataTable) RejectChanges() {
+	rows := dt.Rows[:0]
+	for _, dr := range dt.Rows {
+		switch dr.State() {
+		case RowAdded:
+			continue
+		case RowModified, RowDeleted:
+			// Copied into items, which the copies of the row share
+			copy(dr.items, dr.original)
+		}
+		dr.accept()
+		rows = append(rows, dr)
+	}
+	dt.Rows = rows
+}
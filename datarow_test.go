@@ -0,0 +1,248 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// testRow returns a row of a table whose columns hold each type, and a NULL
+func testRow() *DataRow {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "s", GoType: TypeString},
+		{Name: "i", GoType: TypeInt64},
+		{Name: "f", GoType: TypeFloat64},
+		{Name: "b", GoType: TypeBool},
+		{Name: "t", GoType: TypeTime},
+		{Name: "y", GoType: TypeBytes},
+		{Name: "n", GoType: TypeString},
+		{Name: "si", GoType: TypeString},
+		{Name: "fi", GoType: TypeFloat64},
+	}}
+	dr := dt.NewRow()
+	copy(dr.items, []interface{}{"abc", int64(12), 2.5, true, testTime, []byte("xy"), nil, " 42 ", 3.0})
+	dt.AddRow(dr)
+	return &dt.Rows[0]
+}
+
+func TestGetters(t *testing.T) {
+	dr := testRow()
+	if v, err := dr.GetString("s"); err != nil || v != "abc" {
+		t.Errorf("GetString: %v, %v", v, err)
+	}
+	if v, err := dr.GetString(1); err != nil || v != "12" {
+		t.Errorf("GetString by position: %v, %v", v, err)
+	}
+	if v, err := dr.GetString("f"); err != nil || v != "2.5" {
+		t.Errorf("GetString float: %v, %v", v, err)
+	}
+	if v, err := dr.GetString("t"); err != nil || v != "2017-03-04T05:06:07Z" {
+		t.Errorf("GetString time: %v, %v", v, err)
+	}
+	if v, err := dr.GetString("y"); err != nil || v != "xy" {
+		t.Errorf("GetString bytes: %v, %v", v, err)
+	}
+	if v, err := dr.GetInt64("i"); err != nil || v != 12 {
+		t.Errorf("GetInt64: %v, %v", v, err)
+	}
+	if v, err := dr.GetInt64("si"); err != nil || v != 42 {
+		t.Errorf("GetInt64 string: %v, %v", v, err)
+	}
+	if v, err := dr.GetInt64("fi"); err != nil || v != 3 {
+		t.Errorf("GetInt64 whole float: %v, %v", v, err)
+	}
+	if v, err := dr.GetInt64("b"); err != nil || v != 1 {
+		t.Errorf("GetInt64 bool: %v, %v", v, err)
+	}
+	if v, err := dr.GetFloat64("f"); err != nil || v != 2.5 {
+		t.Errorf("GetFloat64: %v, %v", v, err)
+	}
+	if v, err := dr.GetFloat64("i"); err != nil || v != 12 {
+		t.Errorf("GetFloat64 int: %v, %v", v, err)
+	}
+	if v, err := dr.GetBool("b"); err != nil || !v {
+		t.Errorf("GetBool: %v, %v", v, err)
+	}
+	if v, err := dr.GetBool("i"); err != nil || !v {
+		t.Errorf("GetBool int: %v, %v", v, err)
+	}
+	if v, err := dr.GetTime("t"); err != nil || !v.Equal(testTime) {
+		t.Errorf("GetTime: %v, %v", v, err)
+	}
+	if v, err := dr.GetBytes("y"); err != nil || string(v) != "xy" {
+		t.Errorf("GetBytes: %v, %v", v, err)
+	}
+	if v, err := dr.GetBytes("s"); err != nil || string(v) != "abc" {
+		t.Errorf("GetBytes string: %v, %v", v, err)
+	}
+}
+
+func TestGetNumbers(t *testing.T) {
+	values := []interface{}{int8(-8), int16(16), uint8(8), uint16(16), uint32(32), uint(7), uint64(64),
+		float32(2), uint64(math.MaxUint64), float32(1.5)}
+	dt := &DataTable{}
+	for i := range values {
+		dt.Columns = append(dt.Columns, DataColumn{Name: "c" + strconv.Itoa(i)})
+	}
+	dr := dt.NewRow()
+	copy(dr.items, values)
+	for i, want := range []int64{-8, 16, 8, 16, 32, 7, 64, 2} {
+		if v, err := dr.GetInt64(i); err != nil || v != want {
+			t.Errorf("GetInt64(%T): %v, %v", values[i], v, err)
+		}
+		if v, err := dr.GetFloat64(i); err != nil || v != float64(want) {
+			t.Errorf("GetFloat64(%T): %v, %v", values[i], v, err)
+		}
+	}
+	// Too large for an int64, or with a fractional part
+	for _, i := range []int{8, 9} {
+		if v, err := dr.GetInt64(i); err == nil {
+			t.Errorf("GetInt64(%v) = %v", values[i], v)
+		}
+	}
+	if v, err := dr.GetFloat64(8); err != nil || v != math.MaxUint64 {
+		t.Errorf("GetFloat64(MaxUint64): %v, %v", v, err)
+	}
+}
+
+func TestGetterErrors(t *testing.T) {
+	dr := testRow()
+	if _, err := dr.GetString("n"); err != ErrNull {
+		t.Errorf("NULL: %v", err)
+	}
+	if !dr.IsNull("n") || dr.IsNull("s") || dr.IsNull("nope") {
+		t.Error("IsNull")
+	}
+	if _, err := dr.GetString("nope"); err == nil {
+		t.Error("unknown column")
+	}
+	if _, err := dr.GetString(9); err == nil {
+		t.Error("position out of range")
+	}
+	if _, err := dr.GetString(1.5); err == nil {
+		t.Error("invalid column")
+	}
+	if _, err := dr.GetInt64("s"); err == nil {
+		t.Error("GetInt64 of abc")
+	}
+	if _, err := dr.GetInt64("f"); err == nil {
+		t.Error("GetInt64 of 2.5")
+	}
+	if _, err := dr.GetFloat64("t"); err == nil {
+		t.Error("GetFloat64 of a time")
+	}
+	if _, err := dr.GetBool("s"); err == nil {
+		t.Error("GetBool of abc")
+	}
+	if _, err := dr.GetTime("s"); err == nil {
+		t.Error("GetTime of abc")
+	}
+	if _, err := dr.GetBytes("i"); err == nil {
+		t.Error("GetBytes of an int")
+	}
+	if v, err := dr.Value("n"); err != nil || v != nil {
+		t.Errorf("Value of NULL: %v, %v", v, err)
+	}
+}
+
+func TestSetOnCopy(t *testing.T) {
+	dt := testRow().dt
+	dt.AcceptChanges()
+	for _, r := range dt.Rows {
+		if err := r.Set("s", "def"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !dt.HasChanges() || dt.Rows[0].State() != RowModified {
+		t.Fatalf("change of a copy not tracked: %v", dt.Rows[0].State())
+	}
+	if v, err := dt.Rows[0].Original("s"); v != "abc" || err != nil {
+		t.Errorf("original %v, %v", v, err)
+	}
+	dr := dt.Rows[0]
+	dt.AcceptChanges()
+	if dr.State() != RowUnchanged || dt.Rows[0].GetStringOr("s", "") != "def" {
+		t.Errorf("copy after AcceptChanges: %v", dr.State())
+	}
+	dt.Rows[0].Set("s", "ghi")
+	dt.RejectChanges()
+	if dr.GetStringOr("s", "") != "def" {
+		t.Errorf("copy after RejectChanges: %v", dr.GetStringOr("s", ""))
+	}
+}
+
+func TestZeroRow(t *testing.T) {
+	dt := DataTable{Columns: []DataColumn{{Name: "s"}}}
+	dt.Rows = []DataRow{{items: []interface{}{"abc"}, dt: &dt}}
+	if err := dt.Rows[0].Set("s", "def"); err != nil || dt.Rows[0].State() != RowModified {
+		t.Fatalf("Set: %v, %v", dt.Rows[0].State(), err)
+	}
+	dt.RejectChanges()
+	if dt.Rows[0].GetStringOr("s", "") != "abc" || dt.HasChanges() {
+		t.Errorf("RejectChanges: %v", dt.Rows[0].GetStringOr("s", ""))
+	}
+	dt.AddRow(DataRow{items: []interface{}{"x"}, dt: &dt})
+	if err := dt.DeleteRow(1); err != nil || dt.Rows[1].State() != RowDeleted {
+		t.Fatalf("DeleteRow: %v", err)
+	}
+	dt.Rows = append(dt.Rows, DataRow{items: []interface{}{"y"}, dt: &dt})
+	dt.AcceptChanges()
+	if len(dt.Rows) != 2 || dt.Rows[1].GetStringOr("s", "") != "y" {
+		t.Errorf("AcceptChanges: %d rows", len(dt.Rows))
+	}
+}
+
+func TestGettersOr(t *testing.T) {
+	dr := testRow()
+	if dr.GetStringOr("n", "def") != "def" || dr.GetStringOr("s", "def") != "abc" {
+		t.Error("GetStringOr")
+	}
+	if dr.GetInt64Or("s", -1) != -1 || dr.GetInt64Or("i", -1) != 12 {
+		t.Error("GetInt64Or")
+	}
+	if dr.GetFloat64Or("nope", -1) != -1 || dr.GetFloat64Or("f", -1) != 2.5 {
+		t.Error("GetFloat64Or")
+	}
+	if dr.GetBoolOr("n", false) || !dr.GetBoolOr("b", false) {
+		t.Error("GetBoolOr")
+	}
+	if !dr.GetTimeOr("n", time.Time{}).IsZero() || !dr.GetTimeOr("t", time.Time{}).Equal(testTime) {
+		t.Error("GetTimeOr")
+	}
+	if dr.GetBytesOr("n", nil) != nil || string(dr.GetBytesOr("y", nil)) != "xy" {
+		t.Error("GetBytesOr")
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	tests := []struct {
+		a, b interface{}
+		want bool
+	}{
+		{int64(1), int64(1), true},
+		{int64(1), 1.0, false},
+		{"a", "a", true},
+		{nil, nil, true},
+		{nil, "", false},
+		{testTime, testTime.In(time.FixedZone("X", 3600)), true},
+		{testTime, testTime.Add(time.Second), false},
+		{testTime, "2017-03-04 05:06:07", false},
+		{[]byte("ab"), []byte("ab"), true},
+		{[]byte("ab"), "ab", false},
+		{[]int{1}, []int{1}, true}, // Not comparable with ==
+		{map[string]int{"a": 1}, map[string]int{"a": 2}, false},
+	}
+	for _, x := range tests {
+		if got := sameValue(x.a, x.b); got != x.want {
+			t.Errorf("sameValue(%#v, %#v) = %v", x.a, x.b, got)
+		}
+	}
+}
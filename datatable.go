@@ -8,8 +8,8 @@ package sedi
 import (
 	"bytes"
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"strings"
@@ -19,24 +19,31 @@ import (
 )
 
 type DataTable struct {
-	Columns     []string  // Column names
-	Rows        []DataRow // Collection of DataRow
+	Columns     []DataColumn // Column definitions
+	Rows        []DataRow    // Collection of DataRow
 	colmap      map[string]int
 	hasIDColumn bool
 	tableName   string
 }
 
 type DataRow struct {
-	items []interface{}
-	dt    *DataTable
+	items     []interface{}
+	dt        *DataTable
+	*rowTrack // Shared by the copies of the row, like items
+}
+
+// rowTrack holds the change tracking of a DataRow
+type rowTrack struct {
+	state    RowState
+	original []interface{} // Values read from the database, for modified and deleted rows
 }
 
 // Fill as structure from a data row
 func (this *DataRow) FillStruct(s interface{}) {
 	v := reflect.Indirect(reflect.ValueOf(s))
-	vt := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		fn := vt.Field(i).Name
+	sf := structFieldsOf(v.Type())
+	for x, i := range sf.index {
+		fn := sf.fields[x].Name
 		if f, ok := this.Item(fn); ok && v.Field(i).CanSet() {
 			switch v.Field(i).Interface().(type) {
 			case string:
@@ -64,33 +71,74 @@ func (this *DataRow) FillStruct(s interface{}) {
 
 // Clear empties the DataTable including column definition
 func (dt *DataTable) Clear() {
-	dt.Columns = []string{}
+	dt.Columns = []DataColumn{}
 	dt.Rows = []DataRow{}
 	dt.colmap = nil
 	dt.hasIDColumn = false
 	dt.tableName = ""
 }
 
-// NewRow return a new DataRow from DataTable structure (Does not add the row to the DataTable)
+// NewRow return a new DataRow from DataTable structure (Does not add the row to the DataTable).
+// Its state is RowAdded.
 func (dt *DataTable) NewRow() DataRow {
 	var dr DataRow
 	dr.items = make([]interface{}, len(dt.Columns))
 	dr.dt = dt
+	dr.rowTrack = &rowTrack{state: RowAdded}
 	return dr
 }
 
 func (dt *DataTable) refreshColmap() {
 	dt.colmap = make(map[string]int)
 	for i := range dt.Columns {
-		dt.colmap[dt.Columns[i]] = i
+		dt.colmap[dt.Columns[i].Name] = i
+	}
+}
+
+// initColumns sets the table information derived from the columns
+func (dt *DataTable) initColumns() {
+	for c := range dt.Columns {
+		if strings.ToLower(dt.Columns[c].Name) == "id" {
+			dt.hasIDColumn = true
+		}
+	}
+	dt.tableName = "mytable"
+}
+
+// ColumnNames returns the names of the columns
+func (dt *DataTable) ColumnNames() []string {
+	ret := make([]string, len(dt.Columns))
+	for i := range dt.Columns {
+		ret[i] = dt.Columns[i].Name
+	}
+	return ret
+}
+
+// ColumnIndex returns the position of a column, -1 if not found
+func (dt *DataTable) ColumnIndex(name string) int {
+	if dt.colmap == nil {
+		dt.refreshColmap()
+	}
+	if ix, found := dt.colmap[name]; found {
+		return ix
+	}
+	return -1
+}
+
+// Column returns the definition of a column
+func (dt *DataTable) Column(name string) (DataColumn, bool) {
+	if ix := dt.ColumnIndex(name); ix >= 0 {
+		return dt.Columns[ix], true
 	}
+	return DataColumn{}, false
 }
 
-// AddRow appends a DataRow to a DataTable
+// AddRow appends a DataRow to a DataTable. A row not created by NewRow is added unchanged.
 func (dt *DataTable) AddRow(dr DataRow) {
 	if dt.Rows == nil {
 		dt.Rows = []DataRow{}
 	}
+	dr.tracking()
 	dt.Rows = append(dt.Rows, dr)
 }
 
@@ -102,7 +150,7 @@ func (dt *DataTable) Str() string {
 		if !first {
 			s = s + "\t"
 		}
-		s = s + dt.Columns[c]
+		s = s + dt.Columns[c].Name
 		first = false
 	}
 
@@ -122,39 +170,56 @@ func (dt *DataTable) Str() string {
 	return s
 }
 
-func (dt *DataTable) Fill(rows *sql.Rows, maxrows int) {
+// Fill reads at most maxrows rows of a query in the datatable, all rows if maxrows < 0.
+// It returns the error of a row that cannot be read, or of the query.
+func (dt *DataTable) Fill(rows *sql.Rows, maxrows int) error {
 	rowid := 0
 	dt.Clear()
-	dt.Columns, _ = rows.Columns()
-	for c := range dt.Columns {
-		if strings.ToLower(dt.Columns[c]) == "id" {
-			dt.hasIDColumn = true
-		}
-	}
-
-	dt.tableName = "mytable"
-
-	valuePtrs := make([]interface{}, len(dt.Columns))
+	dt.Columns = columnsOf(rows)
+	dt.initColumns()
 
 	for rows.Next() && (maxrows < 0 || rowid < maxrows) {
 		dr := dt.NewRow()
-
-		for i, _ := range dt.Columns {
-			valuePtrs[i] = &(dr.items[i])
-
-		}
-		rows.Scan(valuePtrs...)
-
-		for i, _ := range dt.Columns {
-			switch dr.items[i].(type) {
-			case []byte:
-				dr.items[i] = string(dr.items[i].([]byte))
-				break
-			}
+		if err := scanRow(rows, dt.Columns, dr.items); err != nil {
+			return err
 		}
+		dr.state = RowUnchanged
 		dt.AddRow(dr)
 		rowid++
 	}
+	return rows.Err()
+}
+
+// columnsOf returns the columns of a query
+func columnsOf(rows *sql.Rows) []DataColumn {
+	columns := []DataColumn{}
+	if types, err := rows.ColumnTypes(); err == nil {
+		for _, ct := range types {
+			columns = append(columns, NewDataColumn(ct))
+		}
+	} else {
+		names, _ := rows.Columns()
+		for _, n := range names {
+			columns = append(columns, DataColumn{Name: n, Nullable: true})
+		}
+	}
+	return columns
+}
+
+// scanRow reads the current row of a query in values, converted to the types of the columns
+func scanRow(rows *sql.Rows, columns []DataColumn, values []interface{}) error {
+	ptrs := make([]interface{}, len(values))
+	for i := range values {
+		values[i] = nil
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return err
+	}
+	for i := range columns {
+		values[i] = columns[i].convert(values[i])
+	}
+	return nil
 }
 
 func (dr *DataRow) Items() []interface{} {
@@ -184,7 +249,7 @@ func (dr *DataRow) ToMap() map[string]interface{} {
 		dr.dt.refreshColmap()
 	}
 	for i := range dr.items {
-		ret[dr.dt.Columns[i]] = dr.items[i]
+		ret[dr.dt.Columns[i].Name] = dr.items[i]
 	}
 	return ret
 }
@@ -206,63 +271,63 @@ func (dt *DataTable) ToJSON() (string, error) {
 
 // SaveToFile saves datatable to a JSON file
 func (dt *DataTable) SaveToFile(filename string) error {
+	return writeFile(filename, func(w io.Writer) error { return NewJSONEncoder(w, false).EncodeTable(dt) })
+}
+
+// LoadFromFile fills a datatable from a JSON file written by SaveToFile
+func (dt *DataTable) LoadFromFile(filename string) error {
+	return readFile(filename, dt.ReadJSON)
+}
+
+// writeFile creates a file and writes it with write
+func writeFile(filename string, write func(w io.Writer) error) error {
 	f, e := os.Create(filename)
-	defer f.Close()
-	dt.WriteAsJSON(f)
+	if e != nil {
+		return e
+	}
+	e = write(f)
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
 	return e
 }
 
-// WriteAsJSON writes the datatable to a writer.
-func (dt *DataTable) WriteAsJSON(dest Writer) error {
-	dest.WriteString("{")
-	dest.WriteString("\n\"Columns\": ")
-	x, e := json.Marshal(dt.Columns)
+// readFile opens a file and reads it with read
+func readFile(filename string, read func(r io.Reader) error) error {
+	f, e := os.Open(filename)
 	if e != nil {
 		return e
 	}
-	dest.WriteString(string(x))
-	dest.WriteString(",")
+	defer f.Close()
+	return read(f)
+}
 
-	dest.WriteString("\n\"Rows\": [\n")
-	for r := range dt.Rows {
-		x, e := json.Marshal(dt.Rows[r].items)
-		if e != nil {
-			return e
-		}
-		dest.WriteString("    ")
-		dest.WriteString(string(x))
-		if r < len(dt.Rows)-1 {
-			dest.WriteString(",\n")
-		}
+// JSONVersion is the version of the JSON format written by WriteAsJSON.
+// Version 2 carries the column definitions and writes each value as the type of its column:
+// int64 and float64 as numbers, time.Time as RFC 3339 strings, []byte in base64 and NULL as null.
+// A value of another type than its column is written {"$type": "int64", "value": 12}.
+const JSONVersion = 2
+
+// WriteAsJSON writes the datatable to a writer, see JSONEncoder
+func (dt *DataTable) WriteAsJSON(dest Writer) error {
+	w, ok := dest.(io.Writer)
+	if !ok {
+		w = stringWriter{dest}
 	}
-	dest.WriteString("]\n")
-	dest.WriteString("}\n")
-	return nil
+	return NewJSONEncoder(w, false).EncodeTable(dt)
 }
 
-// FillFromJSON fills a data table from a JSON string
-func (dt *DataTable) FillFromJSON(js string) error {
-	dt.Clear()
-	obj := make(map[string]interface{})
-	if e := json.Unmarshal([]byte(js), &obj); e == nil {
-		dt.Columns = []string{}
-		for c := range obj["Columns"].([]interface{}) {
-			jsc := obj["Columns"].([]interface{})
-			dt.Columns = append(dt.Columns, jsc[c].(string))
-		}
-		rows := obj["Rows"].([]interface{})
-		for i := range rows {
-			row := rows[i].([]interface{})
-			dr := dt.NewRow()
-			for c := range dt.Columns {
-				dr.items[c] = row[c]
-			}
-			dt.AddRow(dr)
-		}
+// stringWriter makes an io.Writer of a Writer
+type stringWriter struct {
+	Writer
+}
 
-		return nil
-	} else {
-		fmt.Println(e)
-		return e
-	}
+func (w stringWriter) Write(p []byte) (int, error) {
+	return w.WriteString(string(p))
+}
+
+// FillFromJSON fills a data table from a JSON string. It reads the format of WriteAsJSON
+// and the previous formats, whose columns are a list of names and values are not typed.
+func (dt *DataTable) FillFromJSON(js string) error {
+	return dt.ReadJSON(strings.NewReader(js))
 }
@@ -0,0 +1,252 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+// openTyped opens a database in memory with table typed holding two rows, the second one NULL
+func openTyped(t *testing.T) *sedi.Conn {
+	sedi.LogErrors = false
+	memory.Drop("datatable_test")
+	cn, err := sedi.OpenConnection(memory.DriverName, "datatable_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cn.Close()
+		memory.Drop("datatable_test")
+	})
+	if err = cn.ExecNoResult("create table typed (id integer not null, name varchar(20), amount real, done boolean, created datetime, data blob)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cn.ExecArgs("insert into typed (id, name, amount, done, created, data) values (?, ?, ?, ?, ?, ?)",
+		1, "a", 1.5, true, time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC), []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cn.ExecNoResult("insert into typed (id) values (2)", nil); err != nil {
+		t.Fatal(err)
+	}
+	return &cn
+}
+
+func TestFillColumns(t *testing.T) {
+	cn := openTyped(t)
+	dt, err := cn.GetDataTable("select id, name, amount, done, created, data, id + 1 as next from typed order by id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, dbType, goType string }{
+		{"id", "INTEGER", sedi.TypeInt64},
+		{"name", "VARCHAR(20)", sedi.TypeString},
+		{"amount", "REAL", sedi.TypeFloat64},
+		{"done", "BOOLEAN", sedi.TypeBool},
+		{"created", "DATETIME", sedi.TypeTime},
+		{"data", "BLOB", sedi.TypeBytes},
+		{"next", "", ""},
+	}
+	if len(dt.Columns) != len(want) {
+		t.Fatalf("columns %+v", dt.Columns)
+	}
+	for i, w := range want {
+		c := dt.Columns[i]
+		if c.Name != w.name || c.DBType != w.dbType || c.GoType != w.goType {
+			t.Errorf("column %d: %+v, want %+v", i, c, w)
+		}
+	}
+	if names := dt.ColumnNames(); len(names) != 7 || names[4] != "created" {
+		t.Errorf("names %v", names)
+	}
+	if dt.ColumnIndex("amount") != 2 || dt.ColumnIndex("nope") != -1 {
+		t.Error("ColumnIndex")
+	}
+	if c, ok := dt.Column("done"); !ok || c.GoType != sedi.TypeBool {
+		t.Errorf("Column: %+v, %v", c, ok)
+	}
+	if _, ok := dt.Column("nope"); ok {
+		t.Error("Column nope")
+	}
+
+	// Values have the type of their column
+	if len(dt.Rows) != 2 {
+		t.Fatalf("%d rows", len(dt.Rows))
+	}
+	r := dt.Rows[0].Items()
+	if _, ok := r[0].(int64); !ok {
+		t.Errorf("id is %T", r[0])
+	}
+	if r[1] != "a" || r[2] != 1.5 || r[3] != true || r[6] != int64(2) {
+		t.Errorf("values %#v", r)
+	}
+	if tm, ok := r[4].(time.Time); !ok || !tm.Equal(time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)) {
+		t.Errorf("created %#v", r[4])
+	}
+	if b, ok := r[5].([]byte); !ok || len(b) != 2 {
+		t.Errorf("data %#v", r[5])
+	}
+	for i, v := range dt.Rows[1].Items()[1:6] {
+		if v != nil {
+			t.Errorf("column %d of the NULL row is %#v", i+1, v)
+		}
+	}
+
+	// New rows have the columns of the table
+	dr := dt.NewRow()
+	if len(dr.Items()) != 7 {
+		t.Errorf("new row %v", dr.Items())
+	}
+}
+
+// brokenDriver is a database/sql driver whose queries return a row, then fail
+type brokenDriver struct{}
+
+type brokenConn struct{}
+type brokenStmt struct{}
+type brokenRows struct{ n int }
+
+var errBroken = errors.New("connection lost")
+
+func init() {
+	sql.Register("sedi_broken", brokenDriver{})
+}
+
+func (brokenDriver) Open(name string) (driver.Conn, error)         { return brokenConn{}, nil }
+func (brokenConn) Prepare(query string) (driver.Stmt, error)       { return brokenStmt{}, nil }
+func (brokenConn) Close() error                                    { return nil }
+func (brokenConn) Begin() (driver.Tx, error)                       { return nil, errBroken }
+func (brokenStmt) Close() error                                    { return nil }
+func (brokenStmt) NumInput() int                                   { return -1 }
+func (brokenStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, errBroken }
+func (brokenStmt) Query(args []driver.Value) (driver.Rows, error)  { return &brokenRows{}, nil }
+func (r *brokenRows) Columns() []string                            { return []string{"id"} }
+func (r *brokenRows) Close() error                                 { return nil }
+
+func (r *brokenRows) Next(dest []driver.Value) error {
+	r.n++
+	if r.n > 1 {
+		return errBroken
+	}
+	dest[0] = int64(r.n)
+	return nil
+}
+
+func TestFillError(t *testing.T) {
+	db, err := sql.Open("sedi_broken", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var dt sedi.DataTable
+	if err = dt.Fill(rows, -1); err != errBroken {
+		t.Errorf("got %v, want the error of the rows", err)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	cn := openTyped(t)
+	for _, ndjson := range []bool{false, true} {
+		var b bytes.Buffer
+		n, err := cn.StreamJSON(&b, ndjson, "select id, name, created from typed where id >= ? order by id", 1)
+		if err != nil || n != 2 {
+			t.Fatalf("%d rows: %v", n, err)
+		}
+		var dt sedi.DataTable
+		if err = dt.ReadJSON(&b); err != nil {
+			t.Fatal(err)
+		}
+		if len(dt.Rows) != 2 || dt.Columns[2].GoType != sedi.TypeTime {
+			t.Fatalf("read %d rows, columns %+v", len(dt.Rows), dt.Columns)
+		}
+		if tm, err := dt.Rows[0].GetTime("created"); err != nil || tm.Year() != 2017 || !dt.Rows[1].IsNull("created") {
+			t.Errorf("created %v, %v", tm, err)
+		}
+	}
+	var b bytes.Buffer
+	if _, err := cn.StreamJSON(&b, false, "select nope from typed"); err == nil {
+		t.Error("invalid query")
+	}
+}
+
+// blockingWriter runs a query of the connection at its first write, and records
+// whether the query could run while the stream was being written
+type blockingWriter struct {
+	cn      *sedi.Conn
+	writes  int
+	queried bool
+	done    chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.writes == 1 {
+		w.done = make(chan struct{})
+		go func() {
+			w.cn.GetScalar("select count(*) from typed", nil)
+			close(w.done)
+		}()
+		select {
+		case <-w.done:
+			w.queried = true
+		case <-time.After(time.Second): // The query waits for the end of the stream
+		}
+	}
+	return len(p), nil
+}
+
+// countingWriter counts its writes
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w++
+	return len(p), nil
+}
+
+func TestStreamJSONLock(t *testing.T) {
+	cn := openTyped(t)
+	if !cn.DisallowConcurency {
+		t.Fatal("DisallowConcurency is not set by default")
+	}
+	// With a single connection, a query waiting for the lock must not hold the
+	// connection needed by the rows of the stream
+	cn.DB.SetMaxOpenConns(1)
+	for i := 3; i < 200; i++ {
+		if _, err := cn.ExecArgs("insert into typed (id, name) values (?, ?)", i, strings.Repeat("x", 50)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := &blockingWriter{cn: cn}
+	n, err := cn.StreamJSON(w, true, "select id, name from typed")
+	if w.done != nil {
+		<-w.done
+	}
+	if err != nil || n != 199 {
+		t.Fatalf("%d rows: %v", n, err)
+	}
+	if !w.queried {
+		t.Error("query waited for the end of the writes")
+	}
+
+	// Without the lock, the rows are written as they are read
+	cn.DisallowConcurency = false
+	var c countingWriter
+	if n, err = cn.StreamJSON(&c, false, "select id, name from typed"); err != nil || n != 199 || c < 2 {
+		t.Errorf("%d rows in %d writes: %v", n, c, err)
+	}
+}
@@ -0,0 +1,659 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DataView is a filtered and sorted list of the rows of a DataTable. The rows are shared:
+// changing a row of the view changes the row of the table. The view does not follow the rows
+// added to or removed from the table after it was made.
+//
+// The methods return a new view and can be chained. The first error is kept and
+// returned by Err; the following methods return the view unchanged.
+//
+//	dv := dt.View().Filter("country = ? and age >= 18", "FR").Sort("name, age desc nulls last").Page(1, 20)
+type DataView struct {
+	Table *DataTable
+	rows  []int // Positions in Table.Rows
+	err   error
+}
+
+// View returns a view of the rows of the table, deleted rows excepted
+func (dt *DataTable) View() *DataView {
+	dv := &DataView{Table: dt, rows: []int{}}
+	for i := range dt.Rows {
+		if dt.Rows[i].state != RowDeleted {
+			dv.rows = append(dv.rows, i)
+		}
+	}
+	return dv
+}
+
+// Err returns the first error met while building the view
+func (dv *DataView) Err() error {
+	return dv.err
+}
+
+// Count returns the number of rows of the view
+func (dv *DataView) Count() int {
+	return len(dv.rows)
+}
+
+// Row returns the ith row of the view, the row of the table
+func (dv *DataView) Row(i int) *DataRow {
+	return &dv.Table.Rows[dv.rows[i]]
+}
+
+// Rows returns the rows of the view
+func (dv *DataView) Rows() []*DataRow {
+	ret := make([]*DataRow, len(dv.rows))
+	for i, r := range dv.rows {
+		ret[i] = &dv.Table.Rows[r]
+	}
+	return ret
+}
+
+// ToTable returns a new DataTable with the columns of the table and a copy of the rows of the view
+func (dv *DataView) ToTable() DataTable {
+	var dt DataTable
+	dt.Clear()
+	dt.Columns = append(dt.Columns, dv.Table.Columns...)
+	dt.initColumns()
+	for _, r := range dv.rows {
+		dr := dt.NewRow()
+		copy(dr.items, dv.Table.Rows[r].items)
+		dr.state = RowUnchanged
+		dt.AddRow(dr)
+	}
+	return dt
+}
+
+func (dv *DataView) with(rows []int, err error) *DataView {
+	if err != nil {
+		return &DataView{Table: dv.Table, rows: dv.rows, err: err}
+	}
+	return &DataView{Table: dv.Table, rows: rows}
+}
+
+// Filter keeps the rows matching a filter: a func(*DataRow) bool, or an expression like a SQL
+// where clause. Expressions compare columns and values with = <> != < <= > >=, like, in,
+// between and is [not] null, combined with and, or, not and parentheses. Values are numbers,
+// 'strings', true, false, null or ? placeholders taking args in order. Names that are not
+// simple words are written [between brackets]. Comparisons with NULL are never true, as in SQL.
+func (dv *DataView) Filter(filter interface{}, args ...interface{}) *DataView {
+	if dv.err != nil {
+		return dv
+	}
+	var match func(dr *DataRow) bool
+	switch f := filter.(type) {
+	case func(*DataRow) bool:
+		match = f
+	case string:
+		x, err := parseFilter(dv.Table, f, args)
+		if err != nil {
+			return dv.with(nil, err)
+		}
+		match = func(dr *DataRow) bool { return x(dr) == true }
+	default:
+		return dv.with(nil, fmt.Errorf("Filter: invalid filter %T", filter))
+	}
+	rows := []int{}
+	for _, r := range dv.rows {
+		if match(&dv.Table.Rows[r]) {
+			rows = append(rows, r)
+		}
+	}
+	return dv.with(rows, nil)
+}
+
+// NullOrder sets where a sort puts the NULL values
+type NullOrder int
+
+const (
+	NullsDefault NullOrder = iota // First in ascending order, last in descending order
+	NullsFirst
+	NullsLast
+)
+
+// SortKey is a column of a sort
+type SortKey struct {
+	Column string
+	Desc   bool
+	Nulls  NullOrder
+}
+
+// Sort sorts the rows by a list of columns separated by commas, each one optionally
+// followed by asc or desc, and nulls first or nulls last: "name, age desc nulls last".
+// Names that are not simple words are written [between brackets]. The sort is stable.
+func (dv *DataView) Sort(spec string) *DataView {
+	if dv.err != nil {
+		return dv
+	}
+	keys := []SortKey{}
+	for _, part := range strings.Split(spec, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			return dv.with(nil, errors.New("Sort: invalid sort "+spec))
+		}
+		k := SortKey{Column: words[0]}
+		if part = strings.TrimSpace(part); part[0] == '[' { // A name that is not a simple word
+			end := strings.IndexByte(part, ']')
+			if end < 0 {
+				return dv.with(nil, errors.New("Sort: invalid sort "+spec))
+			}
+			k.Column = part[1:end]
+			words = append([]string{""}, strings.Fields(part[end+1:])...)
+		}
+		for i := 1; i < len(words); i++ {
+			switch w := strings.ToLower(words[i]); {
+			case w == "asc":
+			case w == "desc":
+				k.Desc = true
+			case w == "nulls" && i+1 < len(words) && strings.EqualFold(words[i+1], "first"):
+				k.Nulls = NullsFirst
+				i++
+			case w == "nulls" && i+1 < len(words) && strings.EqualFold(words[i+1], "last"):
+				k.Nulls = NullsLast
+				i++
+			default:
+				return dv.with(nil, errors.New("Sort: invalid sort "+spec))
+			}
+		}
+		keys = append(keys, k)
+	}
+	return dv.SortBy(keys...)
+}
+
+// SortBy sorts the rows by the given columns. The sort is stable.
+func (dv *DataView) SortBy(keys ...SortKey) *DataView {
+	if dv.err != nil {
+		return dv
+	}
+	cols := make([]int, len(keys))
+	for i, k := range keys {
+		if cols[i] = dv.Table.ColumnIndex(k.Column); cols[i] < 0 {
+			return dv.with(nil, errors.New("Sort: unknown column "+k.Column))
+		}
+	}
+	rows := append([]int{}, dv.rows...)
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := dv.Table.Rows[rows[i]].items, dv.Table.Rows[rows[j]].items
+		for k, c := range cols {
+			if cmp := compareSort(a[c], b[c], keys[k]); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return dv.with(rows, nil)
+}
+
+// compareSort compares two values for a sort key
+func compareSort(a interface{}, b interface{}, k SortKey) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+		nullsFirst := k.Nulls == NullsFirst || k.Nulls == NullsDefault && !k.Desc
+		if (a == nil) == nullsFirst {
+			return -1
+		}
+		return 1
+	}
+	cmp, ok := compareValues(a, b)
+	if !ok { // Values of different types are sorted by type
+		cmp = strings.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b))
+	}
+	if k.Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// Distinct keeps the first row of each combination of values of the given columns,
+// of all the columns if none is given
+func (dv *DataView) Distinct(columns ...string) *DataView {
+	if dv.err != nil {
+		return dv
+	}
+	cols, err := dv.Table.columnIndexes(columns)
+	if err != nil {
+		return dv.with(nil, errors.New("Distinct:"+err.Error()))
+	}
+	seen := map[string]bool{}
+	rows := []int{}
+	for _, r := range dv.rows {
+		key := rowKey(&dv.Table.Rows[r], cols)
+		if !seen[key] {
+			seen[key] = true
+			rows = append(rows, r)
+		}
+	}
+	return dv.with(rows, nil)
+}
+
+// Page keeps the rows of a page, the first page being 1
+func (dv *DataView) Page(page int, size int) *DataView {
+	if dv.err != nil {
+		return dv
+	}
+	if page < 1 || size < 1 {
+		return dv.with(nil, errors.New("Page: invalid page "+strconv.Itoa(page)+" of size "+strconv.Itoa(size)))
+	}
+	start, end := (page-1)*size, page*size
+	if start > len(dv.rows) {
+		start = len(dv.rows)
+	}
+	if end > len(dv.rows) {
+		end = len(dv.rows)
+	}
+	return dv.with(append([]int{}, dv.rows[start:end]...), nil)
+}
+
+// PageCount returns the number of pages of the given size
+func (dv *DataView) PageCount(size int) int {
+	if size < 1 {
+		return 0
+	}
+	return (len(dv.rows) + size - 1) / size
+}
+
+// columnIndexes returns the positions of columns, of all the columns if none is given
+func (dt *DataTable) columnIndexes(columns []string) ([]int, error) {
+	cols := []int{}
+	for _, name := range columns {
+		c := dt.ColumnIndex(name)
+		if c < 0 {
+			return nil, errors.New(" unknown column " + name)
+		}
+		cols = append(cols, c)
+	}
+	if len(columns) == 0 {
+		for c := range dt.Columns {
+			cols = append(cols, c)
+		}
+	}
+	return cols, nil
+}
+
+// filterExpr evaluates a filter expression on a row: a value, nil for NULL or unknown
+type filterExpr func(dr *DataRow) interface{}
+
+type filterParser struct {
+	dt     *DataTable
+	tokens []string
+	pos    int
+	args   []interface{}
+	arg    int
+}
+
+// parseFilter compiles a filter expression
+func parseFilter(dt *DataTable, s string, args []interface{}) (filterExpr, error) {
+	tokens, err := filterTokens(s)
+	var x filterExpr
+	p := &filterParser{dt: dt, tokens: tokens, args: args}
+	if err == nil {
+		x, err = p.or()
+	}
+	if err == nil && p.pos < len(p.tokens) {
+		err = errors.New("unexpected " + p.tokens[p.pos])
+	}
+	if err == nil && p.arg != len(args) {
+		err = fmt.Errorf("%d arguments for %d placeholders", len(args), p.arg)
+	}
+	if err != nil {
+		return nil, errors.New("Filter: " + err.Error() + " in " + s)
+	}
+	return x, nil
+}
+
+// filterTokens splits an expression in words, numbers, 'strings', [names] and operators
+func filterTokens(s string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'':
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case c == '[' || c == '"' || c == '`':
+			end := map[rune]byte{'[': ']', '"': '"', '`': '`'}[c]
+			j := strings.IndexByte(s[i+1:], end)
+			if j < 0 {
+				return nil, errors.New("unterminated name")
+			}
+			tokens = append(tokens, "["+s[i+1:i+1+j]+"]")
+			i += j + 2
+		case strings.ContainsRune("<>!=", c):
+			j := i + 1
+			if j < len(s) && strings.ContainsRune("<>=", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case strings.ContainsRune("(),?", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' && j == i || s[j] >= 0x80 ||
+				unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return nil, errors.New("unexpected " + string(c))
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *filterParser) accept(words ...string) bool {
+	for i, w := range words {
+		if p.pos+i >= len(p.tokens) || strings.ToLower(p.tokens[p.pos+i]) != w {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	x, err := p.and()
+	for err == nil && p.accept("or") {
+		var y filterExpr
+		if y, err = p.and(); err == nil {
+			a, b := x, y
+			x = func(dr *DataRow) interface{} {
+				u, v := a(dr), b(dr)
+				if u == true || v == true {
+					return true
+				}
+				if u == nil || v == nil {
+					return nil
+				}
+				return false
+			}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	x, err := p.not()
+	for err == nil && p.accept("and") {
+		var y filterExpr
+		if y, err = p.not(); err == nil {
+			a, b := x, y
+			x = func(dr *DataRow) interface{} {
+				u, v := a(dr), b(dr)
+				if u == false || v == false {
+					return false
+				}
+				if u == nil || v == nil {
+					return nil
+				}
+				return true
+			}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) not() (filterExpr, error) {
+	if p.accept("not") {
+		x, err := p.not()
+		return func(dr *DataRow) interface{} {
+			if v, ok := x(dr).(bool); ok {
+				return !v
+			}
+			return nil
+		}, err
+	}
+	return p.comparison()
+}
+
+// negate returns the opposite of a condition, keeping NULL
+func negate(x filterExpr, not bool) filterExpr {
+	if !not {
+		return x
+	}
+	return func(dr *DataRow) interface{} {
+		if v, ok := x(dr).(bool); ok {
+			return !v
+		}
+		return nil
+	}
+}
+
+func (p *filterParser) comparison() (filterExpr, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "=", "==", "<>", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		y, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return func(dr *DataRow) interface{} {
+			a, b := x(dr), y(dr)
+			if a == nil || b == nil {
+				return nil
+			}
+			cmp, ok := compareValues(a, b)
+			if !ok {
+				return op == "<>" || op == "!="
+			}
+			switch op {
+			case "=", "==":
+				return cmp == 0
+			case "<>", "!=":
+				return cmp != 0
+			case "<":
+				return cmp < 0
+			case "<=":
+				return cmp <= 0
+			case ">":
+				return cmp > 0
+			}
+			return cmp >= 0
+		}, nil
+	case "is":
+		p.pos++
+		not := p.accept("not")
+		if !p.accept("null") {
+			return nil, errors.New("null expected after is")
+		}
+		return negate(func(dr *DataRow) interface{} { return x(dr) == nil }, not), nil
+	}
+	not := p.accept("not")
+	switch {
+	case p.accept("like"):
+		y, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return negate(func(dr *DataRow) interface{} {
+			a, b := x(dr), y(dr)
+			if a == nil || b == nil {
+				return nil
+			}
+			return like(csvString(a, &CSVOptions{}), csvString(b, &CSVOptions{}))
+		}, not), nil
+	case p.accept("in"):
+		if !p.accept("(") {
+			return nil, errors.New("( expected after in")
+		}
+		list := []filterExpr{}
+		for {
+			y, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, y)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, errors.New(", or ) expected in list")
+			}
+		}
+		return negate(func(dr *DataRow) interface{} {
+			a := x(dr)
+			if a == nil {
+				return nil
+			}
+			var ret interface{} = false
+			for _, y := range list {
+				b := y(dr)
+				if b == nil {
+					ret = nil
+				} else if cmp, ok := compareValues(a, b); ok && cmp == 0 {
+					return true
+				}
+			}
+			return ret
+		}, not), nil
+	case p.accept("between"):
+		low, err := p.operand()
+		if err == nil && !p.accept("and") {
+			err = errors.New("and expected in between")
+		}
+		var high filterExpr
+		if err == nil {
+			high, err = p.operand()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return negate(func(dr *DataRow) interface{} {
+			a, l, h := x(dr), low(dr), high(dr)
+			if a == nil || l == nil || h == nil {
+				return nil
+			}
+			c1, ok1 := compareValues(a, l)
+			c2, ok2 := compareValues(a, h)
+			return ok1 && ok2 && c1 >= 0 && c2 <= 0
+		}, not), nil
+	case not:
+		return nil, errors.New("like, in or between expected after not")
+	}
+	return x, nil // A boolean column or value
+}
+
+func (p *filterParser) operand() (filterExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch lt := strings.ToLower(t); {
+	case t == "(":
+		x, err := p.or()
+		if err == nil && !p.accept(")") {
+			err = errors.New(") expected")
+		}
+		return x, err
+	case t == "?":
+		if p.arg >= len(p.args) {
+			p.arg++
+			return nil, errors.New("missing argument for ?")
+		}
+		v := p.args[p.arg]
+		p.arg++
+		return func(*DataRow) interface{} { return v }, nil
+	case t[0] == '\'':
+		v := strings.Replace(t[1:len(t)-1], "''", "'", -1)
+		return func(*DataRow) interface{} { return v }, nil
+	case lt == "null":
+		return func(*DataRow) interface{} { return nil }, nil
+	case lt == "true" || lt == "false":
+		v := lt == "true"
+		return func(*DataRow) interface{} { return v }, nil
+	case t[0] == '-' || t[0] >= '0' && t[0] <= '9':
+		if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return func(*DataRow) interface{} { return i }, nil
+		}
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, errors.New("invalid number " + t)
+		}
+		return func(*DataRow) interface{} { return f }, nil
+	case strings.ContainsAny(t[:1], "(),=<>!"):
+		return nil, errors.New("unexpected " + t)
+	}
+	name := t
+	if t[0] == '[' {
+		name = t[1 : len(t)-1]
+	}
+	c := p.dt.ColumnIndex(name)
+	if c < 0 {
+		return nil, errors.New("unknown column " + name)
+	}
+	return func(dr *DataRow) interface{} { return dr.items[c] }, nil
+}
+
+// like tells if s matches a SQL like pattern, where % matches any text and _ any character.
+// The comparison is not case sensitive.
+func like(s string, pattern string) bool {
+	sr, pr := []rune(strings.ToLower(s)), []rune(strings.ToLower(pattern))
+	// Classic wildcard matching with backtracking on the last %
+	si, pi, star, mark := 0, 0, -1, 0
+	for si < len(sr) {
+		switch {
+		case pi < len(pr) && (pr[pi] == '_' || pr[pi] == sr[si]):
+			si++
+			pi++
+		case pi < len(pr) && pr[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+	return pi == len(pr)
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// people returns a table of people, with NULL values and names needing brackets
+func people() *DataTable {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "name", GoType: TypeString},
+		{Name: "age", GoType: TypeInt64},
+		{Name: "country", GoType: TypeString},
+		{Name: "rate", GoType: TypeFloat64},
+		{Name: "born", GoType: TypeTime},
+		{Name: "active", GoType: TypeBool},
+		{Name: "first name", GoType: TypeString},
+	}}
+	day := func(y int) time.Time { return time.Date(y, 1, 2, 0, 0, 0, 0, time.UTC) }
+	rows := [][]interface{}{
+		{"Ann", int64(30), "FR", 1.5, day(1987), true, "A"},
+		{"bob", int64(17), "US", 2.0, day(2000), false, "B"},
+		{"Carl", nil, "FR", nil, nil, nil, nil},
+		{"dora", int64(45), "DE", 0.5, day(1972), true, "D"},
+		{"Ed's", int64(30), nil, 1.5, day(1987), false, "E"},
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+// names returns the names of the rows of a view
+func names(dv *DataView) string {
+	s := []string{}
+	for _, dr := range dv.Rows() {
+		s = append(s, dr.GetStringOr("name", "?"))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestFilter(t *testing.T) {
+	dt := people()
+	tests := []struct {
+		filter string
+		args   []interface{}
+		want   string
+	}{
+		{"age >= 18", nil, "Ann,dora,Ed's"},
+		{"age < 18 or age is null", nil, "bob,Carl"},
+		{"country = 'FR' and age = 30", nil, "Ann"},
+		{"country <> 'FR'", nil, "bob,dora"}, // NULL is not different from FR
+		{"not country = 'FR'", nil, "bob,dora"},
+		{"country != ?", []interface{}{"US"}, "Ann,Carl,dora"},
+		{"country is not null and not (age > 20)", nil, "bob"},
+		{"name like '%a%'", nil, "Ann,Carl,dora"}, // Not case sensitive
+		{"name not like '_o%'", nil, "Ann,Carl,Ed's"},
+		{"name = 'Ed''s'", nil, "Ed's"},
+		{"country in ('DE', 'US', null)", nil, "bob,dora"},
+		{"country not in ('DE', 'US')", nil, "Ann,Carl"},
+		{"age between 17 and ?", []interface{}{30}, "Ann,bob,Ed's"},
+		{"age not between 18 and 40", nil, "bob,dora"},
+		{"rate = 1.5", nil, "Ann,Ed's"},
+		{"rate > 1", nil, "Ann,bob,Ed's"},
+		{"age = '30'", nil, "Ann,Ed's"},
+		{"born < '1980-01-01'", nil, "dora"},
+		{"born = ?", []interface{}{time.Date(1987, 1, 2, 0, 0, 0, 0, time.FixedZone("X", 0))}, "Ann,Ed's"},
+		{"active", nil, "Ann,dora"},
+		{"active = false", nil, "bob,Ed's"},
+		{"not active", nil, "bob,Ed's"},
+		{"[first name] = 'B' or \"first name\" = 'D'", nil, "bob,dora"},
+		{"`first name` IN ('A') OR age >= -1 AND age < 18", nil, "Ann,bob"},
+		{"name <> 12", nil, "Ann,bob,Carl,dora,Ed's"}, // A name is not a number
+	}
+	for _, x := range tests {
+		dv := dt.View().Filter(x.filter, x.args...)
+		if dv.Err() != nil {
+			t.Errorf("%s: %v", x.filter, dv.Err())
+		} else if got := names(dv); got != x.want {
+			t.Errorf("%s: got %s, want %s", x.filter, got, x.want)
+		}
+	}
+
+	if got := names(dt.View().Filter(func(dr *DataRow) bool { return dr.GetInt64Or("age", 0) > 40 })); got != "dora" {
+		t.Errorf("func: %s", got)
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	dt := people()
+	for _, f := range []string{
+		"nope = 1",
+		"Name = 'Ann'",
+		"age =",
+		"age = 1 and",
+		"(age = 1",
+		"age = 1)",
+		"name = 'x",
+		"[name = 1",
+		"age is 1",
+		"age not = 1",
+		"age in 1",
+		"age in (1 2)",
+		"age between 1",
+		"age = ?",
+		"age = 1.2.3",
+		"age # 1",
+	} {
+		if dv := dt.View().Filter(f); dv.Err() == nil {
+			t.Errorf("%s: no error", f)
+		} else if !strings.HasPrefix(dv.Err().Error(), "Filter:") {
+			t.Errorf("%s: %v", f, dv.Err())
+		}
+	}
+	if dv := dt.View().Filter("age = 1", 2); dv.Err() == nil {
+		t.Error("too many arguments")
+	}
+	if dv := dt.View().Filter(12); dv.Err() == nil {
+		t.Error("invalid filter type")
+	}
+
+	// The first error is kept by the following methods
+	dv := dt.View().Filter("nope = 1").Sort("name").Page(1, 2)
+	if dv.Err() == nil || !strings.Contains(dv.Err().Error(), "nope") {
+		t.Errorf("error lost: %v", dv.Err())
+	}
+}
+
+func TestSort(t *testing.T) {
+	dt := people()
+	tests := []struct{ spec, want string }{
+		{"name", "Ann,Carl,Ed's,bob,dora"},
+		{"age", "Carl,bob,Ann,Ed's,dora"},
+		{"age desc", "dora,Ann,Ed's,bob,Carl"},
+		{"age nulls last", "bob,Ann,Ed's,dora,Carl"},
+		{"age desc nulls first, name desc", "Carl,dora,Ed's,Ann,bob"},
+		{"rate, born desc", "Carl,dora,Ann,Ed's,bob"},
+		{"country asc, [first name] DESC", "Ed's,dora,Ann,Carl,bob"},
+		{"active, name", "Carl,Ed's,bob,Ann,dora"},
+	}
+	for _, x := range tests {
+		dv := dt.View().Sort(x.spec)
+		if dv.Err() != nil {
+			t.Errorf("%s: %v", x.spec, dv.Err())
+		} else if got := names(dv); got != x.want {
+			t.Errorf("%s: got %s, want %s", x.spec, got, x.want)
+		}
+	}
+	for _, spec := range []string{"", "name,", "nope", "name up", "name nulls", "[first name"} {
+		if dt.View().Sort(spec).Err() == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+	dv := dt.View().SortBy(SortKey{Column: "country", Nulls: NullsLast}, SortKey{Column: "age", Desc: true})
+	if got := names(dv); got != "dora,Ann,Carl,bob,Ed's" {
+		t.Errorf("SortBy: %s", got)
+	}
+	if dt.View().SortBy(SortKey{Column: "nope"}).Err() == nil {
+		t.Error("SortBy unknown column")
+	}
+
+	// The table is not sorted
+	if dt.Rows[0].GetStringOr("name", "") != "Ann" || dt.Rows[1].GetStringOr("name", "") != "bob" {
+		t.Error("table changed")
+	}
+}
+
+func TestDistinctAndPage(t *testing.T) {
+	dt := people()
+	if got := names(dt.View().Distinct("age")); got != "Ann,bob,Carl,dora" {
+		t.Errorf("distinct age: %s", got)
+	}
+	if got := names(dt.View().Distinct("rate", "born")); got != "Ann,bob,Carl,dora" {
+		t.Errorf("distinct rate, born: %s", got)
+	}
+	if n := dt.View().Distinct().Count(); n != 5 {
+		t.Errorf("distinct rows: %d", n)
+	}
+	if dt.View().Distinct("nope").Err() == nil {
+		t.Error("unknown column")
+	}
+
+	dv := dt.View().Sort("name")
+	if dv.PageCount(2) != 3 || dv.PageCount(5) != 1 || dv.PageCount(0) != 0 {
+		t.Errorf("page counts %d %d", dv.PageCount(2), dv.PageCount(5))
+	}
+	for page, want := range []string{"Ann,Carl", "Ed's,bob", "dora", ""} {
+		if got := names(dv.Page(page+1, 2)); got != want {
+			t.Errorf("page %d: %s", page+1, got)
+		}
+	}
+	if dv.Page(0, 2).Err() == nil || dv.Page(1, 0).Err() == nil {
+		t.Error("invalid page")
+	}
+}
+
+func TestView(t *testing.T) {
+	dt := people()
+	dt.DeleteRow(1)
+	dv := dt.View()
+	if dv.Count() != 4 || names(dv) != "Ann,Carl,dora,Ed's" {
+		t.Errorf("deleted row in view: %s", names(dv))
+	}
+
+	// Rows are shared with the table
+	dv.Row(0).Set("age", 31)
+	if dt.Rows[0].items[1] != int64(31) {
+		t.Error("row not shared")
+	}
+
+	// ToTable copies the rows
+	cp := dv.Filter("age > 30").ToTable()
+	if len(cp.Rows) != 2 || !reflect.DeepEqual(cp.ColumnNames(), dt.ColumnNames()) || cp.Rows[0].State() != RowUnchanged {
+		t.Fatalf("ToTable: %d rows", len(cp.Rows))
+	}
+	cp.Rows[0].Set("age", 99)
+	if dt.Rows[0].items[1] != int64(31) || cp.ColumnIndex("age") != 1 {
+		t.Error("ToTable shares the rows")
+	}
+}
+
+func TestLike(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "ABC", true},
+		{"abc", "a%", true},
+		{"abc", "%c", true},
+		{"abc", "%b%", true},
+		{"abc", "a_c", true},
+		{"abc", "a_", false},
+		{"abc", "%%%", true},
+		{"", "%", true},
+		{"", "_", false},
+		{"aXbXc", "a%b%c", true},
+		{"aXbXd", "a%b%c", false},
+		{"éte", "_te", true},
+	}
+	for _, x := range tests {
+		if got := like(x.s, x.pattern); got != x.want {
+			t.Errorf("like(%q, %q) = %v", x.s, x.pattern, got)
+		}
+	}
+}
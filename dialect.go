@@ -0,0 +1,95 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"time"
+)
+
+// DBColumn describes a column as read from the database
+type DBColumn struct {
+	Name          string
+	Type          string // Type as declared in the database
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// DBIndex describes an index as read from the database
+type DBIndex struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Primary bool
+}
+
+// Dialect holds everything the SQLMapper needs to know about a database:
+// quoting, type mapping, parameter binding, introspection and DDL.
+// A new database only needs a Dialect to be supported by SQLMapper.
+//
+// Statements returned by the DDL methods are executed as they are,
+// an empty statement means the change cannot be done in place
+// (see TableRebuilder).
+type Dialect interface {
+	// DriverName returns the name of the database/sql driver
+	DriverName() string
+	// Quote quotes a table, column or index name
+	Quote(name string) string
+	// Placeholder returns the placeholder of the nth (1 based) statement argument
+	Placeholder(n int) string
+	// SQLType returns the database type of a field
+	SQLType(fd FieldDef) string
+	// SameType tells if a type read from the database matches the type of a field
+	SameType(dbType string, fd FieldDef) bool
+	// BindValue converts a field value to a statement argument
+	BindValue(fd FieldDef, v interface{}) interface{}
+	// DateLiteral formats a time as an SQL literal
+	DateLiteral(t time.Time) string
+	// OnOpen prepares a newly opened connection
+	OnOpen(cn *Conn) error
+
+	// Tables returns the names of the tables of the database
+	Tables(cn *Conn) ([]string, error)
+	// Columns returns the columns of a table
+	Columns(cn *Conn, table string) ([]DBColumn, error)
+	// Indexes returns the indexes of a table
+	Indexes(cn *Conn, table string) ([]DBIndex, error)
+
+	CreateTableSQL(td TableDef) string
+	// AddColumnSQL adds a column after column after ("" for none)
+	AddColumnSQL(td TableDef, fd FieldDef, after string) string
+	ModifyColumnSQL(td TableDef, fd FieldDef) string
+	RenameColumnSQL(td TableDef, from string, to string) string
+	DropColumnSQL(td TableDef, column string) string
+	// IndexName returns the name of the index the mapper creates for a column
+	IndexName(td TableDef, column string) string
+	CreateIndexSQL(td TableDef, fd FieldDef) string
+	DropIndexSQL(td TableDef, index string) string
+	DropTableSQL(table string) string
+	// UpsertSQL returns a statement inserting or updating a row by primary key.
+	// Like the TableDef statements, it takes its parameters as @FieldName.
+	UpsertSQL(td TableDef) string
+}
+
+// TableRebuilder is implemented by the dialects that apply the changes
+// they cannot do in place by recreating the table.
+// Columns unknown to td are kept unless dropOrphans is set.
+type TableRebuilder interface {
+	RebuildTable(cn *Conn, td TableDef, dropOrphans bool) error
+}
+
+// Returning is implemented by the dialects whose driver does not support
+// sql.Result.LastInsertId. The generated key is read from the result of the
+// insert statement followed by ReturningSQL.
+type Returning interface {
+	ReturningSQL(td TableDef) string
+}
+
+// ConnectionInitializer is implemented by the dialects that set up each connection
+// of the pool, for instance with the pragmas or session variables of a Config
+type ConnectionInitializer interface {
+	InitSQL(cfg Config) []string
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status is the connectivity of a connection, as seen by the last health check
+type Status struct {
+	Up         bool      `json:"up"`
+	Checked    time.Time `json:"checked"`         // Time of the last check, zero if none
+	Since      time.Time `json:"since"`           // Time of the last change of Up
+	Error      string    `json:"error,omitempty"` // Error of the last check
+	Failures   int       `json:"failures"`        // Consecutive failed checks
+	Reconnects int       `json:"reconnects"`      // Number of times the connection came back up
+	Monitored  bool      `json:"monitored"`       // A background monitor is running
+}
+
+// MonitorOptions sets the behavior of Conn.Monitor. Zero values take their default.
+type MonitorOptions struct {
+	Interval   time.Duration // Between checks while up, 10s by default
+	Timeout    time.Duration // Of each check, 5s by default
+	MinBackoff time.Duration // First wait after a failure, 1s by default; it doubles after each failure
+	MaxBackoff time.Duration // Longest wait between checks while down, 1 minute by default
+	OnChange   func(Status)  // Called when the connection goes down or comes back up
+}
+
+// health holds the status and the monitor of a connection
+type health struct {
+	name     string // Used in the log, "connection" by default
+	lock     sync.Mutex
+	status   Status
+	stop     chan struct{}
+	done     chan struct{}
+	onChange func(Status)
+}
+
+// HealthCheck pings the database and records the result in the status of the connection.
+// The pool of database/sql replaces broken connections, so a successful check after a
+// failure means that the connection was re-established.
+func (cn *Conn) HealthCheck(ctx context.Context) error {
+	if cn == nil || cn.DB == nil {
+		return ErrNoConnection
+	}
+	err := cn.DB.PingContext(ctx)
+	if cn.health != nil {
+		cn.health.record(err)
+	}
+	return err
+}
+
+// Ping checks the connection with a timeout of 5 seconds
+func (cn *Conn) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return cn.HealthCheck(ctx)
+}
+
+func (h *health) record(err error) {
+	h.lock.Lock()
+	s := &h.status
+	now := time.Now()
+	wasUp, checked := s.Up, !s.Checked.IsZero()
+	s.Checked = now
+	if err == nil {
+		s.Up, s.Error, s.Failures = true, "", 0
+	} else {
+		s.Up, s.Error = false, err.Error()
+		s.Failures++
+	}
+	changed := s.Up != wasUp || !checked
+	if changed {
+		s.Since = now
+		if s.Up && checked {
+			s.Reconnects++
+		}
+	}
+	status, onChange := *s, h.onChange
+	h.lock.Unlock()
+
+	if changed && (checked || !status.Up) { // Not when the first check succeeds
+		if LogErrors {
+			name := h.name
+			if name == "" {
+				name = "connection"
+			}
+			if status.Up {
+				log.Print("sedi: " + name + " is back up")
+			} else {
+				log.Print("sedi: " + name + " is down: " + status.Error)
+			}
+		}
+		if onChange != nil {
+			onChange(status)
+		}
+	}
+}
+
+// Status returns the status of the connection. Without monitor, it is the result of the last
+// call to Ping or HealthCheck, or of a new check if there was none.
+func (cn *Conn) Status() Status {
+	if cn == nil || cn.DB == nil {
+		return Status{Error: ErrNoConnection.Error()}
+	}
+	if cn.health == nil {
+		s := Status{Checked: time.Now()}
+		if err := cn.Ping(); err != nil {
+			s.Error = err.Error()
+		} else {
+			s.Up = true
+		}
+		return s
+	}
+	cn.health.lock.Lock()
+	s := cn.health.status
+	cn.health.lock.Unlock()
+	if s.Checked.IsZero() {
+		cn.Ping()
+		cn.health.lock.Lock()
+		s = cn.health.status
+		cn.health.lock.Unlock()
+	}
+	return s
+}
+
+// Monitor checks the connection in the background. While it is down, checks are
+// retried with an exponential backoff until the database answers again.
+// Calling Monitor again restarts the monitor with the new options.
+func (cn *Conn) Monitor(opt MonitorOptions) {
+	if cn == nil || cn.health == nil {
+		return
+	}
+	cn.StopMonitor()
+	if opt.Interval <= 0 {
+		opt.Interval = 10 * time.Second
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 5 * time.Second
+	}
+	if opt.MinBackoff <= 0 {
+		opt.MinBackoff = time.Second
+	}
+	if opt.MaxBackoff < opt.MinBackoff {
+		opt.MaxBackoff = time.Minute
+		if opt.MaxBackoff < opt.MinBackoff {
+			opt.MaxBackoff = opt.MinBackoff
+		}
+	}
+	h := cn.health
+	h.lock.Lock()
+	h.stop, h.done = make(chan struct{}), make(chan struct{})
+	h.onChange = opt.OnChange
+	h.status.Monitored = true
+	stop, done := h.stop, h.done
+	h.lock.Unlock()
+
+	go func() {
+		defer close(done)
+		backoff := opt.MinBackoff
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), opt.Timeout)
+			err := cn.HealthCheck(ctx)
+			cancel()
+			wait := opt.Interval
+			if err != nil {
+				wait = backoff
+				if backoff *= 2; backoff > opt.MaxBackoff {
+					backoff = opt.MaxBackoff
+				}
+			} else {
+				backoff = opt.MinBackoff
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// StopMonitor stops the background monitor, if any
+func (cn *Conn) StopMonitor() {
+	if cn == nil || cn.health == nil {
+		return
+	}
+	h := cn.health
+	h.lock.Lock()
+	stop, done := h.stop, h.done
+	h.stop, h.done = nil, nil
+	h.status.Monitored = false
+	h.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// StatusHandler returns an http.Handler for readiness probes. It answers the
+// status of the connection in JSON, with code 200 when it is up and 503 otherwise.
+func (cn *Conn) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := cn.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if s.Up {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(s)
+	})
+}
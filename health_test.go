@@ -0,0 +1,204 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDriver is a database/sql driver whose database can be taken down and brought back up
+type flakyDriver struct{ down int32 }
+
+var flaky = &flakyDriver{}
+
+func init() {
+	sql.Register("sedi_flaky", flaky)
+}
+
+var errDown = errors.New("database is down")
+
+func (d *flakyDriver) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&d.down, 1)
+	} else {
+		atomic.StoreInt32(&d.down, 0)
+	}
+}
+
+func (d *flakyDriver) isDown() bool { return atomic.LoadInt32(&d.down) == 1 }
+
+func (d *flakyDriver) Open(name string) (driver.Conn, error) {
+	if d.isDown() {
+		return nil, errDown
+	}
+	return flakyConn{d}, nil
+}
+
+type flakyConn struct{ d *flakyDriver }
+
+func (c flakyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c flakyConn) Close() error              { return nil }
+func (c flakyConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c flakyConn) Ping(ctx context.Context) error {
+	if c.d.isDown() {
+		return errDown
+	}
+	return nil
+}
+
+func openFlaky(t *testing.T) *Conn {
+	LogErrors = false
+	flaky.setDown(false)
+	cn, err := OpenConnection("sedi_flaky", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cn.Close)
+	return &cn
+}
+
+func TestHealthCheck(t *testing.T) {
+	cn := openFlaky(t)
+	if err := cn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cn.Status(); !s.Up || s.Failures != 0 || s.Reconnects != 0 || s.Checked.IsZero() {
+		t.Errorf("up: %+v", s)
+	}
+
+	flaky.setDown(true)
+	for i := 1; i <= 2; i++ {
+		if err := cn.HealthCheck(context.Background()); err == nil {
+			t.Fatal("no error while down")
+		}
+		if s := cn.Status(); s.Up || s.Failures != i || s.Error == "" {
+			t.Errorf("down: %+v", s)
+		}
+	}
+
+	flaky.setDown(false)
+	if err := cn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cn.Status(); !s.Up || s.Failures != 0 || s.Reconnects != 1 || s.Error != "" {
+		t.Errorf("back up: %+v", s)
+	}
+}
+
+func TestHealthNoConnection(t *testing.T) {
+	var cn *Conn
+	if err := cn.Ping(); err != ErrNoConnection {
+		t.Errorf("nil connection: %v", err)
+	}
+	if s := (&Conn{}).Status(); s.Up || s.Error != ErrNoConnection.Error() {
+		t.Errorf("zero connection: %+v", s)
+	}
+	cn.Monitor(MonitorOptions{}) // Does nothing
+	cn.StopMonitor()
+}
+
+func TestRecord(t *testing.T) {
+	var changes []Status
+	h := &health{onChange: func(s Status) { changes = append(changes, s) }}
+	h.record(nil) // The first successful check is not a change
+	h.record(nil)
+	h.record(errDown)
+	h.record(errDown)
+	h.record(nil)
+	if len(changes) != 2 || changes[0].Up || changes[0].Failures != 1 || !changes[1].Up || changes[1].Reconnects != 1 {
+		t.Fatalf("changes %+v", changes)
+	}
+
+	// A connection down at the first check is reported, and coming up counts as a reconnection
+	changes = nil
+	h = &health{onChange: func(s Status) { changes = append(changes, s) }}
+	h.record(errDown)
+	h.record(nil)
+	if len(changes) != 2 || changes[0].Up || !changes[1].Up || changes[1].Reconnects != 1 {
+		t.Fatalf("changes %+v", changes)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	cn := openFlaky(t)
+	var lock sync.Mutex
+	changes := []bool{}
+	changed := make(chan struct{}, 10)
+	cn.Monitor(MonitorOptions{
+		Interval:   5 * time.Millisecond,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		OnChange: func(s Status) {
+			lock.Lock()
+			changes = append(changes, s.Up)
+			lock.Unlock()
+			changed <- struct{}{}
+		}})
+	if !cn.Status().Monitored {
+		t.Error("not monitored")
+	}
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change after " + what)
+		}
+	}
+	flaky.setDown(true)
+	wait("going down")
+	flaky.setDown(false)
+	wait("coming back up")
+
+	cn.StopMonitor()
+	s := cn.Status()
+	if s.Monitored || !s.Up || s.Reconnects != 1 {
+		t.Errorf("after stop: %+v", s)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("changes %v", changes)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	cn := openFlaky(t)
+	get := func() (int, Status) {
+		w := httptest.NewRecorder()
+		cn.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		var s Status
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type %s", ct)
+		}
+		return w.Code, s
+	}
+	if code, s := get(); code != http.StatusOK || !s.Up {
+		t.Errorf("up: %d %+v", code, s)
+	}
+	flaky.setDown(true)
+	defer flaky.setDown(false)
+	cn.Ping()
+	if code, s := get(); code != http.StatusServiceUnavailable || s.Up || s.Error == "" {
+		t.Errorf("down: %d %+v", code, s)
+	}
+}
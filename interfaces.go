@@ -11,3 +11,19 @@ type SQLMapperCRUD interface {
 	Update(st interface{}) error
 	Delete(st interface{}) error
 }
+
+// Mapper is the interface of the mappers returned by Open: model
+// registration, model update and CRUD
+type Mapper interface {
+	SQLMapperCRUD
+	Upsert(st interface{}) error
+	AddPersistence(st interface{}) *SQLMapper
+	Err() error
+	ModelIsUpToDate() (bool, error)
+	UpdateModel() error
+	Connection() *Conn
+	CloseConnection()
+	Status() Status
+}
+
+var _ Mapper = (*SQLMapper)(nil)
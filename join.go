@@ -0,0 +1,387 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefpo/sedi/conv"
+)
+
+// JoinType is the kind of a join between two DataTables
+type JoinType int
+
+const (
+	InnerJoin JoinType = iota // The rows having a match in both tables
+	LeftJoin                  // All the rows of the left table
+	FullJoin                  // All the rows of both tables
+)
+
+// joinKeys reads the key columns of a join: "id" for columns of the same name in both tables,
+// "customer_id=id" for a column of the left table and a column of the right table
+func joinKeys(left *DataTable, right *DataTable, on []string) (lcols []int, rcols []int, err error) {
+	if len(on) == 0 {
+		return nil, nil, errors.New("no key column")
+	}
+	for _, k := range on {
+		ln, rn := k, k
+		if i := strings.Index(k, "="); i >= 0 {
+			ln, rn = strings.TrimSpace(k[:i]), strings.TrimSpace(k[i+1:])
+		}
+		l, r := left.ColumnIndex(ln), right.ColumnIndex(rn)
+		if l < 0 || r < 0 {
+			return nil, nil, errors.New("unknown key column " + k)
+		}
+		lcols, rcols = append(lcols, l), append(rcols, r)
+	}
+	return lcols, rcols, nil
+}
+
+// keyKind returns 'n' for a column of numbers, 't' for a column of times and 0 otherwise.
+// An untyped column has the kind of its first value.
+func keyKind(dt *DataTable, c int) byte {
+	switch dt.Columns[c].GoType {
+	case TypeInt64, TypeFloat64:
+		return 'n'
+	case TypeTime:
+		return 't'
+	case "":
+		for i := range dt.Rows {
+			switch v := dt.Rows[i].items[c]; v.(type) {
+			case nil:
+				continue
+			case time.Time:
+				return 't'
+			default:
+				if isNumber(v) {
+					return 'n'
+				}
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// keyConversions returns the kinds to which the strings of the keys are converted so that the
+// values that compare equal have equal keys: the strings of a column joined with a column of
+// numbers or times are read as numbers or times, like compareValues does.
+func keyConversions(left *DataTable, lcols []int, right *DataTable, rcols []int) []byte {
+	as := make([]byte, len(lcols))
+	for k := range lcols {
+		lk, rk := keyKind(left, lcols[k]), keyKind(right, rcols[k])
+		if lk == 0 || rk == 0 || lk == rk {
+			as[k] = lk | rk
+		}
+	}
+	return as
+}
+
+// joinKey returns the key of the values of some columns of a row, the strings converted
+// to the given kinds when they hold a number or a time
+func joinKey(dr *DataRow, cols []int, as []byte) string {
+	var b strings.Builder
+	for i, c := range cols {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		v := dr.items[c]
+		if s, ok := v.(string); ok && as[i] == 'n' {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				v = f
+			}
+		} else if ok && as[i] == 't' {
+			if t, err := parseTime(s); err == nil {
+				v = t
+			}
+		}
+		b.WriteString(valueKey(v))
+	}
+	return b.String()
+}
+
+// hasNull tells if a row has a NULL value in some columns
+func hasNull(dr *DataRow, cols []int) bool {
+	for _, c := range cols {
+		if dr.items[c] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Join returns the join of the table with another table on key columns, given as "id" when they
+// have the same name in both tables, or as "left_column=right_column". The rows match when their
+// keys are equal; NULL keys never match, as in SQL. Keys are compared like in a filter: a string
+// matches the number or the time it holds.
+//
+// The result has the columns of the table, then the columns of the other table. The key columns
+// of the same name appear once and, in a full join, hold the key of the other table for its rows
+// without match. The other columns whose name is already used get a suffix: name_2, name_3...
+//
+//	dt, err := orders.Join(&customers, sedi.LeftJoin, "customer_id=id")
+func (dt *DataTable) Join(other *DataTable, kind JoinType, on ...string) (DataTable, error) {
+	var ret DataTable
+	lcols, rcols, err := joinKeys(dt, other, on)
+	if err != nil {
+		return ret, errors.New("Join: " + err.Error())
+	}
+
+	// Columns of the result: rmap gives the position of the columns of the other table, and
+	// coalesce the column of the other table filling the key columns of the same name
+	ret.Clear()
+	used := map[string]bool{}
+	for _, c := range dt.Columns {
+		if kind == FullJoin {
+			c.Nullable = true
+		}
+		ret.Columns = append(ret.Columns, c)
+		used[strings.ToLower(c.Name)] = true
+	}
+	rmap := make([]int, len(other.Columns))
+	coalesce := map[int]int{}
+	for i, c := range other.Columns {
+		rmap[i] = -1
+		shared := false
+		for k := range rcols {
+			if rcols[k] == i && dt.Columns[lcols[k]].Name == c.Name {
+				shared = true
+				coalesce[lcols[k]] = i
+			}
+		}
+		if shared {
+			continue
+		}
+		name := c.Name
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = c.Name + "_" + strconv.Itoa(n)
+		}
+		used[strings.ToLower(name)] = true
+		c.Name = name
+		c.Nullable = c.Nullable || kind != InnerJoin
+		rmap[i] = len(ret.Columns)
+		ret.Columns = append(ret.Columns, c)
+	}
+	ret.initColumns()
+
+	addRow := func(l *DataRow, r *DataRow) {
+		dr := ret.NewRow()
+		if l != nil {
+			copy(dr.items, l.items)
+		}
+		if r != nil {
+			for i, v := range r.items {
+				if rmap[i] >= 0 {
+					dr.items[rmap[i]] = v
+				}
+			}
+			if l == nil {
+				for lc, rc := range coalesce {
+					dr.items[lc] = r.items[rc]
+				}
+			}
+		}
+		dr.state = RowUnchanged
+		ret.AddRow(dr)
+	}
+
+	// Hash join on the keys of the other table
+	as := keyConversions(dt, lcols, other, rcols)
+	index := map[string][]int{}
+	for i := range other.Rows {
+		r := &other.Rows[i]
+		if r.state != RowDeleted && !hasNull(r, rcols) {
+			key := joinKey(r, rcols, as)
+			index[key] = append(index[key], i)
+		}
+	}
+	matched := make([]bool, len(other.Rows))
+	for i := range dt.Rows {
+		l := &dt.Rows[i]
+		if l.state == RowDeleted {
+			continue
+		}
+		var found []int
+		if !hasNull(l, lcols) {
+			found = index[joinKey(l, lcols, as)]
+		}
+		for _, j := range found {
+			matched[j] = true
+			addRow(l, &other.Rows[j])
+		}
+		if len(found) == 0 && kind != InnerJoin {
+			addRow(l, nil)
+		}
+	}
+	if kind == FullJoin {
+		for j := range other.Rows {
+			if !matched[j] && other.Rows[j].state != RowDeleted {
+				addRow(nil, &other.Rows[j])
+			}
+		}
+	}
+	return ret, nil
+}
+
+// unionColumns checks that two tables have the same columns and returns the columns of their union.
+// Columns match by position and name; int64 and float64 columns make a float64 column.
+func unionColumns(a *DataTable, b *DataTable) ([]DataColumn, error) {
+	if len(a.Columns) != len(b.Columns) {
+		return nil, errors.New("tables have " + strconv.Itoa(len(a.Columns)) + " and " + strconv.Itoa(len(b.Columns)) + " columns")
+	}
+	cols := make([]DataColumn, len(a.Columns))
+	for i, c := range a.Columns {
+		d := b.Columns[i]
+		if !strings.EqualFold(c.Name, d.Name) {
+			return nil, errors.New("column " + c.Name + " does not match column " + d.Name)
+		}
+		switch {
+		case c.GoType == d.GoType:
+		case c.GoType == "":
+		case d.GoType == "":
+			c.GoType = ""
+		case (c.GoType == TypeInt64 || c.GoType == TypeFloat64) && (d.GoType == TypeInt64 || d.GoType == TypeFloat64):
+			c.GoType = TypeFloat64
+		default:
+			return nil, errors.New("column " + c.Name + " is " + c.GoType + " and " + d.GoType)
+		}
+		if c.GoType != a.Columns[i].GoType || c.GoType != d.GoType {
+			c.DBType, c.Length, c.Precision, c.Scale = "", 0, 0, 0
+		}
+		c.Nullable = c.Nullable || d.Nullable
+		cols[i] = c
+	}
+	return cols, nil
+}
+
+// Union returns the rows of the table and of another table with the same columns,
+// without duplicates, see UnionAll
+func (dt *DataTable) Union(other *DataTable) (DataTable, error) {
+	return dt.union(other, true)
+}
+
+// UnionAll returns the rows of the table followed by the rows of another table. The tables must
+// have the same columns, in the same order, with the same names and compatible types: equal,
+// one of them unknown, or int64 and float64 giving float64.
+func (dt *DataTable) UnionAll(other *DataTable) (DataTable, error) {
+	return dt.union(other, false)
+}
+
+func (dt *DataTable) union(other *DataTable, distinct bool) (DataTable, error) {
+	var ret DataTable
+	cols, err := unionColumns(dt, other)
+	if err != nil {
+		return ret, errors.New("Union: " + err.Error())
+	}
+	ret.Clear()
+	ret.Columns = cols
+	ret.initColumns()
+	all := make([]int, len(cols))
+	for i := range all {
+		all[i] = i
+	}
+	seen := map[string]bool{}
+	for _, t := range []*DataTable{dt, other} {
+		for i := range t.Rows {
+			if t.Rows[i].state == RowDeleted {
+				continue
+			}
+			dr := ret.NewRow()
+			for c, v := range t.Rows[i].items {
+				if v != nil && cols[c].GoType == TypeFloat64 {
+					v = conv.ToFloat64(v)
+				}
+				dr.items[c] = v
+			}
+			if distinct {
+				key := rowKey(&dr, all)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			dr.state = RowUnchanged
+			ret.AddRow(dr)
+		}
+	}
+	return ret, nil
+}
+
+// Merge updates the table with the rows of another table having the same key values: the
+// columns of the same name are set with DataRow.Set, so that the rows changed become
+// RowModified and can be saved by a DataAdapter. The rows of the other table without match
+// are added as RowAdded rows; deleted rows are left alone. Keys and values are compared like
+// in Join: a string matches the number or the time it holds. It returns the number of rows
+// updated and added.
+func (dt *DataTable) Merge(other *DataTable, key ...string) (updated int, added int, err error) {
+	lcols, rcols, err := joinKeys(dt, other, key)
+	if err != nil {
+		return 0, 0, errors.New("Merge: " + err.Error())
+	}
+	cmap := make([]int, len(other.Columns))
+	for i, c := range other.Columns {
+		cmap[i] = dt.ColumnIndex(c.Name)
+	}
+	as := keyConversions(dt, lcols, other, rcols)
+	index := map[string][]int{} // NULL keys never match, their rows are not indexed
+	for i := range dt.Rows {
+		if !hasNull(&dt.Rows[i], lcols) {
+			k := joinKey(&dt.Rows[i], lcols, as)
+			index[k] = append(index[k], i)
+		}
+	}
+	for j := range other.Rows {
+		r := &other.Rows[j]
+		if r.state == RowDeleted {
+			continue
+		}
+		var k string
+		var found []int
+		null := hasNull(r, rcols)
+		if !null {
+			k = joinKey(r, rcols, as)
+			found = index[k]
+		}
+		if len(found) == 0 {
+			dr := dt.NewRow()
+			for i, v := range r.items {
+				if cmap[i] >= 0 {
+					dr.items[cmap[i]] = dt.Columns[cmap[i]].convert(v)
+				}
+			}
+			dt.AddRow(dr)
+			if !null {
+				index[k] = append(index[k], len(dt.Rows)-1)
+			}
+			added++
+			continue
+		}
+		for _, i := range found {
+			dr := &dt.Rows[i]
+			if dr.state == RowDeleted {
+				continue
+			}
+			changed := false
+			for c, v := range r.items {
+				ix := cmap[c]
+				if ix < 0 || equalValues(dt.Columns[ix].convert(v), dr.items[ix]) {
+					continue
+				}
+				if err := dr.Set(ix, v); err != nil {
+					return updated, added, err
+				}
+				changed = true
+			}
+			if changed {
+				updated++
+			}
+		}
+	}
+	return updated, added, nil
+}
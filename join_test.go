@@ -0,0 +1,215 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTable returns an unchanged table of the given columns, "name:type", and rows
+func newTable(columns string, rows ...[]interface{}) *DataTable {
+	dt := &DataTable{}
+	for _, c := range strings.Split(columns, ",") {
+		nt := strings.Split(c, ":")
+		dt.Columns = append(dt.Columns, DataColumn{Name: nt[0], GoType: nt[1]})
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+func TestJoin(t *testing.T) {
+	// The customer ids of the orders were read as text
+	orders := newTable("id:int64,customer_id:string,amount:float64",
+		[]interface{}{int64(1), "1", 10.0},
+		[]interface{}{int64(2), "2", 20.0},
+		[]interface{}{int64(3), "9", 5.0},
+		[]interface{}{int64(4), nil, 1.0},
+		[]interface{}{int64(5), " 1 ", 2.0},
+		[]interface{}{int64(6), "2", 3.0},
+	)
+	orders.DeleteRow(5)
+	customers := newTable("id:int64,name:string",
+		[]interface{}{int64(1), "Ann"},
+		[]interface{}{int64(2), "Bob"},
+		[]interface{}{int64(3), "Cy"},
+		[]interface{}{nil, "Nobody"},
+	)
+
+	got, err := orders.Join(customers, InnerJoin, "customer_id=id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.ColumnNames(), ",") != "id,customer_id,amount,id_2,name" {
+		t.Errorf("columns %v", got.ColumnNames())
+	}
+	sameValues(t, got, [][]interface{}{
+		{int64(1), "1", 10.0, int64(1), "Ann"},
+		{int64(2), "2", 20.0, int64(2), "Bob"},
+		{int64(5), " 1 ", 2.0, int64(1), "Ann"},
+	})
+	if got.Columns[4].Nullable {
+		t.Error("inner join column nullable")
+	}
+
+	got, err = orders.Join(customers, LeftJoin, "customer_id=id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{
+		{int64(1), "1", 10.0, int64(1), "Ann"},
+		{int64(2), "2", 20.0, int64(2), "Bob"},
+		{int64(3), "9", 5.0, nil, nil},
+		{int64(4), nil, 1.0, nil, nil},
+		{int64(5), " 1 ", 2.0, int64(1), "Ann"},
+	})
+	if !got.Columns[4].Nullable || got.Columns[0].Nullable {
+		t.Error("left join nullable columns")
+	}
+
+	// The other way round, numbers match the strings holding them
+	got, err = customers.Join(orders, FullJoin, "id=customer_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{
+		{int64(1), "Ann", int64(1), "1", 10.0},
+		{int64(1), "Ann", int64(5), " 1 ", 2.0},
+		{int64(2), "Bob", int64(2), "2", 20.0},
+		{int64(3), "Cy", nil, nil, nil},
+		{nil, "Nobody", nil, nil, nil},
+		{nil, nil, int64(3), "9", 5.0},
+		{nil, nil, int64(4), nil, 1.0},
+	})
+}
+
+func TestJoinSameName(t *testing.T) {
+	a := newTable("id:int64,v:string", []interface{}{int64(1), "x"}, []interface{}{int64(2), "y"})
+	b := newTable("id:string,v:string", []interface{}{"2", "B"}, []interface{}{"3", "C"}, []interface{}{"x", "X"})
+	got, err := a.Join(b, FullJoin, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key appears once, filled from the other table for its rows without match
+	if strings.Join(got.ColumnNames(), ",") != "id,v,v_2" {
+		t.Errorf("columns %v", got.ColumnNames())
+	}
+	sameValues(t, got, [][]interface{}{
+		{int64(1), "x", nil},
+		{int64(2), "y", "B"},
+		{"3", nil, "C"},
+		{"x", nil, "X"},
+	})
+
+	// Times match the strings holding them; strings that are not times never match
+	day := time.Date(2017, 3, 4, 0, 0, 0, 0, time.UTC)
+	c := newTable("day:time.Time,n:int64", []interface{}{day, int64(1)})
+	d := newTable("day:,m:int64", []interface{}{"2017-03-04", int64(2)}, []interface{}{"never", int64(3)})
+	got, err = d.Join(c, InnerJoin, "day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{"2017-03-04", int64(2), int64(1)}})
+
+	for _, on := range [][]string{{}, {"nope"}, {"id=nope"}} {
+		if _, err := a.Join(b, InnerJoin, on...); err == nil || !strings.HasPrefix(err.Error(), "Join: ") {
+			t.Errorf("%v: %v", on, err)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	// Distinct values of a column keep strings and numbers apart
+	dt := newTable("v:", []interface{}{int64(1)}, []interface{}{1.0}, []interface{}{"1"}, []interface{}{"01"}, []interface{}{nil}, []interface{}{nil})
+	if n := dt.View().Distinct().Count(); n != 4 {
+		t.Errorf("%d distinct values", n)
+	}
+	if !equalValues("01", int64(1)) || !equalValues(nil, nil) || equalValues(nil, "") || equalValues("a", int64(1)) {
+		t.Error("equalValues")
+	}
+}
+
+func TestUnion(t *testing.T) {
+	a := newTable("id:int64,name:string", []interface{}{int64(1), "a"}, []interface{}{int64(2), "b"}, []interface{}{int64(9), "deleted"})
+	a.DeleteRow(2)
+	b := newTable("ID:float64,name:string", []interface{}{1.0, "a"}, []interface{}{3.5, "c"}, []interface{}{3.5, "c"})
+
+	got, err := a.UnionAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Columns[0].GoType != TypeFloat64 || got.Columns[0].Name != "id" {
+		t.Errorf("column %+v", got.Columns[0])
+	}
+	sameValues(t, got, [][]interface{}{{1.0, "a"}, {2.0, "b"}, {1.0, "a"}, {3.5, "c"}, {3.5, "c"}})
+	got, err = a.Union(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, got, [][]interface{}{{1.0, "a"}, {2.0, "b"}, {3.5, "c"}})
+
+	for _, other := range []*DataTable{
+		newTable("id:int64"),
+		newTable("id:int64,label:string"),
+		newTable("id:string,name:string"),
+	} {
+		if _, err := a.Union(other); err == nil || !strings.HasPrefix(err.Error(), "Union: ") {
+			t.Errorf("%v: %v", other.Columns, err)
+		}
+	}
+	// An untyped column makes an untyped column
+	got, err = a.UnionAll(newTable("id:,name:string", []interface{}{"x", nil}))
+	if err != nil || got.Columns[0].GoType != "" || len(got.Rows) != 3 {
+		t.Errorf("untyped: %v %+v", err, got.Columns[0])
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dt := newTable("id:int64,name:string,qty:int64",
+		[]interface{}{int64(1), "a", int64(1)},
+		[]interface{}{int64(2), "b", int64(2)},
+		[]interface{}{int64(4), "d", int64(4)},
+	)
+	dt.DeleteRow(2)
+	// Read from a file, without types
+	other := newTable("id:string,name:string,qty:string,extra:string",
+		[]interface{}{"1", "a", "1", "e"},
+		[]interface{}{"2", "B", "2", "e"},
+		[]interface{}{"3", "c", nil, "e"},
+		[]interface{}{"4", "D", "4", "e"},
+		[]interface{}{nil, "n", nil, "e"},
+	)
+	updated, added, err := dt.Merge(other, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 || added != 2 {
+		t.Errorf("%d updated, %d added", updated, added)
+	}
+	states := []RowState{RowUnchanged, RowModified, RowDeleted, RowAdded, RowAdded}
+	for i, s := range states {
+		if dt.Rows[i].State() != s {
+			t.Errorf("row %d is %v, want %v", i, dt.Rows[i].State(), s)
+		}
+	}
+	if dt.Rows[1].items[1] != "B" || dt.Rows[1].items[2] != int64(2) || dt.Rows[3].items[1] != "c" || dt.Rows[4].items[0] != nil {
+		t.Errorf("rows %#v %#v %#v", dt.Rows[1].items, dt.Rows[3].items, dt.Rows[4].items)
+	}
+
+	// Merging again changes nothing but adds the row with a NULL key
+	if updated, added, err = dt.Merge(other, "id"); err != nil || updated != 0 || added != 1 {
+		t.Errorf("second merge: %d updated, %d added, %v", updated, added, err)
+	}
+	if _, _, err = dt.Merge(other, "nope"); err == nil || err.Error() != "Merge: unknown key column nope" {
+		t.Errorf("unknown key: %v", err)
+	}
+}
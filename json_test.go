@@ -0,0 +1,154 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// typedTable returns a table with a column of each type, a row of NULL and values
+// of another type than their column
+func typedTable() *DataTable {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "s", GoType: TypeString, DBType: "VARCHAR", Length: 20, Nullable: true},
+		{Name: "i", GoType: TypeInt64, DBType: "BIGINT"},
+		{Name: "f", GoType: TypeFloat64, Precision: 10, Scale: 2},
+		{Name: "b", GoType: TypeBool},
+		{Name: "t", GoType: TypeTime},
+		{Name: "y", GoType: TypeBytes},
+		{Name: "any"},
+	}}
+	rows := [][]interface{}{
+		{"a \"quoted\" é", int64(1) << 60, 2.5, true, time.Date(2017, 3, 4, 5, 6, 7, 123456789, time.UTC), []byte{0, 1, 255}, "x"},
+		{nil, nil, nil, nil, nil, nil, nil},
+		{"12", int64(-1), math.NaN(), false, time.Time{}, []byte{}, 1.5},
+		{int64(12), "text", math.Inf(-1), int64(1), "2017", "bytes", int64(7)},
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+// sameRows compares the values of two tables, times with Equal and NaN equal to NaN
+func sameRows(t *testing.T, got *DataTable, want *DataTable) {
+	t.Helper()
+	if len(got.Rows) != len(want.Rows) {
+		t.Fatalf("%d rows, want %d", len(got.Rows), len(want.Rows))
+	}
+	for r := range want.Rows {
+		for c, w := range want.Rows[r].items {
+			g := got.Rows[r].items[c]
+			switch x := w.(type) {
+			case time.Time:
+				if y, ok := g.(time.Time); ok && x.Equal(y) {
+					continue
+				}
+			case float64:
+				if y, ok := g.(float64); ok && math.IsNaN(x) && math.IsNaN(y) {
+					continue
+				}
+			}
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("row %d column %s: got %#v, want %#v", r, want.Columns[c].Name, g, w)
+			}
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	dt := typedTable()
+	js, err := dt.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(js, `"Version":2`) && !strings.Contains(js, `"Version": 2`) {
+		t.Errorf("no version in %s", js)
+	}
+	var got DataTable
+	if err = got.FillFromJSON(js); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Columns, dt.Columns) {
+		t.Errorf("columns %+v", got.Columns)
+	}
+	sameRows(t, &got, dt)
+	if got.Rows[0].State() != RowUnchanged {
+		t.Error("rows read are not unchanged")
+	}
+}
+
+func TestJSONFile(t *testing.T) {
+	dt := typedTable()
+	fn := filepath.Join(t.TempDir(), "table.json")
+	if err := dt.SaveToFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	var got DataTable
+	if err := got.LoadFromFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	sameRows(t, &got, dt)
+	if err := got.LoadFromFile(filepath.Join(t.TempDir(), "none.json")); err == nil {
+		t.Error("missing file")
+	}
+}
+
+func TestJSONPreviousFormat(t *testing.T) {
+	var dt DataTable
+	js := `{
+"Columns": ["id","name","rate"],
+"Rows": [
+    [1,"a",2.5],
+    [2,null,3]]
+}`
+	if err := dt.FillFromJSON(js); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{int64(1), "a", 2.5}, {int64(2), nil, int64(3)}}
+	for r, w := range want {
+		if !reflect.DeepEqual(dt.Rows[r].items, w) {
+			t.Errorf("row %d: %#v", r, dt.Rows[r].items)
+		}
+	}
+
+	// Columns with definitions but untyped values: values that do not match are kept
+	js = `{"Columns": [{"name": "id", "goType": "int64"}, {"name": "t", "goType": "time.Time"}],
+"Rows": [[1, "2017-03-04 05:06:07"], ["x", "never"]]}`
+	if err := dt.FillFromJSON(js); err != nil {
+		t.Fatal(err)
+	}
+	if tm, ok := dt.Rows[0].items[1].(time.Time); !ok || tm.Year() != 2017 || dt.Rows[0].items[0] != int64(1) {
+		t.Errorf("row 0: %#v", dt.Rows[0].items)
+	}
+	if dt.Rows[1].items[0] != "x" || dt.Rows[1].items[1] != "never" {
+		t.Errorf("row 1: %#v", dt.Rows[1].items)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	var dt DataTable
+	for _, js := range []string{
+		``,
+		`[1, 2]`,
+		`{"Version": 2, "Columns": ["a"], "Rows": [[1, 2]]}`,
+		`{"Version": 2, "Columns": [{"name": "a", "goType": "int64"}], "Rows": [["x"]]}`,
+		`{"Version": 2, "Columns": [{"name": "a"}], "Rows": [[{"$type": "nope", "value": 1}]]}`,
+		`{"Version": 2, "Columns": ["a"], "Rows": [[1]`,
+	} {
+		if err := dt.FillFromJSON(js); err == nil {
+			t.Errorf("no error for %s", js)
+		}
+	}
+}
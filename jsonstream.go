@@ -0,0 +1,430 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// JSONEncoder writes rows as JSON while they are read, so that large results are
+// written with constant memory. It writes the format of DataTable.WriteAsJSON:
+//
+//	{"Version": 2, "Columns": [...], "Rows": [[...], [...]]}
+//
+// or NDJSON: a first line {"Version": 2, "Columns": [...]} followed by a line for each row,
+// holding an object with a member for each column.
+// The first write error stops the encoder and is returned by all the following calls.
+type JSONEncoder struct {
+	// FlushRows flushes the output every FlushRows rows, 0 to flush only when the buffer
+	// is full and at the end. Writers implementing http.Flusher are flushed too.
+	FlushRows int
+	dest      io.Writer
+	w         *bufio.Writer
+	ndjson    bool
+	columns   []DataColumn
+	header    bool  // The header was written
+	rows      int64 // Number of rows written
+	closed    bool
+	err       error
+}
+
+// NewJSONEncoder returns an encoder writing to w, in NDJSON if ndjson is set
+func NewJSONEncoder(w io.Writer, ndjson bool) *JSONEncoder {
+	return &JSONEncoder{dest: w, w: bufio.NewWriter(w), ndjson: ndjson}
+}
+
+// Rows returns the number of rows written
+func (e *JSONEncoder) Rows() int64 {
+	return e.rows
+}
+
+func (e *JSONEncoder) write(s string) {
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+// WriteHeader writes the column definitions. It must be called once, before the rows.
+func (e *JSONEncoder) WriteHeader(columns []DataColumn) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.header {
+		return errors.New("JSONEncoder: header already written")
+	}
+	if columns == nil {
+		columns = []DataColumn{}
+	}
+	x, err := json.Marshal(columns)
+	if err != nil {
+		e.err = err
+		return err
+	}
+	e.columns, e.header = columns, true
+	if e.ndjson {
+		e.write("{\"Version\":" + strconv.Itoa(JSONVersion) + ",\"Columns\":" + string(x) + "}\n")
+	} else {
+		e.write("{\n\"Version\": " + strconv.Itoa(JSONVersion) + ",\n\"Columns\": " + string(x) + ",\n\"Rows\": [")
+	}
+	return e.err
+}
+
+// WriteRow writes the values of a row, in the order of the columns
+func (e *JSONEncoder) WriteRow(values []interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+	if !e.header || e.closed {
+		return errors.New("JSONEncoder: row written before the header or after Close")
+	}
+	if len(values) != len(e.columns) {
+		e.err = fmt.Errorf("JSONEncoder: %d values for %d columns", len(values), len(e.columns))
+		return e.err
+	}
+	if e.ndjson {
+		e.write("{")
+	} else if e.rows > 0 {
+		e.write(",\n    [")
+	} else {
+		e.write("\n    [")
+	}
+	for c := range values {
+		x, err := json.Marshal(e.columns[c].jsonValue(values[c]))
+		if err != nil {
+			e.err = err
+			return err
+		}
+		if c > 0 {
+			e.write(",")
+		}
+		if e.ndjson {
+			n, _ := json.Marshal(e.columns[c].Name)
+			e.write(string(n) + ":")
+		}
+		e.write(string(x))
+	}
+	if e.ndjson {
+		e.write("}\n")
+	} else {
+		e.write("]")
+	}
+	e.rows++
+	if e.err == nil && e.FlushRows > 0 && e.rows%int64(e.FlushRows) == 0 {
+		return e.Flush()
+	}
+	return e.err
+}
+
+// Flush writes the buffered data to the underlying writer
+func (e *JSONEncoder) Flush() error {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if f, ok := e.dest.(http.Flusher); ok && e.err == nil {
+		f.Flush()
+	}
+	return e.err
+}
+
+// Close ends the document and flushes the output. It does not close the underlying writer.
+func (e *JSONEncoder) Close() error {
+	if e.closed {
+		return e.err
+	}
+	if !e.header {
+		e.WriteHeader([]DataColumn{})
+	}
+	e.closed = true
+	if !e.ndjson {
+		e.write("]\n}\n")
+	}
+	return e.Flush()
+}
+
+// EncodeRows writes the header and all the rows of a query, then closes the encoder.
+// The rows are closed too.
+func (e *JSONEncoder) EncodeRows(rows *sql.Rows) error {
+	defer rows.Close()
+	if err := e.encodeRows(rows); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// encodeRows writes the header and the rows of a query
+func (e *JSONEncoder) encodeRows(rows *sql.Rows) error {
+	columns := columnsOf(rows)
+	if err := e.WriteHeader(columns); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	for rows.Next() {
+		if err := scanRow(rows, columns, values); err != nil {
+			return err
+		}
+		if err := e.WriteRow(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EncodeTable writes the header and the rows of a DataTable, then closes the encoder.
+// Deleted rows are skipped.
+func (e *JSONEncoder) EncodeTable(dt *DataTable) error {
+	if err := e.WriteHeader(dt.Columns); err != nil {
+		return err
+	}
+	for r := range dt.Rows {
+		if dt.Rows[r].state == RowDeleted {
+			continue
+		}
+		if err := e.WriteRow(dt.Rows[r].items); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// StreamJSON runs a query and writes its rows to w as they are read, in the format of
+// JSONEncoder. It returns the number of rows written.
+// With DisallowConcurency, other queries wait until all the rows are read: the rows are
+// then encoded in memory and written to w once the query is over, so that a slow writer
+// does not hold the other queries.
+func (cn *Conn) StreamJSON(w io.Writer, ndjson bool, query string, args ...interface{}) (int64, error) {
+	var buf *bytes.Buffer
+	out := w
+	if cn != nil && cn.DisallowConcurency {
+		buf = &bytes.Buffer{}
+		out = buf
+	}
+	e := NewJSONEncoder(out, ndjson)
+	err := cn.cursor(nil, "StreamJSON", query, args, e.encodeRows)
+	if err == nil {
+		err = e.Close()
+		if err == nil && buf != nil {
+			_, err = buf.WriteTo(w)
+		}
+		if err != nil {
+			err = errors.New("StreamJSON:" + err.Error())
+		}
+	}
+	return e.Rows(), err
+}
+
+// JSONDecoder reads the rows of a JSON document written by JSONEncoder or DataTable.WriteAsJSON,
+// one at a time. It also reads NDJSON without header line, whose columns are the members
+// of the first row, and the JSON of previous versions.
+type JSONDecoder struct {
+	dec      *json.Decoder
+	columns  []DataColumn
+	typed    bool
+	ndjson   bool
+	started  bool
+	done     bool
+	first    []json.RawMessage // First row of NDJSON without header, read with the columns
+	firstSet bool
+	err      error
+}
+
+// NewJSONDecoder returns a decoder reading r
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return &JSONDecoder{dec: d}
+}
+
+func (d *JSONDecoder) fail(err error) error {
+	if d.err == nil {
+		d.err = errors.New("JSONDecoder:" + err.Error())
+	}
+	return d.err
+}
+
+// expect reads a delimiter token
+func (d *JSONDecoder) expect(delim json.Delim) error {
+	t, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf(" expected %v, found %v", delim, t)
+	}
+	return nil
+}
+
+// Columns reads the beginning of the document and returns the columns
+func (d *JSONDecoder) Columns() ([]DataColumn, error) {
+	if d.started || d.err != nil {
+		return d.columns, d.err
+	}
+	d.started = true
+	if err := d.expect('{'); err != nil {
+		return nil, d.fail(err)
+	}
+	version := 0
+	for d.dec.More() {
+		t, err := d.dec.Token()
+		if err != nil {
+			return nil, d.fail(err)
+		}
+		key, _ := t.(string)
+		switch key {
+		case "Version":
+			if err = d.dec.Decode(&version); err != nil {
+				return nil, d.fail(err)
+			}
+		case "Columns":
+			var raw []json.RawMessage
+			if err = d.dec.Decode(&raw); err != nil {
+				return nil, d.fail(err)
+			}
+			if d.columns, err = parseColumns(raw); err != nil {
+				return nil, d.fail(err)
+			}
+		case "Rows":
+			if err = d.expect('['); err != nil {
+				return nil, d.fail(err)
+			}
+			d.typed = version >= 2
+			return d.columns, nil
+		default: // NDJSON without header: the object is the first row
+			var raw json.RawMessage
+			names := []string{key}
+			if err = d.dec.Decode(&raw); err != nil {
+				return nil, d.fail(err)
+			}
+			d.first = []json.RawMessage{raw}
+			for d.dec.More() {
+				var value json.RawMessage
+				if t, err = d.dec.Token(); err == nil {
+					key, _ = t.(string)
+					err = d.dec.Decode(&value)
+				}
+				if err != nil {
+					return nil, d.fail(err)
+				}
+				names, d.first = append(names, key), append(d.first, value)
+			}
+			if err = d.expect('}'); err != nil {
+				return nil, d.fail(err)
+			}
+			d.columns = []DataColumn{}
+			for _, n := range names {
+				d.columns = append(d.columns, DataColumn{Name: n, Nullable: true})
+			}
+			d.ndjson, d.firstSet = true, true
+			return d.columns, nil
+		}
+	}
+	if err := d.expect('}'); err != nil { // NDJSON header line
+		return nil, d.fail(err)
+	}
+	d.ndjson, d.typed = true, version >= 2
+	return d.columns, nil
+}
+
+// Next returns the values of the next row, io.EOF after the last one
+func (d *JSONDecoder) Next() ([]interface{}, error) {
+	if _, err := d.Columns(); err != nil {
+		return nil, err
+	}
+	if d.done {
+		return nil, io.EOF
+	}
+	var raw []json.RawMessage
+	switch {
+	case d.firstSet:
+		raw, d.first, d.firstSet = d.first, nil, false
+	case d.ndjson:
+		if !d.dec.More() {
+			d.done = true
+			return nil, io.EOF
+		}
+		var obj map[string]json.RawMessage
+		if err := d.dec.Decode(&obj); err != nil {
+			return nil, d.fail(err)
+		}
+		raw = make([]json.RawMessage, len(d.columns))
+		for c := range d.columns {
+			if v, ok := obj[d.columns[c].Name]; ok {
+				raw[c] = v
+			} else {
+				raw[c] = json.RawMessage("null")
+			}
+		}
+	default:
+		if !d.dec.More() {
+			d.done = true
+			if err := d.expect(']'); err != nil {
+				return nil, d.fail(err)
+			}
+			return nil, io.EOF
+		}
+		if err := d.dec.Decode(&raw); err != nil {
+			return nil, d.fail(err)
+		}
+		if len(raw) != len(d.columns) {
+			return nil, d.fail(fmt.Errorf(" %d values for %d columns", len(raw), len(d.columns)))
+		}
+	}
+	values := make([]interface{}, len(d.columns))
+	for c := range d.columns {
+		v, err := d.columns[c].fromJSON(raw[c], d.typed)
+		if err != nil {
+			return nil, d.fail(fmt.Errorf(" column %s: %s", d.columns[c].Name, err.Error()))
+		}
+		values[c] = v
+	}
+	return values, nil
+}
+
+// parseColumns decodes column definitions, or column names for the previous versions of the format
+func parseColumns(raw []json.RawMessage) ([]DataColumn, error) {
+	columns := []DataColumn{}
+	for _, jsc := range raw {
+		var c DataColumn
+		if e := json.Unmarshal(jsc, &c.Name); e == nil {
+			c.Nullable = true
+		} else if e := json.Unmarshal(jsc, &c); e != nil {
+			return nil, e
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// ReadJSON fills the datatable with a JSON document read by a JSONDecoder
+func (dt *DataTable) ReadJSON(r io.Reader) error {
+	dt.Clear()
+	d := NewJSONDecoder(r)
+	columns, err := d.Columns()
+	if err != nil {
+		return err
+	}
+	dt.Columns = columns
+	dt.initColumns()
+	for {
+		values, err := d.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		dr := dt.NewRow()
+		copy(dr.items, values)
+		dr.state = RowUnchanged
+		dt.AddRow(dr)
+	}
+}
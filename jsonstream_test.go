@@ -0,0 +1,137 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeAll reads a document with a JSONDecoder, one row at a time
+func decodeAll(t *testing.T, r io.Reader) *DataTable {
+	t.Helper()
+	d := NewJSONDecoder(r)
+	columns, err := d.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := &DataTable{Columns: columns}
+	for {
+		values, err := d.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		dr := dt.NewRow()
+		copy(dr.items, values)
+		dt.AddRow(dr)
+	}
+	if _, err = d.Next(); err != io.EOF {
+		t.Errorf("after the last row: %v", err)
+	}
+	return dt
+}
+
+func TestJSONStream(t *testing.T) {
+	for _, ndjson := range []bool{false, true} {
+		dt := typedTable()
+		var b bytes.Buffer
+		e := NewJSONEncoder(&b, ndjson)
+		if err := e.EncodeTable(dt); err != nil {
+			t.Fatal(err)
+		}
+		if e.Rows() != int64(len(dt.Rows)) {
+			t.Errorf("%d rows written", e.Rows())
+		}
+		if ndjson && strings.Count(b.String(), "\n") != len(dt.Rows)+1 {
+			t.Errorf("not one line per row: %s", b.String())
+		}
+		got := decodeAll(t, &b)
+		sameRows(t, got, dt)
+		if len(got.Columns) != len(dt.Columns) || got.Columns[2].Precision != 10 {
+			t.Errorf("columns %+v", got.Columns)
+		}
+	}
+}
+
+func TestJSONStreamEmpty(t *testing.T) {
+	for _, ndjson := range []bool{false, true} {
+		var b bytes.Buffer
+		if err := NewJSONEncoder(&b, ndjson).Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeAll(t, &b); len(got.Columns) != 0 || len(got.Rows) != 0 {
+			t.Errorf("ndjson %v: %+v", ndjson, got)
+		}
+	}
+}
+
+func TestNDJSONWithoutHeader(t *testing.T) {
+	src := `{"id": 1, "name": "a", "rate": 1.5}
+{"name": "b", "id": 2}
+{"id": 3, "other": true}
+`
+	got := decodeAll(t, strings.NewReader(src))
+	if strings.Join(got.ColumnNames(), ",") != "id,name,rate" {
+		t.Fatalf("columns %v", got.ColumnNames())
+	}
+	want := [][]interface{}{{int64(1), "a", 1.5}, {int64(2), "b", nil}, {int64(3), nil, nil}}
+	for r, w := range want {
+		for c := range w {
+			if got.Rows[r].items[c] != w[c] {
+				t.Errorf("row %d: %#v", r, got.Rows[r].items)
+				break
+			}
+		}
+	}
+}
+
+func TestJSONFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	e := NewJSONEncoder(w, true)
+	e.FlushRows = 2
+	e.WriteHeader([]DataColumn{{Name: "a", GoType: TypeInt64}})
+	e.WriteRow([]interface{}{int64(1)})
+	if w.Body.Len() != 0 || w.Flushed {
+		t.Error("flushed after one row")
+	}
+	e.WriteRow([]interface{}{int64(2)})
+	if !w.Flushed || strings.Count(w.Body.String(), "\n") != 3 {
+		t.Errorf("not flushed after two rows: %q", w.Body.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestJSONEncoderErrors(t *testing.T) {
+	var b bytes.Buffer
+	e := NewJSONEncoder(&b, false)
+	if err := e.WriteRow([]interface{}{1}); err == nil {
+		t.Error("row before the header")
+	}
+	e.WriteHeader([]DataColumn{{Name: "a"}})
+	if err := e.WriteHeader([]DataColumn{{Name: "a"}}); err == nil {
+		t.Error("header written twice")
+	}
+	if err := e.WriteRow([]interface{}{1, 2}); err == nil {
+		t.Error("too many values")
+	}
+	if err := e.Close(); err == nil {
+		t.Error("error not kept")
+	}
+
+	e = NewJSONEncoder(failingWriter{}, false)
+	if err := e.EncodeTable(typedTable()); err == nil || err.Error() != "disk full" {
+		t.Errorf("write error: %v", err)
+	}
+}
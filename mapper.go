@@ -0,0 +1,645 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/stefpo/sedi/conv"
+)
+
+// SQLMapper provides persistence framework for structures.
+// Everything specific to a database is delegated to its Dialect.
+type SQLMapper struct {
+	dialect Dialect
+	conn    *Conn
+	config  Config
+	TableDefs
+	modelDiffDone bool
+	Prune         PruneMode // Handling of objects no longer in the model
+	KeepTables    []string  // Tables never considered as orphans
+	DropTables    []string  // Orphan tables UpdateModel drops with PruneDrop, the others are only reported
+	OrphanTables  []string  // Tables not in the model, found by ModelIsUpToDate
+	defs          *TableDefRegistry
+	defsOnce      sync.Once
+	stmts         map[*TableDef]*statements
+	stmtsLock     sync.Mutex
+	err           error
+}
+
+// statement is a TableDef statement with its @FieldName parameters
+// replaced by the placeholders of the dialect
+type statement struct {
+	sql    string
+	fields []int // Field of each argument, index in TableDef.Fields
+}
+
+type statements struct {
+	insert statement
+	read   statement
+	update statement
+	delete statement
+	upsert statement
+}
+
+// NewSQLMapper creates a SQLMapper for a dialect
+func NewSQLMapper(d Dialect) *SQLMapper {
+	return &SQLMapper{
+		dialect:    d,
+		KeepTables: []string{"schema_migrations", "schema_migrations_lock"},
+		stmts:      make(map[*TableDef]*statements)}
+}
+
+// Dialect returns the dialect of the mapper
+func (me *SQLMapper) Dialect() Dialect {
+	return me.dialect
+}
+
+func (me *SQLMapper) Connection() *Conn {
+	return me.conn
+}
+
+// OpenConnection opens the connection of the mapper. Errors are logged, use sedi.Open to get them.
+func (me *SQLMapper) OpenConnection(url string) *SQLMapper {
+	if e := me.open(url); e != nil && LogErrors {
+		log.Print(e.Error())
+	}
+	return me
+}
+
+// Open opens the connection of the mapper and checks that the database answers
+func (me *SQLMapper) Open(dsn string) error {
+	err := me.open(dsn)
+	if err == nil {
+		if err = me.conn.Ping(); err != nil {
+			me.CloseConnection()
+		}
+	}
+	return err
+}
+
+// open opens the connection with a data source name of the driver
+func (me *SQLMapper) open(dsn string) error {
+	cn, e := OpenConnectionConfig(me.dialect, dsn, me.config)
+	if e != nil {
+		if cn.DB != nil {
+			cn.DB.Close()
+		}
+		me.conn = nil
+		return e
+	}
+	me.conn = &cn
+	return nil
+}
+
+// SetConfig sets the pool, pragma and session settings used by OpenConnection
+func (me *SQLMapper) SetConfig(cfg Config) *SQLMapper {
+	me.config = cfg
+	return me
+}
+
+func (me *SQLMapper) CloseConnection() {
+	me.conn.Close()
+	me.conn = nil
+}
+
+// Status returns the status of the connection of the mapper
+func (me *SQLMapper) Status() Status {
+	return me.conn.Status()
+}
+
+// SetPruneMode sets how ModelIsUpToDate and UpdateModel handle orphan columns, indexes and tables
+func (me *SQLMapper) SetPruneMode(mode PruneMode) *SQLMapper {
+	me.Prune = mode
+	me.modelDiffDone = false
+	return me
+}
+
+// AddPersistence registers a structure in the model. An invalid structure
+// is reported by Err, ModelIsUpToDate and UpdateModel.
+func (me *SQLMapper) AddPersistence(st interface{}) *SQLMapper {
+	if me.TableDefs == nil {
+		me.TableDefs = make(TableDefs, 0)
+	}
+	td, err := me.tableDef(st)
+	if err != nil {
+		if me.err == nil {
+			me.err = err
+		}
+		if LogErrors {
+			log.Print(err.Error())
+		}
+		return me
+	}
+	me.TableDefs = append(me.TableDefs, td.Copy())
+	me.modelDiffDone = false
+	return me
+}
+
+// Add is a synonym of AddPersistence
+func (me *SQLMapper) Add(st interface{}) *SQLMapper {
+	return me.AddPersistence(st)
+}
+
+// Err returns the first error met while registering structures
+func (me *SQLMapper) Err() error {
+	return me.err
+}
+
+// tableDef returns the cached TableDef of a structure
+func (me *SQLMapper) tableDef(st interface{}) (*TableDef, error) {
+	me.defsOnce.Do(func() {
+		me.defs = NewTableDefRegistry(me.dialect.Quote)
+	})
+	return me.defs.Register(st)
+}
+
+// SQLType returns the database type of a field
+func (me *SQLMapper) SQLType(fd FieldDef) string {
+	return me.dialect.SQLType(fd)
+}
+
+func (me *SQLMapper) ModelIsUpToDate() (ok bool, err error) {
+	if me.err != nil {
+		return false, me.err
+	}
+	if me.conn == nil {
+		return false, ErrNoConnection
+	}
+	d := me.dialect
+	tables, err := d.Tables(me.conn)
+	if err != nil {
+		return false, err
+	}
+	exists := make(map[string]bool)
+	for _, tn := range tables {
+		exists[tn] = true
+	}
+
+	ok = true
+	for i := range me.TableDefs {
+		td := &(me.TableDefs[i])
+		td.ResetModelDiff()
+		if !exists[td.SQLName] {
+			td.MustCreate = true
+			ok = false
+			continue
+		}
+		if err = me.compareTable(td); err != nil {
+			return false, err
+		}
+		if td.MustCreate || td.MustModify || td.MustReIndex {
+			ok = false
+		}
+	}
+	me.OrphanTables = nil
+	if me.Prune != PruneNone {
+		for _, tn := range tables {
+			if !me.mappedTable(tn) && !containsFold(me.KeepTables, tn) {
+				me.OrphanTables = append(me.OrphanTables, tn)
+				if me.Prune == PruneDrop && containsFold(me.DropTables, tn) {
+					ok = false
+				}
+			}
+		}
+	}
+	me.modelDiffDone = true
+	return ok, nil
+}
+
+// compareTable compares an existing table with its model and sets the Must* flags
+func (me *SQLMapper) compareTable(td *TableDef) error {
+	d := me.dialect
+	cols, err := d.Columns(me.conn, td.SQLName)
+	if err != nil {
+		return err
+	}
+	indexes, err := d.Indexes(me.conn, td.SQLName)
+	if err != nil {
+		return err
+	}
+	for fi := range td.Fields {
+		fld := &(td.Fields[fi])
+		col := findColumn(cols, fld.SQLName)
+		if fld.RenamedFrom != "" {
+			old := findColumn(cols, fld.RenamedFrom)
+			switch {
+			case col != nil && old != nil:
+				return errors.New("sedi: cannot rename " + td.SQLName + "." + fld.RenamedFrom + " to " + fld.SQLName + ", the column already exists")
+			case col == nil && old == nil:
+				return errors.New("sedi: column " + td.SQLName + "." + fld.RenamedFrom + " renamed to " + fld.SQLName + " does not exist")
+			case old != nil:
+				col = old
+				fld.DBMustRename = true
+				td.MustModify = true
+			}
+		}
+		if col == nil {
+			td.MustModify = true
+			if d.AddColumnSQL(*td, *fld, "") == "" {
+				td.MustRecreate = true
+			}
+			continue
+		}
+		fld.DBFIeldExists = true
+		if d.SameType(col.Type, *fld) {
+			fld.DBTypeOK = true
+		} else {
+			td.MustModify = true
+			if d.ModifyColumnSQL(*td, *fld) == "" {
+				td.MustRecreate = true
+			}
+		}
+		ix := findIndex(indexes, d.IndexName(*td, fld.SQLName))
+		if fld.Indexed {
+			if ix != nil && ix.Unique == fld.Unique && len(ix.Columns) == 1 && ix.Columns[0] == fld.SQLName {
+				fld.DBIndexOK = true
+			} else {
+				td.MustReIndex = true
+			}
+		} else if ix != nil {
+			td.MustReIndex = true
+		}
+	}
+	if me.Prune != PruneNone {
+		me.findOrphans(td, cols, indexes)
+	}
+	return nil
+}
+
+func findColumn(cols []DBColumn, name string) *DBColumn {
+	for i := range cols {
+		if cols[i].Name == name {
+			return &(cols[i])
+		}
+	}
+	return nil
+}
+
+func findIndex(indexes []DBIndex, name string) *DBIndex {
+	for i := range indexes {
+		if indexes[i].Name == name {
+			return &(indexes[i])
+		}
+	}
+	return nil
+}
+
+// mappedTable tells if a database table is in the model. Table names are
+// compared without case, as MySQL and PostgreSQL may change it.
+func (me *SQLMapper) mappedTable(name string) bool {
+	for _, td := range me.TableDefs {
+		if strings.EqualFold(td.SQLName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, name string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// findOrphans lists the columns and mapper indexes of a table that are not in the model.
+// An index is a mapper index when it has the name the dialect gives to the index of its column.
+func (me *SQLMapper) findOrphans(td *TableDef, cols []DBColumn, indexes []DBIndex) {
+	d := me.dialect
+	for _, c := range cols {
+		if td.Fields.BySQLName(c.Name) == nil && !td.Fields.HasRenamedFrom(c.Name) {
+			td.OrphanColumns = append(td.OrphanColumns, c.Name)
+		}
+	}
+	for _, ix := range indexes {
+		if ix.Primary || len(ix.Columns) != 1 || ix.Name != d.IndexName(*td, ix.Columns[0]) {
+			continue
+		}
+		if td.Fields.BySQLName(ix.Columns[0]) == nil && !td.Fields.HasRenamedFrom(ix.Columns[0]) {
+			td.OrphanIndexes = append(td.OrphanIndexes, ix.Name)
+		}
+	}
+	if me.Prune == PruneDrop {
+		for _, c := range td.OrphanColumns {
+			td.MustModify = true
+			if d.DropColumnSQL(*td, c) == "" {
+				td.MustRecreate = true
+			}
+		}
+		if len(td.OrphanIndexes) > 0 {
+			td.MustReIndex = true
+		}
+	}
+}
+
+func (me *SQLMapper) UpdateModel() (err error) {
+	if me.err != nil {
+		return me.err
+	}
+	if !me.modelDiffDone {
+		if _, err = me.ModelIsUpToDate(); err != nil {
+			return err
+		}
+	}
+	for _, td := range me.TableDefs {
+		if td.MustCreate {
+			err = me.exec(me.dialect.CreateTableSQL(td))
+		} else {
+			if me.Prune == PruneDrop {
+				err = me.dropOrphanIndexes(td)
+			}
+			if err == nil && td.MustModify {
+				err = me.modifyTableStructure(td)
+			}
+		}
+		if err == nil {
+			err = me.createTableIndexes(td)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if me.Prune == PruneDrop {
+		kept := []string{}
+		for _, tn := range me.OrphanTables {
+			if !containsFold(me.DropTables, tn) {
+				kept = append(kept, tn)
+			} else if err = me.exec(me.dialect.DropTableSQL(tn)); err != nil {
+				return err
+			}
+		}
+		me.OrphanTables = kept
+	}
+	me.modelDiffDone = false
+	return nil
+}
+
+func (me *SQLMapper) exec(sql string) error {
+	_, err := me.conn.Exec(sql, nil)
+	return err
+}
+
+func (me *SQLMapper) dropOrphanIndexes(td TableDef) (err error) {
+	for _, in := range td.OrphanIndexes {
+		if err == nil {
+			err = me.exec(me.dialect.DropIndexSQL(td, in))
+		}
+	}
+	return err
+}
+
+func (me *SQLMapper) modifyTableStructure(td TableDef) (err error) {
+	d := me.dialect
+	if err = me.renameColumns(td); err != nil {
+		return err
+	}
+	if td.MustRecreate {
+		if r, ok := d.(TableRebuilder); ok {
+			return r.RebuildTable(me.conn, td, me.Prune == PruneDrop)
+		}
+		return errors.New("sedi: table " + td.SQLName + " cannot be modified in place")
+	}
+	after := ""
+	for _, fld := range td.Fields {
+		if !fld.DBFIeldExists {
+			err = me.exec(d.AddColumnSQL(td, fld, after))
+		} else if !fld.DBTypeOK {
+			err = me.exec(d.ModifyColumnSQL(td, fld))
+		}
+		if err != nil {
+			return err
+		}
+		after = fld.SQLName
+	}
+	if me.Prune == PruneDrop {
+		for _, c := range td.OrphanColumns {
+			if err = me.exec(d.DropColumnSQL(td, c)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renameColumns renames in place the columns whose field carries a renamedFrom tag.
+// The index of the old column is dropped, createTableIndexes will create the new one.
+func (me *SQLMapper) renameColumns(td TableDef) (err error) {
+	d := me.dialect
+	indexes, err := d.Indexes(me.conn, td.SQLName)
+	for _, fld := range td.Fields {
+		if fld.DBMustRename && err == nil {
+			if ix := findIndex(indexes, d.IndexName(td, fld.RenamedFrom)); ix != nil {
+				err = me.exec(d.DropIndexSQL(td, ix.Name))
+			}
+			if err == nil {
+				sql := d.RenameColumnSQL(td, fld.RenamedFrom, fld.SQLName)
+				if sql == "" {
+					return errors.New("sedi: column " + td.SQLName + "." + fld.RenamedFrom + " cannot be renamed to " + fld.SQLName)
+				}
+				err = me.exec(sql)
+			}
+		}
+	}
+	return err
+}
+
+// createTableIndexes creates, recreates or drops the mapper index of each field
+func (me *SQLMapper) createTableIndexes(td TableDef) error {
+	d := me.dialect
+	indexes, err := d.Indexes(me.conn, td.SQLName)
+	if err != nil {
+		return err
+	}
+	for _, fld := range td.Fields {
+		ix := findIndex(indexes, d.IndexName(td, fld.SQLName))
+		same := ix != nil && ix.Unique == fld.Unique && len(ix.Columns) == 1 && ix.Columns[0] == fld.SQLName
+		if ix != nil && (!fld.Indexed || !same) {
+			err = me.exec(d.DropIndexSQL(td, ix.Name))
+		}
+		if err == nil && fld.Indexed && !same {
+			err = me.exec(d.CreateIndexSQL(td, fld))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statementsOf returns the statements of a TableDef, compiled for the dialect
+func (me *SQLMapper) statementsOf(td *TableDef) *statements {
+	me.stmtsLock.Lock()
+	defer me.stmtsLock.Unlock()
+	if me.stmts == nil {
+		me.stmts = make(map[*TableDef]*statements)
+	}
+	s, found := me.stmts[td]
+	if !found {
+		s = &statements{
+			insert: compileStatement(me.dialect, td, me.insertSQL(td)),
+			read:   compileStatement(me.dialect, td, td.SelectStatement),
+			update: compileStatement(me.dialect, td, td.UpdateStatement),
+			delete: compileStatement(me.dialect, td, td.DeleteStatement),
+			upsert: compileStatement(me.dialect, td, me.dialect.UpsertSQL(*td))}
+		me.stmts[td] = s
+	}
+	return s
+}
+
+// insertSQL returns the insert statement of a TableDef, with the returning
+// clause of the dialect when the key is generated by the database
+func (me *SQLMapper) insertSQL(td *TableDef) string {
+	if r, ok := me.dialect.(Returning); ok && td.Fields[td.PkIx].AutoIncrement {
+		return td.InsertStatement + " " + r.ReturningSQL(*td)
+	}
+	return td.InsertStatement
+}
+
+// compileStatement replaces the @FieldName parameters of a statement by placeholders
+func compileStatement(d Dialect, td *TableDef, sql string) statement {
+	var b strings.Builder
+	ret := statement{fields: []int{}}
+	for i := 0; i < len(sql); i++ {
+		if sql[i] != '@' {
+			b.WriteByte(sql[i])
+			continue
+		}
+		j := i + 1
+		for j < len(sql) && (sql[j] == '_' || sql[j] >= 'a' && sql[j] <= 'z' || sql[j] >= 'A' && sql[j] <= 'Z' || sql[j] >= '0' && sql[j] <= '9') {
+			j++
+		}
+		fi := -1
+		for k := range td.Fields {
+			if td.Fields[k].Name == sql[i+1:j] {
+				fi = k
+			}
+		}
+		if fi == -1 {
+			b.WriteByte(sql[i])
+			continue
+		}
+		ret.fields = append(ret.fields, fi)
+		b.WriteString(d.Placeholder(len(ret.fields)))
+		i = j - 1
+	}
+	ret.sql = b.String()
+	return ret
+}
+
+// CompileStatement replaces the @FieldName parameters of a statement of td by the placeholders
+// of dialect d, and returns the positions in td.Fields of the fields to bind in that order
+func CompileStatement(d Dialect, td *TableDef, sql string) (string, []int) {
+	s := compileStatement(d, td, sql)
+	return s.sql, s.fields
+}
+
+// args returns the arguments of a statement taken from a structure
+func (me *SQLMapper) args(td *TableDef, s statement, values []reflect.Value) []interface{} {
+	ret := make([]interface{}, len(s.fields))
+	for i, fi := range s.fields {
+		ret[i] = me.dialect.BindValue(td.Fields[fi], values[fi].Interface())
+	}
+	return ret
+}
+
+func (me *SQLMapper) Insert(st interface{}) error {
+	td, e := me.tableDef(st)
+	if e != nil {
+		return e
+	}
+	s := me.statementsOf(td).insert
+	values := td.StructValues(st)
+	if !td.Fields[td.PkIx].AutoIncrement {
+		_, e = me.conn.ExecArgs(s.sql, me.args(td, s, values)...)
+		return e
+	}
+	var id int64
+	if _, ok := me.dialect.(Returning); ok {
+		var dr DataRow
+		if dr, e = me.conn.GetSingleRowArgs(s.sql, me.args(td, s, values)...); e == nil {
+			id = conv.ToInt64(dr.Items()[0])
+		}
+	} else {
+		var result sql.Result
+		if result, e = me.conn.ExecArgs(s.sql, me.args(td, s, values)...); e == nil {
+			id, e = result.LastInsertId()
+		}
+	}
+	if e == nil {
+		setKey(values[td.PkIx], id)
+	}
+	return e
+}
+
+// setKey stores a generated key in the primary key field of a structure
+func setKey(idv reflect.Value, id int64) {
+	if !idv.CanSet() {
+		return
+	}
+	switch idv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		idv.SetInt(id)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		idv.SetUint(uint64(id))
+	}
+}
+
+func (me *SQLMapper) Read(st interface{}) error {
+	td, e := me.tableDef(st)
+	if e != nil {
+		return e
+	}
+	s := me.statementsOf(td).read
+	dr, e := me.conn.GetSingleRowArgs(s.sql, me.args(td, s, td.StructValues(st))...)
+	if e == nil {
+		dr.FillStruct(st)
+	}
+	return e
+}
+
+func (me *SQLMapper) Update(st interface{}) error {
+	td, e := me.tableDef(st)
+	if e != nil {
+		return e
+	}
+	s := me.statementsOf(td).update
+	_, e = me.conn.ExecArgs(s.sql, me.args(td, s, td.StructValues(st))...)
+	return e
+}
+
+func (me *SQLMapper) Delete(st interface{}) error {
+	td, e := me.tableDef(st)
+	if e != nil {
+		return e
+	}
+	s := me.statementsOf(td).delete
+	_, e = me.conn.ExecArgs(s.sql, me.args(td, s, td.StructValues(st))...)
+	return e
+}
+
+// Upsert inserts a structure or updates it if its primary key exists.
+// A structure with an auto-increment key left to zero is inserted.
+func (me *SQLMapper) Upsert(st interface{}) error {
+	td, e := me.tableDef(st)
+	if e != nil {
+		return e
+	}
+	values := td.StructValues(st)
+	if td.Fields[td.PkIx].AutoIncrement && values[td.PkIx].IsZero() {
+		return me.Insert(st)
+	}
+	s := me.statementsOf(td).upsert
+	_, e = me.conn.ExecArgs(s.sql, me.args(td, s, values)...)
+	return e
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DriverName is the name of the database/sql driver registered by the package
+const DriverName = "sedimem"
+
+func init() {
+	sql.Register(DriverName, &memDriver{})
+}
+
+var databases = struct {
+	sync.Mutex
+	m map[string]*database
+}{m: make(map[string]*database)}
+
+// Drop deletes the in-memory database with the given name.
+// Connections opened later with the same name start with an empty database.
+func Drop(name string) {
+	databases.Lock()
+	delete(databases.m, name)
+	databases.Unlock()
+}
+
+type memDriver struct{}
+
+// Open opens the database with the given name, created on first use.
+// All the connections using the same name share the same database.
+func (d *memDriver) Open(name string) (driver.Conn, error) {
+	databases.Lock()
+	defer databases.Unlock()
+	db, ok := databases.m[name]
+	if !ok {
+		db = newDatabase()
+		databases.m[name] = db
+	}
+	return &memConn{db: db}, nil
+}
+
+type memConn struct {
+	db *database
+}
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) {
+	st, n, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &memStmt{db: c.db, st: st, params: n}, nil
+}
+
+func (c *memConn) Close() error {
+	return nil
+}
+
+// Begin starts a transaction. Transactions apply to the whole database,
+// only one can be active at a time.
+func (c *memConn) Begin() (driver.Tx, error) {
+	if _, err := c.db.execute(&txStmt{"begin"}, nil); err != nil {
+		return nil, err
+	}
+	return &memTx{db: c.db}, nil
+}
+
+type memTx struct {
+	db *database
+}
+
+func (tx *memTx) Commit() error {
+	_, err := tx.db.execute(&txStmt{"commit"}, nil)
+	return err
+}
+
+func (tx *memTx) Rollback() error {
+	_, err := tx.db.execute(&txStmt{"rollback"}, nil)
+	return err
+}
+
+type memStmt struct {
+	db     *database
+	st     interface{}
+	params int
+}
+
+func (s *memStmt) Close() error {
+	return nil
+}
+
+func (s *memStmt) NumInput() int {
+	return s.params
+}
+
+func (s *memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.db.execute(s.st, args)
+}
+
+func (s *memStmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := s.db.execute(s.st, args)
+	if err != nil {
+		return nil, err
+	}
+	return &memRows{res: res}, nil
+}
+
+type memRows struct {
+	res *result
+	pos int
+}
+
+func (r *memRows) Columns() []string {
+	return r.res.cols
+}
+
+// ColumnTypeDatabaseTypeName returns the type of the columns read from a table, "" for expressions
+func (r *memRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.res.types) {
+		return strings.ToUpper(r.res.types[index])
+	}
+	return ""
+}
+
+func (r *memRows) ColumnTypeScanType(index int) reflect.Type {
+	typ := ""
+	if index < len(r.res.types) {
+		typ = r.res.types[index]
+	}
+	switch affinity(typ) {
+	case "integer":
+		return reflect.TypeOf(int64(0))
+	case "real":
+		return reflect.TypeOf(float64(0))
+	case "text":
+		return reflect.TypeOf("")
+	case "bool":
+		return reflect.TypeOf(false)
+	case "time":
+		return reflect.TypeOf(time.Time{})
+	case "blob":
+		return reflect.TypeOf([]byte{})
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *memRows) Close() error {
+	return nil
+}
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.rows) {
+		return io.EOF
+	}
+	for i, v := range r.res.rows[r.pos] {
+		dest[i] = v
+	}
+	r.pos++
+	return nil
+}
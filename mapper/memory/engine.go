@@ -0,0 +1,1085 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type column struct {
+	name    string
+	typ     string
+	pk      bool
+	autoinc bool
+	notNull bool
+	def     interface{}
+}
+
+type table struct {
+	name string
+	cols []column
+	rows [][]interface{}
+	seq  int64 // Last generated auto-increment value
+}
+
+type index struct {
+	name   string
+	table  string
+	cols   []string
+	unique bool
+}
+
+// state is the content of a database, copied when a transaction begins
+type state struct {
+	tables  map[string]*table
+	indexes map[string]*index
+}
+
+type database struct {
+	lock     sync.Mutex
+	st       state
+	snapshot *state // State at the beginning of the current transaction
+}
+
+func newDatabase() *database {
+	return &database{st: state{tables: make(map[string]*table), indexes: make(map[string]*index)}}
+}
+
+func (s state) copy() state {
+	ret := state{tables: make(map[string]*table), indexes: make(map[string]*index)}
+	for k, t := range s.tables {
+		x := &table{name: t.name, seq: t.seq, cols: append([]column{}, t.cols...), rows: make([][]interface{}, len(t.rows))}
+		for i, r := range t.rows {
+			x.rows[i] = append([]interface{}{}, r...)
+		}
+		ret.tables[k] = x
+	}
+	for k, ix := range s.indexes {
+		x := *ix
+		x.cols = append([]string{}, ix.cols...)
+		ret.indexes[k] = &x
+	}
+	return ret
+}
+
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+func (t *table) colIndex(name string) int {
+	for i, c := range t.cols {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (db *database) table(name string) (*table, error) {
+	if t, ok := db.st.tables[key(name)]; ok {
+		return t, nil
+	}
+	return nil, errors.New("memory: no such table: " + name)
+}
+
+// result is the outcome of a statement
+type result struct {
+	cols         []string
+	types        []string // Type of the columns read from a table, "" for expressions
+	rows         [][]interface{}
+	lastID       int64
+	rowsAffected int64
+}
+
+func (r *result) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r *result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// execute runs a parsed statement
+func (db *database) execute(st interface{}, args []driver.Value) (*result, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	switch s := st.(type) {
+	case *selectStmt:
+		return db.selectRows(s, args)
+	case *insertStmt:
+		return db.insert(s, args)
+	case *updateStmt:
+		return db.update(s, args)
+	case *deleteStmt:
+		return db.delete(s, args)
+	case *createTableStmt:
+		return &result{}, db.createTable(s)
+	case *dropTableStmt:
+		return &result{}, db.dropTable(s)
+	case *createIndexStmt:
+		return &result{}, db.createIndex(s)
+	case *dropIndexStmt:
+		if _, ok := db.st.indexes[key(s.name)]; !ok && !s.ifExists {
+			return nil, errors.New("memory: no such index: " + s.name)
+		}
+		delete(db.st.indexes, key(s.name))
+		return &result{}, nil
+	case *alterTableStmt:
+		return &result{}, db.alterTable(s)
+	case *txStmt:
+		return &result{}, db.transaction(s.op)
+	case *showStmt:
+		return db.show(s)
+	}
+	return nil, errors.New("memory: unsupported statement")
+}
+
+func (db *database) transaction(op string) error {
+	switch op {
+	case "begin":
+		if db.snapshot != nil {
+			return errors.New("memory: cannot start a transaction within a transaction")
+		}
+		s := db.st.copy()
+		db.snapshot = &s
+	case "commit":
+		if db.snapshot == nil {
+			return errors.New("memory: no transaction is active")
+		}
+		db.snapshot = nil
+	case "rollback":
+		if db.snapshot == nil {
+			return errors.New("memory: no transaction is active")
+		}
+		db.st = *db.snapshot
+		db.snapshot = nil
+	}
+	return nil
+}
+
+// env gives access to the columns of the current row while evaluating an expression
+type env struct {
+	t        *table
+	row      []interface{}
+	excluded []interface{} // Row rejected by on conflict
+	group    [][]interface{}
+	args     []driver.Value
+}
+
+func (e *env) column(c *colExpr) (interface{}, error) {
+	if e.t == nil {
+		return nil, errors.New("memory: no such column: " + c.name)
+	}
+	i := e.t.colIndex(c.name)
+	if i == -1 {
+		return nil, errors.New("memory: no such column: " + c.name)
+	}
+	if strings.EqualFold(c.qual, "excluded") {
+		if e.excluded == nil {
+			return nil, errors.New("memory: excluded is only valid in on conflict")
+		}
+		return e.excluded[i], nil
+	}
+	if e.row == nil {
+		return nil, nil
+	}
+	return e.row[i], nil
+}
+
+func (e *env) eval(x expr) (interface{}, error) {
+	switch x := x.(type) {
+	case *litExpr:
+		return x.v, nil
+	case *paramExpr:
+		if x.n >= len(e.args) {
+			return nil, errors.New("memory: missing statement argument")
+		}
+		return normalize(e.args[x.n]), nil
+	case *colExpr:
+		return e.column(x)
+	case *unaryExpr:
+		v, err := e.eval(x.x)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		if x.op == "not" {
+			return !truth(v), nil
+		}
+		return arithmetic("-", int64(0), v)
+	case *binExpr:
+		return e.binary(x)
+	case *isNullExpr:
+		v, err := e.eval(x.x)
+		return (v == nil) != x.not, err
+	case *inExpr:
+		v, err := e.eval(x.x)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		found := false
+		for _, le := range x.list {
+			lv, err := e.eval(le)
+			if err != nil {
+				return nil, err
+			}
+			if c, ok := compare(v, lv); ok && c == 0 {
+				found = true
+			}
+		}
+		return found != x.not, nil
+	case *betweenExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := e.eval(x.lo)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := e.eval(x.hi)
+		if err != nil {
+			return nil, err
+		}
+		c1, ok1 := compare(v, lo)
+		c2, ok2 := compare(v, hi)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return (c1 >= 0 && c2 <= 0) != x.not, nil
+	case *likeExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		p, err := e.eval(x.pattern)
+		if err != nil || v == nil || p == nil {
+			return nil, err
+		}
+		return like(toString(v), toString(p)) != x.not, nil
+	case *funcExpr:
+		return e.function(x)
+	}
+	return nil, errors.New("memory: unsupported expression")
+}
+
+func (e *env) binary(x *binExpr) (interface{}, error) {
+	l, err := e.eval(x.l)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "and":
+		if l != nil && !truth(l) {
+			return false, nil
+		}
+	case "or":
+		if l != nil && truth(l) {
+			return true, nil
+		}
+	}
+	r, err := e.eval(x.r)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "and":
+		if r != nil && !truth(r) {
+			return false, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	case "or":
+		if r != nil && truth(r) {
+			return true, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return false, nil
+	case "=", "<>", "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return nil, nil
+		}
+		switch x.op {
+		case "=":
+			return c == 0, nil
+		case "<>":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	return arithmetic(x.op, l, r)
+}
+
+func isAggregate(name string) bool {
+	switch name {
+	case "count", "sum", "avg", "min", "max":
+		return true
+	}
+	return false
+}
+
+func (e *env) function(f *funcExpr) (interface{}, error) {
+	if isAggregate(f.name) {
+		return e.aggregate(f)
+	}
+	args := make([]interface{}, len(f.args))
+	for i, a := range f.args {
+		v, err := e.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch f.name {
+	case "lower", "upper", "length", "abs", "datetime", "date":
+		if len(args) != 1 {
+			return nil, errors.New("memory: " + f.name + " takes one argument")
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		switch f.name {
+		case "lower":
+			return strings.ToLower(toString(args[0])), nil
+		case "upper":
+			return strings.ToUpper(toString(args[0])), nil
+		case "length":
+			return int64(len([]rune(toString(args[0])))), nil
+		case "abs":
+			if c, _ := compare(args[0], int64(0)); c < 0 {
+				return arithmetic("-", int64(0), args[0])
+			}
+			return args[0], nil
+		case "datetime":
+			return convert(args[0], "timestamp")
+		case "date":
+			t, err := convert(args[0], "timestamp")
+			if err != nil {
+				return nil, err
+			}
+			return toString(t)[:10], nil
+		}
+	case "coalesce", "ifnull":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}
+	return nil, errors.New("memory: unknown function " + f.name)
+}
+
+func (e *env) aggregate(f *funcExpr) (interface{}, error) {
+	if e.group == nil {
+		return nil, errors.New("memory: misuse of aggregate " + f.name)
+	}
+	if f.star {
+		if f.name != "count" {
+			return nil, errors.New("memory: " + f.name + "(*) is not valid")
+		}
+		return int64(len(e.group)), nil
+	}
+	if len(f.args) != 1 {
+		return nil, errors.New("memory: " + f.name + " takes one argument")
+	}
+	var acc interface{}
+	n := int64(0)
+	for _, r := range e.group {
+		re := &env{t: e.t, row: r, args: e.args}
+		v, err := re.eval(f.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		n++
+		switch {
+		case acc == nil:
+			acc = v
+		case f.name == "sum" || f.name == "avg":
+			if acc, err = arithmetic("+", acc, v); err != nil {
+				return nil, err
+			}
+		case f.name == "min":
+			if c, _ := compare(v, acc); c < 0 {
+				acc = v
+			}
+		case f.name == "max":
+			if c, _ := compare(v, acc); c > 0 {
+				acc = v
+			}
+		}
+	}
+	switch f.name {
+	case "count":
+		return n, nil
+	case "avg":
+		if n == 0 {
+			return nil, nil
+		}
+		s, _ := toFloat(acc)
+		return s / float64(n), nil
+	}
+	return acc, nil
+}
+
+func hasAggregate(x expr) bool {
+	switch x := x.(type) {
+	case *funcExpr:
+		if isAggregate(x.name) {
+			return true
+		}
+		for _, a := range x.args {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	case *unaryExpr:
+		return hasAggregate(x.x)
+	case *binExpr:
+		return hasAggregate(x.l) || hasAggregate(x.r)
+	}
+	return false
+}
+
+// filter returns the positions of the rows matching a where clause
+func (db *database) filter(t *table, where expr, args []driver.Value) ([]int, error) {
+	ret := []int{}
+	for i, r := range t.rows {
+		if where != nil {
+			v, err := (&env{t: t, row: r, args: args}).eval(where)
+			if err != nil {
+				return nil, err
+			}
+			if !truth(v) {
+				continue
+			}
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+func (db *database) selectRows(s *selectStmt, args []driver.Value) (*result, error) {
+	var t *table
+	rows := [][]interface{}{nil} // select without from returns one row
+	if s.table != "" {
+		var err error
+		if t, err = db.table(s.table); err != nil {
+			return nil, err
+		}
+		pos, err := db.filter(t, s.where, args)
+		if err != nil {
+			return nil, err
+		}
+		rows = make([][]interface{}, len(pos))
+		for i, p := range pos {
+			rows[i] = t.rows[p]
+		}
+	}
+
+	res := &result{}
+	for _, it := range s.items {
+		if it.star {
+			if t == nil {
+				return nil, errors.New("memory: * needs a table")
+			}
+			for _, c := range t.cols {
+				res.cols = append(res.cols, c.name)
+				res.types = append(res.types, c.typ)
+			}
+		} else {
+			res.cols = append(res.cols, it.name)
+			typ := ""
+			if c, ok := it.x.(*colExpr); ok && t != nil {
+				if i := t.colIndex(c.name); i >= 0 {
+					typ = t.cols[i].typ
+				}
+			}
+			res.types = append(res.types, typ)
+		}
+	}
+
+	aggregate := false
+	for _, it := range s.items {
+		aggregate = aggregate || !it.star && hasAggregate(it.x)
+	}
+	if aggregate {
+		e := &env{t: t, group: rows, args: args}
+		if len(rows) > 0 {
+			e.row = rows[0]
+		}
+		out, err := e.project(s.items)
+		if err != nil {
+			return nil, err
+		}
+		res.rows = [][]interface{}{out}
+		return res, nil
+	}
+
+	if len(s.orderBy) > 0 {
+		var sortErr error
+		keys := make([][]interface{}, len(rows))
+		for i, r := range rows {
+			keys[i] = make([]interface{}, len(s.orderBy))
+			for k, o := range s.orderBy {
+				v, err := db.orderKey(t, r, o.x, s, args)
+				if err != nil {
+					sortErr = err
+				}
+				keys[i][k] = v
+			}
+		}
+		if sortErr != nil {
+			return nil, sortErr
+		}
+		idx := make([]int, len(rows))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			for k, o := range s.orderBy {
+				c, ok := compare(keys[idx[a]][k], keys[idx[b]][k])
+				if !ok {
+					// Nulls first
+					an, bn := keys[idx[a]][k] == nil, keys[idx[b]][k] == nil
+					if an == bn {
+						continue
+					}
+					c = 1
+					if an {
+						c = -1
+					}
+				}
+				if c != 0 {
+					return (c < 0) != o.desc
+				}
+			}
+			return false
+		})
+		sorted := make([][]interface{}, len(rows))
+		for i, p := range idx {
+			sorted[i] = rows[p]
+		}
+		rows = sorted
+	}
+
+	for _, r := range rows {
+		out, err := (&env{t: t, row: r, args: args}).project(s.items)
+		if err != nil {
+			return nil, err
+		}
+		if s.distinct && containsRow(res.rows, out) {
+			continue
+		}
+		res.rows = append(res.rows, out)
+	}
+
+	if s.limit != nil || s.offset != nil {
+		e := &env{args: args}
+		offset := int64(0)
+		if s.offset != nil {
+			v, err := e.eval(s.offset)
+			if err != nil {
+				return nil, err
+			}
+			x, _ := convert(v, "integer")
+			offset, _ = x.(int64)
+		}
+		if offset > int64(len(res.rows)) {
+			offset = int64(len(res.rows))
+		}
+		if offset > 0 {
+			res.rows = res.rows[offset:]
+		}
+		if s.limit != nil {
+			v, err := e.eval(s.limit)
+			if err != nil {
+				return nil, err
+			}
+			x, _ := convert(v, "integer")
+			if limit, ok := x.(int64); ok && limit >= 0 && limit < int64(len(res.rows)) {
+				res.rows = res.rows[:limit]
+			}
+		}
+	}
+	return res, nil
+}
+
+// orderKey evaluates an order by expression. A name can be a column alias of the select.
+func (db *database) orderKey(t *table, r []interface{}, x expr, s *selectStmt, args []driver.Value) (interface{}, error) {
+	if c, ok := x.(*colExpr); ok && c.qual == "" && (t == nil || t.colIndex(c.name) == -1) {
+		for _, it := range s.items {
+			if !it.star && strings.EqualFold(it.name, c.name) {
+				return (&env{t: t, row: r, args: args}).eval(it.x)
+			}
+		}
+	}
+	return (&env{t: t, row: r, args: args}).eval(x)
+}
+
+func (e *env) project(items []selectItem) ([]interface{}, error) {
+	out := []interface{}{}
+	for _, it := range items {
+		if it.star {
+			out = append(out, e.row...)
+			continue
+		}
+		v, err := e.eval(it.x)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func containsRow(rows [][]interface{}, r []interface{}) bool {
+	for _, x := range rows {
+		same := true
+		for i := range x {
+			c, ok := compare(x[i], r[i])
+			if ok && c != 0 || !ok && (x[i] == nil) != (r[i] == nil) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies the constraints of a row about to be stored at position pos (-1 for a new row).
+// It returns the position of the row in conflict for a unique constraint, -1 if none.
+func (db *database) check(t *table, row []interface{}, pos int) (int, error) {
+	for i, c := range t.cols {
+		if row[i] == nil && (c.notNull || c.pk) {
+			return -1, errors.New("memory: NOT NULL constraint failed: " + t.name + "." + c.name)
+		}
+	}
+	uniques := [][]int{}
+	pk := []int{}
+	for i, c := range t.cols {
+		if c.pk {
+			pk = append(pk, i)
+		}
+	}
+	if len(pk) > 0 {
+		uniques = append(uniques, pk)
+	}
+	for _, ix := range db.st.indexes {
+		if ix.unique && strings.EqualFold(ix.table, t.name) {
+			cols := []int{}
+			for _, cn := range ix.cols {
+				cols = append(cols, t.colIndex(cn))
+			}
+			uniques = append(uniques, cols)
+		}
+	}
+	for _, u := range uniques {
+		for ri, r := range t.rows {
+			if ri == pos {
+				continue
+			}
+			same := true
+			for _, ci := range u {
+				c, ok := compare(r[ci], row[ci])
+				if !ok || c != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				names := []string{}
+				for _, ci := range u {
+					names = append(names, t.name+"."+t.cols[ci].name)
+				}
+				return ri, errors.New("memory: UNIQUE constraint failed: " + strings.Join(names, ", "))
+			}
+		}
+	}
+	return -1, nil
+}
+
+func (db *database) insert(s *insertStmt, args []driver.Value) (*result, error) {
+	t, err := db.table(s.table)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]int, 0, len(t.cols))
+	if len(s.cols) == 0 {
+		for i := range t.cols {
+			cols = append(cols, i)
+		}
+	} else {
+		for _, cn := range s.cols {
+			i := t.colIndex(cn)
+			if i == -1 {
+				return nil, errors.New("memory: table " + t.name + " has no column named " + cn)
+			}
+			cols = append(cols, i)
+		}
+	}
+	res := &result{}
+	e := &env{t: t, args: args}
+	for _, values := range s.rows {
+		if len(values) != len(cols) {
+			return nil, errors.New("memory: " + t.name + " expects " + itoa(len(cols)) + " values")
+		}
+		row := make([]interface{}, len(t.cols))
+		set := make([]bool, len(t.cols))
+		for i, c := range t.cols {
+			row[i] = c.def
+		}
+		for k, x := range values {
+			v, err := e.eval(x)
+			if err != nil {
+				return nil, err
+			}
+			ci := cols[k]
+			if row[ci], err = convert(v, t.cols[ci].typ); err != nil {
+				return nil, err
+			}
+			set[ci] = true
+		}
+		for i, c := range t.cols {
+			if c.autoinc && row[i] == nil {
+				row[i] = t.seq + 1
+			}
+		}
+		conflict, err := db.check(t, row, -1)
+		if err != nil {
+			if !s.onConflict || conflict == -1 {
+				return nil, err
+			}
+			if s.doNothing {
+				continue
+			}
+			if err = db.assign(t, conflict, s.set, &env{t: t, row: t.rows[conflict], excluded: row, args: args}); err != nil {
+				return nil, err
+			}
+			res.rowsAffected++
+			continue
+		}
+		for i, c := range t.cols {
+			if c.autoinc {
+				if id, ok := row[i].(int64); ok && id > t.seq {
+					t.seq = id
+				}
+				res.lastID, _ = row[i].(int64)
+			}
+		}
+		t.rows = append(t.rows, row)
+		res.rowsAffected++
+	}
+	return res, nil
+}
+
+// assign applies set clauses to the row at position pos
+func (db *database) assign(t *table, pos int, set []assign, e *env) error {
+	row := append([]interface{}{}, t.rows[pos]...)
+	for _, a := range set {
+		ci := t.colIndex(a.col)
+		if ci == -1 {
+			return errors.New("memory: no such column: " + a.col)
+		}
+		v, err := e.eval(a.x)
+		if err != nil {
+			return err
+		}
+		if row[ci], err = convert(v, t.cols[ci].typ); err != nil {
+			return err
+		}
+	}
+	if _, err := db.check(t, row, pos); err != nil {
+		return err
+	}
+	t.rows[pos] = row
+	return nil
+}
+
+func (db *database) update(s *updateStmt, args []driver.Value) (*result, error) {
+	t, err := db.table(s.table)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := db.filter(t, s.where, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pos {
+		if err = db.assign(t, p, s.set, &env{t: t, row: t.rows[p], args: args}); err != nil {
+			return nil, err
+		}
+	}
+	return &result{rowsAffected: int64(len(pos))}, nil
+}
+
+func (db *database) delete(s *deleteStmt, args []driver.Value) (*result, error) {
+	t, err := db.table(s.table)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := db.filter(t, s.where, args)
+	if err != nil {
+		return nil, err
+	}
+	drop := make(map[int]bool)
+	for _, p := range pos {
+		drop[p] = true
+	}
+	kept := [][]interface{}{}
+	for i, r := range t.rows {
+		if !drop[i] {
+			kept = append(kept, r)
+		}
+	}
+	t.rows = kept
+	return &result{rowsAffected: int64(len(pos))}, nil
+}
+
+func (db *database) newColumn(cd columnDef) (column, error) {
+	c := column{name: cd.name, typ: cd.typ, pk: cd.pk, autoinc: cd.autoinc, notNull: cd.notNull}
+	if cd.def != nil {
+		v, err := (&env{}).eval(cd.def)
+		if err == nil {
+			c.def, err = convert(v, c.typ)
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	if c.autoinc && affinity(c.typ) != "integer" {
+		return c, errors.New("memory: auto-increment column " + c.name + " must be an integer")
+	}
+	return c, nil
+}
+
+func (db *database) createTable(s *createTableStmt) error {
+	if _, found := db.st.tables[key(s.table)]; found {
+		if s.ifNotExists {
+			return nil
+		}
+		return errors.New("memory: table " + s.table + " already exists")
+	}
+	t := &table{name: s.table}
+	for _, cd := range s.cols {
+		if t.colIndex(cd.name) != -1 {
+			return errors.New("memory: duplicate column name: " + cd.name)
+		}
+		c, err := db.newColumn(cd)
+		if err != nil {
+			return err
+		}
+		t.cols = append(t.cols, c)
+	}
+	for _, pk := range s.pk {
+		i := t.colIndex(pk)
+		if i == -1 {
+			return errors.New("memory: no such column: " + pk)
+		}
+		t.cols[i].pk = true
+	}
+	db.st.tables[key(s.table)] = t
+	for _, cd := range s.cols {
+		if cd.unique {
+			n := s.table + "_" + cd.name + "_key"
+			db.st.indexes[key(n)] = &index{name: n, table: s.table, cols: []string{cd.name}, unique: true}
+		}
+	}
+	return nil
+}
+
+func (db *database) dropTable(s *dropTableStmt) error {
+	if _, found := db.st.tables[key(s.table)]; !found {
+		if s.ifExists {
+			return nil
+		}
+		return errors.New("memory: no such table: " + s.table)
+	}
+	delete(db.st.tables, key(s.table))
+	for k, ix := range db.st.indexes {
+		if strings.EqualFold(ix.table, s.table) {
+			delete(db.st.indexes, k)
+		}
+	}
+	return nil
+}
+
+func (db *database) createIndex(s *createIndexStmt) error {
+	if _, found := db.st.indexes[key(s.name)]; found {
+		if s.ifNotExists {
+			return nil
+		}
+		return errors.New("memory: index " + s.name + " already exists")
+	}
+	t, err := db.table(s.table)
+	if err != nil {
+		return err
+	}
+	for _, c := range s.cols {
+		if t.colIndex(c) == -1 {
+			return errors.New("memory: no such column: " + c)
+		}
+	}
+	ix := &index{name: s.name, table: t.name, cols: s.cols, unique: s.unique}
+	db.st.indexes[key(s.name)] = ix
+	if s.unique {
+		for i, r := range t.rows {
+			if _, err := db.check(t, r, i); err != nil {
+				delete(db.st.indexes, key(s.name))
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *database) alterTable(s *alterTableStmt) error {
+	t, err := db.table(s.table)
+	if err != nil {
+		return err
+	}
+	switch s.action {
+	case "add":
+		if t.colIndex(s.col.name) != -1 {
+			return errors.New("memory: duplicate column name: " + s.col.name)
+		}
+		c, err := db.newColumn(s.col)
+		if err != nil {
+			return err
+		}
+		if (c.pk || c.notNull && c.def == nil) && len(t.rows) > 0 {
+			return errors.New("memory: cannot add column " + c.name + " to a table with rows")
+		}
+		t.cols = append(t.cols, c)
+		for i := range t.rows {
+			t.rows[i] = append(t.rows[i], c.def)
+		}
+	case "drop":
+		i := t.colIndex(s.name)
+		if i == -1 {
+			return errors.New("memory: no such column: " + s.name)
+		}
+		if t.cols[i].pk {
+			return errors.New("memory: cannot drop primary key column " + s.name)
+		}
+		t.cols = append(t.cols[:i:i], t.cols[i+1:]...)
+		for ri, r := range t.rows {
+			t.rows[ri] = append(r[:i:i], r[i+1:]...)
+		}
+		for k, ix := range db.st.indexes {
+			for _, c := range ix.cols {
+				if strings.EqualFold(ix.table, t.name) && strings.EqualFold(c, s.name) {
+					delete(db.st.indexes, k)
+				}
+			}
+		}
+	case "rename column":
+		i := t.colIndex(s.name)
+		if i == -1 {
+			return errors.New("memory: no such column: " + s.name)
+		}
+		if t.colIndex(s.to) != -1 {
+			return errors.New("memory: duplicate column name: " + s.to)
+		}
+		t.cols[i].name = s.to
+		for _, ix := range db.st.indexes {
+			for ci, c := range ix.cols {
+				if strings.EqualFold(ix.table, t.name) && strings.EqualFold(c, s.name) {
+					ix.cols[ci] = s.to
+				}
+			}
+		}
+	case "rename":
+		if _, found := db.st.tables[key(s.to)]; found {
+			return errors.New("memory: table " + s.to + " already exists")
+		}
+		delete(db.st.tables, key(t.name))
+		for _, ix := range db.st.indexes {
+			if strings.EqualFold(ix.table, t.name) {
+				ix.table = s.to
+			}
+		}
+		t.name = s.to
+		db.st.tables[key(s.to)] = t
+	case "type":
+		i := t.colIndex(s.name)
+		if i == -1 {
+			return errors.New("memory: no such column: " + s.name)
+		}
+		values := make([]interface{}, len(t.rows))
+		for ri, r := range t.rows {
+			if values[ri], err = convert(r[i], s.col.typ); err != nil {
+				return err
+			}
+		}
+		for ri := range t.rows {
+			t.rows[ri][i] = values[ri]
+		}
+		t.cols[i].typ = s.col.typ
+	}
+	return nil
+}
+
+// show lists tables, columns or indexes. It is used by the dialect to read the model.
+func (db *database) show(s *showStmt) (*result, error) {
+	res := &result{}
+	switch s.what {
+	case "tables":
+		res.cols = []string{"name"}
+		for _, t := range db.st.tables {
+			res.rows = append(res.rows, []interface{}{t.name})
+		}
+		sort.Slice(res.rows, func(a, b int) bool { return res.rows[a][0].(string) < res.rows[b][0].(string) })
+	case "columns":
+		t, err := db.table(s.table)
+		if err != nil {
+			return nil, err
+		}
+		res.cols = []string{"name", "type", "nullable", "pk", "autoincrement"}
+		for _, c := range t.cols {
+			res.rows = append(res.rows, []interface{}{c.name, c.typ, !c.notNull && !c.pk, c.pk, c.autoinc})
+		}
+	case "indexes":
+		t, err := db.table(s.table)
+		if err != nil {
+			return nil, err
+		}
+		res.cols = []string{"name", "column", "unique"}
+		names := []string{}
+		for k, ix := range db.st.indexes {
+			if strings.EqualFold(ix.table, t.name) {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			ix := db.st.indexes[k]
+			for _, c := range ix.cols {
+				res.rows = append(res.rows, []interface{}{ix.name, c, ix.unique})
+			}
+		}
+	}
+	return res, nil
+}
+
+func itoa(i int) string {
+	return toString(int64(i))
+}
@@ -0,0 +1,220 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package memory provides an in-memory database written in Go, and its dialect
+// of the sedi mapper. It is meant for unit tests: no cgo, no server, no files.
+//
+// The package registers the "sedimem" database/sql driver. The data source name
+// is the name of the database; connections using the same name share the same
+// tables until Drop is called.
+//
+// The SQL understood is a subset common to the other databases: select (where,
+// order by, limit, distinct, count/sum/avg/min/max without group by), insert
+// (with on conflict), update, delete, create/drop table and index, alter table,
+// begin/commit/rollback. Joins, sub-queries and group by are not supported.
+package memory
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
+)
+
+// SQLMapper provides persistence framework for structures
+// in an in-memory database
+type SQLMapper = sedi.SQLMapper
+
+func init() {
+	sedi.Register(Dialect{}, "memory")
+}
+
+// GetSQLMapper create a new SQLmapper for the in-memory database
+func GetSQLMapper() *SQLMapper {
+	return sedi.NewSQLMapper(Dialect{})
+}
+
+// Dialect implements sedi.Dialect for the in-memory database
+type Dialect struct{}
+
+func (d Dialect) DriverName() string {
+	return DriverName
+}
+
+func (d Dialect) Quote(s string) string {
+	return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+func (d Dialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d Dialect) SQLType(fd sedi.FieldDef) string {
+	var ts string
+	switch fd.GoTypeName {
+	case "string":
+		ts = "varchar(" + strconv.FormatInt(int64(fd.Size), 10) + ")"
+	case "Time":
+		ts = "timestamp"
+	case "bool":
+		ts = "boolean"
+	case "int8", "uint8", "byte", "char", "int16", "short", "uint16", "int32",
+		"uint32", "int64", "int", "uint64", "uint":
+		ts = "integer"
+	case "float32", "float64":
+		ts = "real"
+	}
+	return ts
+}
+
+func (d Dialect) SameType(dbType string, fd sedi.FieldDef) bool {
+	return strings.ToLower(dbType) == d.SQLType(fd)
+}
+
+// BindValue stores times in UTC, a zero time is stored as null
+func (d Dialect) BindValue(fd sedi.FieldDef, v interface{}) interface{} {
+	if x, ok := v.(time.Time); ok {
+		if x.IsZero() {
+			return nil
+		}
+		return x.UTC()
+	}
+	return v
+}
+
+func (d Dialect) DateLiteral(t time.Time) string {
+	return "'" + t.UTC().Format(timeFormat) + "'"
+}
+
+func (d Dialect) OnOpen(cn *sedi.Conn) error {
+	return nil
+}
+
+func (d Dialect) Tables(cn *sedi.Conn) ([]string, error) {
+	ret := []string{}
+	dt, err := cn.GetDataTable("show tables", nil)
+	if err == nil {
+		for _, r := range dt.Rows {
+			ret = append(ret, conv.ToString(r.ItemSingle("name")))
+		}
+	}
+	return ret, err
+}
+
+func (d Dialect) Columns(cn *sedi.Conn, table string) ([]sedi.DBColumn, error) {
+	ret := []sedi.DBColumn{}
+	dt, err := cn.GetDataTable("show columns from "+d.Quote(table), nil)
+	if err == nil {
+		for _, r := range dt.Rows {
+			ret = append(ret, sedi.DBColumn{
+				Name:          conv.ToString(r.ItemSingle("name")),
+				Type:          conv.ToString(r.ItemSingle("type")),
+				Nullable:      conv.ToBool(r.ItemSingle("nullable")),
+				PrimaryKey:    conv.ToBool(r.ItemSingle("pk")),
+				AutoIncrement: conv.ToBool(r.ItemSingle("autoincrement"))})
+		}
+	}
+	return ret, err
+}
+
+func (d Dialect) Indexes(cn *sedi.Conn, table string) ([]sedi.DBIndex, error) {
+	ret := []sedi.DBIndex{}
+	dt, err := cn.GetDataTable("show indexes from "+d.Quote(table), nil)
+	if err != nil {
+		return ret, err
+	}
+	for _, r := range dt.Rows {
+		name := conv.ToString(r.ItemSingle("name"))
+		if len(ret) == 0 || ret[len(ret)-1].Name != name {
+			ret = append(ret, sedi.DBIndex{Name: name, Unique: conv.ToBool(r.ItemSingle("unique"))})
+		}
+		ix := &(ret[len(ret)-1])
+		ix.Columns = append(ix.Columns, conv.ToString(r.ItemSingle("column")))
+	}
+	return ret, nil
+}
+
+// columnSQL returns the definition of a column
+func (d Dialect) columnSQL(fd sedi.FieldDef) string {
+	sql := d.Quote(fd.SQLName) + " " + d.SQLType(fd)
+	if fd.PrimaryKey {
+		sql += " primary key"
+	}
+	if fd.AutoIncrement {
+		sql += " autoincrement"
+	}
+	return sql
+}
+
+func (d Dialect) CreateTableSQL(td sedi.TableDef) string {
+	sql := "create table " + d.Quote(td.SQLName) + " ("
+	for i, fld := range td.Fields {
+		if i != 0 {
+			sql += ","
+		}
+		sql += "\n   " + d.columnSQL(fld)
+	}
+	sql += ")"
+	return sql
+}
+
+// AddColumnSQL ignores after, columns are added at the end of the table
+func (d Dialect) AddColumnSQL(td sedi.TableDef, fd sedi.FieldDef, after string) string {
+	return "alter table " + d.Quote(td.SQLName) + " add column " + d.columnSQL(fd)
+}
+
+func (d Dialect) ModifyColumnSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	return "alter table " + d.Quote(td.SQLName) + " alter column " + d.Quote(fd.SQLName) + " type " + d.SQLType(fd)
+}
+
+func (d Dialect) RenameColumnSQL(td sedi.TableDef, from string, to string) string {
+	return "alter table " + d.Quote(td.SQLName) + " rename column " + d.Quote(from) + " to " + d.Quote(to)
+}
+
+func (d Dialect) DropColumnSQL(td sedi.TableDef, column string) string {
+	return "alter table " + d.Quote(td.SQLName) + " drop column " + d.Quote(column)
+}
+
+// IndexName includes the table name, index names are unique in a database
+func (d Dialect) IndexName(td sedi.TableDef, column string) string {
+	return td.SQLName + "_" + column + "_idx"
+}
+
+func (d Dialect) CreateIndexSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	ui := ""
+	if fd.Unique {
+		ui = "unique "
+	}
+	return "create " + ui + "index " + d.Quote(d.IndexName(td, fd.SQLName)) + " on " + d.Quote(td.SQLName) + " (" + d.Quote(fd.SQLName) + ")"
+}
+
+func (d Dialect) DropIndexSQL(td sedi.TableDef, index string) string {
+	return "drop index if exists " + d.Quote(index)
+}
+
+func (d Dialect) DropTableSQL(table string) string {
+	return "drop table " + d.Quote(table)
+}
+
+func (d Dialect) UpsertSQL(td sedi.TableDef) string {
+	cols := []string{}
+	parms := []string{}
+	set := []string{}
+	for _, fld := range td.Fields {
+		cols = append(cols, d.Quote(fld.SQLName))
+		parms = append(parms, "@"+fld.Name)
+		if !fld.PrimaryKey && fld.CanUpdate {
+			set = append(set, d.Quote(fld.SQLName)+" = excluded."+d.Quote(fld.SQLName))
+		}
+	}
+	sql := "insert into " + d.Quote(td.SQLName) + " (" + strings.Join(cols, ", ") + ") values (" + strings.Join(parms, ", ") +
+		") on conflict (" + d.Quote(td.Fields[td.PkIx].SQLName) + ") do "
+	if len(set) == 0 {
+		return sql + "nothing"
+	}
+	return sql + "update set " + strings.Join(set, ", ")
+}
@@ -0,0 +1,463 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+)
+
+// openDB opens a new memory database through database/sql
+func openDB(t *testing.T) *sql.DB {
+	Drop(t.Name())
+	db, err := sql.Open(DriverName, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		Drop(t.Name())
+	})
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, SQL string, args ...interface{}) sql.Result {
+	t.Helper()
+	r, err := db.Exec(SQL, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", SQL, err)
+	}
+	return r
+}
+
+// query returns the rows of a query
+func query(t *testing.T, db *sql.DB, SQL string, args ...interface{}) [][]interface{} {
+	t.Helper()
+	rows, err := db.Query(SQL, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", SQL, err)
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	ret := [][]interface{}{}
+	for rows.Next() {
+		r := make([]interface{}, len(cols))
+		p := make([]interface{}, len(cols))
+		for i := range r {
+			p[i] = &r[i]
+		}
+		if err := rows.Scan(p...); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func checkRows(t *testing.T, db *sql.DB, SQL string, want [][]interface{}, args ...interface{}) {
+	t.Helper()
+	if got := query(t, db, SQL, args...); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s:\ngot  %v\nwant %v", SQL, got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"select * from t",
+		"select distinct a, b as x, c y, count(*) from \"t\" where a = ? and (b <> 'x' or c is not null) order by a desc, b limit 10 offset ?",
+		"select a from t limit 5, 10",
+		"insert into t (a, b) values (1, 'x'), (?, ?) on conflict (a) do update set b = excluded.b",
+		"insert into `t` values (1) on conflict do nothing",
+		"update t set a = a + 1, b = 'it''s' where a in (1, 2, 3) and b not like 'x%'",
+		"delete from t where a between 1 and 5",
+		"create table if not exists t (id integer primary key autoincrement, name varchar(30) not null unique, d timestamp default null)",
+		"create unique index if not exists t_name on t (name, id)",
+		"drop index if exists t_name on t",
+		"drop table if exists t",
+		"alter table t add column c real",
+		"alter table t drop column c",
+		"alter table t rename column a to b",
+		"alter table t rename to u",
+		"alter table t alter column a type varchar(10)",
+		"begin transaction", "start transaction", "commit", "end", "rollback",
+		"show tables", "show columns from t", "show indexes from t",
+		"select 1; -- comment",
+	}
+	for _, s := range valid {
+		if _, _, err := parse(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	_, n, _ := parse("select a from t where a = ? or b in (?, ?)")
+	if n != 3 {
+		t.Errorf("%d parameters", n)
+	}
+
+	invalid := map[string]string{
+		"select a from":                     "expected a name",
+		"select a from t where":             "near end of statement",
+		"insert into t (a values (1)":       "expected )",
+		"update t a = 1":                    "expected set",
+		"alter table t truncate":            "unsupported alter table",
+		"merge into t":                      "unsupported statement",
+		"select 'abc":                       "unterminated '",
+		"select a from t order by a desc x": "unexpected x",
+		"select a # b":                      "unexpected character #",
+	}
+	for s, want := range invalid {
+		if _, _, err := parse(s); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	db := openDB(t)
+	mustExec(t, db, "create table city (id integer primary key, name varchar(30), country varchar(2), pop integer, area real)")
+	mustExec(t, db, "insert into city (id, name, country, pop, area) values "+
+		"(1, 'Paris', 'FR', 2100000, 105.4), (2, 'Lyon', 'FR', 510000, 47.9), (3, 'Berlin', 'DE', 3600000, 891.8), "+
+		"(4, 'Bonn', 'DE', 330000, null), (5, 'Nowhere', null, null, null)")
+
+	checkRows(t, db, "select name from city where country = ? order by pop desc", [][]interface{}{{"Paris"}, {"Lyon"}}, "FR")
+	checkRows(t, db, "select id from city where pop > 500000 and area is not null order by id limit 2 offset 1",
+		[][]interface{}{{int64(2)}, {int64(3)}})
+	checkRows(t, db, "select id from city where name like 'b%' or country is null order by id",
+		[][]interface{}{{int64(3)}, {int64(4)}, {int64(5)}})
+	checkRows(t, db, "select id from city where id in (1, 3, 9) or pop between 300000 and 400000 order by id",
+		[][]interface{}{{int64(1)}, {int64(3)}, {int64(4)}})
+	checkRows(t, db, "select id from city where not (country = 'FR') order by id", [][]interface{}{{int64(3)}, {int64(4)}})
+	checkRows(t, db, "select distinct country from city where country is not null order by country",
+		[][]interface{}{{"DE"}, {"FR"}})
+	checkRows(t, db, "select count(*), count(area), max(pop), min(name) from city",
+		[][]interface{}{{int64(5), int64(3), int64(3600000), "Berlin"}})
+	checkRows(t, db, "select id * 10 + 1 as x from city where id = 2", [][]interface{}{{int64(21)}})
+
+	rows, err := db.Query("select id as \"Key\", name from city where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, _ := rows.Columns()
+	rows.Close()
+	if !reflect.DeepEqual(cols, []string{"Key", "name"}) {
+		t.Errorf("columns %v", cols)
+	}
+	if _, err := db.Query("select nope from city"); err == nil {
+		t.Error("unknown column accepted")
+	}
+	if _, err := db.Query("select * from nope"); err == nil {
+		t.Error("unknown table accepted")
+	}
+}
+
+func TestInsert(t *testing.T) {
+	db := openDB(t)
+	mustExec(t, db, "create table item (id integer primary key autoincrement, code varchar(10) not null, qty integer, "+
+		"at timestamp)")
+	mustExec(t, db, "create unique index item_code on item (code)")
+	r := mustExec(t, db, "insert into item (code, qty, at) values (?, ?, ?)", "a", 1, time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+	if id, _ := r.LastInsertId(); id != 1 {
+		t.Errorf("id %d", id)
+	}
+	r = mustExec(t, db, "insert into item (code, qty) values ('b', 2), ('c', 3)")
+	if id, _ := r.LastInsertId(); id != 3 {
+		t.Errorf("id %d", id)
+	}
+	if n, _ := r.RowsAffected(); n != 2 {
+		t.Errorf("%d rows", n)
+	}
+	for _, s := range []string{
+		"insert into item (id, code) values (1, 'z')", // duplicate key
+		"insert into item (code) values ('a')",        // unique index
+		"insert into item (qty) values (1)",           // not null
+		"insert into item (nope) values (1)",
+		"insert into item (code, qty) values ('x')",
+	} {
+		if _, err := db.Exec(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+
+	mustExec(t, db, "insert into item (id, code, qty) values (1, 'a', 10), (9, 'i', 9) on conflict (id) do update set qty = excluded.qty")
+	mustExec(t, db, "insert into item (id, code, qty) values (2, 'b', 20) on conflict (id) do nothing")
+	checkRows(t, db, "select id, code, qty from item order by id", [][]interface{}{
+		{int64(1), "a", int64(10)}, {int64(2), "b", int64(2)}, {int64(3), "c", int64(3)}, {int64(9), "i", int64(9)}})
+	checkRows(t, db, "select at from item where id = 1", [][]interface{}{{time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)}})
+
+	r = mustExec(t, db, "update item set qty = qty * 2 where qty < ?", 5)
+	if n, _ := r.RowsAffected(); n != 2 {
+		t.Errorf("%d rows updated", n)
+	}
+	r = mustExec(t, db, "delete from item where code in ('a', 'b')")
+	if n, _ := r.RowsAffected(); n != 2 {
+		t.Errorf("%d rows deleted", n)
+	}
+	checkRows(t, db, "select id, qty from item order by id", [][]interface{}{{int64(3), int64(6)}, {int64(9), int64(9)}})
+	// The autoincrement continues after the largest key
+	r = mustExec(t, db, "insert into item (code) values ('j')")
+	if id, _ := r.LastInsertId(); id != 10 {
+		t.Errorf("id %d", id)
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	db := openDB(t)
+	mustExec(t, db, "create table p (id integer primary key, name varchar(10), age varchar(10))")
+	mustExec(t, db, "create index p_name on p (name)")
+	mustExec(t, db, "insert into p (id, name, age) values (1, 'a', '42')")
+
+	mustExec(t, db, "alter table p add column city varchar(20)")
+	mustExec(t, db, "alter table p rename column name to full_name")
+	mustExec(t, db, "alter table p alter column age type integer")
+	checkRows(t, db, "select id, full_name, age, city from p", [][]interface{}{{int64(1), "a", int64(42), nil}})
+	checkRows(t, db, "show indexes from p", [][]interface{}{{"p_name", "full_name", false}})
+
+	mustExec(t, db, "alter table p drop column city")
+	mustExec(t, db, "alter table p rename to person")
+	checkRows(t, db, "show tables", [][]interface{}{{"person"}})
+	checkRows(t, db, "show columns from person", [][]interface{}{
+		{"id", "integer", false, true, false}, {"full_name", "varchar(10)", true, false, false}, {"age", "integer", true, false, false}})
+
+	if _, err := db.Exec("insert into person (id, age) values (2, 'x')"); err == nil {
+		t.Error("inserted 'x' in an integer column")
+	}
+	mustExec(t, db, "alter table person add code varchar(5)")
+	mustExec(t, db, "update person set code = 'x'")
+	if _, err := db.Exec("alter table person alter column code type integer"); err == nil {
+		t.Error("converted 'x' to an integer")
+	}
+	if _, err := db.Exec("alter table person drop column nope"); err == nil {
+		t.Error("dropped a missing column")
+	}
+	mustExec(t, db, "drop table person")
+	if _, err := db.Exec("drop table person"); err == nil {
+		t.Error("dropped a missing table")
+	}
+	mustExec(t, db, "drop table if exists person")
+}
+
+func TestTransactions(t *testing.T) {
+	db := openDB(t)
+	mustExec(t, db, "create table a (id integer primary key)")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("insert into a (id) values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("create table b (id integer primary key)"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, db, "select count(*) from a", [][]interface{}{{int64(0)}})
+	checkRows(t, db, "show tables", [][]interface{}{{"a"}})
+
+	tx, _ = db.Begin()
+	tx.Exec("insert into a (id) values (2)")
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, db, "select id from a", [][]interface{}{{int64(2)}})
+
+	// begin and rollback statements on a single connection
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, s := range []string{"begin", "insert into a (id) values (3)", "rollback"} {
+		if _, err = conn.ExecContext(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkRows(t, db, "select id from a", [][]interface{}{{int64(2)}})
+}
+
+type Contact struct {
+	Id     int64  `autoincrement:"y"`
+	Name   string `size:"30" indexed:"y" unique:"y"`
+	Born   time.Time
+	Active bool
+	Rate   float64
+}
+
+func TestSQLMapper(t *testing.T) {
+	sedi.LogErrors = false
+	Drop("mapper_test")
+	defer Drop("mapper_test")
+	m, err := sedi.Open("memory://mapper_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseConnection()
+	m.AddPersistence(&Contact{})
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("not up to date: %v", err)
+	}
+
+	c := Contact{Name: "O'Hara", Born: time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC), Active: true, Rate: 1.5}
+	if err = m.Insert(&c); err != nil || c.Id != 1 {
+		t.Fatalf("insert: id %d, %v", c.Id, err)
+	}
+	r := Contact{Id: 1}
+	if err = m.Read(&r); err != nil || r != c {
+		t.Fatalf("read %+v, %v", r, err)
+	}
+	r.Name = "Smith"
+	if err = m.Update(&r); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Insert(&Contact{Name: "Smith"}); err == nil {
+		t.Error("unique index not enforced")
+	}
+	r.Rate = 2
+	if err = m.Upsert(&r); err != nil {
+		t.Fatal(err)
+	}
+	x := Contact{Id: 1}
+	if err = m.Read(&x); err != nil || x != r {
+		t.Fatalf("read %+v, %v", x, err)
+	}
+	if err = m.Delete(&x); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Read(&x); err == nil {
+		t.Error("deleted row read")
+	}
+
+	// A second model of the table adds and renames columns in place
+	type contact struct {
+		Id       int64  `autoincrement:"y"`
+		FullName string `size:"30" renamedFrom:"name"`
+		Born     time.Time
+		Active   bool
+		Rate     float64
+		City     string
+	}
+	m.Insert(&Contact{Name: "kept"})
+	m2 := GetSQLMapper().OpenConnection("mapper_test").AddPersistence(&contact{})
+	defer m2.CloseConnection()
+	if ok, _ := m2.ModelIsUpToDate(); ok {
+		t.Fatal("changed model up to date")
+	}
+	if err = m2.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m2.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("not up to date after UpdateModel: %v", err)
+	}
+	y := contact{Id: 2}
+	if err = m2.Read(&y); err != nil || y.FullName != "kept" || y.City != "" {
+		t.Errorf("read %+v, %v", y, err)
+	}
+}
+
+func TestRenameErrors(t *testing.T) {
+	sedi.LogErrors = false
+	Drop(t.Name())
+	defer Drop(t.Name())
+	m, err := sedi.Open("memory://" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseConnection()
+	cn := m.Connection()
+	if err = cn.ExecNoResult("create table person (id integer primary key, name varchar(30), full_name varchar(30))", nil); err != nil {
+		t.Fatal(err)
+	}
+	type person struct {
+		Id       int64
+		FullName string `size:"30" renamedFrom:"name"`
+	}
+	m.AddPersistence(&person{})
+	if _, err = m.ModelIsUpToDate(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("both columns exist: got %v", err)
+	}
+
+	if err = cn.ExecNoResult("alter table person drop column name", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Errorf("renamed column: %v, %v", ok, err)
+	}
+
+	type other struct {
+		Id   int64
+		City string `size:"30" renamedFrom:"town"`
+	}
+	if err = cn.ExecNoResult("create table other (id integer primary key)", nil); err != nil {
+		t.Fatal(err)
+	}
+	m2, _ := sedi.Open("memory://" + t.Name())
+	defer m2.CloseConnection()
+	m2.AddPersistence(&other{})
+	if err = m2.UpdateModel(); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("missing column: got %v", err)
+	}
+}
+
+func TestOrphanTables(t *testing.T) {
+	sedi.LogErrors = false
+	Drop(t.Name())
+	defer Drop(t.Name())
+	m, err := sedi.Open("memory://" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseConnection()
+	cn := m.Connection()
+	for _, s := range []string{"create table contact (id integer primary key)", "create table schema_migrations (version bigint)",
+		"create table csv_import (id integer)", "create table old_log (id integer)", "create table Keep_Me (id integer)"} {
+		if err = cn.ExecNoResult(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type contact struct {
+		Id int64
+	}
+	m.AddPersistence(&contact{})
+	m.KeepTables = append(m.KeepTables, "keep_me")
+	m.DropTables = []string{"OLD_LOG"}
+
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil || m.OrphanTables != nil {
+		t.Fatalf("PruneNone: %v, %v, %v", ok, err, m.OrphanTables)
+	}
+	m.SetPruneMode(sedi.PruneReport)
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil || !reflect.DeepEqual(m.OrphanTables, []string{"csv_import", "old_log"}) {
+		t.Fatalf("PruneReport: %v, %v, %v", ok, err, m.OrphanTables)
+	}
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	m.SetPruneMode(sedi.PruneDrop)
+	if ok, err := m.ModelIsUpToDate(); ok || err != nil {
+		t.Fatalf("PruneDrop with a table to drop: %v, %v", ok, err)
+	}
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.OrphanTables, []string{"csv_import"}) {
+		t.Errorf("orphans after the drop %v", m.OrphanTables)
+	}
+	tables, _ := Dialect{}.Tables(cn)
+	if !reflect.DeepEqual(tables, []string{"Keep_Me", "contact", "csv_import", "schema_migrations"}) {
+		t.Errorf("tables after the drop %v", tables)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Errorf("up to date after the drop: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,923 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tkEOF tokenKind = iota
+	tkIdent
+	tkQuoted // Quoted identifier
+	tkNumber
+	tkString
+	tkSymbol
+	tkParam
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// is tells if the token is the given keyword or symbol
+func (t token) is(s string) bool {
+	return (t.kind == tkIdent || t.kind == tkSymbol) && strings.EqualFold(t.text, s)
+}
+
+func tokenize(sql string) ([]token, error) {
+	ret := []token{}
+	rs := []rune(sql)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ';':
+			i++
+		case c == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			s := []rune{}
+			for {
+				if j >= len(rs) {
+					return ret, errors.New("unterminated " + string(c))
+				}
+				if rs[j] == c {
+					if j+1 < len(rs) && rs[j+1] == c {
+						s = append(s, c)
+						j += 2
+						continue
+					}
+					break
+				}
+				s = append(s, rs[j])
+				j++
+			}
+			if c == '\'' {
+				ret = append(ret, token{tkString, string(s)})
+			} else {
+				ret = append(ret, token{tkQuoted, string(s)})
+			}
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(rs) && rs[i+1] >= '0' && rs[i+1] <= '9':
+			j := i
+			for j < len(rs) && (rs[j] >= '0' && rs[j] <= '9' || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E' ||
+				(rs[j] == '-' || rs[j] == '+') && (rs[j-1] == 'e' || rs[j-1] == 'E')) {
+				j++
+			}
+			ret = append(ret, token{tkNumber, string(rs[i:j])})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(rs) && (rs[j] == '_' || rs[j] >= 'a' && rs[j] <= 'z' || rs[j] >= 'A' && rs[j] <= 'Z' || rs[j] >= '0' && rs[j] <= '9') {
+				j++
+			}
+			ret = append(ret, token{tkIdent, string(rs[i:j])})
+			i = j
+		case c == '?':
+			ret = append(ret, token{tkParam, "?"})
+			i++
+		default:
+			if i+1 < len(rs) {
+				two := string(rs[i : i+2])
+				if two == "<=" || two == ">=" || two == "<>" || two == "!=" || two == "||" || two == "==" {
+					ret = append(ret, token{tkSymbol, two})
+					i += 2
+					continue
+				}
+			}
+			if strings.ContainsRune("(),.*=<>+-/%", c) {
+				ret = append(ret, token{tkSymbol, string(c)})
+				i++
+				continue
+			}
+			return ret, errors.New("unexpected character " + string(c))
+		}
+	}
+	return append(ret, token{kind: tkEOF}), nil
+}
+
+// Expressions
+
+type expr interface{}
+
+type litExpr struct{ v interface{} }
+
+type paramExpr struct{ n int }
+
+type colExpr struct{ qual, name string }
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binExpr struct {
+	op   string
+	l, r expr
+}
+
+type isNullExpr struct {
+	x   expr
+	not bool
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+type betweenExpr struct {
+	x, lo, hi expr
+	not       bool
+}
+
+type likeExpr struct {
+	x, pattern expr
+	not        bool
+}
+
+type funcExpr struct {
+	name string
+	args []expr
+	star bool // count(*)
+}
+
+// Statements
+
+type selectItem struct {
+	star bool
+	x    expr
+	name string
+}
+
+type orderItem struct {
+	x    expr
+	desc bool
+}
+
+type selectStmt struct {
+	distinct bool
+	items    []selectItem
+	table    string
+	where    expr
+	orderBy  []orderItem
+	limit    expr
+	offset   expr
+}
+
+type assign struct {
+	col string
+	x   expr
+}
+
+type insertStmt struct {
+	table       string
+	cols        []string
+	rows        [][]expr
+	onConflict  bool
+	conflictCol []string
+	doNothing   bool
+	set         []assign
+}
+
+type updateStmt struct {
+	table string
+	set   []assign
+	where expr
+}
+
+type deleteStmt struct {
+	table string
+	where expr
+}
+
+type columnDef struct {
+	name    string
+	typ     string
+	pk      bool
+	autoinc bool
+	notNull bool
+	unique  bool
+	def     expr
+}
+
+type createTableStmt struct {
+	table       string
+	ifNotExists bool
+	cols        []columnDef
+	pk          []string
+}
+
+type dropTableStmt struct {
+	table    string
+	ifExists bool
+}
+
+type createIndexStmt struct {
+	name        string
+	table       string
+	cols        []string
+	unique      bool
+	ifNotExists bool
+}
+
+type dropIndexStmt struct {
+	name     string
+	ifExists bool
+}
+
+type alterTableStmt struct {
+	table  string
+	action string // add, drop, rename column, rename, type
+	col    columnDef
+	name   string
+	to     string
+}
+
+type txStmt struct{ op string }
+
+type showStmt struct{ what, table string }
+
+type parser struct {
+	toks   []token
+	pos    int
+	params int
+}
+
+// parse parses a statement and returns it with its number of ? parameters
+func parse(sql string) (interface{}, int, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &parser{toks: toks}
+	st, err := p.statement()
+	if err == nil && p.peek().kind != tkEOF {
+		err = p.errorf("unexpected " + p.peek().text)
+	}
+	return st, p.params, err
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tkEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next tokens if they are the given keywords or symbols
+func (p *parser) accept(words ...string) bool {
+	for i, w := range words {
+		if p.pos+i >= len(p.toks) || !p.toks[p.pos+i].is(w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *parser) expect(words ...string) error {
+	if !p.accept(words...) {
+		return p.errorf("expected " + strings.Join(words, " "))
+	}
+	return nil
+}
+
+func (p *parser) errorf(msg string) error {
+	near := p.peek().text
+	if p.peek().kind == tkEOF {
+		near = "end of statement"
+	}
+	return errors.New("memory: syntax error, " + msg + " near " + near)
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind == tkIdent || t.kind == tkQuoted {
+		p.pos++
+		return t.text, nil
+	}
+	return "", p.errorf("expected a name")
+}
+
+func (p *parser) identList() ([]string, error) {
+	ret := []string{}
+	if err := p.expect("("); err != nil {
+		return ret, err
+	}
+	for {
+		n, err := p.ident()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, n)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return ret, p.expect(")")
+}
+
+func (p *parser) statement() (interface{}, error) {
+	switch {
+	case p.accept("select"):
+		return p.selectStatement()
+	case p.accept("insert", "into"):
+		return p.insertStatement()
+	case p.accept("update"):
+		return p.updateStatement()
+	case p.accept("delete", "from"):
+		st := &deleteStmt{}
+		var err error
+		if st.table, err = p.ident(); err == nil && p.accept("where") {
+			st.where, err = p.expr()
+		}
+		return st, err
+	case p.accept("create", "table"):
+		return p.createTable()
+	case p.accept("create"):
+		st := &createIndexStmt{unique: p.accept("unique")}
+		if err := p.expect("index"); err != nil {
+			return nil, err
+		}
+		st.ifNotExists = p.accept("if", "not", "exists")
+		var err error
+		if st.name, err = p.ident(); err == nil {
+			if err = p.expect("on"); err == nil {
+				if st.table, err = p.ident(); err == nil {
+					st.cols, err = p.identList()
+				}
+			}
+		}
+		return st, err
+	case p.accept("drop", "table"):
+		st := &dropTableStmt{ifExists: p.accept("if", "exists")}
+		var err error
+		st.table, err = p.ident()
+		return st, err
+	case p.accept("drop", "index"):
+		st := &dropIndexStmt{ifExists: p.accept("if", "exists")}
+		var err error
+		st.name, err = p.ident()
+		if err == nil && p.accept("on") {
+			_, err = p.ident()
+		}
+		return st, err
+	case p.accept("alter", "table"):
+		return p.alterTable()
+	case p.accept("begin"), p.accept("start", "transaction"):
+		p.accept("transaction")
+		return &txStmt{"begin"}, nil
+	case p.accept("commit"), p.accept("end"):
+		p.accept("transaction")
+		return &txStmt{"commit"}, nil
+	case p.accept("rollback"):
+		p.accept("transaction")
+		return &txStmt{"rollback"}, nil
+	case p.accept("show", "tables"):
+		return &showStmt{what: "tables"}, nil
+	case p.accept("show", "columns", "from"), p.accept("show", "indexes", "from"):
+		st := &showStmt{what: strings.ToLower(p.toks[p.pos-2].text)}
+		var err error
+		st.table, err = p.ident()
+		return st, err
+	}
+	return nil, p.errorf("unsupported statement")
+}
+
+func (p *parser) selectStatement() (interface{}, error) {
+	st := &selectStmt{distinct: p.accept("distinct")}
+	for {
+		if p.accept("*") {
+			st.items = append(st.items, selectItem{star: true})
+		} else {
+			start := p.pos
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			it := selectItem{x: x, name: p.text(start, p.pos)}
+			if c, ok := x.(*colExpr); ok {
+				it.name = c.name
+			}
+			if p.accept("as") {
+				if it.name, err = p.ident(); err != nil {
+					return nil, err
+				}
+			} else if t := p.peek(); t.kind == tkQuoted || t.kind == tkIdent && !isKeyword(t.text) {
+				it.name = p.next().text
+			}
+			st.items = append(st.items, it)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	var err error
+	if p.accept("from") {
+		if st.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("where") {
+		if st.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("order", "by") {
+		for {
+			oi := orderItem{}
+			if oi.x, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if p.accept("desc") {
+				oi.desc = true
+			} else {
+				p.accept("asc")
+			}
+			st.orderBy = append(st.orderBy, oi)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("limit") {
+		if st.limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.accept("offset") {
+			st.offset, err = p.expr()
+		} else if p.accept(",") {
+			// limit offset, count
+			st.offset = st.limit
+			st.limit, err = p.expr()
+		}
+	}
+	return st, err
+}
+
+// text returns the source of tokens [start, end), used to name select columns
+func (p *parser) text(start, end int) string {
+	s := ""
+	for i := start; i < end; i++ {
+		t := p.toks[i]
+		switch t.kind {
+		case tkString:
+			s += "'" + strings.Replace(t.text, "'", "''", -1) + "'"
+		default:
+			s += t.text
+		}
+	}
+	return s
+}
+
+func isKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "from", "where", "order", "limit", "offset", "as", "and", "or", "not", "asc", "desc", "on", "set", "values":
+		return true
+	}
+	return false
+}
+
+func (p *parser) insertStatement() (interface{}, error) {
+	st := &insertStmt{}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.peek().is("(") {
+		if st.cols, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.expect("values"); err != nil {
+		return nil, err
+	}
+	for {
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		st.rows = append(st.rows, row)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("on", "conflict") {
+		st.onConflict = true
+		if p.peek().is("(") {
+			if st.conflictCol, err = p.identList(); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.expect("do"); err != nil {
+			return nil, err
+		}
+		if p.accept("nothing") {
+			st.doNothing = true
+		} else {
+			if err = p.expect("update", "set"); err != nil {
+				return nil, err
+			}
+			st.set, err = p.assignments()
+		}
+	}
+	return st, err
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	ret := []expr{}
+	if err := p.expect("("); err != nil {
+		return ret, err
+	}
+	if p.accept(")") {
+		return ret, nil
+	}
+	for {
+		x, err := p.expr()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, x)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return ret, p.expect(")")
+}
+
+func (p *parser) assignments() ([]assign, error) {
+	ret := []assign{}
+	for {
+		c, err := p.ident()
+		if err != nil {
+			return ret, err
+		}
+		if err = p.expect("="); err != nil {
+			return ret, err
+		}
+		x, err := p.expr()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, assign{c, x})
+		if !p.accept(",") {
+			return ret, nil
+		}
+	}
+}
+
+func (p *parser) updateStatement() (interface{}, error) {
+	st := &updateStmt{}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("set"); err != nil {
+		return nil, err
+	}
+	if st.set, err = p.assignments(); err != nil {
+		return nil, err
+	}
+	if p.accept("where") {
+		st.where, err = p.expr()
+	}
+	return st, err
+}
+
+func (p *parser) createTable() (interface{}, error) {
+	st := &createTableStmt{ifNotExists: p.accept("if", "not", "exists")}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.accept("primary", "key") {
+			if st.pk, err = p.identList(); err != nil {
+				return nil, err
+			}
+		} else {
+			cd, err := p.columnDef()
+			if err != nil {
+				return nil, err
+			}
+			st.cols = append(st.cols, cd)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return st, p.expect(")")
+}
+
+// columnDef parses a column name, its type and its constraints
+func (p *parser) columnDef() (columnDef, error) {
+	cd := columnDef{}
+	var err error
+	if cd.name, err = p.ident(); err != nil {
+		return cd, err
+	}
+	cd.typ, err = p.typeName()
+	for err == nil {
+		switch {
+		case p.accept("primary", "key"):
+			cd.pk = true
+		case p.accept("autoincrement"), p.accept("auto_increment"):
+			cd.autoinc = true
+		case p.accept("not", "null"):
+			cd.notNull = true
+		case p.accept("null"):
+		case p.accept("unique"):
+			cd.unique = true
+		case p.accept("default"):
+			cd.def, err = p.primary()
+		default:
+			return cd, nil
+		}
+	}
+	return cd, err
+}
+
+// typeName reads a type made of several words and an optional size, as varchar(50) or double precision
+func (p *parser) typeName() (string, error) {
+	words := []string{}
+	for p.peek().kind == tkIdent && !isConstraint(p.peek().text) {
+		words = append(words, strings.ToLower(p.next().text))
+	}
+	if len(words) == 0 {
+		return "", p.errorf("expected a type")
+	}
+	t := strings.Join(words, " ")
+	if p.accept("(") {
+		size := []string{}
+		for !p.peek().is(")") {
+			if p.peek().kind == tkEOF {
+				return t, p.errorf("expected )")
+			}
+			size = append(size, p.next().text)
+		}
+		p.next()
+		t += "(" + strings.Join(size, "") + ")"
+	}
+	return t, nil
+}
+
+func isConstraint(s string) bool {
+	switch strings.ToLower(s) {
+	case "primary", "autoincrement", "auto_increment", "not", "null", "unique", "default":
+		return true
+	}
+	return false
+}
+
+func (p *parser) alterTable() (interface{}, error) {
+	st := &alterTableStmt{}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.accept("add"):
+		p.accept("column")
+		st.action = "add"
+		st.col, err = p.columnDef()
+	case p.accept("drop"):
+		p.accept("column")
+		st.action = "drop"
+		st.name, err = p.ident()
+	case p.accept("rename", "column"):
+		st.action = "rename column"
+		if st.name, err = p.ident(); err == nil {
+			if err = p.expect("to"); err == nil {
+				st.to, err = p.ident()
+			}
+		}
+	case p.accept("rename", "to"):
+		st.action = "rename"
+		st.to, err = p.ident()
+	case p.accept("alter"):
+		p.accept("column")
+		st.action = "type"
+		if st.name, err = p.ident(); err == nil {
+			if err = p.expect("type"); err == nil {
+				st.col.typ, err = p.typeName()
+			}
+		}
+	default:
+		err = p.errorf("unsupported alter table")
+	}
+	return st, err
+}
+
+// Expression grammar, by increasing precedence: or, and, not, comparison, +, *, unary
+
+func (p *parser) expr() (expr, error) {
+	l, err := p.andExpr()
+	for err == nil && p.accept("or") {
+		var r expr
+		if r, err = p.andExpr(); err == nil {
+			l = &binExpr{"or", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) andExpr() (expr, error) {
+	l, err := p.notExpr()
+	for err == nil && p.accept("and") {
+		var r expr
+		if r, err = p.notExpr(); err == nil {
+			l = &binExpr{"and", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) notExpr() (expr, error) {
+	if p.accept("not") {
+		x, err := p.notExpr()
+		return &unaryExpr{"not", x}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.addExpr()
+	if err != nil {
+		return l, err
+	}
+	for _, op := range []string{"=", "==", "<>", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			r, err := p.addExpr()
+			if op == "==" {
+				op = "="
+			} else if op == "!=" {
+				op = "<>"
+			}
+			return &binExpr{op, l, r}, err
+		}
+	}
+	if p.accept("is") {
+		not := p.accept("not")
+		return &isNullExpr{l, not}, p.expect("null")
+	}
+	not := p.accept("not")
+	switch {
+	case p.accept("like"):
+		r, err := p.addExpr()
+		return &likeExpr{l, r, not}, err
+	case p.accept("in"):
+		list, err := p.exprList()
+		return &inExpr{l, list, not}, err
+	case p.accept("between"):
+		lo, err := p.addExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("and"); err != nil {
+			return nil, err
+		}
+		hi, err := p.addExpr()
+		return &betweenExpr{l, lo, hi, not}, err
+	}
+	if not {
+		return nil, p.errorf("expected like, in or between")
+	}
+	return l, nil
+}
+
+func (p *parser) addExpr() (expr, error) {
+	l, err := p.mulExpr()
+	for err == nil {
+		op := p.peek().text
+		if !p.accept("+") && !p.accept("-") && !p.accept("||") {
+			break
+		}
+		var r expr
+		if r, err = p.mulExpr(); err == nil {
+			l = &binExpr{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) mulExpr() (expr, error) {
+	l, err := p.unary()
+	for err == nil {
+		op := p.peek().text
+		if !p.accept("*") && !p.accept("/") && !p.accept("%") {
+			break
+		}
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = &binExpr{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.unary()
+		return &unaryExpr{"-", x}, err
+	}
+	p.accept("+")
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tkNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &litExpr{i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errors.New("memory: invalid number " + t.text)
+		}
+		return &litExpr{f}, nil
+	case tkString:
+		return &litExpr{t.text}, nil
+	case tkParam:
+		p.params++
+		return &paramExpr{p.params - 1}, nil
+	case tkSymbol:
+		if t.text == "(" {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	case tkIdent, tkQuoted:
+		if t.kind == tkIdent {
+			switch strings.ToLower(t.text) {
+			case "null":
+				return &litExpr{nil}, nil
+			case "true":
+				return &litExpr{true}, nil
+			case "false":
+				return &litExpr{false}, nil
+			}
+			if p.peek().is("(") {
+				return p.function(strings.ToLower(t.text))
+			}
+			if isKeyword(t.text) {
+				break
+			}
+		}
+		if p.accept(".") {
+			n, err := p.ident()
+			return &colExpr{t.text, n}, err
+		}
+		return &colExpr{"", t.text}, nil
+	case tkEOF:
+		return nil, p.errorf("expected an expression")
+	}
+	p.pos--
+	return nil, p.errorf("unexpected " + t.text)
+}
+
+func (p *parser) function(name string) (expr, error) {
+	p.next() // (
+	f := &funcExpr{name: name}
+	if p.accept("*", ")") {
+		f.star = true
+		return f, nil
+	}
+	if p.accept(")") {
+		return f, nil
+	}
+	for {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		f.args = append(f.args, x)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return f, p.expect(")")
+}
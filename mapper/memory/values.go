@@ -0,0 +1,360 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Values are stored as int64, float64, string, bool, time.Time, []byte or nil
+
+const timeFormat = "2006-01-02 15:04:05"
+
+var timeLayouts = []string{timeFormat, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// affinity returns the kind of value stored by a column type
+func affinity(typ string) string {
+	t := strings.ToLower(typ)
+	switch {
+	case strings.Contains(t, "bool"):
+		return "bool"
+	case strings.Contains(t, "int"):
+		return "integer"
+	case strings.Contains(t, "char"), strings.Contains(t, "text"), strings.Contains(t, "clob"):
+		return "text"
+	case strings.Contains(t, "real"), strings.Contains(t, "floa"), strings.Contains(t, "doub"),
+		strings.Contains(t, "numeric"), strings.Contains(t, "decimal"):
+		return "real"
+	case strings.Contains(t, "time"), strings.Contains(t, "date"):
+		return "time"
+	case strings.Contains(t, "blob"), strings.Contains(t, "binary"):
+		return "blob"
+	}
+	return ""
+}
+
+// normalize converts a Go value to one of the stored types
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint8:
+		return int64(x)
+	case uint16:
+		return int64(x)
+	case uint32:
+		return int64(x)
+	case uint:
+		return int64(x)
+	case uint64:
+		return int64(x)
+	case float32:
+		return float64(x)
+	}
+	return v
+}
+
+func parseTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, l := range timeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// convert converts a value to the affinity of a column type
+func convert(v interface{}, typ string) (interface{}, error) {
+	v = normalize(v)
+	if v == nil {
+		return nil, nil
+	}
+	switch affinity(typ) {
+	case "integer":
+		switch x := v.(type) {
+		case int64:
+			return x, nil
+		case float64:
+			if x == math.Trunc(x) {
+				return int64(x), nil
+			}
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+				return i, nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil && f == math.Trunc(f) {
+				return int64(f), nil
+			}
+		}
+	case "real":
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int64:
+			return float64(x), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+				return f, nil
+			}
+		}
+	case "text":
+		switch x := v.(type) {
+		case time.Time:
+			return x.UTC().Format(timeFormat), nil
+		case []byte:
+			return string(x), nil
+		}
+		return toString(v), nil
+	case "bool":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case int64:
+			return x != 0, nil
+		case float64:
+			return x != 0, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(x)); err == nil {
+				return b, nil
+			}
+		}
+	case "time":
+		switch x := v.(type) {
+		case time.Time:
+			return x.UTC(), nil
+		case string:
+			if t, ok := parseTime(x); ok {
+				return t, nil
+			}
+		}
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("memory: cannot convert %v to %s", v, typ)
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case time.Time:
+		return x.UTC().Format(timeFormat)
+	case []byte:
+		return string(x)
+	}
+	return fmt.Sprint(v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compare compares two non null values. ok is false when a value is null.
+func compare(a, b interface{}) (c int, ok bool) {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return 0, false
+	}
+	_, aNum := a.(int64)
+	_, bNum := b.(int64)
+	_, aF := a.(float64)
+	_, bF := b.(float64)
+	aNum, bNum = aNum || aF, bNum || bF
+	if ab, isBool := a.(bool); isBool {
+		a, aNum = boolInt(ab), true
+	}
+	if bb, isBool := b.(bool); isBool {
+		b, bNum = boolInt(bb), true
+	}
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			return cmp(ai < bi, ai > bi), true
+		}
+	}
+	if aNum || bNum {
+		af, ok1 := toFloat(a)
+		bf, ok2 := toFloat(b)
+		if ok1 && ok2 {
+			return cmp(af < bf, af > bf), true
+		}
+	}
+	at, aT := a.(time.Time)
+	bt, bT := b.(time.Time)
+	if aT || bT {
+		if !aT {
+			at, aT = parseTime(toString(a))
+		}
+		if !bT {
+			bt, bT = parseTime(toString(b))
+		}
+		if aT && bT {
+			return cmp(at.Before(bt), at.After(bt)), true
+		}
+	}
+	as, bs := toString(a), toString(b)
+	return cmp(as < bs, as > bs), true
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func cmp(less bool, more bool) int {
+	if less {
+		return -1
+	}
+	if more {
+		return 1
+	}
+	return 0
+}
+
+// truth tells if a value is true in a where clause
+func truth(v interface{}) bool {
+	switch x := normalize(v).(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case int64:
+		return x != 0
+	case float64:
+		return x != 0
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f != 0
+	}
+	return true
+}
+
+// like matches a value with a like pattern, case insensitive for ASCII letters
+func like(s string, pattern string) bool {
+	return likeRunes([]rune(s), []rune(pattern))
+}
+
+func likeRunes(s []rune, p []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '%':
+			for len(p) > 0 && p[0] == '%' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if likeRunes(s[i:], p) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || unicode.ToLower(s[0]) != unicode.ToLower(p[0]) {
+				return false
+			}
+		}
+		s, p = s[1:], p[1:]
+	}
+	return len(s) == 0
+}
+
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	if op == "||" {
+		return toString(a) + toString(b), nil
+	}
+	ai, aInt := a.(int64)
+	bi, bInt := b.(int64)
+	if aInt && bInt {
+		switch op {
+		case "+":
+			return ai + bi, nil
+		case "-":
+			return ai - bi, nil
+		case "*":
+			return ai * bi, nil
+		case "/", "%":
+			if bi == 0 {
+				return nil, nil
+			}
+			if op == "/" {
+				return ai / bi, nil
+			}
+			return ai % bi, nil
+		}
+	}
+	af, ok1 := toFloat(a)
+	bf, ok2 := toFloat(b)
+	if !ok1 || !ok2 {
+		return nil, errors.New("memory: " + op + " needs numbers")
+	}
+	switch op {
+	case "+":
+		return af + bf, nil
+	case "-":
+		return af - bf, nil
+	case "*":
+		return af * bf, nil
+	case "/":
+		if bf == 0 {
+			return nil, nil
+		}
+		return af / bf, nil
+	case "%":
+		if bf == 0 {
+			return nil, nil
+		}
+		return math.Mod(af, bf), nil
+	}
+	return nil, errors.New("memory: unknown operator " + op)
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mysql
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+)
+
+type Contact struct {
+	Id      int64  `autoincrement:"y"`
+	Name    string `size:"30" indexed:"y"`
+	Born    time.Time
+	Active  bool
+	Rate    float64
+	Small   uint8
+	Counter uint64
+}
+
+func contactDef() sedi.TableDef {
+	return sedi.TableDefFromStruct(&Contact{}, Dialect{}.Quote)
+}
+
+func TestCreateTableSQL(t *testing.T) {
+	want := "create table `contact` (\n" +
+		"   `id` bigint auto_increment primary key,\n" +
+		"   `name` varchar(30) character set utf8mb4 collate utf8mb4_unicode_ci,\n" +
+		"   `born` datetime,\n" +
+		"   `active` tinyint(1),\n" +
+		"   `rate` double,\n" +
+		"   `small` tinyint unsigned,\n" +
+		"   `counter` bigint unsigned) engine=InnoDB default charset=utf8mb4 collate=utf8mb4_unicode_ci"
+	if got := (Dialect{}).CreateTableSQL(contactDef()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	d := Dialect{Engine: "Aria", Charset: "latin1"}
+	if got := d.tableOptions(); got != " engine=Aria default charset=latin1" {
+		t.Errorf("options %s", got)
+	}
+	if got := d.columnSQL(contactDef().Fields[1]); got != "`name` varchar(30) character set latin1" {
+		t.Errorf("column %s", got)
+	}
+	if got := (Dialect{Collation: "utf8mb4_bin"}).columnSQL(contactDef().Fields[1]); got != "`name` varchar(30) character set utf8mb4 collate utf8mb4_bin" {
+		t.Errorf("column %s", got)
+	}
+}
+
+func TestAlterSQL(t *testing.T) {
+	td := contactDef()
+	d := Dialect{}
+	tests := []struct{ got, want string }{
+		{d.AddColumnSQL(td, td.Fields[2], "name"), "alter table `contact` add `born` datetime after `name`"},
+		{d.AddColumnSQL(td, td.Fields[2], ""), "alter table `contact` add `born` datetime first"},
+		{d.ModifyColumnSQL(td, td.Fields[1]), "alter table `contact` modify column `name` varchar(30) character set utf8mb4 collate utf8mb4_unicode_ci"},
+		{d.CreateIndexSQL(td, td.Fields[1]), "create index `name` on `contact` (`name`)"},
+		{d.DropIndexSQL(td, "name"), "drop index `name` on `contact`"},
+		{d.UpsertSQL(td), "insert into `contact` (`id`, `name`, `born`, `active`, `rate`, `small`, `counter`) " +
+			"values (@Id, @Name, @Born, @Active, @Rate, @Small, @Counter) on duplicate key update " +
+			"`name` = values(`name`), `born` = values(`born`), `active` = values(`active`), `rate` = values(`rate`), " +
+			"`small` = values(`small`), `counter` = values(`counter`)"},
+	}
+	for _, x := range tests {
+		if x.got != x.want {
+			t.Errorf("got\n%s\nwant\n%s", x.got, x.want)
+		}
+	}
+}
+
+func TestRenameColumnSQL(t *testing.T) {
+	type contact struct {
+		Id       int64     `autoincrement:"y"`
+		FullName string    `size:"30" renamedFrom:"Name"`
+		Born     time.Time `renamedFrom:"birth"`
+	}
+	td := sedi.TableDefFromStruct(&contact{}, Dialect{}.Quote)
+	d := Dialect{}
+	tests := []struct{ got, want string }{
+		{d.RenameColumnSQL(td, td.Fields[1].RenamedFrom, td.Fields[1].SQLName),
+			"alter table `contact` change column `name` `full_name` varchar(30) character set utf8mb4 collate utf8mb4_unicode_ci"},
+		{d.RenameColumnSQL(td, td.Fields[2].RenamedFrom, td.Fields[2].SQLName), "alter table `contact` change column `birth` `born` datetime"},
+		{d.RenameColumnSQL(td, "nom", "nope"), ""},
+	}
+	for _, x := range tests {
+		if x.got != x.want {
+			t.Errorf("got\n%s\nwant\n%s", x.got, x.want)
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	for in, want := range map[string]string{
+		"int(11)":                      "int",
+		"bigint(20) unsigned":          "bigint unsigned",
+		"TINYINT(1)":                   "tinyint(1)",
+		"tinyint(4)":                   "tinyint",
+		"smallint(5) unsigned":         "smallint unsigned",
+		"varchar(30)":                  "varchar(30)",
+		" double ":                     "double",
+		"mediumint(8) unsigned":        "mediumint unsigned",
+		"decimal(10,2)":                "decimal(10,2)",
+		"bigint(20) unsigned zerofill": "bigint unsigned zerofill",
+	} {
+		if got := normalType(in); got != want {
+			t.Errorf("normalType(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	td := contactDef()
+	name := td.Fields[1]
+	tests := []struct {
+		d      Dialect
+		dbType string
+		fd     sedi.FieldDef
+		same   bool
+	}{
+		{Dialect{}, "varchar(30) character set utf8mb4", name, true},
+		{Dialect{}, "VARCHAR(30) character set UTF8MB4", name, true},
+		{Dialect{}, "varchar(30) character set latin1", name, false},
+		{Dialect{}, "varchar(40) character set utf8mb4", name, false},
+		{Dialect{Charset: "latin1", Collation: "latin1_swedish_ci"}, "varchar(30) character set latin1", name, true},
+		{Dialect{Collation: "utf8mb4_bin"}, "varchar(30) character set utf8mb4", name, true},
+		{Dialect{}, "bigint(20)", td.Fields[0], true},
+		{Dialect{}, "tinyint(1)", td.Fields[3], true},
+		{Dialect{}, "tinyint(4)", td.Fields[3], false},
+		{Dialect{}, "tinyint(3) unsigned", td.Fields[5], true},
+		{Dialect{}, "bigint(20) unsigned", td.Fields[6], true},
+		{Dialect{}, "datetime", td.Fields[2], true},
+		{Dialect{}, "timestamp", td.Fields[2], false},
+	}
+	for _, x := range tests {
+		if got := x.d.SameType(x.dbType, x.fd); got != x.same {
+			t.Errorf("%+v SameType(%q, %s) = %v", x.d, x.dbType, x.fd.Name, got)
+		}
+	}
+}
+
+func TestDataSourceName(t *testing.T) {
+	tests := []struct {
+		d         Dialect
+		url, want string
+	}{
+		{Dialect{}, "mysql://user:pw@db.local:3307/app", "user:pw@tcp(db.local:3307)/app?charset=utf8mb4&clientFoundRows=true"},
+		{Dialect{}, "mariadb://root@localhost/app?parseTime=false", "root@tcp(localhost)/app?charset=utf8mb4&clientFoundRows=true&parseTime=false"},
+		{Dialect{}, "mysql:///app?charset=latin1", "/app?charset=latin1&clientFoundRows=true"},
+		{Dialect{Charset: "latin1"}, "mysql://u@h/app", "u@tcp(h)/app?charset=latin1&clientFoundRows=true"},
+		{Dialect{}, "mysql://u:p%40ss@h/app", "u:p@ss@tcp(h)/app?charset=utf8mb4&clientFoundRows=true"},
+		{Dialect{}, "mysql://u@h/app?clientFoundRows=false", "u@tcp(h)/app?charset=utf8mb4&clientFoundRows=false"},
+	}
+	for _, x := range tests {
+		if got, err := x.d.DataSourceName(x.url); err != nil || got != x.want {
+			t.Errorf("%s: got %s, %v, want %s", x.url, got, err, x.want)
+		}
+	}
+	if _, err := (Dialect{}).DataSourceName("mysql://%zz"); err == nil {
+		t.Error("invalid url accepted")
+	}
+}
+
+func TestBindValue(t *testing.T) {
+	d := Dialect{}
+	fd := sedi.FieldDef{}
+	if d.BindValue(fd, true) != int64(1) || d.BindValue(fd, false) != int64(0) || d.BindValue(fd, time.Time{}) != nil {
+		t.Error("bool or zero time")
+	}
+	if v := d.BindValue(fd, time.Date(2017, 1, 2, 3, 0, 0, 0, time.FixedZone("CET", 3600))); v != time.Date(2017, 1, 2, 2, 0, 0, 0, time.UTC) {
+		t.Errorf("time bound as %#v", v)
+	}
+}
+
+// TestServer runs the mapper against the MySQL or MariaDB server of SEDI_MYSQL_URL,
+// mariadb://root@localhost/sedi_test by default, and is skipped when it does not answer
+func TestServer(t *testing.T) {
+	url := os.Getenv("SEDI_MYSQL_URL")
+	if url == "" {
+		url = "mariadb://root@localhost/sedi_test"
+	}
+	sedi.LogErrors = false
+	m, err := sedi.Open(url)
+	if err != nil {
+		t.Skip("no MySQL or MariaDB server: " + err.Error())
+	}
+	defer m.CloseConnection()
+	cn := m.Connection()
+	cn.ExecNoResult("drop table if exists contact", nil)
+	defer cn.ExecNoResult("drop table if exists contact", nil)
+
+	// A latin1 table is converted to utf8mb4 by UpdateModel
+	if err = cn.ExecNoResult("create table contact (id bigint auto_increment primary key, name varchar(30)) default charset=latin1", nil); err != nil {
+		t.Fatal(err)
+	}
+	m.AddPersistence(&Contact{})
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("not up to date: %v", err)
+	}
+	c := Contact{Name: "Zoë 🙂", Born: time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC), Active: true, Rate: 2.5,
+		Small: 200, Counter: 1<<64 - 1}
+	if err = m.Insert(&c); err != nil || c.Id == 0 {
+		t.Fatalf("insert: id %d, %v", c.Id, err)
+	}
+	r := Contact{Id: c.Id}
+	if err = m.Read(&r); err != nil || r.Name != c.Name || !r.Born.Equal(c.Born) || r.Counter != c.Counter ||
+		r.Small != c.Small || !r.Active {
+		t.Fatalf("read %+v, %v", r, err)
+	}
+	c.Rate = 4
+	if err = m.Upsert(&c); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Delete(&c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitSQL(t *testing.T) {
+	got := Dialect{}.InitSQL(sedi.Config{Session: map[string]string{"time_zone": "'+00:00'", "sql_mode": "'STRICT_ALL_TABLES'"}})
+	if len(got) != 2 || got[0] != "set session sql_mode = 'STRICT_ALL_TABLES'" || got[1] != "set session time_zone = '+00:00'" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package postgres provides the PostgreSQL dialect of the sedi mapper.
+// Tables are looked up in the current schema (see search_path).
+package postgres
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // Make sure we load the driver !
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
+)
+
+// SQLMapper provides persistence framework for structures
+// in a PostgreSQL database
+type SQLMapper = sedi.SQLMapper
+
+func init() {
+	sedi.Register(Dialect{}, "postgresql")
+}
+
+// GetSQLMapper create a new SQLmapper using identity columns for auto-increment keys
+func GetSQLMapper() *SQLMapper {
+	return sedi.NewSQLMapper(Dialect{})
+}
+
+// Dialect implements sedi.Dialect for PostgreSQL.
+// Auto-increment keys are identity columns (PostgreSQL 10 and later),
+// or serial columns when UseSerial is set.
+//
+// PostgreSQL has no unsigned integers: uint64 and uint fields are numeric(20)
+// columns, bound as text, except auto-increment keys which are bigint.
+//
+// Upsert writes the key of auto-increment tables explicitly, which does not
+// advance their sequence.
+type Dialect struct {
+	UseSerial bool
+}
+
+func (d Dialect) DriverName() string {
+	return "postgres"
+}
+
+func (d Dialect) Quote(s string) string {
+	return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+func (d Dialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d Dialect) SQLType(fd sedi.FieldDef) string {
+	var ts string
+	switch fd.GoTypeName {
+	case "string":
+		ts = "varchar(" + strconv.FormatInt(int64(fd.Size), 10) + ")"
+	case "Time":
+		ts = "timestamp"
+	case "bool":
+		ts = "boolean"
+	case "int8", "uint8", "byte", "char", "int16", "short":
+		ts = "smallint"
+	case "uint16", "int32":
+		ts = "integer"
+	case "uint32", "int64", "int":
+		ts = "bigint"
+	case "uint64", "uint":
+		ts = "numeric(20)"
+		if fd.AutoIncrement {
+			ts = "bigint"
+		}
+	case "float32":
+		ts = "real"
+	case "float64":
+		ts = "double precision"
+	}
+	return ts
+}
+
+// serialType returns the serial pseudo-type matching an integer type
+func serialType(ts string) string {
+	switch ts {
+	case "smallint":
+		return "smallserial"
+	case "integer":
+		return "serial"
+	}
+	return "bigserial"
+}
+
+// SameType compares a type as returned by Columns with the type of a field
+func (d Dialect) SameType(dbType string, fd sedi.FieldDef) bool {
+	return strings.ToLower(dbType) == d.SQLType(fd)
+}
+
+// BindValue stores times in UTC, a zero time is stored as null.
+// Unsigned 64 bits integers are bound as text, database/sql rejects those above the int64 range.
+func (d Dialect) BindValue(fd sedi.FieldDef, v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		if x.IsZero() {
+			return nil
+		}
+		return x.UTC()
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case uint:
+		return strconv.FormatUint(uint64(x), 10)
+	}
+	return v
+}
+
+func (d Dialect) DateLiteral(t time.Time) string {
+	return "timestamp '" + t.UTC().Format("2006-01-02 15:04:05") + "'"
+}
+
+func (d Dialect) OnOpen(cn *sedi.Conn) error {
+	return nil
+}
+
+// InitSQL sets the session parameters of cfg on each connection
+func (d Dialect) InitSQL(cfg sedi.Config) []string {
+	names := []string{}
+	for k := range cfg.Session {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := []string{}
+	for _, k := range names {
+		ret = append(ret, "set "+k+" = "+cfg.Session[k])
+	}
+	return ret
+}
+
+// DataSourceName keeps the url given to sedi.Open, lib/pq accepts postgres:// urls
+func (d Dialect) DataSourceName(url string) (string, error) {
+	return url, nil
+}
+
+func (d Dialect) Tables(cn *sedi.Conn) ([]string, error) {
+	ret := []string{}
+	dt, err := cn.GetDataTable("select table_name from information_schema.tables "+
+		"where table_schema = current_schema() and table_type = 'BASE TABLE'", nil)
+	if err == nil {
+		for _, r := range dt.Rows {
+			ret = append(ret, conv.ToString(r.ItemSingle("table_name")))
+		}
+	}
+	return ret, err
+}
+
+// Columns reads information_schema.columns. Types are returned with
+// the names used by SQLType (varchar(n), timestamp, ...).
+func (d Dialect) Columns(cn *sedi.Conn, table string) ([]sedi.DBColumn, error) {
+	ret := []sedi.DBColumn{}
+	dt, err := cn.GetDataTableArgs("select c.column_name, c.data_type, c.character_maximum_length, "+
+		"c.numeric_precision, c.numeric_scale, c.is_nullable, "+
+		"c.column_default, c.is_identity, "+
+		"(select count(*) from information_schema.table_constraints tc "+
+		"join information_schema.key_column_usage k on k.constraint_name = tc.constraint_name "+
+		"and k.table_schema = tc.table_schema and k.table_name = tc.table_name "+
+		"where tc.constraint_type = 'PRIMARY KEY' and tc.table_schema = c.table_schema "+
+		"and tc.table_name = c.table_name and k.column_name = c.column_name) as pk "+
+		"from information_schema.columns c where c.table_schema = current_schema() and c.table_name = $1 "+
+		"order by c.ordinal_position", table)
+	if err != nil {
+		return ret, err
+	}
+	for _, r := range dt.Rows {
+		ct := columnType(conv.ToString(r.ItemSingle("data_type")), r.ItemSingle("character_maximum_length"),
+			r.ItemSingle("numeric_precision"), r.ItemSingle("numeric_scale"))
+		ret = append(ret, sedi.DBColumn{
+			Name:       conv.ToString(r.ItemSingle("column_name")),
+			Type:       ct,
+			Nullable:   conv.ToString(r.ItemSingle("is_nullable")) == "YES",
+			PrimaryKey: conv.ToInt64(r.ItemSingle("pk")) > 0,
+			AutoIncrement: conv.ToString(r.ItemSingle("is_identity")) == "YES" ||
+				strings.HasPrefix(conv.ToString(r.ItemSingle("column_default")), "nextval(")})
+	}
+	return ret, nil
+}
+
+// columnType converts an information_schema data type to the name used by SQLType
+func columnType(dataType string, size interface{}, precision interface{}, scale interface{}) string {
+	switch dataType {
+	case "numeric":
+		if precision == nil {
+			return "numeric"
+		}
+		if conv.ToInt64(scale) == 0 {
+			return "numeric(" + conv.ToString(precision) + ")"
+		}
+		return "numeric(" + conv.ToString(precision) + "," + conv.ToString(scale) + ")"
+	case "character varying":
+		if size != nil {
+			return "varchar(" + conv.ToString(size) + ")"
+		}
+		return "varchar"
+	case "character":
+		if size != nil {
+			return "char(" + conv.ToString(size) + ")"
+		}
+		return "char"
+	case "timestamp without time zone":
+		return "timestamp"
+	case "timestamp with time zone":
+		return "timestamptz"
+	}
+	return dataType
+}
+
+// Indexes reads the indexes listed by pg_indexes, with their columns in order
+func (d Dialect) Indexes(cn *sedi.Conn, table string) ([]sedi.DBIndex, error) {
+	ret := []sedi.DBIndex{}
+	dt, err := cn.GetDataTableArgs("select p.indexname, x.indisunique, x.indisprimary, a.attname "+
+		"from pg_indexes p "+
+		"join pg_namespace n on n.nspname = p.schemaname "+
+		"join pg_class i on i.relname = p.indexname and i.relnamespace = n.oid "+
+		"join pg_index x on x.indexrelid = i.oid "+
+		"join pg_attribute a on a.attrelid = x.indrelid and a.attnum = any(x.indkey) "+
+		"where p.schemaname = current_schema() and p.tablename = $1 "+
+		"order by p.indexname, array_position(x.indkey::int2[], a.attnum)", table)
+	if err != nil {
+		return ret, err
+	}
+	for _, r := range dt.Rows {
+		name := conv.ToString(r.ItemSingle("indexname"))
+		if len(ret) == 0 || ret[len(ret)-1].Name != name {
+			ret = append(ret, sedi.DBIndex{
+				Name:    name,
+				Unique:  conv.ToBool(r.ItemSingle("indisunique")),
+				Primary: conv.ToBool(r.ItemSingle("indisprimary"))})
+		}
+		ix := &(ret[len(ret)-1])
+		ix.Columns = append(ix.Columns, conv.ToString(r.ItemSingle("attname")))
+	}
+	return ret, nil
+}
+
+// columnSQL returns the definition of a column
+func (d Dialect) columnSQL(fd sedi.FieldDef) string {
+	sql := d.Quote(fd.SQLName) + " "
+	switch {
+	case fd.AutoIncrement && d.UseSerial:
+		sql += serialType(d.SQLType(fd))
+	case fd.AutoIncrement:
+		sql += d.SQLType(fd) + " generated by default as identity"
+	default:
+		sql += d.SQLType(fd)
+	}
+	if fd.PrimaryKey {
+		sql += " primary key"
+	}
+	return sql
+}
+
+func (d Dialect) CreateTableSQL(td sedi.TableDef) string {
+	sql := "create table " + d.Quote(td.SQLName) + " ("
+	for i, fld := range td.Fields {
+		if i != 0 {
+			sql += ","
+		}
+		sql += "\n   " + d.columnSQL(fld)
+	}
+	sql += ")"
+	return sql
+}
+
+// AddColumnSQL ignores after, PostgreSQL always adds columns at the end of the table
+func (d Dialect) AddColumnSQL(td sedi.TableDef, fd sedi.FieldDef, after string) string {
+	return "alter table " + d.Quote(td.SQLName) + " add column " + d.columnSQL(fd)
+}
+
+func (d Dialect) ModifyColumnSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	c := d.Quote(fd.SQLName)
+	return "alter table " + d.Quote(td.SQLName) + " alter column " + c + " type " + d.SQLType(fd) +
+		" using " + c + "::" + d.SQLType(fd)
+}
+
+func (d Dialect) RenameColumnSQL(td sedi.TableDef, from string, to string) string {
+	return "alter table " + d.Quote(td.SQLName) + " rename column " + d.Quote(from) + " to " + d.Quote(to)
+}
+
+func (d Dialect) DropColumnSQL(td sedi.TableDef, column string) string {
+	return "alter table " + d.Quote(td.SQLName) + " drop column " + d.Quote(column)
+}
+
+// IndexName follows the PostgreSQL naming convention, index names are unique in a schema
+func (d Dialect) IndexName(td sedi.TableDef, column string) string {
+	return td.SQLName + "_" + column + "_idx"
+}
+
+func (d Dialect) CreateIndexSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	ui := ""
+	if fd.Unique {
+		ui = "unique "
+	}
+	return "create " + ui + "index " + d.Quote(d.IndexName(td, fd.SQLName)) + " on " + d.Quote(td.SQLName) + " (" + d.Quote(fd.SQLName) + ")"
+}
+
+func (d Dialect) DropIndexSQL(td sedi.TableDef, index string) string {
+	return "drop index if exists " + d.Quote(index)
+}
+
+func (d Dialect) DropTableSQL(table string) string {
+	return "drop table " + d.Quote(table)
+}
+
+func (d Dialect) UpsertSQL(td sedi.TableDef) string {
+	cols := []string{}
+	parms := []string{}
+	set := []string{}
+	for _, fld := range td.Fields {
+		cols = append(cols, d.Quote(fld.SQLName))
+		parms = append(parms, "@"+fld.Name)
+		if !fld.PrimaryKey && fld.CanUpdate {
+			set = append(set, d.Quote(fld.SQLName)+" = excluded."+d.Quote(fld.SQLName))
+		}
+	}
+	sql := "insert into " + d.Quote(td.SQLName) + " (" + strings.Join(cols, ", ") + ") values (" + strings.Join(parms, ", ") +
+		") on conflict (" + d.Quote(td.Fields[td.PkIx].SQLName) + ") do "
+	if len(set) == 0 {
+		return sql + "nothing"
+	}
+	return sql + "update set " + strings.Join(set, ", ")
+}
+
+// ReturningSQL returns the generated key with the insert statement,
+// lib/pq does not support LastInsertId
+func (d Dialect) ReturningSQL(td sedi.TableDef) string {
+	return "returning " + d.Quote(td.Fields[td.PkIx].SQLName)
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+)
+
+type Contact struct {
+	Id      int64  `autoincrement:"y"`
+	Name    string `size:"30" indexed:"y" unique:"y"`
+	Born    time.Time
+	Active  bool
+	Rate    float64
+	Small   int8
+	Counter uint64
+}
+
+type Tag struct {
+	Code string `primaryKey:"y" size:"8"`
+}
+
+func contactDef() sedi.TableDef {
+	return sedi.TableDefFromStruct(&Contact{}, Dialect{}.Quote)
+}
+
+func TestCreateTableSQL(t *testing.T) {
+	want := "create table \"contact\" (\n" +
+		"   \"id\" bigint generated by default as identity primary key,\n" +
+		"   \"name\" varchar(30),\n" +
+		"   \"born\" timestamp,\n" +
+		"   \"active\" boolean,\n" +
+		"   \"rate\" double precision,\n" +
+		"   \"small\" smallint,\n" +
+		"   \"counter\" numeric(20))"
+	if got := (Dialect{}).CreateTableSQL(contactDef()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := (Dialect{UseSerial: true}).columnSQL(contactDef().Fields[0]); got != "\"id\" bigserial primary key" {
+		t.Errorf("serial: %s", got)
+	}
+}
+
+func TestUpsertSQL(t *testing.T) {
+	want := "insert into \"contact\" (\"id\", \"name\", \"born\", \"active\", \"rate\", \"small\", \"counter\") " +
+		"values (@Id, @Name, @Born, @Active, @Rate, @Small, @Counter) on conflict (\"id\") do update set " +
+		"\"name\" = excluded.\"name\", \"born\" = excluded.\"born\", \"active\" = excluded.\"active\", " +
+		"\"rate\" = excluded.\"rate\", \"small\" = excluded.\"small\", \"counter\" = excluded.\"counter\""
+	if got := (Dialect{}).UpsertSQL(contactDef()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	td := sedi.TableDefFromStruct(&Tag{}, Dialect{}.Quote)
+	want = "insert into \"tag\" (\"code\") values (@Code) on conflict (\"code\") do nothing"
+	if got := (Dialect{}).UpsertSQL(td); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestModifyColumnSQL(t *testing.T) {
+	td := contactDef()
+	want := "alter table \"contact\" alter column \"rate\" type double precision using \"rate\"::double precision"
+	if got := (Dialect{}).ModifyColumnSQL(td, td.Fields[4]); got != want {
+		t.Errorf("got %s", got)
+	}
+	want = "alter table \"contact\" add column \"name\" varchar(30)"
+	if got := (Dialect{}).AddColumnSQL(td, td.Fields[1], "id"); got != want {
+		t.Errorf("got %s", got)
+	}
+	want = "create unique index \"contact_name_idx\" on \"contact\" (\"name\")"
+	if got := (Dialect{}).CreateIndexSQL(td, td.Fields[1]); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestRenameColumnSQL(t *testing.T) {
+	type contact struct {
+		Id       int64  `autoincrement:"y"`
+		FullName string `size:"30" renamedFrom:"Name"`
+	}
+	td := sedi.TableDefFromStruct(&contact{}, Dialect{}.Quote)
+	want := "alter table \"contact\" rename column \"name\" to \"full_name\""
+	if got := (Dialect{}).RenameColumnSQL(td, td.Fields[1].RenamedFrom, td.Fields[1].SQLName); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestReturningSQL(t *testing.T) {
+	if got := (Dialect{}).ReturningSQL(contactDef()); got != "returning \"id\"" {
+		t.Errorf("got %s", got)
+	}
+	if (Dialect{}).Placeholder(12) != "$12" || (Dialect{}).Quote("a\"b") != "\"a\"\"b\"" {
+		t.Error("placeholder or quote")
+	}
+}
+
+func TestTypes(t *testing.T) {
+	td := contactDef()
+	types := []string{"bigint", "varchar(30)", "timestamp", "boolean", "double precision", "smallint", "numeric(20)"}
+	for i, fd := range td.Fields {
+		if !(Dialect{}).SameType(types[i], fd) {
+			t.Errorf("%s is not %s", fd.Name, types[i])
+		}
+	}
+	if (Dialect{}).SameType("varchar(20)", td.Fields[1]) {
+		t.Error("varchar(20) is the type of a size 30 string")
+	}
+	for _, x := range []struct {
+		dataType          string
+		size, prec, scale interface{}
+		want              string
+	}{
+		{"character varying", int64(30), nil, nil, "varchar(30)"},
+		{"character varying", nil, nil, nil, "varchar"},
+		{"character", int64(2), nil, nil, "char(2)"},
+		{"timestamp without time zone", nil, nil, nil, "timestamp"},
+		{"numeric", nil, int64(20), int64(0), "numeric(20)"},
+		{"numeric", nil, int64(10), int64(2), "numeric(10,2)"},
+		{"numeric", nil, nil, nil, "numeric"},
+		{"integer", nil, int64(32), int64(0), "integer"},
+	} {
+		if got := columnType(x.dataType, x.size, x.prec, x.scale); got != x.want {
+			t.Errorf("%s: got %s, want %s", x.dataType, got, x.want)
+		}
+	}
+}
+
+func TestBindValue(t *testing.T) {
+	d := Dialect{}
+	fd := sedi.FieldDef{}
+	if v := d.BindValue(fd, uint64(math.MaxUint64)); v != "18446744073709551615" {
+		t.Errorf("uint64 bound as %#v", v)
+	}
+	if v := d.BindValue(fd, time.Time{}); v != nil {
+		t.Errorf("zero time bound as %#v", v)
+	}
+	paris := time.FixedZone("CET", 3600)
+	if v := d.BindValue(fd, time.Date(2017, 1, 2, 3, 0, 0, 0, paris)); v != time.Date(2017, 1, 2, 2, 0, 0, 0, time.UTC) {
+		t.Errorf("time bound as %#v", v)
+	}
+}
+
+// TestServer runs the mapper against the server of SEDI_POSTGRES_URL,
+// postgres://postgres@localhost/sedi_test?sslmode=disable by default, and is skipped when it does not answer
+func TestServer(t *testing.T) {
+	url := os.Getenv("SEDI_POSTGRES_URL")
+	if url == "" {
+		url = "postgres://postgres@localhost/sedi_test?sslmode=disable"
+	}
+	sedi.LogErrors = false
+	m, err := sedi.Open(url)
+	if err != nil {
+		t.Skip("no PostgreSQL server: " + err.Error())
+	}
+	defer m.CloseConnection()
+	cn := m.Connection()
+	cn.ExecNoResult("drop table if exists contact", nil)
+	defer cn.ExecNoResult("drop table if exists contact", nil)
+
+	m.AddPersistence(&Contact{})
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("not up to date: %v", err)
+	}
+	c := Contact{Name: "O'Hara", Born: time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC), Active: true, Rate: 2.5,
+		Small: -3, Counter: math.MaxUint64}
+	if err = m.Insert(&c); err != nil || c.Id == 0 {
+		t.Fatalf("insert: id %d, %v", c.Id, err)
+	}
+	r := Contact{Id: c.Id}
+	if err = m.Read(&r); err != nil || r.Name != c.Name || !r.Born.Equal(c.Born) || r.Counter != c.Counter ||
+		r.Small != c.Small || !r.Active {
+		t.Fatalf("read %+v, %v", r, err)
+	}
+	c.Rate = 4
+	if err = m.Upsert(&c); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Delete(&c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitSQL(t *testing.T) {
+	got := Dialect{}.InitSQL(sedi.Config{Session: map[string]string{"timezone": "'UTC'", "search_path": "app"}})
+	if len(got) != 2 || got[0] != "set search_path = app" || got[1] != "set timezone = 'UTC'" {
+		t.Errorf("got %q", got)
+	}
+}
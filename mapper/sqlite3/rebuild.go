@@ -0,0 +1,399 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
+)
+
+// RowConversionError describes a value that cannot be converted to the new column type
+type RowConversionError struct {
+	RowID  int64
+	Column string
+	Value  interface{}
+	Reason string
+}
+
+// RebuildError is returned when a table rebuild is aborted. The table is left unchanged.
+type RebuildError struct {
+	Table string
+	Rows  []RowConversionError // Values that failed conversion
+	Check []string             // Violations reported by pragma foreign_key_check
+}
+
+func (e *RebuildError) Error() string {
+	s := "rebuild of table " + e.Table + " aborted:"
+	for _, r := range e.Rows {
+		s += fmt.Sprintf("\n   rowid %d, column %s: cannot convert %v (%s)", r.RowID, r.Column, r.Value, r.Reason)
+	}
+	for _, c := range e.Check {
+		s += "\n   " + c
+	}
+	return s
+}
+
+type dbColumn struct {
+	name   string
+	dbType string
+}
+
+type dbObject struct {
+	objType string
+	name    string
+	sql     string
+}
+
+// pinnedConn runs the statements of a rebuild on a single connection of the pool:
+// the foreign_keys pragma only applies to the connection it runs on.
+type pinnedConn struct {
+	ctx context.Context
+	c   *sql.Conn
+}
+
+func (p pinnedConn) exec(SQL string, args ...interface{}) error {
+	if sedi.LogAll {
+		log.Print(SQL)
+	}
+	_, err := p.c.ExecContext(p.ctx, SQL, args...)
+	return err
+}
+
+func (p pinnedConn) query(SQL string, args ...interface{}) (sedi.DataTable, error) {
+	var dt sedi.DataTable
+	if sedi.LogAll {
+		log.Print(SQL)
+	}
+	rows, err := p.c.QueryContext(p.ctx, SQL, args...)
+	if err != nil {
+		return dt, err
+	}
+	defer rows.Close()
+	err = dt.Fill(rows, -1)
+	return dt, err
+}
+
+// RebuildTable recreates a table with the structure described by td.
+// Data is copied column by column using the column names and converted to the new types.
+// Columns unknown to td are kept as they are unless dropOrphans is set.
+// The table foreign keys, the indexes and the triggers are preserved. The whole operation
+// runs in a transaction, on a single connection of the pool with foreign keys off, and is
+// rolled back if a value cannot be converted or if foreign_key_check fails, including for
+// the tables referencing the rebuilt table.
+func (d Dialect) RebuildTable(cn *sedi.Conn, td sedi.TableDef, dropOrphans bool) (err error) {
+	if cn == nil || cn.DB == nil {
+		return sedi.ErrNoConnection
+	}
+	tmp := "tmp_rebuild_" + td.SQLName
+	p := pinnedConn{ctx: context.Background()}
+	if p.c, err = cn.DB.Conn(p.ctx); err != nil {
+		return err
+	}
+	defer p.c.Close()
+
+	dt, err := p.query("pragma foreign_keys")
+	if err != nil {
+		return err
+	}
+	if len(dt.Rows) > 0 && conv.ToInt64(dt.Rows[0].Items()[0]) != 0 {
+		// foreign_keys can only be changed outside a transaction
+		if err = p.exec("pragma foreign_keys = off"); err != nil {
+			return err
+		}
+		defer p.exec("pragma foreign_keys = on")
+	}
+
+	oldCols, err := tableColumns(p, td.SQLName)
+	if err != nil {
+		return err
+	}
+	objects, err := tableObjects(p, td.SQLName)
+	if err != nil {
+		return err
+	}
+	fks, err := foreignKeyClauses(p, td.SQLName)
+	if err != nil {
+		return err
+	}
+	extra := []string{}
+	keep := []dbColumn{}
+	for _, c := range oldCols {
+		if td.Fields.BySQLName(c.name) != nil {
+			keep = append(keep, c)
+		} else if !dropOrphans {
+			extra = append(extra, "`"+c.name+"` "+c.dbType)
+			keep = append(keep, c)
+		}
+	}
+	oldCols = keep
+	extra = append(extra, fks...)
+
+	if err = p.exec("begin exclusive transaction"); err != nil {
+		return err
+	}
+	err = p.exec(d.createTableSQL(td, tmp, extra))
+	if err == nil {
+		err = copyRows(p, td, oldCols, td.SQLName, tmp)
+	}
+	if err == nil {
+		err = p.exec("drop table `" + td.SQLName + "`")
+	}
+	if err == nil {
+		err = p.exec("alter table `" + tmp + "` rename to `" + td.SQLName + "`")
+	}
+	for _, o := range objects {
+		if err == nil && !(o.objType == "index" && strings.HasPrefix(o.name, td.SQLName+".")) {
+			// Indexes managed by the mapper are recreated by createIndexes
+			err = p.exec(o.sql)
+		}
+	}
+	if err == nil {
+		err = d.createIndexes(p, td)
+	}
+	if err == nil {
+		err = foreignKeyCheck(p, td.SQLName)
+	}
+	if err == nil {
+		err = p.exec("commit")
+	} else {
+		p.exec("rollback transaction")
+	}
+	return err
+}
+
+// createIndexes creates the mapper indexes of a rebuilt table
+func (d Dialect) createIndexes(p pinnedConn, td sedi.TableDef) (err error) {
+	for _, fld := range td.Fields {
+		if fld.Indexed && err == nil {
+			err = p.exec(d.CreateIndexSQL(td, fld))
+		}
+	}
+	return err
+}
+
+func tableColumns(p pinnedConn, table string) ([]dbColumn, error) {
+	ret := []dbColumn{}
+	dt, err := p.query("pragma table_info ('" + escapeParameter(table) + "')")
+	if err == nil {
+		for _, r := range dt.Rows {
+			ret = append(ret, dbColumn{name: conv.ToString(r.ItemSingle("name")), dbType: conv.ToString(r.ItemSingle("type"))})
+		}
+	}
+	return ret, err
+}
+
+func tableObjects(p pinnedConn, table string) ([]dbObject, error) {
+	ret := []dbObject{}
+	dt, err := p.query("select type, name, sql from sqlite_master where tbl_name = ? and type in ('index', 'trigger') and sql is not null", table)
+	if err == nil {
+		for _, r := range dt.Rows {
+			ret = append(ret, dbObject{
+				objType: conv.ToString(r.ItemSingle("type")),
+				name:    conv.ToString(r.ItemSingle("name")),
+				sql:     conv.ToString(r.ItemSingle("sql"))})
+		}
+	}
+	return ret, err
+}
+
+// foreignKeyClauses returns the foreign key constraints declared by a table
+func foreignKeyClauses(p pinnedConn, table string) ([]string, error) {
+	ret := []string{}
+	dt, err := p.query("pragma foreign_key_list ('" + escapeParameter(table) + "')")
+	if err != nil {
+		return ret, err
+	}
+	type fk struct {
+		from, to           []string
+		table              string
+		onUpdate, onDelete string
+	}
+	fks := []*fk{}
+	byID := make(map[int64]*fk)
+	for _, r := range dt.Rows {
+		id := conv.ToInt64(r.ItemSingle("id"))
+		f, ok := byID[id]
+		if !ok {
+			f = &fk{
+				table:    conv.ToString(r.ItemSingle("table")),
+				onUpdate: conv.ToString(r.ItemSingle("on_update")),
+				onDelete: conv.ToString(r.ItemSingle("on_delete"))}
+			byID[id] = f
+			fks = append(fks, f)
+		}
+		f.from = append(f.from, "`"+conv.ToString(r.ItemSingle("from"))+"`")
+		if to := conv.ToString(r.ItemSingle("to")); to != "" {
+			f.to = append(f.to, "`"+to+"`")
+		}
+	}
+	for _, f := range fks {
+		c := "foreign key (" + strings.Join(f.from, ", ") + ") references `" + f.table + "`"
+		if len(f.to) > 0 {
+			c += " (" + strings.Join(f.to, ", ") + ")"
+		}
+		if f.onUpdate != "" && strings.ToUpper(f.onUpdate) != "NO ACTION" {
+			c += " on update " + f.onUpdate
+		}
+		if f.onDelete != "" && strings.ToUpper(f.onDelete) != "NO ACTION" {
+			c += " on delete " + f.onDelete
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// foreignKeyCheck checks the foreign keys of the whole database: those of the rebuilt
+// table, and those of the tables referencing it
+func foreignKeyCheck(p pinnedConn, table string) error {
+	dt, err := p.query("pragma foreign_key_check")
+	if err != nil || len(dt.Rows) == 0 {
+		return err
+	}
+	re := &RebuildError{Table: table}
+	for _, r := range dt.Rows {
+		re.Check = append(re.Check, fmt.Sprintf("%v rowid %v references a missing row in %v",
+			r.ItemSingle("table"), r.ItemSingle("rowid"), r.ItemSingle("parent")))
+	}
+	return re
+}
+
+// copyRows copies the rows of table src into table dst, matching columns by name.
+// Values of the columns described in td are converted to the type of the field.
+func copyRows(p pinnedConn, td sedi.TableDef, oldCols []dbColumn, src string, dst string) error {
+	cols := []string{}
+	marks := []string{}
+	for _, c := range oldCols {
+		cols = append(cols, "`"+c.name+"`")
+		marks = append(marks, "?")
+	}
+	dt, err := p.query("select rowid as `__sedi_rowid`, " + strings.Join(cols, ", ") + " from `" + src + "`")
+	if err != nil {
+		return err
+	}
+	re := &RebuildError{Table: td.SQLName}
+	rows := [][]interface{}{}
+	for _, r := range dt.Rows {
+		rowid := conv.ToInt64(r.ItemSingle("__sedi_rowid"))
+		values := []interface{}{}
+		for _, c := range oldCols {
+			v := r.ItemSingle(c.name)
+			if fd := td.Fields.BySQLName(c.name); fd != nil {
+				cv, e := convertValue(v, *fd)
+				if e != nil {
+					re.Rows = append(re.Rows, RowConversionError{RowID: rowid, Column: c.name, Value: v, Reason: e.Error()})
+					continue
+				}
+				v = cv
+			}
+			values = append(values, v)
+		}
+		rows = append(rows, values)
+	}
+	if len(re.Rows) > 0 {
+		return re
+	}
+	insert := "insert into `" + dst + "` (" + strings.Join(cols, ", ") + ") values (" + strings.Join(marks, ", ") + ")"
+	for _, values := range rows {
+		if err = p.exec(insert, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertValue converts a value read from the database to the storage type of a field
+func convertValue(v interface{}, fd sedi.FieldDef) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s := strings.TrimSpace(conv.ToString(v))
+	switch fd.GoTypeName {
+	case "bool":
+		switch x := v.(type) {
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if b, e := strconv.ParseBool(s); e == nil {
+				if b {
+					return int64(1), nil
+				}
+				return int64(0), nil
+			}
+		}
+		return toInteger(v, s)
+	case "byte", "int", "short", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "uint", "char":
+		return toInteger(v, s)
+	case "float32", "float64":
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int64:
+			return float64(x), nil
+		}
+		f, e := strconv.ParseFloat(s, 64)
+		if e != nil {
+			return nil, fmt.Errorf("not a number")
+		}
+		return f, nil
+	case "Time":
+		if t, ok := v.(time.Time); ok {
+			return t.UTC().Format("2006-01-02 15:04:05"), nil
+		}
+		if _, ok := v.(string); !ok {
+			return nil, fmt.Errorf("not a date")
+		}
+		if s == "" {
+			return nil, nil
+		}
+		t := conv.ToTime(s)
+		if t.IsZero() {
+			return nil, fmt.Errorf("not a date")
+		}
+		return t.UTC().Format("2006-01-02 15:04:05"), nil
+	case "string":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), nil
+		case time.Time:
+			return x.UTC().Format("2006-01-02 15:04:05"), nil
+		}
+		return fmt.Sprint(v), nil
+	}
+	return v, nil
+}
+
+func toInteger(v interface{}, s string) (interface{}, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case float64:
+		if x != math.Trunc(x) {
+			return nil, fmt.Errorf("not an integer")
+		}
+		return int64(x), nil
+	}
+	if i, e := strconv.ParseInt(s, 10, 64); e == nil {
+		return i, nil
+	}
+	if f, e := strconv.ParseFloat(s, 64); e == nil && f == math.Trunc(f) {
+		return int64(f), nil
+	}
+	return nil, fmt.Errorf("not an integer")
+}
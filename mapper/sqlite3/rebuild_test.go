@@ -0,0 +1,194 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
+)
+
+// openTest opens a database in a temporary file, with a pool of several connections
+func openTest(t *testing.T, pragmas map[string]string) *sedi.Conn {
+	sedi.LogErrors = false
+	m, err := sedi.OpenConfig(sedi.Config{
+		URL:          "sqlite3://" + filepath.Join(t.TempDir(), "test.db"),
+		MaxOpenConns: 4,
+		Pragmas:      pragmas})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.CloseConnection)
+	return m.Connection()
+}
+
+func mustExec(t *testing.T, cn *sedi.Conn, SQL string) {
+	t.Helper()
+	if err := cn.ExecNoResult(SQL, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Invoice is the new structure of the invoice table: amount becomes a number,
+// year an integer and note is dropped from the model
+type Invoice struct {
+	Id       int64 `autoincrement:"y"`
+	Customer string
+	Amount   float64 `indexed:"y"`
+	Year     int64
+}
+
+func invoiceDef() sedi.TableDef {
+	return sedi.TableDefFromStruct(&Invoice{}, Dialect{}.Quote)
+}
+
+func createInvoices(t *testing.T, cn *sedi.Conn, amount2 string) {
+	mustExec(t, cn, "create table customer (name text primary key)")
+	mustExec(t, cn, "insert into customer (name) values ('acme'), ('bolt')")
+	mustExec(t, cn, "create table invoice (id integer primary key autoincrement, customer text references customer (name), amount text, year text, note text)")
+	mustExec(t, cn, "create index invoice_year on invoice (year)")
+	mustExec(t, cn, "create table line (id integer primary key, invoice_id integer references invoice (id))")
+	mustExec(t, cn, "insert into invoice (id, customer, amount, year, note) values (1, 'acme', '12.5', '2017', 'first'), (2, 'bolt', "+amount2+", ' 2016 ', null), (3, null, null, null, 'x')")
+	mustExec(t, cn, "insert into line (id, invoice_id) values (10, 1), (11, 2)")
+}
+
+func TestRebuildKeepsData(t *testing.T) {
+	cn := openTest(t, map[string]string{"foreign_keys": "on"})
+	createInvoices(t, cn, "'7'")
+	if err := (Dialect{}).RebuildTable(cn, invoiceDef(), false); err != nil {
+		t.Fatal(err)
+	}
+	dt, err := cn.GetDataTable("select id, customer, amount, typeof(amount) as ta, year, typeof(year) as ty, note from invoice order by id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{int64(1), "acme", 12.5, "real", int64(2017), "integer", "first"},
+		{int64(2), "bolt", 7.0, "real", int64(2016), "integer", nil},
+		{int64(3), nil, nil, "null", nil, "null", "x"}}
+	if len(dt.Rows) != len(want) {
+		t.Fatalf("%d rows", len(dt.Rows))
+	}
+	for i, w := range want {
+		for c, v := range dt.Rows[i].Items() {
+			if v != w[c] {
+				t.Errorf("row %d column %s: got %#v, want %#v", i, dt.Columns[c].Name, v, w[c])
+			}
+		}
+	}
+
+	// Indexes, foreign keys and the new types are in place
+	cols, _ := Dialect{}.Columns(cn, "invoice")
+	for _, c := range cols {
+		if c.Name == "amount" && !strings.EqualFold(c.Type, "real") {
+			t.Errorf("amount is %s", c.Type)
+		}
+	}
+	indexes, _ := Dialect{}.Indexes(cn, "invoice")
+	found := map[string]bool{}
+	for _, ix := range indexes {
+		found[ix.Name] = true
+	}
+	if !found["invoice_year"] || !found[Dialect{}.IndexName(invoiceDef(), "amount")] {
+		t.Errorf("indexes %+v", indexes)
+	}
+	fks, _ := foreignKeys(cn, "invoice")
+	if fks != 1 {
+		t.Errorf("%d foreign keys", fks)
+	}
+
+	// foreign_keys is back on, on every connection of the pool
+	for i := 0; i < 8; i++ {
+		if err := cn.ExecNoResult("insert into line (id, invoice_id) values (100, 99)", nil); err == nil {
+			t.Fatal("foreign keys are off after the rebuild")
+		}
+	}
+}
+
+func foreignKeys(cn *sedi.Conn, table string) (int, error) {
+	dt, err := cn.GetDataTable("pragma foreign_key_list ('"+table+"')", nil)
+	return len(dt.Rows), err
+}
+
+func TestRebuildDropOrphans(t *testing.T) {
+	cn := openTest(t, nil)
+	createInvoices(t, cn, "'7'")
+	if err := (Dialect{}).RebuildTable(cn, invoiceDef(), true); err != nil {
+		t.Fatal(err)
+	}
+	cols, _ := Dialect{}.Columns(cn, "invoice")
+	for _, c := range cols {
+		if c.Name == "note" {
+			t.Error("note not dropped")
+		}
+	}
+	if n, _ := cn.GetScalar("select count(*) from invoice", nil); conv.ToInt64(n) != 3 {
+		t.Errorf("%v rows", n)
+	}
+}
+
+func TestRebuildConversionError(t *testing.T) {
+	cn := openTest(t, map[string]string{"foreign_keys": "on"})
+	createInvoices(t, cn, "'seven'")
+	err := (Dialect{}).RebuildTable(cn, invoiceDef(), false)
+	re, ok := err.(*RebuildError)
+	if !ok || len(re.Rows) != 1 || re.Rows[0].RowID != 2 || re.Rows[0].Column != "amount" {
+		t.Fatalf("got %v", err)
+	}
+	if v, _ := cn.GetScalar("select typeof(amount) from invoice where id = 1", nil); v != "text" {
+		t.Errorf("table changed: amount is %v", v)
+	}
+}
+
+func TestRebuildChecksReferencingTables(t *testing.T) {
+	cn := openTest(t, nil) // foreign keys off, to create a line without invoice
+	createInvoices(t, cn, "'7'")
+	mustExec(t, cn, "insert into line (id, invoice_id) values (12, 99)")
+	err := (Dialect{}).RebuildTable(cn, invoiceDef(), false)
+	if re, ok := err.(*RebuildError); !ok || len(re.Check) != 1 {
+		t.Fatalf("got %v", err)
+	}
+	if v, _ := cn.GetScalar("select typeof(amount) from invoice where id = 1", nil); v != "text" {
+		t.Errorf("table changed: amount is %v", v)
+	}
+	if n, _ := cn.GetScalar("select count(*) from sqlite_master where name like 'tmp_rebuild_%'", nil); conv.ToInt64(n) != 0 {
+		t.Error("temporary table left")
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	fd := func(goType string) sedi.FieldDef { return sedi.FieldDef{GoTypeName: goType} }
+	tests := []struct {
+		v      interface{}
+		goType string
+		want   interface{}
+		fails  bool
+	}{
+		{"12", "int64", int64(12), false},
+		{" 12.0 ", "int", int64(12), false},
+		{12.5, "int64", nil, true},
+		{"x", "int32", nil, true},
+		{"true", "bool", int64(1), false},
+		{int64(3), "float64", 3.0, false},
+		{"1e3", "float32", 1000.0, false},
+		{"abc", "float64", nil, true},
+		{"2017-03-04 05:06:07", "Time", "2017-03-04 05:06:07", false},
+		{"", "Time", nil, false},
+		{"not a date", "Time", nil, true},
+		{2.5, "string", "2.5", false},
+		{int64(7), "string", "7", false},
+		{nil, "int64", nil, false},
+	}
+	for _, x := range tests {
+		got, err := convertValue(x.v, fd(x.goType))
+		if (err != nil) != x.fails || got != x.want {
+			t.Errorf("convertValue(%#v, %s) = %#v, %v", x.v, x.goType, got, err)
+		}
+	}
+}
@@ -6,70 +6,50 @@
 package sqlite3
 
 import (
-	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
 
 	_ "github.com/mattn/go-sqlite3" // me package works only with SQLite3
 	//_ "github.com/mxk/go-sqlite/sqlite3"
 )
 
-// GetSQLMapper create a new SQLmapper.
-func GetSQLMapper() *SQLMapper {
-	return &SQLMapper{}
-}
-
 // SQLMapper provides persistence framework for structures
-// in a MySQL database
-type SQLMapper struct {
-	conn *sedi.Conn
-	sedi.TableDefs
-	modelDiffDone bool
-}
+// in a SQLite3 database
+type SQLMapper = sedi.SQLMapper
 
-func (me *SQLMapper) Connection() *sedi.Conn {
-	return me.conn
+func init() {
+	sedi.Register(Dialect{}, "sqlite")
 }
 
-func (me *SQLMapper) quoteFieldName(s string) string {
-	return "`" + s + "`"
+// GetSQLMapper create a new SQLmapper.
+func GetSQLMapper() *SQLMapper {
+	return sedi.NewSQLMapper(Dialect{})
 }
 
-func (me *SQLMapper) OpenConnection(url string) *SQLMapper {
-	if cn, e := sedi.OpenConnection("sqlite3", url); e == nil {
-		cn.DisallowConcurency = true
-		me.conn = &cn
-		cn.SleepTime = time.Duration(10) * time.Millisecond
-		me.conn.ExecNoResult("pragma busy_timeout=10000", nil)
-		me.conn.ExecNoResult("pragma locking_mode = NORMAL", nil)
-		me.conn.ExecNoResult("pragma encoding = \"UTF-8\"", nil)
-	} else {
-		me.conn = nil
-	}
-	return me
+// Dialect implements sedi.Dialect for SQLite3.
+// Changes that SQLite cannot do with alter table are applied by rebuilding the table.
+type Dialect struct{}
+
+func (d Dialect) DriverName() string {
+	return "sqlite3"
 }
 
-func (me *SQLMapper) CloseConnection() {
-	me.conn.Close()
-	me.conn = nil
+func (d Dialect) Quote(s string) string {
+	return "`" + s + "`"
 }
 
-func (me *SQLMapper) AddPersistence(st interface{}) *SQLMapper {
-	if me.TableDefs == nil {
-		me.TableDefs = make(sedi.TableDefs, 0)
-	}
-	td := sedi.TableDefFromStruct(st, me.quoteFieldName)
-	me.TableDefs = append(me.TableDefs, td)
-	return me
+func (d Dialect) Placeholder(n int) string {
+	return "?"
 }
 
-func (me *SQLMapper) SQLType(fd sedi.FieldDef) string {
+func (d Dialect) SQLType(fd sedi.FieldDef) string {
 	var ts string
 	switch fd.GoTypeName {
-	case "byte", "int", "short", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "char", "bool":
+	case "byte", "int", "short", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "uint", "char", "bool":
 		ts = "integer"
 	case "string":
 		ts = "text"
@@ -81,279 +61,203 @@ func (me *SQLMapper) SQLType(fd sedi.FieldDef) string {
 	return ts
 }
 
-func (me *SQLMapper) ModelIsUpToDate() (ok bool, err error) {
-	ok = true
-	err = nil
-	for i := range me.TableDefs {
-		td := &(me.TableDefs[i])
-		if dt, err := me.conn.GetDataTable("pragma table_info ('"+td.SQLName+"')", nil); err == nil {
-			if len(dt.Rows) == 0 {
-				td.MustCreate = true
-			} else {
-				for fi := range td.Fields {
-					fld := &(td.Fields[fi])
-					dbfieldid := -1
-					dbtype := ""
-					for i, r := range dt.Rows {
-						if fn, _ := r.Item("name"); fn == fld.SQLName {
-							dbfieldid = i
-							dbtype = r.ItemSingle("type").(string)
-						}
-					}
-					if dbfieldid != -1 {
-						fld.DBFIeldExists = true
-						if strings.ToLower(me.SQLType(*fld)) == strings.ToLower(dbtype) {
-							fld.DBTypeOK = true
-						} else {
-							td.MustModify = true
-							td.MustRecreate = true
-						}
-						ui := ""
-						if fld.Unique {
-							ui = "unique "
-						}
-						createIndexSQL := "create " + ui + "index `" + td.SQLName + "." + fld.SQLName + "` on `" + td.SQLName + "`(`" + fld.SQLName + "`)"
-						dt, _ := me.conn.GetDataTable("select * from sqlite_master where type='index' and tbl_name=@tbl_name and name=@key_name;",
-							sedi.SQLParms{"@tbl_name": td.SQLName, "@key_name": td.SQLName + "." + fld.SQLName})
-						if len(dt.Rows) > 0 {
-							if strings.ToLower(dt.Rows[0].ItemSingle("sql").(string)) != strings.ToLower(createIndexSQL) || !fld.Indexed {
-								td.MustReIndex = true
-							} else {
-								fld.DBIndexOK = true
-							}
-						} else {
-							if fld.Indexed {
-								td.MustReIndex = true
-							}
-						}
-
-					} else {
-						fld.DBFIeldExists = false
-						fld.DBIndexOK = false
-						fld.DBTypeOK = false
-						td.MustModify = true
-					}
-				}
-			}
+func (d Dialect) SameType(dbType string, fd sedi.FieldDef) bool {
+	return strings.ToLower(d.SQLType(fd)) == strings.ToLower(dbType)
+}
+
+// BindValue stores booleans as 0 or 1 and times as UTC text, a zero time is stored as null
+func (d Dialect) BindValue(fd sedi.FieldDef, v interface{}) interface{} {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return int64(1)
 		}
-		if td.MustCreate || td.MustModify || td.MustReIndex {
-			ok = false
+		return int64(0)
+	case time.Time:
+		if x.IsZero() {
+			return nil
 		}
+		return x.UTC().Format("2006-01-02 15:04:05")
+	}
+	return v
+}
+
+func (d Dialect) DateLiteral(t time.Time) string {
+	return "datetime('" + t.UTC().Format("2006-01-02 15:04:05") + "')"
+}
+
+func (d Dialect) OnOpen(cn *sedi.Conn) error {
+	cn.DisallowConcurency = true
+	return nil
+}
+
+// InitSQL returns the pragmas run on each connection: busy_timeout=10000,
+// locking_mode=NORMAL and encoding="UTF-8" unless cfg.Pragmas changes them,
+// and the other pragmas of cfg (journal_mode, synchronous, foreign_keys, cache_size...)
+func (d Dialect) InitSQL(cfg sedi.Config) []string {
+	pragmas := map[string]string{"busy_timeout": "10000", "locking_mode": "NORMAL", "encoding": "\"UTF-8\""}
+	for k, v := range cfg.Pragmas {
+		pragmas[strings.ToLower(k)] = v
+	}
+	names := []string{}
+	for k := range pragmas {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := []string{}
+	for _, k := range names {
+		ret = append(ret, "pragma "+k+" = "+pragmas[k])
 	}
+	return ret
+}
+
+func (d Dialect) Tables(cn *sedi.Conn) ([]string, error) {
+	ret := []string{}
+	dt, err := cn.GetDataTable("select name from sqlite_master where type='table' and name not like 'sqlite_%'", nil)
 	if err == nil {
-		me.modelDiffDone = true
+		for _, r := range dt.Rows {
+			ret = append(ret, conv.ToString(r.ItemSingle("name")))
+		}
 	}
-	return ok, err
+	return ret, err
 }
 
-func (me *SQLMapper) UpdateModel() (err error) {
-	if !me.modelDiffDone {
-		me.ModelIsUpToDate()
+func (d Dialect) Columns(cn *sedi.Conn, table string) ([]sedi.DBColumn, error) {
+	ret := []sedi.DBColumn{}
+	dt, err := cn.GetDataTable("pragma table_info ('"+escapeParameter(table)+"')", nil)
+	if err != nil {
+		return ret, err
 	}
-	for _, v := range me.TableDefs {
-		if v.MustCreate {
-			me.createTableStructure(v)
-		} else if v.MustModify {
-			err = me.modifyTableStructure(v)
+	pk := 0
+	for _, r := range dt.Rows {
+		c := sedi.DBColumn{
+			Name:       conv.ToString(r.ItemSingle("name")),
+			Type:       conv.ToString(r.ItemSingle("type")),
+			Nullable:   conv.ToInt64(r.ItemSingle("notnull")) == 0,
+			PrimaryKey: conv.ToInt64(r.ItemSingle("pk")) > 0}
+		if c.PrimaryKey {
+			pk++
 		}
-		err = me.createTableIndexes(v)
+		ret = append(ret, c)
 	}
-	return nil
+	for i := range ret {
+		// An integer primary key is an alias of the rowid
+		if pk == 1 && ret[i].PrimaryKey && strings.ToLower(ret[i].Type) == "integer" {
+			ret[i].AutoIncrement = true
+		}
+	}
+	return ret, nil
 }
 
-func (me *SQLMapper) createTableStructure(td sedi.TableDef) (err error) {
-	sql := "create table `" + td.SQLName + "` ("
+func (d Dialect) Indexes(cn *sedi.Conn, table string) ([]sedi.DBIndex, error) {
+	ret := []sedi.DBIndex{}
+	dt, err := cn.GetDataTable("pragma index_list ('"+escapeParameter(table)+"')", nil)
+	if err != nil {
+		return ret, err
+	}
+	for _, r := range dt.Rows {
+		ix := sedi.DBIndex{
+			Name:    conv.ToString(r.ItemSingle("name")),
+			Unique:  conv.ToInt64(r.ItemSingle("unique")) != 0,
+			Primary: conv.ToString(r.ItemSingle("origin")) == "pk"}
+		ic, err := cn.GetDataTable("pragma index_info ('"+escapeParameter(ix.Name)+"')", nil)
+		if err != nil {
+			return ret, err
+		}
+		for _, c := range ic.Rows {
+			ix.Columns = append(ix.Columns, conv.ToString(c.ItemSingle("name")))
+		}
+		ret = append(ret, ix)
+	}
+	return ret, nil
+}
+
+func (d Dialect) CreateTableSQL(td sedi.TableDef) string {
+	return d.createTableSQL(td, td.SQLName, nil)
+}
+
+// createTableSQL returns the create table statement for td under the given name.
+// extra holds additional column or constraint definitions.
+func (d Dialect) createTableSQL(td sedi.TableDef, name string, extra []string) string {
+	sql := "create table `" + name + "` ("
 	for i := 0; i < len(td.Fields); i++ {
 		fld := td.Fields[i]
 		if i != 0 {
 			sql += ","
 		}
-		sql += "\n   `" + fld.SQLName + "` " + me.SQLType(fld)
+		sql += "\n   `" + fld.SQLName + "` " + d.SQLType(fld)
 		if fld.PrimaryKey {
 			sql += " primary key"
 		}
 	}
+	for _, x := range extra {
+		sql += ",\n   " + x
+	}
 	sql += ");"
-	_, err = me.conn.Exec(sql, nil)
-	return err
+	return sql
 }
 
-func (me *SQLMapper) modifyTableStructure(td sedi.TableDef) (err error) {
-	if !td.MustRecreate {
-		for _, fld := range td.Fields {
-			sql := ""
-
-			if !fld.DBFIeldExists {
-				sql = "alter table `" + td.SQLName + "` add `" + fld.SQLName + "` " + me.SQLType(fld)
-				if fld.PrimaryKey {
-					sql += " primary key"
-				}
-
-			} else {
-				if !fld.PrimaryKey {
-					//sql = sql + "alter table `" + td.SQLName + "` modify column `" + fld.SQLName + "` " + me.SqlType(fld)
-				}
-			}
-			if sql != "" {
-				_, err = me.conn.Exec(sql, nil)
-			}
-		}
-	} else {
-		err = me.conn.ExecNoResult("begin exclusive transaction", nil)
-		if err == nil {
-			err = me.conn.ExecNoResult("alter table `"+td.SQLName+"` rename to `tmp_rename_"+td.SQLName+"`", nil)
-		}
-		if err == nil {
-			err = me.createTableStructure(td)
-		}
-		if err == nil {
-			err = me.conn.ExecNoResult("insert into `"+td.SQLName+"` select * from `tmp_rename_"+td.SQLName+"`", nil)
-		}
-		if err == nil {
-			err = me.createTableIndexes(td)
-		}
-		if err == nil {
-			err = me.conn.ExecNoResult("drop table `tmp_rename_"+td.SQLName+"`", nil)
-		}
-		if err == nil {
-			err = me.conn.ExecNoResult("commit", nil)
-		} else {
-			me.conn.ExecNoResult("rollback transaction", nil)
-		}
+// AddColumnSQL returns "" for a primary key, which SQLite cannot add in place
+func (d Dialect) AddColumnSQL(td sedi.TableDef, fd sedi.FieldDef, after string) string {
+	if fd.PrimaryKey {
+		return ""
 	}
-	return err
+	return "alter table `" + td.SQLName + "` add `" + fd.SQLName + "` " + d.SQLType(fd)
+}
 
+// ModifyColumnSQL always returns "", SQLite cannot change the type of a column in place
+func (d Dialect) ModifyColumnSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	return ""
 }
 
-func (me *SQLMapper) createTableIndexes(td sedi.TableDef) (err error) {
-	for _, fld := range td.Fields {
-		ui := ""
-		if fld.Unique {
-			ui = "unique "
-		}
-		createIndexSQL := "create " + ui + "index `" + td.SQLName + "." + fld.SQLName + "` on `" + td.SQLName + "`(`" + fld.SQLName + "`)"
-		dropIndexSQL := "drop index `" + td.SQLName + "." + fld.SQLName + "`"
-
-		dt, _ := me.conn.GetDataTable("select * from sqlite_master where type='index' and tbl_name=@tbl_name and name=@key_name;",
-			sedi.SQLParms{"@tbl_name": td.SQLName, "@key_name": td.SQLName + "." + fld.SQLName})
-		if len(dt.Rows) > 0 {
-			if strings.ToLower(dt.Rows[0].ItemSingle("sql").(string)) != strings.ToLower(createIndexSQL) {
-				me.conn.Exec(dropIndexSQL, nil)
-				me.conn.Exec(createIndexSQL, nil)
-			} else if !fld.Indexed {
-				me.conn.Exec(dropIndexSQL, nil)
-			}
-		} else {
-			if fld.Indexed {
-				me.conn.Exec(createIndexSQL, nil)
-			}
-		}
-	}
-	return err
+func (d Dialect) RenameColumnSQL(td sedi.TableDef, from string, to string) string {
+	return "alter table `" + td.SQLName + "` rename column `" + from + "` to `" + to + "`"
 }
 
-func (me *SQLMapper) Insert(st interface{}) error {
-	td := sedi.TableDefFromStruct(st, me.quoteFieldName)
-	sql := td.InsertStatement
-	v := reflect.Indirect(reflect.ValueOf(st))
-
-	result, e := me.conn.Exec(sql, structToSQLParms(st))
-	if e == nil {
-		fx := td.Fields[td.PkIx]
-		if fx.AutoIncrement {
-			idv := v.FieldByName(td.Fields[td.PkIx].Name)
-			if id, e := result.LastInsertId(); e == nil {
-				switch idv.Interface().(type) {
-				case int, int8, int16, int32, int64:
-					idv.SetInt(id)
-				case uint, uint8, uint16, uint32, uint64:
-					idv.SetUint(uint64(id))
-				}
-
-			}
-		}
-	}
-	return e
+// DropColumnSQL always returns "", columns are dropped by rebuilding the table
+func (d Dialect) DropColumnSQL(td sedi.TableDef, column string) string {
+	return ""
 }
 
-func (me *SQLMapper) Read(st interface{}) error {
-	td := sedi.TableDefFromStruct(st, me.quoteFieldName)
-	sql := td.SelectStatement
-	dr, e := me.conn.GetSingleRow(sql, structToSQLParms(st))
-	if e == nil {
-		dr.FillStruct(st)
-	}
-	return e
+func (d Dialect) IndexName(td sedi.TableDef, column string) string {
+	return td.SQLName + "." + column
 }
 
-func (me *SQLMapper) Update(st interface{}) error {
-	td := sedi.TableDefFromStruct(st, me.quoteFieldName)
-	sql := td.UpdateStatement
-	_, e := me.conn.Exec(sql, structToSQLParms(st))
-	return e
+func (d Dialect) CreateIndexSQL(td sedi.TableDef, fd sedi.FieldDef) string {
+	ui := ""
+	if fd.Unique {
+		ui = "unique "
+	}
+	return "create " + ui + "index `" + d.IndexName(td, fd.SQLName) + "` on `" + td.SQLName + "`(`" + fd.SQLName + "`)"
 }
 
-func (me *SQLMapper) Delete(st interface{}) error {
-	td := sedi.TableDefFromStruct(st, me.quoteFieldName)
-	sql := td.DeleteStatement
-	_, e := me.conn.Exec(sql, structToSQLParms(st))
-	return e
+func (d Dialect) DropIndexSQL(td sedi.TableDef, index string) string {
+	return "drop index if exists `" + index + "`"
 }
 
-func escapeParameter(parm string) string {
-	return strings.Replace(parm, "'", "''", -1)
+func (d Dialect) DropTableSQL(table string) string {
+	return "drop table `" + table + "`"
 }
 
-func structToSQLParms(o interface{}) sedi.SQLParms {
-	p := make(map[string]interface{})
-	v := reflect.Indirect(reflect.ValueOf(o))
-	vt := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		pname := "@" + vt.Field(i).Name
-		switch v.Field(i).Interface().(type) {
-		case string:
-			p[pname] = sedi.SqlParm("'" + escapeParameter(v.Field(i).String()) + "'")
-		case bool:
-			xv := v.Field(i).Bool()
-			if xv {
-				p[pname] = sedi.SqlParm("1")
-			} else {
-				p[pname] = sedi.SqlParm("0")
-			}
-		case uint8:
-			p[pname] = sedi.SqlParm(fmt.Sprint(uint8(v.Field(i).Uint())))
-		case uint16:
-			p[pname] = sedi.SqlParm(fmt.Sprint(uint16(v.Field(i).Uint())))
-		case uint32:
-			p[pname] = sedi.SqlParm(fmt.Sprint(uint32(v.Field(i).Uint())))
-		case uint64:
-			p[pname] = sedi.SqlParm(fmt.Sprint(uint64(v.Field(i).Uint())))
-		case uint:
-			p[pname] = sedi.SqlParm(fmt.Sprint(uint(v.Field(i).Uint())))
-		case int8:
-			p[pname] = sedi.SqlParm(fmt.Sprint(int8(v.Field(i).Int())))
-		case int16:
-			p[pname] = sedi.SqlParm(fmt.Sprint(int16(v.Field(i).Int())))
-		case int32:
-			p[pname] = sedi.SqlParm(fmt.Sprint(int32(v.Field(i).Int())))
-		case int64:
-			p[pname] = sedi.SqlParm(fmt.Sprint(int64(v.Field(i).Int())))
-		case int:
-			p[pname] = sedi.SqlParm(fmt.Sprint(int(v.Field(i).Int())))
-		case float32:
-			p[pname] = sedi.SqlParm(fmt.Sprint(float32(v.Field(i).Float())))
-		case float64:
-			p[pname] = sedi.SqlParm(fmt.Sprint(float64(v.Field(i).Float())))
-		case time.Time:
-			//p[pname] = sedi.SqlParm("datetime('" + time.Time(v.Field(i).Interface().(time.Time)).Format("2006-01-02 15:04:05") + "')")
-			if v.Field(i).Interface().(time.Time).IsZero() {
-				p[pname] = "null"
-			} else {
-				p[pname] = sedi.SqlParm("datetime('" + v.Field(i).Interface().(time.Time).UTC().Format("2006-01-02 15:04:05") + "')")
-			}
-		default:
-			p[pname] = v.Field(i).Type().Name()
+func (d Dialect) UpsertSQL(td sedi.TableDef) string {
+	cols := []string{}
+	parms := []string{}
+	set := []string{}
+	for _, fld := range td.Fields {
+		cols = append(cols, "`"+fld.SQLName+"`")
+		parms = append(parms, "@"+fld.Name)
+		if !fld.PrimaryKey && fld.CanUpdate {
+			set = append(set, "`"+fld.SQLName+"` = excluded.`"+fld.SQLName+"`")
 		}
 	}
-	return p
+	sql := "insert into `" + td.SQLName + "` (" + strings.Join(cols, ", ") + ") values (" + strings.Join(parms, ", ") +
+		") on conflict (`" + td.Fields[td.PkIx].SQLName + "`) do "
+	if len(set) == 0 {
+		return sql + "nothing"
+	}
+	return sql + "update set " + strings.Join(set, ", ")
+}
+
+func escapeParameter(parm string) string {
+	return strings.Replace(parm, "'", "''", -1)
 }
@@ -0,0 +1,262 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+)
+
+func openMapper(t *testing.T) *sedi.SQLMapper {
+	sedi.LogErrors = false
+	m, err := sedi.Open("sqlite3://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.CloseConnection)
+	return m
+}
+
+type Contact struct {
+	Id     int64  `autoincrement:"y"`
+	Name   string `indexed:"y"`
+	Born   time.Time
+	Active bool
+	Rate   float64
+}
+
+func TestCRUD(t *testing.T) {
+	m := openMapper(t).AddPersistence(&Contact{})
+	if ok, err := m.ModelIsUpToDate(); ok || err != nil {
+		t.Fatalf("new model up to date: %v, %v", ok, err)
+	}
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("model not up to date after UpdateModel: %v", err)
+	}
+
+	born := time.Date(1990, 5, 6, 7, 8, 9, 0, time.UTC)
+	c := Contact{Name: "O'Hara", Born: born, Active: true, Rate: 1.5}
+	if err := m.Insert(&c); err != nil || c.Id != 1 {
+		t.Fatalf("insert: id %d, %v", c.Id, err)
+	}
+	r := Contact{Id: c.Id}
+	if err := m.Read(&r); err != nil || r != c {
+		t.Fatalf("read %+v, %v", r, err)
+	}
+	r.Name, r.Born, r.Active = "Smith", time.Time{}, false
+	if err := m.Update(&r); err != nil {
+		t.Fatal(err)
+	}
+	x := Contact{Id: c.Id}
+	if err := m.Read(&x); err != nil || x != r {
+		t.Fatalf("read after update %+v, %v", x, err)
+	}
+
+	// Upsert updates an existing key and inserts a zero auto-increment key
+	x.Rate = 3
+	u := Contact{Name: "new"}
+	if err := m.Upsert(&x); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Upsert(&u); err != nil || u.Id != 2 {
+		t.Fatalf("upsert insert: id %d, %v", u.Id, err)
+	}
+	if n, _ := m.Connection().GetScalar("select rate from contact where id = 1", nil); n != 3.0 {
+		t.Errorf("rate %v", n)
+	}
+
+	if err := m.Delete(&x); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Read(&x); err == nil {
+		t.Error("deleted row read")
+	}
+}
+
+// TestDataAdapter checks that the original values found by the adapter, times and
+// booleans included, are bound like the mapper stores them
+func TestDataAdapter(t *testing.T) {
+	m := openMapper(t).AddPersistence(&Contact{})
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	born := time.Date(1990, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := m.Insert(&Contact{Name: "a", Born: born, Active: true, Rate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cn := m.Connection()
+	dt, err := cn.GetDataTable("select id, name, born, active, rate from contact", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt.Rows[0].Set("rate", 2.5)
+	dr := dt.NewRow()
+	dr.Set("name", "b")
+	dr.Set("born", born.In(time.FixedZone("X", 3600)))
+	dr.Set("active", true)
+	dr.Set("rate", 0.0)
+	dt.AddRow(dr)
+	da := sedi.NewDataAdapter(cn, "contact", "id")
+	da.Identity = "id"
+	if err = da.Update(&dt); err != nil {
+		t.Fatal(err)
+	}
+	for id, rate := range []float64{2.5, 0} {
+		c := Contact{Id: int64(id + 1)}
+		if err = m.Read(&c); err != nil || !c.Born.Equal(born) || !c.Active || c.Rate != rate {
+			t.Errorf("contact %d: %+v, %v", id+1, c, err)
+		}
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	m := openMapper(t).AddPersistence(&Contact{})
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	td := m.TableDefs.BySQLName("contact")
+	src := "name;Born;active;rate;id\n\"O'Hara; Jr\";1990-05-06T07:08:09Z;true;1.5;\nb;;false;2;10\nc;;0;;\n"
+	n, err := m.Connection().LoadCSV(strings.NewReader(src), td, sedi.CSVOptions{Comma: ';'})
+	if err != nil || n != 3 {
+		t.Fatalf("loaded %d rows: %v", n, err)
+	}
+	want := []Contact{
+		{Id: 1, Name: "O'Hara; Jr", Born: time.Date(1990, 5, 6, 7, 8, 9, 0, time.UTC), Active: true, Rate: 1.5},
+		{Id: 10, Name: "b", Rate: 2},
+		{Id: 11, Name: "c"}}
+	for _, w := range want {
+		c := Contact{Id: w.Id}
+		if err = m.Read(&c); err != nil || c != w {
+			t.Errorf("read %+v, %v, want %+v", c, err, w)
+		}
+	}
+
+	// An error rolls back the whole file
+	_, err = m.Connection().LoadCSV(strings.NewReader("name,rate\nd,1\ne,x\n"), td, sedi.CSVOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("conversion error: %v", err)
+	}
+	if _, err = m.Connection().LoadCSV(strings.NewReader("name,nope\nd,1\n"), td, sedi.CSVOptions{}); err == nil {
+		t.Error("unknown column")
+	}
+	if n, _ := m.Connection().GetScalar("select count(*) from contact", nil); n != int64(3) {
+		t.Errorf("%v rows after the errors", n)
+	}
+}
+
+func TestModelChanges(t *testing.T) {
+	m := openMapper(t)
+	cn := m.Connection()
+	mustExec(t, cn, "create table person (id integer primary key, name text, age text, nick text)")
+	mustExec(t, cn, "insert into person (id, name, age, nick) values (1, 'a', '42', 'x')")
+
+	type Person struct {
+		Id       int64
+		FullName string `renamedFrom:"name" indexed:"y"`
+		Age      int64
+		City     string
+	}
+	m.AddPersistence(&Person{})
+	m.SetPruneMode(sedi.PruneReport)
+	if ok, err := m.ModelIsUpToDate(); ok || err != nil {
+		t.Fatalf("%v, %v", ok, err)
+	}
+	td := m.TableDefs[0]
+	if !td.MustModify || !td.MustRecreate || len(td.OrphanColumns) != 1 || td.OrphanColumns[0] != "nick" {
+		t.Fatalf("diff %+v", td)
+	}
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	dt, err := cn.GetDataTable("select id, full_name, age, typeof(age) as ta, city, nick from person", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{int64(1), "a", int64(42), "integer", nil, "x"}
+	for i, v := range dt.Rows[0].Items() {
+		if v != want[i] {
+			t.Errorf("%s: got %#v, want %#v", dt.Columns[i].Name, v, want[i])
+		}
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Fatalf("not up to date: %v", err)
+	}
+
+	m.SetPruneMode(sedi.PruneDrop)
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	cols, _ := Dialect{}.Columns(cn, "person")
+	if len(cols) != 4 {
+		t.Errorf("columns %+v", cols)
+	}
+}
+
+func TestRenameColumnSQL(t *testing.T) {
+	type contact struct {
+		Id       int64
+		FullName string `renamedFrom:"Name"`
+	}
+	td := sedi.TableDefFromStruct(&contact{}, Dialect{}.Quote)
+	want := "alter table `contact` rename column `name` to `full_name`"
+	if got := (Dialect{}).RenameColumnSQL(td, td.Fields[1].RenamedFrom, td.Fields[1].SQLName); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestInitSQL(t *testing.T) {
+	got := Dialect{}.InitSQL(sedi.Config{Pragmas: map[string]string{"Journal_Mode": "WAL", "busy_timeout": "5"}})
+	want := []string{"pragma busy_timeout = 5", "pragma encoding = \"UTF-8\"", "pragma journal_mode = WAL", "pragma locking_mode = NORMAL"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %q, want %q", got[i], want[i])
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cn := openTest(t, map[string]string{"foreign_keys": "on", "cache_size": "-4000"})
+	if n := cn.DB.Stats().MaxOpenConnections; n != 4 {
+		t.Errorf("%d connections", n)
+	}
+	// Every connection of the pool runs the pragmas
+	conns := []*sql.Conn{}
+	for i := 0; i < 4; i++ {
+		c, err := cn.DB.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		var fk, cache, timeout int64
+		c.QueryRowContext(context.Background(), "pragma foreign_keys").Scan(&fk)
+		c.QueryRowContext(context.Background(), "pragma cache_size").Scan(&cache)
+		c.QueryRowContext(context.Background(), "pragma busy_timeout").Scan(&timeout)
+		if fk != 1 || cache != -4000 || timeout != 10000 {
+			t.Errorf("foreign_keys %d, cache_size %d, busy_timeout %d", fk, cache, timeout)
+		}
+	}
+
+	sedi.LogErrors = false
+	if _, err := sedi.OpenConfig(sedi.Config{URL: "sqlite3://" + filepath.Join(t.TempDir(), "x.db"),
+		Pragmas: map[string]string{"nope(": "1"}}); err == nil {
+		t.Error("invalid pragma accepted")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testDialect is a Dialect numbering its placeholders, for the tests of the package
+type testDialect struct {
+	Dialect
+}
+
+func (d testDialect) Placeholder(n int) string {
+	return "$" + string(rune('0'+n))
+}
+
+type mapName struct {
+	Id       int64
+	Name     string
+	NameFull string
+}
+
+func TestCompileStatement(t *testing.T) {
+	td := TableDefFromStruct(&mapName{}, quoteTest)
+	sql, fields := CompileStatement(testDialect{}, &td, "update x set a = @NameFull, b = @Name where id = @Id and c = '@Other' and d = @Name")
+	if sql != "update x set a = $1, b = $2 where id = $3 and c = '@Other' and d = $4" {
+		t.Errorf("sql %s", sql)
+	}
+	if !reflect.DeepEqual(fields, []int{2, 1, 0, 1}) {
+		t.Errorf("fields %v", fields)
+	}
+}
+
+func TestInsertParameters(t *testing.T) {
+	var cn *Conn
+	got := cn.insertParameters("select @Name, @NameFull, @N, @When, @Null, @Raw",
+		SQLParms{"@N": 3, "@Name": "O'Hara", "@NameFull": "full", "@Null": nil, "@Raw": SqlParm("now()"),
+			"@When": time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)})
+	want := "select 'O''Hara', 'full', 3, date('2017-01-02 03:04:05'), null, now()"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
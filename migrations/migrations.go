@@ -0,0 +1,651 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package migrations provides versioned schema migrations on top of sedi.Conn.
+//
+// Migrations are numbered and may be written in SQL (NNNN_name.up.sql and
+// NNNN_name.down.sql files read from a directory or an fs.FS such as embed.FS)
+// or in Go. Applied migrations are recorded in the schema_migrations table
+// together with a checksum of their up script, so that a migration edited
+// after it has been applied is detected.
+//
+// Each migration runs in a transaction with the update of schema_migrations, and is
+// rolled back as a whole when one of its statements fails. MySQL and MariaDB commit
+// DDL statements (create, alter, drop...) implicitly: on these databases a migration
+// failing after a DDL statement is left partly applied and must be fixed by hand.
+//
+// Migrator.Lock keeps two processes from migrating the same database at once.
+// A lock left behind by a crashed process expires after LockExpiry, or can be
+// released at once with Migrator.Unlock.
+//
+// The SQL used by this package is understood by SQLite3, MySQL and PostgreSQL.
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/conv"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+var fileNameRx = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrLocked is returned when another process holds the migration lock
+var ErrLocked = errors.New("migrations: schema is locked by another process")
+
+// Migration is a single schema change. Either the SQL scripts or the
+// Go functions are used, the Go functions having precedence. The Go functions
+// run their statements in the transaction of the migration.
+type Migration struct {
+	Version  int64
+	Name     string
+	UpSQL    string
+	DownSQL  string
+	Up       func(tx *sql.Tx) error
+	Down     func(tx *sql.Tx) error
+	Checksum string
+}
+
+// Reversible tells if the migration can be rolled back
+func (m *Migration) Reversible() bool {
+	return m.Down != nil || strings.TrimSpace(m.DownSQL) != ""
+}
+
+// MigrationStatus describes the state of a migration in the database
+type MigrationStatus struct {
+	Version    int64
+	Name       string
+	Applied    bool
+	AppliedAt  time.Time
+	ChecksumOK bool // false when the up script changed after being applied
+	Missing    bool // applied in the database but unknown to the Migrator
+}
+
+// Migrator applies and rolls back migrations through a sedi.Conn
+type Migrator struct {
+	conn        *sedi.Conn
+	migrations  []Migration
+	TableName   string        // Migration history table, schema_migrations by default
+	LockTimeout time.Duration // How long to wait for the migration lock
+	LockExpiry  time.Duration // Age after which a lock is taken over, never if 0
+}
+
+// New creates a Migrator working on an open connection
+func New(cn *sedi.Conn) *Migrator {
+	return &Migrator{
+		conn:        cn,
+		migrations:  []Migration{},
+		TableName:   "schema_migrations",
+		LockTimeout: time.Duration(30) * time.Second,
+		LockExpiry:  time.Duration(1) * time.Hour,
+	}
+}
+
+// Migrations returns the known migrations ordered by version
+func (me *Migrator) Migrations() []Migration {
+	return me.migrations
+}
+
+// Add registers a migration
+func (me *Migrator) Add(m Migration) error {
+	for _, x := range me.migrations {
+		if x.Version == m.Version {
+			return fmt.Errorf("migrations: duplicate version %d (%s, %s)", m.Version, x.Name, m.Name)
+		}
+	}
+	if m.Checksum == "" && m.UpSQL != "" {
+		m.Checksum = checksum(m.UpSQL)
+	}
+	me.migrations = append(me.migrations, m)
+	sort.Slice(me.migrations, func(i, j int) bool {
+		return me.migrations[i].Version < me.migrations[j].Version
+	})
+	return nil
+}
+
+// AddGo registers a migration written in Go
+func (me *Migrator) AddGo(version int64, name string, up, down func(tx *sql.Tx) error) error {
+	return me.Add(Migration{Version: version, Name: name, Up: up, Down: down})
+}
+
+// LoadDir loads the SQL migrations found in a directory
+func (me *Migrator) LoadDir(dir string) error {
+	return me.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS loads the SQL migrations found in a directory of a fs.FS (embed.FS for instance)
+func (me *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	found := make(map[int64]*Migration)
+	versions := []int64{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := fileNameRx.FindStringSubmatch(e.Name())
+		if p == nil {
+			continue
+		}
+		v, err := strconv.ParseInt(p[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("migrations: bad version in %s", e.Name())
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		m, ok := found[v]
+		if !ok {
+			m = &Migration{Version: v, Name: p[2]}
+			found[v] = m
+			versions = append(versions, v)
+		} else if m.Name != p[2] {
+			return fmt.Errorf("migrations: version %d used by %s and %s", v, m.Name, p[2])
+		}
+		script := &m.UpSQL
+		if p[3] == "down" {
+			script = &m.DownSQL
+		}
+		if *script != "" {
+			return fmt.Errorf("migrations: version %d has two %s scripts", v, p[3])
+		}
+		*script = string(b)
+	}
+	for _, v := range versions {
+		if found[v].UpSQL == "" {
+			return fmt.Errorf("migrations: version %d has no up script", v)
+		}
+		if err := me.Add(*found[v]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checksum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func (me *Migrator) quote(s string) string {
+	if d := me.conn.Dialect(); d != nil {
+		return d.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+// placeholder returns the placeholder of the nth statement argument
+func (me *Migrator) placeholder(n int) string {
+	if d := me.conn.Dialect(); d != nil {
+		return d.Placeholder(n)
+	}
+	return "?"
+}
+
+func (me *Migrator) lockTableName() string {
+	return me.TableName + "_lock"
+}
+
+func (me *Migrator) ensureTables() error {
+	err := me.conn.ExecNoResult("create table if not exists "+me.quote(me.TableName)+" ("+
+		"version bigint primary key, "+
+		"name varchar(255), "+
+		"checksum varchar(64), "+
+		"applied_at varchar(30))", nil)
+	if err == nil {
+		err = me.conn.ExecNoResult("create table if not exists "+me.quote(me.lockTableName())+" ("+
+			"id integer primary key, "+
+			"owner varchar(255), "+
+			"locked_at varchar(30))", nil)
+	}
+	return err
+}
+
+// Lock acquires the migration lock, waiting at most LockTimeout while another
+// process holds it. A lock older than LockExpiry is considered left behind by a
+// crashed process and is taken over.
+func (me *Migrator) Lock() error {
+	if err := me.ensureTables(); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", host, os.Getpid())
+	deadline := time.Now().Add(me.LockTimeout)
+	for {
+		if ok, err := me.tryLock(owner); ok || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(time.Duration(200) * time.Millisecond)
+	}
+}
+
+// tryLock takes the lock if no other process holds it
+func (me *Migrator) tryLock(owner string) (bool, error) {
+	// The lock row is checked first, so that waiting does not log failed inserts
+	var held bool
+	var insertErr error
+	err := me.inTx(func(tx *sedi.Tx) (err error) {
+		if held, err = me.lockHeld(tx); err != nil || held {
+			return err
+		}
+		_, insertErr = tx.ExecArgs("insert into "+me.quote(me.lockTableName())+" (id, owner, locked_at) values (1, "+me.placeholder(1)+", "+me.placeholder(2)+")",
+			owner, time.Now().UTC().Format(timeFormat))
+		return insertErr
+	})
+	if err == nil || insertErr == nil {
+		return err == nil && !held, err
+	}
+	// The insert fails because another process took the lock meanwhile,
+	// or for another reason returned at once
+	if e := me.inTx(func(tx *sedi.Tx) (e error) {
+		held, e = me.lockHeld(tx)
+		return e
+	}); e != nil {
+		return false, e
+	}
+	if held {
+		return false, nil
+	}
+	return false, err
+}
+
+// lockHeld tells if the lock row exists, after removing it if it has expired
+func (me *Migrator) lockHeld(tx *sedi.Tx) (bool, error) {
+	if me.LockExpiry > 0 {
+		if _, err := tx.ExecArgs("delete from "+me.quote(me.lockTableName())+" where id = 1 and locked_at < "+me.placeholder(1),
+			time.Now().Add(-me.LockExpiry).UTC().Format(timeFormat)); err != nil {
+			return false, err
+		}
+	}
+	dt, err := tx.GetDataTableArgs("select id from " + me.quote(me.lockTableName()) + " where id = 1")
+	return len(dt.Rows) > 0, err
+}
+
+// inTx runs f in a transaction, so that the migration tables are read
+// on the primary and never on a replica
+func (me *Migrator) inTx(f func(tx *sedi.Tx) error) error {
+	tx, err := me.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unlock releases the migration lock, whoever holds it. It can be used to
+// force the release of a lock left behind by a crashed process.
+func (me *Migrator) Unlock() error {
+	return me.conn.ExecNoResult("delete from "+me.quote(me.lockTableName())+" where id = 1", nil)
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (me *Migrator) applied() (map[int64]appliedMigration, error) {
+	ret := make(map[int64]appliedMigration)
+	if err := me.ensureTables(); err != nil {
+		return ret, err
+	}
+	err := me.inTx(func(tx *sedi.Tx) (err error) {
+		ret, err = me.appliedIn(tx)
+		return err
+	})
+	return ret, err
+}
+
+// appliedIn reads the applied migrations in a transaction
+func (me *Migrator) appliedIn(tx *sedi.Tx) (map[int64]appliedMigration, error) {
+	ret := make(map[int64]appliedMigration)
+	dt, err := tx.GetDataTable("select version, name, checksum, applied_at from "+me.quote(me.TableName), nil)
+	if err != nil {
+		return ret, err
+	}
+	for _, r := range dt.Rows {
+		ret[conv.ToInt64(r.ItemSingle("version"))] = appliedMigration{
+			name:      conv.ToString(r.ItemSingle("name")),
+			checksum:  conv.ToString(r.ItemSingle("checksum")),
+			appliedAt: conv.ToTime(conv.ToString(r.ItemSingle("applied_at")))}
+	}
+	return ret, nil
+}
+
+// Status reports the state of every known or applied migration, ordered by version
+func (me *Migrator) Status() ([]MigrationStatus, error) {
+	ret := []MigrationStatus{}
+	am, err := me.applied()
+	if err != nil {
+		return ret, err
+	}
+	for _, m := range me.migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name, ChecksumOK: true}
+		if a, ok := am[m.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.appliedAt
+			st.ChecksumOK = a.checksum == "" || m.Checksum == "" || a.checksum == m.Checksum
+			delete(am, m.Version)
+		}
+		ret = append(ret, st)
+	}
+	for v, a := range am {
+		ret = append(ret, MigrationStatus{Version: v, Name: a.name, Applied: true, AppliedAt: a.appliedAt, ChecksumOK: true, Missing: true})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret, nil
+}
+
+// Verify returns an error if an applied migration has been edited since
+func (me *Migrator) Verify() error {
+	st, err := me.Status()
+	if err != nil {
+		return err
+	}
+	bad := []string{}
+	for _, s := range st {
+		if !s.ChecksumOK {
+			bad = append(bad, fmt.Sprintf("%d_%s", s.Version, s.Name))
+		}
+	}
+	if len(bad) > 0 {
+		return errors.New("migrations: checksum mismatch for " + strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// CurrentVersion returns the highest applied version, 0 if none
+func (me *Migrator) CurrentVersion() (int64, error) {
+	var v int64
+	am, err := me.applied()
+	for k := range am {
+		if k > v {
+			v = k
+		}
+	}
+	return v, err
+}
+
+// Up applies all pending migrations
+func (me *Migrator) Up() error {
+	var last int64
+	for _, m := range me.migrations {
+		last = m.Version
+	}
+	return me.MigrateTo(last)
+}
+
+// Down rolls back the last applied migration
+func (me *Migrator) Down() error {
+	am, err := me.applied()
+	if err != nil {
+		return err
+	}
+	versions := []int64{}
+	for v := range am {
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	target := int64(0)
+	if len(versions) > 1 {
+		target = versions[len(versions)-2]
+	}
+	return me.MigrateTo(target)
+}
+
+// MigrateTo applies pending migrations up to version and rolls back
+// the applied migrations above it. MigrateTo(0) rolls back everything.
+func (me *Migrator) MigrateTo(version int64) error {
+	if err := me.Lock(); err != nil {
+		return err
+	}
+	defer me.Unlock()
+
+	if err := me.Verify(); err != nil {
+		return err
+	}
+	am, err := me.applied()
+	if err != nil {
+		return err
+	}
+	known := make(map[int64]bool)
+	for _, m := range me.migrations {
+		known[m.Version] = true
+	}
+	for v, a := range am {
+		if v > version && !known[v] {
+			return fmt.Errorf("migrations: cannot roll back %d_%s, migration not found", v, a.name)
+		}
+	}
+
+	for i := len(me.migrations) - 1; i >= 0; i-- {
+		m := &me.migrations[i]
+		if _, ok := am[m.Version]; ok && m.Version > version {
+			if err := me.run(m, false); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range me.migrations {
+		m := &me.migrations[i]
+		if _, ok := am[m.Version]; !ok && m.Version <= version {
+			if err := me.run(m, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (me *Migrator) run(m *Migration, up bool) (err error) {
+	if !up && !m.Reversible() {
+		return fmt.Errorf("migrations: %d_%s is irreversible", m.Version, m.Name)
+	}
+	if me.conn.DB == nil {
+		return sedi.ErrNoConnection
+	}
+	// The transaction keeps the statements of the migration on the same connection
+	// of the pool of the primary
+	tx, err := me.conn.Begin()
+	if err == nil {
+		err = me.apply(tx, m, up)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("migrations: %d_%s: %s", m.Version, m.Name, err.Error())
+	}
+	return err
+}
+
+// apply runs a migration and records it in a transaction
+func (me *Migrator) apply(tx *sedi.Tx, m *Migration, up bool) (err error) {
+	// The history is read again in the transaction, in case another process
+	// migrated the database since the migrations to run were chosen
+	am, err := me.appliedIn(tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := am[m.Version]; ok && up {
+		return errors.New("applied meanwhile by another process")
+	} else if !ok && !up {
+		return errors.New("rolled back meanwhile by another process")
+	}
+	stx := tx.SQLTx()
+	switch {
+	case up && m.Up != nil:
+		err = m.Up(stx)
+	case up:
+		err = me.execScript(stx, m.UpSQL)
+	case m.Down != nil:
+		err = m.Down(stx)
+	default:
+		err = me.execScript(stx, m.DownSQL)
+	}
+	if err != nil {
+		return err
+	}
+	if up {
+		_, err = stx.Exec("insert into "+me.quote(me.TableName)+" (version, name, checksum, applied_at) values ("+
+			me.placeholder(1)+", "+me.placeholder(2)+", "+me.placeholder(3)+", "+me.placeholder(4)+")",
+			m.Version, m.Name, m.Checksum, time.Now().UTC().Format(timeFormat))
+	} else {
+		_, err = stx.Exec("delete from "+me.quote(me.TableName)+" where version = "+me.placeholder(1), m.Version)
+	}
+	return err
+}
+
+func (me *Migrator) execScript(tx *sql.Tx, script string) error {
+	for _, s := range splitStatements(script) {
+		if sedi.LogAll {
+			log.Print(s)
+		}
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a script on semicolons, ignoring those found in
+// quoted strings, comments and PostgreSQL dollar-quoted bodies ($$ ... $$).
+// A CREATE statement with a BEGIN ... END body, such as a trigger, is kept whole.
+// Empty statements are removed.
+func splitStatements(script string) []string {
+	ret := []string{}
+	cur := strings.Builder{}
+	rs := []rune(script)
+	var quote rune
+	depth := 0 // BEGIN ... END blocks open in a CREATE statement
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			ret = append(ret, s)
+		}
+		cur.Reset()
+		depth = 0
+	}
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_')
+	}
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			cur.WriteRune(c)
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			cur.WriteRune(c)
+		case c == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			cur.WriteRune('\n')
+		case c == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == '$' && !isWord(i-1) && dollarTag(rs[i:]) > 0:
+			// The body runs up to the same tag, $$ or $name$
+			n := dollarTag(rs[i:])
+			tag := string(rs[i : i+n])
+			end := len(rs)
+			for j := i + n; j+n <= len(rs); j++ {
+				if string(rs[j:j+n]) == tag {
+					end = j + n
+					break
+				}
+			}
+			cur.WriteString(string(rs[i:end]))
+			i = end - 1
+		case isWord(i) && !isWord(i-1):
+			j := i
+			for isWord(j) {
+				j++
+			}
+			word := strings.ToLower(string(rs[i:j]))
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(cur.String())), "create") {
+				switch word {
+				case "begin", "case":
+					depth++
+				case "end":
+					if next := nextWord(rs[j:]); depth > 0 && next != "if" && next != "loop" && next != "while" && next != "repeat" {
+						depth--
+					}
+				}
+			}
+			cur.WriteString(string(rs[i:j]))
+			i = j - 1
+		case c == ';' && depth == 0:
+			flush()
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	flush()
+	return ret
+}
+
+// dollarTag returns the length of the $tag$ starting s, 0 if s does not start with one
+func dollarTag(s []rune) int {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return i + 1
+		case c == '_' || unicode.IsLetter(c) || (i > 1 && unicode.IsDigit(c)):
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// nextWord returns the lower case word following the spaces starting s
+func nextWord(s []rune) string {
+	i := 0
+	for i < len(s) && unicode.IsSpace(s[i]) {
+		i++
+	}
+	j := i
+	for j < len(s) && (unicode.IsLetter(s[j]) || s[j] == '_') {
+		j++
+	}
+	return strings.ToLower(string(s[i:j]))
+}
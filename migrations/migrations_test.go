@@ -0,0 +1,357 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+func testMigrator(t *testing.T) *Migrator {
+	sedi.LogErrors = false
+	memory.Drop(t.Name())
+	m := memory.GetSQLMapper().OpenConnection(t.Name())
+	if err := m.Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.CloseConnection()
+		memory.Drop(t.Name())
+	})
+	return New(m.Connection())
+}
+
+var testFS = fstest.MapFS{
+	"db/0001_contact.up.sql":     {Data: []byte("create table contact (id integer primary key, name varchar(50));\n-- A comment; with a semicolon\ninsert into contact (id, name) values (1, 'a;b')")},
+	"db/0001_contact.down.sql":   {Data: []byte("drop table contact")},
+	"db/0002_city.up.sql":        {Data: []byte("alter table contact add city varchar(50)")},
+	"db/0002_city.down.sql":      {Data: []byte("alter table contact drop column city")},
+	"db/0003_seed.up.sql":        {Data: []byte("insert into contact (id, name) values (2, 'b')")},
+	"db/readme.txt":              {Data: []byte("not a migration")},
+	"other/0001_x.up.sql":        {Data: []byte("select 1")},
+	"other/0001_y.down.sql":      {Data: []byte("select 1")},
+	"missing/0001_x.down.sql":    {Data: []byte("select 1")},
+	"duplicate/0001_a.up.sql":    {Data: []byte("select 1")},
+	"duplicate/0001_a.down.sql":  {Data: []byte("select 1")},
+	"duplicate/00001_a.up.sql":   {Data: []byte("select 1")},
+	"duplicate/00001_a.down.sql": {Data: []byte("select 1")},
+}
+
+func tableExists(t *testing.T, mg *Migrator, table string) bool {
+	tables, err := mg.conn.Dialect().Tables(mg.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range tables {
+		if s == table {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadFS(t *testing.T) {
+	mg := testMigrator(t)
+	if err := mg.LoadFS(testFS, "db"); err != nil {
+		t.Fatal(err)
+	}
+	ms := mg.Migrations()
+	if len(ms) != 3 || ms[0].Name != "contact" || ms[2].Version != 3 {
+		t.Fatalf("migrations: %+v", ms)
+	}
+	if !ms[0].Reversible() || ms[2].Reversible() || ms[0].Checksum == "" {
+		t.Errorf("migration 1: %+v, migration 3: %+v", ms[0], ms[2])
+	}
+	for _, dir := range []string{"other", "missing", "duplicate"} {
+		if err := New(mg.conn).LoadFS(testFS, dir); err == nil {
+			t.Errorf("%s: no error", dir)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("create table t (s varchar(5) default ';');\n/* a; b */ insert into t values ('x''; y');; -- end;\n")
+	want := []string{"create table t (s varchar(5) default ';')", "insert into t values ('x''; y')"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitTrigger(t *testing.T) {
+	got := splitStatements(`create table t (id integer, n integer);
+create trigger tr after insert on t for each row begin
+  update t set n = case when new.id > 0 then 1 else 0 end where id = new.id;
+  insert into log values (new.id);
+end;
+insert into t values (1, 0)`)
+	if len(got) != 3 || !strings.HasSuffix(got[1], "insert into log values (new.id);\nend") {
+		t.Errorf("got %q", got)
+	}
+	// In a MySQL procedure, END IF closes IF and not the BEGIN block
+	got = splitStatements("create procedure p() begin if 1 then select 1; end if; select 2; end; select 3")
+	if len(got) != 2 || got[1] != "select 3" {
+		t.Errorf("got %q", got)
+	}
+	// BEGIN starting a statement is a transaction
+	got = splitStatements("begin; select 1; commit")
+	if len(got) != 3 {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSplitDollarQuoted(t *testing.T) {
+	fn := `create function f() returns trigger as $$
+begin
+  new.s := 'a;b';
+  return new;
+end;
+$$ language plpgsql`
+	proc := "do $body$ begin perform 1; end $body$"
+	got := splitStatements(fn + ";\n" + proc + ";\nselect $1")
+	want := []string{fn, proc, "select $1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	mg := testMigrator(t)
+	memory.Drop(t.Name() + "_replica")
+	t.Cleanup(func() { memory.Drop(t.Name() + "_replica") })
+	// The replica is empty: reading the history there would fail or find nothing
+	if err := mg.conn.SetReplicas(sedi.ReplicaOptions{}, t.Name()+"_replica"); err != nil {
+		t.Fatal(err)
+	}
+	mg.LoadFS(testFS, "db")
+	if err := mg.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mg.CurrentVersion(); v != 3 || err != nil {
+		t.Errorf("version %d, %v", v, err)
+	}
+	if err := mg.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	mg.LockTimeout = 0
+	if err := mg.Lock(); err != ErrLocked {
+		t.Errorf("got %v, want ErrLocked", err)
+	}
+	mg.Unlock()
+}
+
+func TestUpDown(t *testing.T) {
+	mg := testMigrator(t)
+	if err := mg.LoadFS(testFS, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mg.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mg.CurrentVersion(); v != 3 || err != nil {
+		t.Fatalf("version %d, %v", v, err)
+	}
+	n, err := mg.conn.GetScalar("select count(*) from contact where city is null", nil)
+	if err != nil || n != int64(2) {
+		t.Fatalf("count %v, %v", n, err)
+	}
+	// Applying again does nothing
+	if err := mg.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0003 is irreversible
+	if err := mg.Down(); err == nil {
+		t.Fatal("rolled back an irreversible migration")
+	}
+	if err := mg.MigrateTo(3); err != nil {
+		t.Fatal(err)
+	}
+	mg.migrations = mg.migrations[:2]
+	mg.Add(Migration{Version: 3, Name: "seed", UpSQL: "insert into contact (id, name) values (2, 'b')",
+		DownSQL: "delete from contact where id = 2"})
+	if err := mg.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mg.CurrentVersion(); v != 2 {
+		t.Fatalf("version %d after Down", v)
+	}
+	if err := mg.MigrateTo(0); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, mg, "contact") {
+		t.Error("contact not dropped")
+	}
+	st, err := mg.Status()
+	if err != nil || len(st) != 3 || st[0].Applied {
+		t.Errorf("status %+v, %v", st, err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	mg := testMigrator(t)
+	mg.LoadFS(testFS, "db")
+	if err := mg.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	mg.migrations[0].UpSQL += ";\ncreate index ix on contact (name)"
+	mg.migrations[0].Checksum = checksum(mg.migrations[0].UpSQL)
+	if err := mg.Verify(); err == nil {
+		t.Fatal("edited migration not detected")
+	}
+	st, _ := mg.Status()
+	if st[0].ChecksumOK || !st[1].ChecksumOK {
+		t.Errorf("status %+v", st)
+	}
+	if err := mg.Up(); err == nil {
+		t.Error("Up ran with an edited migration")
+	}
+	if v, _ := mg.CurrentVersion(); v != 1 {
+		t.Errorf("version %d", v)
+	}
+}
+
+func TestMissingMigration(t *testing.T) {
+	mg := testMigrator(t)
+	mg.LoadFS(testFS, "db")
+	if err := mg.Up(); err != nil {
+		t.Fatal(err)
+	}
+	mg2 := New(mg.conn)
+	mg2.LoadFS(testFS, "db")
+	mg2.migrations = mg2.migrations[:1]
+	st, _ := mg2.Status()
+	if len(st) != 3 || !st[2].Missing {
+		t.Errorf("status %+v", st)
+	}
+	if err := mg2.MigrateTo(1); err == nil {
+		t.Error("rolled back an unknown migration")
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	mg := testMigrator(t)
+	mg.Add(Migration{Version: 1, Name: "table", UpSQL: "create table a (id integer primary key)"})
+	mg.Add(Migration{Version: 2, Name: "bad", UpSQL: "insert into a (id) values (1);\ninsert into nope (id) values (1)"})
+	if err := mg.Up(); err == nil {
+		t.Fatal("no error")
+	}
+	if v, _ := mg.CurrentVersion(); v != 1 {
+		t.Errorf("version %d", v)
+	}
+	if n, _ := mg.conn.GetScalar("select count(*) from a", nil); n != int64(0) {
+		t.Errorf("%v rows left by the failed migration", n)
+	}
+	if err := mg.Lock(); err != nil {
+		t.Errorf("lock not released: %v", err)
+	}
+}
+
+func TestGoMigration(t *testing.T) {
+	mg := testMigrator(t)
+	var inTx *sql.Tx
+	err := mg.AddGo(1, "go", func(tx *sql.Tx) error {
+		inTx = tx
+		_, err := tx.Exec("create table g (id integer primary key)")
+		return err
+	}, func(tx *sql.Tx) error {
+		_, err := tx.Exec("drop table g")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg.AddGo(2, "fails", func(tx *sql.Tx) error {
+		tx.Exec("insert into g (id) values (1)")
+		return errors.New("failure")
+	}, nil)
+	if err := mg.Up(); err == nil {
+		t.Fatal("no error")
+	}
+	if inTx == nil || !tableExists(t, mg, "g") {
+		t.Fatal("migration 1 not applied")
+	}
+	if n, _ := mg.conn.GetScalar("select count(*) from g", nil); n != int64(0) {
+		t.Errorf("%v rows left by the failed migration", n)
+	}
+	if err := mg.MigrateTo(0); err != nil || tableExists(t, mg, "g") {
+		t.Errorf("down: %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	mg := testMigrator(t)
+	mg.LockTimeout = 300 * time.Millisecond
+	if err := mg.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := mg.Lock(); err != ErrLocked {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	if time.Since(start) < mg.LockTimeout {
+		t.Error("did not wait for the lock")
+	}
+	if err := mg.Up(); err != ErrLocked {
+		t.Errorf("Up: got %v, want ErrLocked", err)
+	}
+	mg.Unlock()
+	if err := mg.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	mg.Unlock()
+}
+
+func TestLockExpiry(t *testing.T) {
+	mg := testMigrator(t)
+	mg.LockTimeout = 300 * time.Millisecond
+	if err := mg.ensureTables(); err != nil {
+		t.Fatal(err)
+	}
+	// A lock left behind by a crashed process two hours ago
+	if _, err := mg.conn.ExecArgs("insert into schema_migrations_lock (id, owner, locked_at) values (1, 'crashed', ?)",
+		time.Now().Add(-2*time.Hour).UTC().Format(timeFormat)); err != nil {
+		t.Fatal(err)
+	}
+	mg.LockExpiry = 0
+	if err := mg.Lock(); err != ErrLocked {
+		t.Fatalf("without expiry: got %v, want ErrLocked", err)
+	}
+	mg.LockExpiry = time.Hour
+	if err := mg.Lock(); err != nil {
+		t.Fatalf("expired lock not taken over: %v", err)
+	}
+	// The new lock is recent and does not expire
+	other := New(mg.conn)
+	other.LockTimeout = 300 * time.Millisecond
+	if err := other.Lock(); err != ErrLocked {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	mg.Unlock()
+}
+
+func TestLockError(t *testing.T) {
+	mg := testMigrator(t)
+	mg.LockTimeout = 10 * time.Second
+	// A lock table without the owner column: the insert fails, the lock is not held
+	if err := mg.conn.ExecNoResult("create table schema_migrations_lock (id integer primary key)", nil); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := mg.Lock(); err == nil || err == ErrLocked {
+		t.Fatalf("got %v, want the error of the insert", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("waited for a lock that is not held")
+	}
+}
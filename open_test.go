@@ -0,0 +1,92 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+type Contact struct {
+	Id   int64 `autoincrement:"y"`
+	Name string
+}
+
+func TestRegistered(t *testing.T) {
+	found := false
+	for _, s := range sedi.Registered() {
+		found = found || s == "memory"
+	}
+	if !found {
+		t.Errorf("memory not in %v", sedi.Registered())
+	}
+	if d, ok := sedi.LookupDialect("MEMORY"); !ok || d.DriverName() != memory.DriverName {
+		t.Errorf("lookup: %v, %v", d, ok)
+	}
+	if _, ok := sedi.LookupDialect("nope"); ok {
+		t.Error("nope registered")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	sedi.LogErrors = false
+	defer memory.Drop("open_test")
+	var m sedi.Mapper
+	m, err := sedi.Open("Memory://open_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Connection().Dialect().DriverName() != memory.DriverName {
+		t.Error("wrong dialect")
+	}
+	m.AddPersistence(&Contact{})
+	if err = m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	c := Contact{Name: "a"}
+	if err = m.Insert(&c); err != nil || c.Id != 1 {
+		t.Fatalf("insert: %d, %v", c.Id, err)
+	}
+
+	// Mappers opened on the same name share the database
+	m2, err := sedi.Open("memory://open_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Contact{Id: 1}
+	if err = m2.AddPersistence(&Contact{}).Read(&r); err != nil || r.Name != "a" {
+		t.Errorf("read %+v, %v", r, err)
+	}
+
+	m.CloseConnection()
+	if _, err = m.ModelIsUpToDate(); err != sedi.ErrNoConnection {
+		t.Errorf("ModelIsUpToDate after close: %v", err)
+	}
+	if err = m.Insert(&c); err == nil || !strings.Contains(err.Error(), sedi.ErrNoConnection.Error()) {
+		t.Errorf("Insert after close: %v", err)
+	}
+	m2.CloseConnection()
+}
+
+func TestOpenErrors(t *testing.T) {
+	for url, want := range map[string]string{
+		"file.db":              "must start with a scheme",
+		"://x":                 "must start with a scheme",
+		"oracle://scott@tiger": "unknown scheme oracle",
+	} {
+		if _, err := sedi.Open(url); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", url, err, want)
+		}
+	}
+	_, err := sedi.OpenConfig(sedi.Config{URL: "memory://open_errors", Replicas: []string{"sqlite3://x.db"}})
+	if err == nil || !strings.Contains(err.Error(), "scheme of the primary") {
+		t.Errorf("replica: %v", err)
+	}
+	memory.Drop("open_errors")
+}
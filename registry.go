@@ -0,0 +1,94 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// structFields holds the mapped fields of a structure type
+type structFields struct {
+	name   string
+	fields FieldDefs
+	index  []int // Position of each field in the structure
+}
+
+// structCache maps reflect.Type to *structFields
+var structCache sync.Map
+
+// structFieldsOf returns the mapped fields of a structure type, parsing its tags only once
+func structFieldsOf(t reflect.Type) *structFields {
+	if sf, ok := structCache.Load(t); ok {
+		return sf.(*structFields)
+	}
+	sf := &structFields{name: t.Name(), fields: FieldDefs{}, index: []int{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" { // Exported
+			sf.fields = append(sf.fields, NewFieldDef(f.Name, f.Type.Name(), f.Tag))
+			sf.index = append(sf.index, i)
+		}
+	}
+	actual, _ := structCache.LoadOrStore(t, sf)
+	return actual.(*structFields)
+}
+
+func structType(st interface{}) (reflect.Type, error) {
+	if st == nil {
+		return nil, errors.New("sedi: nil model")
+	}
+	t := reflect.TypeOf(st)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("sedi: " + t.String() + " is not a structure")
+	}
+	return t, nil
+}
+
+// TableDefRegistry caches the TableDef of the structure types used by a mapper,
+// so that tags are parsed and statements are built only once per type.
+// It is safe for concurrent use.
+type TableDefRegistry struct {
+	quoter func(string) string
+	lock   sync.RWMutex
+	defs   map[reflect.Type]*TableDef
+}
+
+// NewTableDefRegistry creates a registry building TableDefs with the given quoter
+func NewTableDefRegistry(QuoterFunc func(string) string) *TableDefRegistry {
+	return &TableDefRegistry{quoter: QuoterFunc, defs: make(map[reflect.Type]*TableDef)}
+}
+
+// Register builds, validates and caches the TableDef of a structure.
+// Registering a type twice returns the cached TableDef, which is shared and must not be modified.
+func (r *TableDefRegistry) Register(st interface{}) (*TableDef, error) {
+	t, err := structType(st)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	td, found := r.defs[t]
+	r.lock.RUnlock()
+	if found {
+		return td, nil
+	}
+
+	x := TableDefFromStruct(reflect.New(t).Interface(), r.quoter)
+	if err = x.Validate(); err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if td, found = r.defs[t]; !found {
+		td = &x
+		r.defs[t] = td
+	}
+	return td, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type regContact struct {
+	Id      int64 `autoincrement:"y"`
+	Name    string
+	Born    time.Time
+	private int
+	Age     int32
+}
+
+func quoteTest(s string) string {
+	return "`" + s + "`"
+}
+
+func TestRegistryCaches(t *testing.T) {
+	r := NewTableDefRegistry(quoteTest)
+	td, err := r.Register(&regContact{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var x regContact
+	px := &x
+	for _, st := range []interface{}{regContact{}, &x, &px} {
+		if td2, err := r.Register(st); err != nil || td2 != td {
+			t.Errorf("%T: %p, %v", st, td2, err)
+		}
+	}
+	if len(td.Fields) != 4 || td.Fields[3].Name != "Age" || td.SelectStatement == "" {
+		t.Errorf("fields %+v", td.Fields)
+	}
+
+	// StructValues uses the cached field positions, skipping unexported fields
+	x = regContact{Id: 3, Name: "a", private: 9, Age: 7}
+	values := td.StructValues(&x)
+	if values[0].Int() != 3 || values[1].String() != "a" || values[3].Int() != 7 {
+		t.Errorf("values %v", values)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewTableDefRegistry(quoteTest)
+	tds := make([]*TableDef, 20)
+	var wg sync.WaitGroup
+	for i := range tds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tds[i], _ = r.Register(&regContact{})
+		}(i)
+	}
+	wg.Wait()
+	for _, td := range tds {
+		if td == nil || td != tds[0] {
+			t.Fatal("TableDef built more than once")
+		}
+	}
+}
+
+type noKey struct {
+	Name string
+}
+
+type twoKeys struct {
+	Id     int64
+	PkCode string
+}
+
+type autoNotKey struct {
+	Id    int64
+	Count int64 `autoincrement:"y"`
+}
+
+type noField struct {
+	id int64
+}
+
+func TestRegistryValidates(t *testing.T) {
+	r := NewTableDefRegistry(quoteTest)
+	tests := []struct {
+		st   interface{}
+		want string
+	}{
+		{nil, "nil model"},
+		{3, "is not a structure"},
+		{&noKey{}, "has no primary key"},
+		{&twoKeys{}, "more than one primary key"},
+		{&autoNotKey{}, "is auto-increment but is not the primary key"},
+		{&noField{}, "has no exported field"},
+	}
+	for _, x := range tests {
+		if _, err := r.Register(x.st); err == nil || !strings.Contains(err.Error(), x.want) {
+			t.Errorf("%T: got %v, want %q", x.st, err, x.want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	td := TableDefFromStruct(&regContact{}, quoteTest)
+	c := td.Copy()
+	c.Fields[0].DBTypeOK = true
+	c.OrphanColumns = append(c.OrphanColumns, "x")
+	if td.Fields[0].DBTypeOK || len(td.OrphanColumns) != 0 {
+		t.Error("the copy shares its fields")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy selects the replica running a query
+type ReplicaPolicy int
+
+const (
+	RoundRobin  ReplicaPolicy = iota // Each replica in turn
+	LeastLoaded                      // The replica with the fewest connections in use
+)
+
+// ParseReplicaPolicy converts "round-robin" or "least-loaded" to a ReplicaPolicy
+func ParseReplicaPolicy(s string) (ReplicaPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "round-robin", "roundrobin":
+		return RoundRobin, nil
+	case "least-loaded", "leastloaded":
+		return LeastLoaded, nil
+	}
+	return RoundRobin, errors.New("sedi: unknown replica policy " + s)
+}
+
+// ReplicaOptions sets how a connection uses its replicas
+type ReplicaOptions struct {
+	Policy ReplicaPolicy
+	// ReadYourWrites sends the queries to the primary during this time after a write
+	// made with the connection, so that they see it despite the replication lag. 0 disables it.
+	ReadYourWrites time.Duration
+	CheckInterval  time.Duration // Health check of the replicas, 10s by default
+	CheckTimeout   time.Duration // 5s by default
+}
+
+// replicaSet holds the read replicas of a connection. The queries running on a
+// replica hold a read lock of use, so that close waits for them.
+type replicaSet struct {
+	lock      sync.Mutex
+	use       sync.RWMutex
+	closed    bool
+	dbs       []*sql.DB
+	health    []*health
+	opt       ReplicaOptions
+	next      int
+	lastWrite time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// SetReplicas opens read replicas of the primary database, with the data source names of
+// the driver. Select statements run on a healthy replica, other statements and the
+// transactions started with Conn.Begin run on the primary.
+// A replica failing a health check is not used until it answers again.
+func (cn *Conn) SetReplicas(opt ReplicaOptions, dsns ...string) error {
+	if cn == nil || cn.DB == nil {
+		return ErrNoConnection
+	}
+	if cn.open == nil {
+		return errors.New("SetReplicas: connection was not opened by sedi")
+	}
+	if opt.CheckInterval <= 0 {
+		opt.CheckInterval = 10 * time.Second
+	}
+	if opt.CheckTimeout <= 0 {
+		opt.CheckTimeout = 5 * time.Second
+	}
+	rs := &replicaSet{opt: opt}
+	for _, dsn := range dsns {
+		db, err := cn.open(dsn)
+		if err != nil {
+			rs.close()
+			return errors.New("SetReplicas:" + err.Error())
+		}
+		rs.dbs = append(rs.dbs, db)
+		rs.health = append(rs.health, &health{name: "replica " + strconv.Itoa(len(rs.dbs))})
+	}
+	if len(rs.dbs) > 0 {
+		rs.stop, rs.done = make(chan struct{}), make(chan struct{})
+		go rs.monitor()
+	}
+	// The previous replicas are closed once the queries use the new ones
+	if old := cn.replicas.swap(rs); old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// ReplicaStatus returns the status of each replica, in the order given to SetReplicas
+func (cn *Conn) ReplicaStatus() []Status {
+	ret := []Status{}
+	if cn == nil || cn.replicas.load() == nil {
+		return ret
+	}
+	for _, h := range cn.replicas.load().health {
+		h.lock.Lock()
+		ret = append(ret, h.status)
+		h.lock.Unlock()
+	}
+	return ret
+}
+
+// replicaRef holds the replica set of a connection, swapped by SetReplicas while queries run
+type replicaRef struct {
+	v atomic.Value // *replicaSet
+}
+
+// load returns the replicas of the connection, nil if it has none
+func (r *replicaRef) load() *replicaSet {
+	rs, _ := r.v.Load().(*replicaSet)
+	return rs
+}
+
+// swap replaces the replicas of the connection and returns the previous ones
+func (r *replicaRef) swap(rs *replicaSet) *replicaSet {
+	old, _ := r.v.Swap(rs).(*replicaSet)
+	return old
+}
+
+// wrote records a write of the connection, see ReadYourWrites
+func (r *replicaRef) wrote() {
+	r.load().wrote()
+}
+
+func (cn *Conn) closeReplicas() {
+	if rs := cn.replicas.swap(nil); rs != nil {
+		rs.close()
+	}
+}
+
+// close stops the monitor and closes the replicas once their running queries are done
+func (rs *replicaSet) close() {
+	if rs.stop != nil {
+		close(rs.stop)
+		<-rs.done
+	}
+	rs.use.Lock()
+	defer rs.use.Unlock()
+	rs.closed = true
+	for _, db := range rs.dbs {
+		db.Close()
+	}
+}
+
+// monitor checks the replicas until the replica set is closed
+func (rs *replicaSet) monitor() {
+	defer close(rs.done)
+	for {
+		rs.check()
+		select {
+		case <-rs.stop:
+			return
+		case <-time.After(rs.opt.CheckInterval):
+		}
+	}
+}
+
+func (rs *replicaSet) check() {
+	for i, db := range rs.dbs {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.opt.CheckTimeout)
+		rs.health[i].record(db.PingContext(ctx))
+		cancel()
+	}
+}
+
+// isRead tells if a statement can run on a replica
+func isRead(SQL string) bool {
+	s := strings.ToLower(strings.TrimSpace(SQL))
+	return strings.HasPrefix(s, "select") && !strings.Contains(s, " for update") && !strings.Contains(s, " for share")
+}
+
+// reader returns the database running a query, and the replica set of the database
+// when it is a replica. The caller must call rs.release once the query is over.
+func (cn *Conn) reader(SQL string) (*sql.DB, *replicaSet) {
+	rs := cn.replicas.load()
+	if rs == nil || len(rs.dbs) == 0 || !isRead(SQL) {
+		return cn.DB, nil
+	}
+	rs.use.RLock()
+	if !rs.closed {
+		if db := rs.pick(); db != nil {
+			return db, rs
+		}
+	}
+	rs.use.RUnlock()
+	return cn.DB, nil
+}
+
+// release ends a query started on a replica returned by reader
+func (rs *replicaSet) release() {
+	if rs != nil {
+		rs.use.RUnlock()
+	}
+}
+
+// pick returns the replica running a query, nil when the query must run on the primary
+func (rs *replicaSet) pick() *sql.DB {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.opt.ReadYourWrites > 0 && time.Since(rs.lastWrite) < rs.opt.ReadYourWrites {
+		return nil
+	}
+	var best *sql.DB
+	bestLoad := 0
+	n := len(rs.dbs)
+	for k := 0; k < n; k++ {
+		i := (rs.next + k) % n
+		if !rs.health[i].up() {
+			continue
+		}
+		if rs.opt.Policy == RoundRobin {
+			rs.next = i + 1
+			return rs.dbs[i]
+		}
+		if load := rs.dbs[i].Stats().InUse; best == nil || load < bestLoad {
+			best, bestLoad = rs.dbs[i], load
+		}
+	}
+	if best == nil {
+		return nil // No healthy replica
+	}
+	rs.next++
+	return best
+}
+
+// up tells if a replica can be used: it is up or was never checked
+func (h *health) up() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.status.Up || h.status.Checked.IsZero()
+}
+
+// failed is called when a query failed on a replica. It checks the replica
+// and tells if it is down, in which case the query should run on the primary.
+func (rs *replicaSet) failed(db *sql.DB) bool {
+	for i := range rs.dbs {
+		if rs.dbs[i] == db {
+			ctx, cancel := context.WithTimeout(context.Background(), rs.opt.CheckTimeout)
+			err := db.PingContext(ctx)
+			cancel()
+			rs.health[i].record(err)
+			return err != nil
+		}
+	}
+	return false
+}
+
+// wrote records the time of a write on the primary, for read-your-writes
+func (rs *replicaSet) wrote() {
+	if rs == nil {
+		return
+	}
+	rs.lock.Lock()
+	rs.lastWrite = time.Now()
+	rs.lock.Unlock()
+}
@@ -0,0 +1,194 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+)
+
+// openReplicated opens a primary and a replica in memory, each with table t holding its name
+func openReplicated(t *testing.T, opt sedi.ReplicaOptions) *sedi.Conn {
+	sedi.LogErrors = false
+	for _, name := range []string{"replicas_primary", "replicas_replica"} {
+		memory.Drop(name)
+		cn, err := sedi.OpenConnection(memory.DriverName, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"create table t (name varchar(20))", "insert into t (name) values ('" + name + "')"} {
+			if err := cn.ExecNoResult(s, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cn.Close()
+	}
+	cn, err := sedi.OpenConnection(memory.DriverName, "replicas_primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cn.SetReplicas(opt, "replicas_replica"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cn.Close()
+		memory.Drop("replicas_primary")
+		memory.Drop("replicas_replica")
+	})
+	return &cn
+}
+
+// source returns the database answering a select on t
+func source(t *testing.T, q interface {
+	GetDataTableArgs(string, ...interface{}) (sedi.DataTable, error)
+}) string {
+	t.Helper()
+	dt, err := q.GetDataTableArgs("select name from t order by name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dt.Rows) == 0 {
+		return ""
+	}
+	return dt.Rows[0].GetStringOr("name", "")
+}
+
+func TestReplicaReads(t *testing.T) {
+	cn := openReplicated(t, sedi.ReplicaOptions{})
+	if s := source(t, cn); s != "replicas_replica" {
+		t.Errorf("select ran on %s", s)
+	}
+	if err := cn.ExecNoResult("insert into t (name) values ('a')", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := source(t, cn); s != "replicas_replica" {
+		t.Errorf("select after a write ran on %s without ReadYourWrites", s)
+	}
+	if st := cn.ReplicaStatus(); len(st) != 1 {
+		t.Errorf("status %+v", st)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	cn := openReplicated(t, sedi.ReplicaOptions{ReadYourWrites: 100 * time.Millisecond})
+	if s := source(t, cn); s != "replicas_replica" {
+		t.Errorf("select ran on %s", s)
+	}
+	if err := cn.ExecNoResult("update t set name = 'b'", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := source(t, cn); s != "b" {
+		t.Errorf("select after a write ran on %s", s)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if s := source(t, cn); s != "replicas_replica" {
+		t.Errorf("select after the delay ran on %s", s)
+	}
+
+	// A statement returning rows that is not a select is a write
+	if _, err := cn.GetDataTable("show tables", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := source(t, cn); s != "b" {
+		t.Errorf("select after show tables ran on %s", s)
+	}
+}
+
+func TestSetReplicasWhileQuerying(t *testing.T) {
+	cn := openReplicated(t, sedi.ReplicaOptions{})
+	cn.DisallowConcurency = false
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					errs <- nil
+					return
+				default:
+				}
+				if _, err := cn.GetDataTableArgs("select name from t"); err != nil {
+					errs <- err
+					return
+				}
+				cn.ReplicaStatus()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := cn.SetReplicas(sedi.ReplicaOptions{}, "replicas_replica"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	for g := 0; g < 4; g++ {
+		if err := <-errs; err != nil {
+			t.Errorf("query while replacing the replicas: %v", err)
+		}
+	}
+}
+
+func TestTx(t *testing.T) {
+	cn := openReplicated(t, sedi.ReplicaOptions{})
+	tx, err := cn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if tx.Conn() != cn {
+		t.Error("wrong connection")
+	}
+	if _, err = tx.ExecArgs("insert into t (name) values (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if s := source(t, tx); s != "a" {
+		t.Errorf("select in the transaction ran on %s", s)
+	}
+	if dr, err := tx.GetSingleRowArgs("select count(*) as n from t"); err != nil || dr.GetInt64Or("n", 0) != 2 {
+		t.Errorf("count: %v, %v", dr.Items(), err)
+	}
+	if s := source(t, cn); s != "replicas_replica" {
+		t.Errorf("select outside the transaction ran on %s", s)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Errorf("second rollback: %v", err)
+	}
+	if _, err = tx.Exec("insert into t (name) values ('b')", nil); err == nil {
+		t.Error("exec after rollback")
+	}
+	if err = tx.Commit(); err == nil {
+		t.Error("commit after rollback")
+	}
+
+	tx, err = cn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("delete from t where name = @name", sedi.SQLParms{"@name": "replicas_primary"}); err != nil {
+		t.Fatal(err)
+	}
+	if dt, err := tx.GetDataTable("select name from t", nil); err != nil || len(dt.Rows) != 0 {
+		t.Errorf("rows after delete: %d, %v", len(dt.Rows), err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := cn.GetScalar("select count(*) from t", nil); n == nil || n.(int64) != 1 {
+		t.Errorf("replica changed: %v rows", n) // The replica still has its row
+	}
+
+	var nc *sedi.Conn
+	if _, err = nc.Begin(); err == nil {
+		t.Error("begin without connection")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package reverse reads the structure of an existing database and writes
+// the Go structures that the sedi mappers use to model it.
+package reverse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/stefpo/sedi"
+)
+
+// Column describes a database column
+type Column struct {
+	Name          string
+	DBType        string // Type as declared in the database
+	Size          int    // Maximum length of character columns, 0 if unknown
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// Index describes a database index
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// Table describes a database table
+type Table struct {
+	Name    string
+	Columns []Column
+	Indexes []Index
+}
+
+// Inspect reads the structure of the tables of the database with the dialect of the
+// connection, which must have been opened by sedi.Open or a mapper.
+// All tables are read when tables is empty.
+func Inspect(cn *sedi.Conn, tables []string) ([]Table, error) {
+	ret := []Table{}
+	d := cn.Dialect()
+	if d == nil {
+		return ret, errors.New("reverse: the connection has no dialect, open it with sedi.Open")
+	}
+	names, err := d.Tables(cn)
+	if err != nil {
+		return ret, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !selected(name, tables) {
+			continue
+		}
+		t := Table{Name: name}
+		cols, err := d.Columns(cn, name)
+		if err != nil {
+			return ret, err
+		}
+		for _, c := range cols {
+			t.Columns = append(t.Columns, Column{
+				Name:          c.Name,
+				DBType:        strings.ToLower(c.Type),
+				Size:          typeSize(c.Type),
+				Nullable:      c.Nullable,
+				PrimaryKey:    c.PrimaryKey,
+				AutoIncrement: c.AutoIncrement})
+		}
+		indexes, err := d.Indexes(cn, name)
+		if err != nil {
+			return ret, err
+		}
+		for _, ix := range indexes {
+			if !ix.Primary {
+				t.Indexes = append(t.Indexes, Index{Name: ix.Name, Columns: ix.Columns, Unique: ix.Unique})
+			}
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+func selected(name string, tables []string) bool {
+	if len(tables) == 0 {
+		return true
+	}
+	for _, t := range tables {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// typeSize returns the length of a type like varchar(50), 0 if there is none
+func typeSize(dbType string) int {
+	p := strings.Index(dbType, "(")
+	q := strings.Index(dbType, ")")
+	if p < 0 || q < p {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(dbType[p+1 : q]))
+	return n
+}
+
+// GoName returns the Go name mapped by sedi to a database name, "" if there is none
+func GoName(dbName string) string {
+	parts := strings.Split(dbName, "_")
+	ret := ""
+	for _, p := range parts {
+		if p == "" {
+			return ""
+		}
+		rs := []rune(p)
+		rs[0] = unicode.ToUpper(rs[0])
+		ret += string(rs)
+	}
+	rs := []rune(ret)
+	if len(rs) == 0 || !unicode.IsLetter(rs[0]) {
+		return ""
+	}
+	for _, r := range rs {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return ""
+		}
+	}
+	if sedi.DBName(ret) != dbName {
+		return ""
+	}
+	return ret
+}
+
+// goTypes are the Go types tried by goType, the most common first
+var goTypes = []string{"int64", "int32", "int16", "int8", "uint64", "uint32", "uint16", "uint8",
+	"bool", "float64", "float32", "string", "Time"}
+
+// goType returns the Go type of a column, and whether the mapper of dialect d
+// declares the same database type for it
+func goType(d sedi.Dialect, c Column) (string, bool) {
+	for _, gt := range goTypes {
+		if d.SameType(c.DBType, sedi.FieldDef{GoTypeName: gt, Size: int16(c.Size)}) {
+			if gt == "Time" {
+				return "time.Time", true
+			}
+			return gt, true
+		}
+	}
+	t := c.DBType
+	switch {
+	case strings.Contains(t, "bool"):
+		return "bool", false
+	case strings.Contains(t, "int"):
+		return "int64", false
+	case strings.Contains(t, "char"), strings.Contains(t, "clob"), strings.Contains(t, "text"):
+		return "string", false
+	case strings.Contains(t, "real"), strings.Contains(t, "floa"), strings.Contains(t, "doub"),
+		strings.Contains(t, "dec"), strings.Contains(t, "num"):
+		return "float64", false
+	case strings.Contains(t, "date"), strings.Contains(t, "time"):
+		return "time.Time", false
+	}
+	return "string", false
+}
+
+// Generate writes the Go source of a package declaring one structure per table,
+// with the types and tags the mapper of dialect d uses for the columns
+func Generate(w io.Writer, pkg string, d sedi.Dialect, tables []Table) error {
+	var b bytes.Buffer
+	usesTime := false
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			if gt, _ := goType(d, c); gt == "time.Time" {
+				usesTime = true
+			}
+		}
+	}
+
+	b.WriteString("// Code generated by sedi-reverse. DO NOT EDIT.\n\n")
+	b.WriteString("package " + pkg + "\n\n")
+	if usesTime {
+		b.WriteString("import \"time\"\n\n")
+	}
+	for _, t := range tables {
+		writeStruct(&b, d, t)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func writeStruct(b *bytes.Buffer, d sedi.Dialect, t Table) {
+	sn := GoName(t.Name)
+	if sn == "" {
+		fmt.Fprintf(b, "// Table %s cannot be mapped to a structure name\n\n", t.Name)
+		return
+	}
+
+	// Only the indexes named as the mapper names them are modelled: for the
+	// others, the mapper would create a second index on the column
+	td := sedi.TableDef{Name: sn, SQLName: t.Name}
+	indexed := make(map[string]Index)
+	notes := []string{}
+	for _, ix := range t.Indexes {
+		if len(ix.Columns) == 1 && ix.Name == d.IndexName(td, ix.Columns[0]) {
+			indexed[ix.Columns[0]] = ix
+		} else {
+			notes = append(notes, "index "+ix.Name+" on ("+strings.Join(ix.Columns, ", ")+") is not modelled")
+		}
+	}
+	pk := 0
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			pk++
+		}
+	}
+	if pk == 0 {
+		notes = append(notes, "the table has no primary key")
+	} else if pk > 1 {
+		notes = append(notes, "composite primary keys are not supported, only the first column is used")
+	}
+
+	fmt.Fprintf(b, "// %s maps table %s\n", sn, t.Name)
+	for _, n := range notes {
+		fmt.Fprintf(b, "// Note: %s\n", n)
+	}
+	fmt.Fprintf(b, "type %s struct {\n", sn)
+	pkDone := false
+	for _, c := range t.Columns {
+		fn := GoName(c.Name)
+		if fn == "" {
+			fmt.Fprintf(b, "\t// Column %s cannot be mapped to a field name\n", c.Name)
+			continue
+		}
+		gt, exact := goType(d, c)
+		tags := []string{}
+		if gt == "string" && c.Size > 0 && exact {
+			tags = append(tags, "size:\""+strconv.Itoa(c.Size)+"\"")
+		}
+		lfn := strings.ToLower(fn)
+		if c.PrimaryKey && !pkDone {
+			pkDone = true
+			if lfn != "id" && !strings.HasPrefix(lfn, "pk") {
+				tags = append(tags, "primaryKey:\"y\"")
+			}
+			if c.AutoIncrement {
+				tags = append(tags, "autoincrement:\"y\"")
+			}
+		} else if lfn == "id" || strings.HasPrefix(lfn, "pk") {
+			fmt.Fprintf(b, "\t// Column %s would be taken as the primary key\n", c.Name)
+			continue
+		}
+		if ix, ok := indexed[c.Name]; ok {
+			if !strings.HasPrefix(lfn, "fk") {
+				tags = append(tags, "indexed:\"y\"")
+			}
+			if ix.Unique {
+				tags = append(tags, "unique:\"y\"")
+			}
+		} else if strings.HasPrefix(lfn, "fk") {
+			fmt.Fprintf(b, "\t// Column %s is not indexed, the mapper will index it because of the Fk prefix\n", c.Name)
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(b, "\t%s %s", fn, gt)
+		if len(tags) > 0 {
+			fmt.Fprintf(b, " `%s`", strings.Join(tags, " "))
+		}
+		if !exact {
+			fmt.Fprintf(b, " // database type %s", c.DBType)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n\n")
+}
@@ -0,0 +1,218 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reverse
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefpo/sedi"
+	"github.com/stefpo/sedi/mapper/memory"
+	"github.com/stefpo/sedi/mapper/sqlite3"
+)
+
+type Customer struct {
+	Id      int64  `autoincrement:"y"`
+	Code    string `size:"12" unique:"y" indexed:"y"`
+	Name    string `size:"30"`
+	Born    time.Time
+	Rate    float64
+	Active  bool
+	FkGroup int64
+}
+
+type Group struct {
+	Code string `primaryKey:"y" size:"8"`
+	Name string
+}
+
+// model creates the tables of the test structures and returns the connection of the mapper
+func model(t *testing.T, m *sedi.SQLMapper) *sedi.Conn {
+	sedi.LogErrors = false
+	m.AddPersistence(&Customer{}).AddPersistence(&Group{})
+	if err := m.UpdateModel(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.CloseConnection)
+	return m.Connection()
+}
+
+func generate(t *testing.T, cn *sedi.Conn, tables ...string) string {
+	td, err := Inspect(cn, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = Generate(&b, "model", cn.Dialect(), td); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func contains(t *testing.T, src string, lines ...string) {
+	t.Helper()
+	for _, l := range lines {
+		if !strings.Contains(src, l) {
+			t.Errorf("missing %q in\n%s", l, src)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	memory.Drop(t.Name())
+	defer memory.Drop(t.Name())
+	cn := model(t, memory.GetSQLMapper().OpenConnection(t.Name()))
+	src := generate(t, cn)
+	contains(t, src,
+		"package model\n",
+		"import \"time\"",
+		"type Customer struct {",
+		"Id      int64  `autoincrement:\"y\"`\n",
+		"Code    string `indexed:\"y\" size:\"12\" unique:\"y\"`\n",
+		"Name    string `size:\"30\"`\n",
+		"Born    time.Time\n",
+		"Rate    float64\n",
+		"Active  bool\n",
+		"FkGroup int64\n",
+		"type Group struct {",
+		"Code string `primaryKey:\"y\" size:\"8\"`\n",
+		"Name string `size:\"50\"`\n")
+	if strings.Contains(src, "database type") {
+		t.Errorf("inexact types in\n%s", src)
+	}
+
+	src = generate(t, cn, "group")
+	if strings.Contains(src, "Customer") || !strings.Contains(src, "type Group struct") || strings.Contains(src, "import") {
+		t.Errorf("tables not selected:\n%s", src)
+	}
+}
+
+func TestSqlite3(t *testing.T) {
+	m, err := sedi.Open("sqlite3://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := model(t, m)
+	if err = cn.ExecNoResult("create table legacy (id integer primary key, amount decimal(10,2), a_b_ text, "+
+		"x integer, y integer)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = cn.ExecNoResult("create index legacy_xy on legacy (x, y)", nil); err != nil {
+		t.Fatal(err)
+	}
+	src := generate(t, cn)
+	contains(t, src,
+		"Code    string `indexed:\"y\" unique:\"y\"`\n",
+		"Born    string\n", // Times are stored as text by SQLite3
+		"Rate    float64\n",
+		"Amount float64 // database type decimal(10,2)\n",
+		"// Column a_b_ cannot be mapped to a field name\n",
+		"// Note: index legacy_xy on (x, y) is not modelled\n")
+	if _, err := Inspect(&sedi.Conn{}, nil); err == nil {
+		t.Error("no error without a dialect")
+	}
+}
+
+// structDefs builds the TableDefs of the structures of a generated source
+func structDefs(t *testing.T, src string, quote func(string) string) sedi.TableDefs {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]reflect.Type{"int64": reflect.TypeOf(int64(0)), "float64": reflect.TypeOf(float64(0)),
+		"string": reflect.TypeOf(""), "bool": reflect.TypeOf(false), "time.Time": reflect.TypeOf(time.Time{})}
+	ret := sedi.TableDefs{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		fields := []reflect.StructField{}
+		for _, fd := range ts.Type.(*ast.StructType).Fields.List {
+			sf := reflect.StructField{Name: fd.Names[0].Name, Type: types[fmt.Sprint(fd.Type)]}
+			if sel, ok := fd.Type.(*ast.SelectorExpr); ok {
+				sf.Type = types[fmt.Sprint(sel.X)+"."+sel.Sel.Name]
+			}
+			if fd.Tag != nil {
+				sf.Tag = reflect.StructTag(strings.Trim(fd.Tag.Value, "`"))
+			}
+			fields = append(fields, sf)
+		}
+		st := sedi.TableDefFromStruct(reflect.New(reflect.StructOf(fields)).Interface(), quote)
+		ret = append(ret, sedi.TableDefFromFields(ts.Name.Name, st.Fields, quote))
+		return false
+	})
+	return ret
+}
+
+func TestRoundTrip(t *testing.T) {
+	memory.Drop(t.Name())
+	defer memory.Drop(t.Name())
+	cn := model(t, memory.GetSQLMapper().OpenConnection(t.Name()))
+	// Indexes the mapper did not create are not modelled
+	for _, s := range []string{"create index group_by_name on \"group\" (name)", "create unique index customer_name on customer (name)"} {
+		if err := cn.ExecNoResult(s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := generate(t, cn)
+	contains(t, src,
+		"// Note: index customer_name on (name) is not modelled\n",
+		"// Note: index group_by_name on (name) is not modelled\n",
+		"Name    string `size:\"30\"`\n")
+
+	m := memory.GetSQLMapper().OpenConnection(t.Name())
+	defer m.CloseConnection()
+	m.TableDefs = structDefs(t, src, cn.Dialect().Quote)
+	if len(m.TableDefs) != 2 {
+		t.Fatalf("%d structures in\n%s", len(m.TableDefs), src)
+	}
+	if ok, err := m.ModelIsUpToDate(); !ok || err != nil {
+		t.Errorf("model of the generated structures is not up to date: %v\n%s", err, src)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for db, want := range map[string]string{"customer": "Customer", "fk_group": "FkGroup", "line2": "Line2",
+		"a__b": "", "_a": "", "2a": "", "a-b": "", "ZIP": ""} {
+		if got := GoName(db); got != want {
+			t.Errorf("GoName(%q) = %q, want %q", db, got, want)
+		}
+	}
+}
+
+func TestGoType(t *testing.T) {
+	tests := []struct {
+		d      sedi.Dialect
+		dbType string
+		size   int
+		want   string
+		exact  bool
+	}{
+		{memory.Dialect{}, "integer", 0, "int64", true},
+		{memory.Dialect{}, "varchar(20)", 20, "string", true},
+		{memory.Dialect{}, "timestamp", 0, "time.Time", true},
+		{memory.Dialect{}, "numeric", 0, "float64", false},
+		{sqlite3.Dialect{}, "text", 0, "string", true},
+		{sqlite3.Dialect{}, "real", 0, "float64", true},
+		{sqlite3.Dialect{}, "blob", 0, "string", false},
+		{sqlite3.Dialect{}, "datetime", 0, "time.Time", false},
+	}
+	for _, x := range tests {
+		got, exact := goType(x.d, Column{DBType: x.dbType, Size: x.size})
+		if got != x.want || exact != x.exact {
+			t.Errorf("%s %s: got %s %v", x.d.DriverName(), x.dbType, got, exact)
+		}
+	}
+}
@@ -6,6 +6,7 @@
 package sedi
 
 import (
+	"errors"
 	"reflect"
 	"strconv"
 	"strings"
@@ -24,7 +25,9 @@ type FieldDef struct {
 	Indexed       bool
 	Unique        bool
 	CanUpdate     bool
+	RenamedFrom   string // Previous SQL name of the field (renamedFrom tag)
 	DBFIeldExists bool
+	DBMustRename  bool
 	DBTypeOK      bool
 	DBIndexOK     bool
 }
@@ -48,17 +51,51 @@ type TableDef struct {
 	MustModify      bool
 	MustRecreate    bool
 	MustReIndex     bool
+	OrphanColumns   []string // Database columns not mapped to a field
+	OrphanIndexes   []string // Mapper indexes not matching a field
+	fieldIndex      []int    // Position of Fields in the structure, nil if unknown
 }
 
 // TableDefs is simply a list of TableDef
 type TableDefs []TableDef
 
+// PruneMode tells the mappers what to do with the database objects
+// that are no longer part of the model.
+type PruneMode int
+
+const (
+	// PruneNone ignores orphan columns, indexes and tables (default)
+	PruneNone PruneMode = iota
+	// PruneReport detects orphans and reports them in the TableDefs and the mapper
+	PruneReport
+	// PruneDrop detects orphans and lets UpdateModel drop the orphan columns and indexes,
+	// and the orphan tables listed in SQLMapper.DropTables
+	PruneDrop
+)
+
+// BySQLName returns the table mapped to a database table, nil if none
+func (tds TableDefs) BySQLName(name string) *TableDef {
+	for i := range tds {
+		if tds[i].SQLName == name {
+			return &(tds[i])
+		}
+	}
+	return nil
+}
+
 // TableDefFromStruct creates a TableDef from a Go sttucture
 func TableDefFromStruct(st interface{}, QuoterFunc func(string) string) TableDef {
+	sf := structFieldsOf(reflect.Indirect(reflect.ValueOf(st)).Type())
+	td := TableDefFromFields(sf.name, sf.fields, QuoterFunc)
+	td.fieldIndex = sf.index
+	return td
+}
+
+// TableDefFromFields creates a TableDef from a structure name and the definition of its fields.
+// It is used by code generators which do not have the structure at hand.
+func TableDefFromFields(name string, fields FieldDefs, QuoterFunc func(string) string) TableDef {
 	td := TableDef{}
-	v := reflect.Indirect(reflect.ValueOf(st))
-	vt := v.Type()
-	td.Name = vt.Name()
+	td.Name = name
 	td.SQLName = dbFieldName(td.Name)
 	td.Fields = FieldDefs{}
 	fl := ""
@@ -78,27 +115,26 @@ func TableDefFromStruct(st interface{}, QuoterFunc func(string) string) TableDef
 		}
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		if v.Field(i).CanInterface() {
-			//if vt.Field(i).Name != "SQLMapper" {
-			fd := fieldDefFromStructField(vt.Field(i))
-			if fd.PrimaryKey {
-				td.PkIx = len(td.Fields)
-			}
-			td.Fields = append(td.Fields, fd)
-			flk = addField(flk, sqq(fd.SQLName))
-			flka = addField(flka, sqq(fd.SQLName)+" AS "+sqq(fd.Name))
-			plk = addField(plk, "@"+fd.Name)
-			if !fd.AutoIncrement {
-				fl = addField(fl, sqq(fd.SQLName))
-				pl = addField(pl, "@"+fd.Name)
-				if fd.CanUpdate {
-					ul = addField(ul, sqq(fd.SQLName)+" = @"+fd.Name)
-				}
+	for _, fd := range fields {
+		if fd.PrimaryKey {
+			td.PkIx = len(td.Fields)
+		}
+		td.Fields = append(td.Fields, fd)
+		flk = addField(flk, sqq(fd.SQLName))
+		flka = addField(flka, sqq(fd.SQLName)+" AS "+sqq(fd.Name))
+		plk = addField(plk, "@"+fd.Name)
+		if !fd.AutoIncrement {
+			fl = addField(fl, sqq(fd.SQLName))
+			pl = addField(pl, "@"+fd.Name)
+			if fd.CanUpdate {
+				ul = addField(ul, sqq(fd.SQLName)+" = @"+fd.Name)
 			}
-			//}
 		}
 	}
+	if len(td.Fields) == 0 {
+		// Not a valid model, see Validate
+		return td
+	}
 	td.SelectStatement = "select " + flka + " from " + sqq(td.SQLName) + " where " + sqq(td.Fields[td.PkIx].SQLName) + " = @" + td.Fields[td.PkIx].Name
 	td.InsertStatement = "insert into " + sqq(td.SQLName) + " (" + fl + ") values (" + pl + ")"
 	td.UpdateStatement = "update " + sqq(td.SQLName) + " set " + ul + " where " + sqq(td.Fields[td.PkIx].SQLName) + " = @" + td.Fields[td.PkIx].Name
@@ -108,6 +144,95 @@ func TableDefFromStruct(st interface{}, QuoterFunc func(string) string) TableDef
 	return td
 }
 
+// Validate checks that the TableDef describes a usable model
+func (td *TableDef) Validate() error {
+	if len(td.Fields) == 0 {
+		return errors.New("sedi: " + td.Name + " has no exported field")
+	}
+	pk := 0
+	names := make(map[string]string)
+	for _, fd := range td.Fields {
+		if fd.PrimaryKey {
+			pk++
+		}
+		if other, found := names[fd.SQLName]; found {
+			return errors.New("sedi: " + td.Name + "." + fd.Name + " and " + td.Name + "." + other + " map to the same column " + fd.SQLName)
+		}
+		names[fd.SQLName] = fd.Name
+		if fd.AutoIncrement && !fd.PrimaryKey {
+			return errors.New("sedi: " + td.Name + "." + fd.Name + " is auto-increment but is not the primary key")
+		}
+	}
+	if pk == 0 {
+		return errors.New("sedi: " + td.Name + " has no primary key (name a field Id, prefix it with Pk or tag it primaryKey:\"y\")")
+	}
+	if pk > 1 {
+		return errors.New("sedi: " + td.Name + " has more than one primary key field")
+	}
+	return nil
+}
+
+// Copy returns a copy of the TableDef which does not share its fields with td
+func (td TableDef) Copy() TableDef {
+	ret := td
+	ret.Fields = make(FieldDefs, len(td.Fields))
+	copy(ret.Fields, td.Fields)
+	ret.OrphanColumns = nil
+	ret.OrphanIndexes = nil
+	return ret
+}
+
+// StructValues returns the values of the fields of a structure, in the order of td.Fields
+func (td *TableDef) StructValues(st interface{}) []reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(st))
+	ret := make([]reflect.Value, len(td.Fields))
+	for i := range td.Fields {
+		if td.fieldIndex != nil {
+			ret[i] = v.Field(td.fieldIndex[i])
+		} else {
+			ret[i] = v.FieldByName(td.Fields[i].Name)
+		}
+	}
+	return ret
+}
+
+// BySQLName returns the field mapped to a database column, nil if none
+func (fds FieldDefs) BySQLName(name string) *FieldDef {
+	for i := range fds {
+		if fds[i].SQLName == name {
+			return &(fds[i])
+		}
+	}
+	return nil
+}
+
+// HasRenamedFrom tells if a field is the new name of a database column
+func (fds FieldDefs) HasRenamedFrom(name string) bool {
+	for i := range fds {
+		if fds[i].RenamedFrom == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetModelDiff clears the result of a previous comparison with the database
+func (td *TableDef) ResetModelDiff() {
+	td.MustCreate = false
+	td.MustModify = false
+	td.MustRecreate = false
+	td.MustReIndex = false
+	td.OrphanColumns = nil
+	td.OrphanIndexes = nil
+	for i := range td.Fields {
+		fd := &(td.Fields[i])
+		fd.DBFIeldExists = false
+		fd.DBMustRename = false
+		fd.DBTypeOK = false
+		fd.DBIndexOK = false
+	}
+}
+
 func addField(list string, field string) (ret string) {
 	if field != "" {
 		if list != "" {
@@ -121,25 +246,29 @@ func addField(list string, field string) (ret string) {
 	return ret
 }
 
-func fieldDefFromStructField(fld reflect.StructField) FieldDef {
-	fn := fld.Name
-	ft := fld.Type.Name()
+// NewFieldDef creates the FieldDef of a structure field from its name, the name of its type
+// (as given by reflect.Type.Name, "Time" for time.Time) and its tag
+func NewFieldDef(fn string, ft string, tag reflect.StructTag) FieldDef {
 	fd := FieldDef{
 		Name:       fn,
 		SQLName:    dbFieldName(fn),
 		GoTypeName: ft,
-		GoTag:      string(fld.Tag),
+		GoTag:      string(tag),
 		Size:       -1,
-		PrimaryKey: fieldIsPrimaryKey(fld),
-		CanUpdate:  strings.ToLower(fld.Tag.Get("canUpdate")) != "n",
+		PrimaryKey: fieldIsPrimaryKey(fn, tag),
+		CanUpdate:  strings.ToLower(tag.Get("canUpdate")) != "n",
 		//AutoIncrement: (strings.ToLower(fn) == "id" && (strings.HasPrefix(ft, "int") || strings.HasPrefix(ft, "uint"))),
-		AutoIncrement: strings.ToLower(fld.Tag.Get("autoincrement")) == "y",
-		Indexed:       fieldIsForeignKey(fld) || strings.ToLower(fld.Tag.Get("indexed")) == "y",
-		Unique:        strings.ToLower(fld.Tag.Get("unique")) == "y"}
+		AutoIncrement: strings.ToLower(tag.Get("autoincrement")) == "y",
+		Indexed:       fieldIsForeignKey(fn) || strings.ToLower(tag.Get("indexed")) == "y",
+		Unique:        strings.ToLower(tag.Get("unique")) == "y"}
+
+	if rf := tag.Get("renamedFrom"); rf != "" {
+		fd.RenamedFrom = dbFieldName(rf)
+	}
 
 	fs := ""
 	if fd.GoTypeName == "string" {
-		fs = fld.Tag.Get("size")
+		fs = tag.Get("size")
 		if x, err := strconv.ParseInt(fs, 10, 16); err == nil {
 			fd.Size = int16(x)
 		} else {
@@ -150,6 +279,11 @@ func fieldDefFromStructField(fld reflect.StructField) FieldDef {
 	return fd
 }
 
+// DBName returns the database name of a Go structure or field name
+func DBName(goName string) string {
+	return dbFieldName(goName)
+}
+
 func dbFieldName(fn string) string {
 	ora := []rune(fn)
 	fra := make([]rune, 2*len(ora))
@@ -172,12 +306,12 @@ func dbFieldName(fn string) string {
 	return strings.ToLower(string(flc))
 }
 
-func fieldIsPrimaryKey(sf reflect.StructField) bool {
-	f := strings.ToLower(sf.Name)
-	return f == "id" || strings.Index(f, "pk") == 0
+func fieldIsPrimaryKey(fn string, tag reflect.StructTag) bool {
+	f := strings.ToLower(fn)
+	return f == "id" || strings.Index(f, "pk") == 0 || strings.ToLower(tag.Get("primaryKey")) == "y"
 }
 
-func fieldIsForeignKey(sf reflect.StructField) bool {
-	f := strings.ToLower(sf.Name)
+func fieldIsForeignKey(fn string) bool {
+	f := strings.ToLower(fn)
 	return (strings.Index(f, "fk") == 0)
 }
@@ -0,0 +1,133 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// Tx is a transaction started with Conn.Begin. Its statements run on a single
+// connection of the pool of the primary, never on a replica.
+// A Tx must not be used by several go routines at the same time.
+type Tx struct {
+	cn *Conn
+	tx *sql.Tx
+}
+
+// ErrTxDone is returned when a transaction is used after Commit or Rollback
+var ErrTxDone = errors.New("Transaction has already been committed or rolled back")
+
+// Begin starts a transaction on the primary database
+func (cn *Conn) Begin() (*Tx, error) {
+	if cn == nil || cn.DB == nil {
+		return nil, errors.New("Begin:" + ErrNoConnection.Error())
+	}
+	if LogAll {
+		log.Print("begin")
+	}
+	tx, err := cn.DB.Begin()
+	if err != nil {
+		if LogErrors {
+			log.Print(err.Error())
+		}
+		return nil, errors.New("Begin:" + err.Error())
+	}
+	return &Tx{cn: cn, tx: tx}, nil
+}
+
+// Conn returns the connection of the transaction
+func (tx *Tx) Conn() *Conn {
+	return tx.cn
+}
+
+// SQLTx returns the database/sql transaction, nil after Commit or Rollback
+func (tx *Tx) SQLTx() *sql.Tx {
+	return tx.tx
+}
+
+func (tx *Tx) check(fname string) error {
+	if tx == nil || tx.tx == nil {
+		return errors.New(fname + ":" + ErrTxDone.Error())
+	}
+	return nil
+}
+
+// Exec executes a statement in the transaction
+func (tx *Tx) Exec(query string, parms SQLParms) (sql.Result, error) {
+	if err := tx.check("Exec"); err != nil {
+		return nil, err
+	}
+	return tx.cn.exec(tx.tx, tx.cn.prepare(query, parms), nil)
+}
+
+// ExecArgs executes a statement in the transaction using the placeholders of the driver
+func (tx *Tx) ExecArgs(query string, args ...interface{}) (sql.Result, error) {
+	if err := tx.check("Exec"); err != nil {
+		return nil, err
+	}
+	return tx.cn.exec(tx.tx, query, args)
+}
+
+// GetDataTable executes a SELECT statement in the transaction and returns the result in a datatable
+func (tx *Tx) GetDataTable(query string, parms SQLParms) (DataTable, error) {
+	if err := tx.check("GetDataTable"); err != nil {
+		return DataTable{}, err
+	}
+	return tx.cn.query(tx.tx, "GetDataTable", tx.cn.prepare(query, parms), nil, -1)
+}
+
+// GetDataTableArgs executes a SELECT statement in the transaction using the placeholders of the driver
+func (tx *Tx) GetDataTableArgs(query string, args ...interface{}) (DataTable, error) {
+	if err := tx.check("GetDataTable"); err != nil {
+		return DataTable{}, err
+	}
+	return tx.cn.query(tx.tx, "GetDataTable", query, args, -1)
+}
+
+// GetSingleRowArgs returns the first row of a SELECT statement run in the transaction, ErrNoData if there is none
+func (tx *Tx) GetSingleRowArgs(query string, args ...interface{}) (DataRow, error) {
+	if err := tx.check("GetSingleRow"); err != nil {
+		return DataRow{}, err
+	}
+	return tx.cn.singleRow(tx.tx, query, args)
+}
+
+// Commit commits the transaction
+func (tx *Tx) Commit() error {
+	return tx.end("Commit", true)
+}
+
+// Rollback cancels the transaction. It does nothing after Commit or Rollback,
+// so that it can be deferred.
+func (tx *Tx) Rollback() error {
+	if tx == nil || tx.tx == nil {
+		return nil
+	}
+	return tx.end("Rollback", false)
+}
+
+func (tx *Tx) end(fname string, commit bool) error {
+	if err := tx.check(fname); err != nil {
+		return err
+	}
+	var err error
+	if commit {
+		err = tx.tx.Commit()
+	} else {
+		err = tx.tx.Rollback()
+	}
+	tx.tx = nil
+	tx.cn.replicas.wrote()
+	if err != nil {
+		if LogErrors {
+			log.Print(err.Error())
+		}
+		return errors.New(fname + ":" + err.Error())
+	}
+	return nil
+}
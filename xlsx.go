@@ -0,0 +1,634 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// XLSXSheet is a worksheet of an Excel workbook
+type XLSXSheet struct {
+	Name  string // Sheet1, Sheet2... by default
+	Table *DataTable
+	// NoHeader leaves out the header line with the column names. It is written in bold with
+	// a gray background, and stays visible when scrolling.
+	NoHeader bool
+	// ColumnWidths holds the width of the columns in characters. Columns without width,
+	// or with a width of 0, get the width of their content.
+	ColumnWidths []float64
+}
+
+// Styles of the cells, positions in the cellXfs of xlsxStyles
+const (
+	xlsxStyleHeader   = 1
+	xlsxStyleDate     = 2
+	xlsxStyleDateTime = 3
+)
+
+const xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+const xlsxRelationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="` + xlsxMain + `">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9D9D9"/><bgColor indexed="64"/></patternFill></fill></fills>
+<borders count="2"><border><left/><right/><top/><bottom/><diagonal/></border>` +
+	`<border><left/><right/><top/><bottom style="thin"><color auto="1"/></bottom><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="1" xfId="0" applyFont="1" applyFill="1" applyBorder="1"/>` +
+	`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// WriteXLSX writes the datatable as an Excel workbook with a single sheet
+func (dt *DataTable) WriteXLSX(w io.Writer, sheetName string) error {
+	return WriteWorkbook(w, XLSXSheet{Name: sheetName, Table: dt})
+}
+
+// SaveToXLSX saves the datatable to an Excel file with a single sheet
+func (dt *DataTable) SaveToXLSX(filename string, sheetName string) error {
+	return writeFile(filename, func(w io.Writer) error { return dt.WriteXLSX(w, sheetName) })
+}
+
+// WriteWorkbook writes an Excel workbook with a sheet for each DataTable.
+// Numbers, booleans and times are written as typed cells, NULL as empty cells and
+// []byte in base64. Times are written with their wall clock, Excel has no time zones;
+// times before 1900 are written as text.
+func WriteWorkbook(w io.Writer, sheets ...XLSXSheet) error {
+	if len(sheets) == 0 {
+		return errors.New("WriteWorkbook: no sheet")
+	}
+	names, found := make([]string, len(sheets)), map[string]bool{}
+	for i := range sheets {
+		if sheets[i].Table == nil {
+			return errors.New("WriteWorkbook: no table for sheet " + strconv.Itoa(i+1))
+		}
+		name, err := xlsxSheetName(sheets[i].Name, i)
+		if err != nil {
+			return errors.New("WriteWorkbook:" + err.Error())
+		}
+		if found[strings.ToLower(name)] {
+			return errors.New("WriteWorkbook: duplicate sheet name " + name)
+		}
+		found[strings.ToLower(name)] = true
+		names[i] = name
+	}
+
+	z := zip.NewWriter(w)
+	var ct, wb, rels bytes.Buffer
+	ct.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	wb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<workbook xmlns="` + xlsxMain + `" xmlns:r="` + xlsxRelationships + `"><sheets>`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := range sheets {
+		n := strconv.Itoa(i + 1)
+		ct.WriteString(`<Override PartName="/xl/worksheets/sheet` + n + `.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`)
+		wb.WriteString(`<sheet name="` + xmlEscape(names[i]) + `" sheetId="` + n + `" r:id="rId` + n + `"/>`)
+		rels.WriteString(`<Relationship Id="rId` + n + `" Type="` + xlsxRelationships + `/worksheet" Target="worksheets/sheet` + n + `.xml"/>`)
+	}
+	ct.WriteString(`</Types>`)
+	wb.WriteString(`</sheets></workbook>`)
+	rels.WriteString(`<Relationship Id="rId` + strconv.Itoa(len(sheets)+1) + `" Type="` + xlsxRelationships +
+		`/styles" Target="styles.xml"/></Relationships>`)
+
+	parts := []struct {
+		name string
+		data []byte
+	}{
+		{"[Content_Types].xml", ct.Bytes()},
+		{"_rels/.rels", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + xlsxRelationships + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`)},
+		{"xl/workbook.xml", wb.Bytes()},
+		{"xl/_rels/workbook.xml.rels", rels.Bytes()},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+	}
+	for _, p := range parts {
+		f, err := z.Create(p.name)
+		if err == nil {
+			_, err = f.Write(p.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := range sheets {
+		f, err := z.Create("xl/worksheets/sheet" + strconv.Itoa(i+1) + ".xml")
+		if err != nil {
+			return err
+		}
+		if err = writeXLSXSheet(f, &sheets[i]); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// SaveWorkbook saves an Excel workbook with a sheet for each DataTable
+func SaveWorkbook(filename string, sheets ...XLSXSheet) error {
+	return writeFile(filename, func(w io.Writer) error { return WriteWorkbook(w, sheets...) })
+}
+
+// xlsxSheetName checks a sheet name, giving a default name to the nth sheet
+func xlsxSheetName(name string, n int) (string, error) {
+	if name == "" {
+		return "Sheet" + strconv.Itoa(n+1), nil
+	}
+	if utf8.RuneCountInString(name) > 31 || strings.ContainsAny(name, `[]:*?/\`) || strings.HasPrefix(name, "'") {
+		return "", errors.New(" invalid sheet name " + name)
+	}
+	return name, nil
+}
+
+func writeXLSXSheet(w io.Writer, sh *XLSXSheet) error {
+	dt := sh.Table
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" + `<worksheet xmlns="` + xlsxMain + `">`)
+	if !sh.NoHeader {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	if len(dt.Columns) > 0 {
+		b.WriteString(`<cols>`)
+		for c := range dt.Columns {
+			n := strconv.Itoa(c + 1)
+			b.WriteString(`<col min="` + n + `" max="` + n + `" width="` + strconv.FormatFloat(xlsxWidth(sh, c), 'f', -1, 64) + `" customWidth="1"/>`)
+		}
+		b.WriteString(`</cols>`)
+	}
+	b.WriteString(`<sheetData>`)
+	row := 1
+	if !sh.NoHeader {
+		b.WriteString(`<row r="1">`)
+		for c := range dt.Columns {
+			b.WriteString(`<c r="` + xlsxCellRef(c, 1) + `" s="` + strconv.Itoa(xlsxStyleHeader) + `" t="inlineStr"><is><t xml:space="preserve">` +
+				xmlEscape(dt.Columns[c].Name) + `</t></is></c>`)
+		}
+		b.WriteString(`</row>`)
+		row++
+	}
+	for r := range dt.Rows {
+		if dt.Rows[r].state == RowDeleted {
+			continue
+		}
+		b.WriteString(`<row r="` + strconv.Itoa(row) + `">`)
+		for c, v := range dt.Rows[r].items {
+			writeXLSXCell(&b, xlsxCellRef(c, row), v)
+		}
+		b.WriteString(`</row>`)
+		row++
+		if b.Len() > 64*1024 {
+			if _, err := w.Write(b.Bytes()); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeXLSXCell(b *bytes.Buffer, ref string, v interface{}) {
+	text := ""
+	switch x := v.(type) {
+	case nil:
+		return
+	case int64:
+		b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(x, 10) + `</v></c>`)
+		return
+	case float64:
+		if !math.IsNaN(x) && !math.IsInf(x, 0) {
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatFloat(x, 'g', -1, 64) + `</v></c>`)
+			return
+		}
+		text = strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		s := "0"
+		if x {
+			s = "1"
+		}
+		b.WriteString(`<c r="` + ref + `" t="b"><v>` + s + `</v></c>`)
+		return
+	case time.Time:
+		if x.Year() >= 1900 {
+			style := xlsxStyleDateTime
+			if x.Hour() == 0 && x.Minute() == 0 && x.Second() == 0 && x.Nanosecond() == 0 {
+				style = xlsxStyleDate
+			}
+			b.WriteString(`<c r="` + ref + `" s="` + strconv.Itoa(style) + `"><v>` + strconv.FormatFloat(excelSerial(x), 'f', -1, 64) + `</v></c>`)
+			return
+		}
+		text = x.Format(time.RFC3339Nano)
+	case []byte:
+		text = base64.StdEncoding.EncodeToString(x)
+	case string:
+		text = x
+	default:
+		text = fmt.Sprint(v)
+	}
+	b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(text) + `</t></is></c>`)
+}
+
+// xlsxWidth returns the width of a column: the width given in the sheet, or the width of its content
+func xlsxWidth(sh *XLSXSheet, c int) float64 {
+	if c < len(sh.ColumnWidths) && sh.ColumnWidths[c] > 0 {
+		return sh.ColumnWidths[c]
+	}
+	dt := sh.Table
+	n := 0
+	if !sh.NoHeader {
+		n = utf8.RuneCountInString(dt.Columns[c].Name)
+	}
+	for r := range dt.Rows {
+		l := 0
+		switch x := dt.Rows[r].items[c].(type) {
+		case time.Time:
+			l = 19
+		case string:
+			l = utf8.RuneCountInString(x)
+		case nil:
+		default:
+			l = len(csvString(x, &CSVOptions{}))
+		}
+		if l > n {
+			n = l
+		}
+		if n >= 60 {
+			return 60
+		}
+	}
+	if n < 8 {
+		n = 8
+	}
+	return float64(n + 2)
+}
+
+// xlsxCellRef returns the reference of a cell, A1 for column 0 and row 1
+func xlsxCellRef(col int, row int) string {
+	s := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		s = string(rune('A'+(col-1)%26)) + s
+	}
+	return s + strconv.Itoa(row)
+}
+
+// xlsxColumn returns the column of a cell reference, 0 for A1, -1 if invalid
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A') + 1
+	}
+	return col - 1
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+var excelEpoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// excelSerial returns the Excel date number of the wall clock of a time
+func excelSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	d := wall.Sub(excelEpoch)
+	return float64(d/(24*time.Hour)) + float64(d%(24*time.Hour))/float64(24*time.Hour)
+}
+
+// excelTime returns the time of an Excel date number, in UTC and rounded to the millisecond
+func excelTime(serial float64, date1904 bool) time.Time {
+	epoch := excelEpoch
+	if date1904 {
+		epoch = excelEpoch1904
+	}
+	days := math.Floor(serial)
+	ms := math.Round((serial - days) * 86400000)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+}
+
+// ReadXLSX fills the datatable with a sheet of an Excel workbook, the first sheet if sheetName is "".
+// The first row holds the column names. The type of each column is the type of its cells:
+// int64 for whole numbers, float64, bool, time.Time for numbers with a date format, or string.
+// Columns mixing types keep the value of each cell and have no type.
+func (dt *DataTable) ReadXLSX(r io.Reader, sheetName string) error {
+	sheets, err := ReadWorkbook(r)
+	if err != nil {
+		return err
+	}
+	for _, sh := range sheets {
+		if sheetName == "" || sh.Name == sheetName {
+			*dt = *sh.Table
+			dt.refreshColmap()
+			for i := range dt.Rows {
+				dt.Rows[i].dt = dt
+			}
+			return nil
+		}
+	}
+	return errors.New("ReadXLSX: no sheet " + sheetName)
+}
+
+// LoadFromXLSX fills the datatable from a sheet of an Excel file
+func (dt *DataTable) LoadFromXLSX(filename string, sheetName string) error {
+	return readFile(filename, func(r io.Reader) error { return dt.ReadXLSX(r, sheetName) })
+}
+
+// xlsxText is a string of the workbook, plain or with formatting runs
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (x *xlsxText) String() string {
+	s := x.T
+	for _, r := range x.Runs {
+		s += r.T
+	}
+	return s
+}
+
+type xlsxCell struct {
+	Ref   string    `xml:"r,attr"`
+	Type  string    `xml:"t,attr"`
+	Style int       `xml:"s,attr"`
+	Value string    `xml:"v"`
+	Is    *xlsxText `xml:"is"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ReadWorkbook reads all the sheets of an Excel workbook, see DataTable.ReadXLSX
+func ReadWorkbook(r io.Reader) ([]XLSXSheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("ReadWorkbook:" + err.Error())
+	}
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	readXML := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return errors.New("ReadWorkbook: missing " + name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if err = xml.NewDecoder(rc).Decode(v); err != nil {
+			return errors.New("ReadWorkbook: " + name + ": " + err.Error())
+		}
+		return nil
+	}
+
+	var wb struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err = readXML("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err = readXML("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err = readXML("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+	}
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if _, ok := files["xl/styles.xml"]; ok {
+		if err = readXML("xl/styles.xml", &styles); err != nil {
+			return nil, err
+		}
+	}
+	dateFormats := map[int]bool{}
+	for _, f := range styles.NumFmts {
+		dateFormats[f.ID] = isDateFormat(f.Code)
+	}
+	dateStyles := make([]bool, len(styles.Xfs))
+	for i, x := range styles.Xfs {
+		dateStyles[i] = x.NumFmtID >= 14 && x.NumFmtID <= 22 || x.NumFmtID >= 45 && x.NumFmtID <= 47 || dateFormats[x.NumFmtID]
+	}
+	date1904 := wb.Pr.Date1904 == "1" || wb.Pr.Date1904 == "true"
+
+	ret := []XLSXSheet{}
+	for _, s := range wb.Sheets {
+		target := ""
+		for _, rel := range rels.Items {
+			if rel.ID == s.ID {
+				target = rel.Target
+			}
+		}
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		var ws xlsxWorksheet
+		if err = readXML(target, &ws); err != nil {
+			return nil, err
+		}
+		values := [][]interface{}{}
+		for _, row := range ws.Rows {
+			line := []interface{}{}
+			for i, c := range row.Cells {
+				col := i
+				if c.Ref != "" {
+					col = xlsxColumn(c.Ref)
+				}
+				if col < 0 {
+					return nil, errors.New("ReadWorkbook: invalid cell reference " + c.Ref)
+				}
+				for len(line) <= col {
+					line = append(line, nil)
+				}
+				v, err := xlsxValue(&c, sst.Items, c.Style < len(dateStyles) && dateStyles[c.Style], date1904)
+				if err != nil {
+					return nil, fmt.Errorf("ReadWorkbook: sheet %s, cell %s: %s", s.Name, c.Ref, err.Error())
+				}
+				line[col] = v
+			}
+			values = append(values, line)
+		}
+		ret = append(ret, XLSXSheet{Name: s.Name, Table: xlsxTable(values)})
+	}
+	return ret, nil
+}
+
+// xlsxValue returns the value of a cell
+func xlsxValue(c *xlsxCell, sst []xlsxText, date bool, date1904 bool) (interface{}, error) {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(sst) {
+			return nil, errors.New("invalid shared string " + c.Value)
+		}
+		return sst[i].String(), nil
+	case "inlineStr":
+		if c.Is == nil {
+			return "", nil
+		}
+		return c.Is.String(), nil
+	case "str", "e":
+		return c.Value, nil
+	case "b":
+		return c.Value == "1" || c.Value == "true", nil
+	case "d":
+		return parseTime(c.Value)
+	}
+	if c.Value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(c.Value, 64)
+	if err != nil {
+		return nil, err
+	}
+	if date {
+		return excelTime(f, date1904), nil
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), nil
+	}
+	return f, nil
+}
+
+// isDateFormat tells if a number format code displays dates or times
+func isDateFormat(code string) bool {
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case inBracket:
+		case strings.ContainsRune("ymdhs", r):
+			return true
+		}
+	}
+	return false
+}
+
+// xlsxTable builds a DataTable from the values of a sheet, the first row giving the column names
+func xlsxTable(values [][]interface{}) *DataTable {
+	dt := &DataTable{}
+	dt.Clear()
+	if len(values) == 0 {
+		return dt
+	}
+	width := 0
+	for _, line := range values {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	for c := 0; c < width; c++ {
+		name := ""
+		if c < len(values[0]) && values[0][c] != nil {
+			name = fmt.Sprint(values[0][c])
+		}
+		if name == "" {
+			name = "Column" + strconv.Itoa(c+1)
+		}
+		typ := ""
+		for _, line := range values[1:] {
+			if c >= len(line) || line[c] == nil {
+				continue
+			}
+			switch t := valueType(line[c]); {
+			case typ == "" || typ == t:
+				typ = t
+			case typ == TypeInt64 && t == TypeFloat64 || typ == TypeFloat64 && t == TypeInt64:
+				typ = TypeFloat64
+			default:
+				typ = "mixed"
+			}
+		}
+		if typ == "mixed" {
+			typ = ""
+		}
+		dt.Columns = append(dt.Columns, DataColumn{Name: name, GoType: typ, Nullable: true})
+	}
+	dt.initColumns()
+	for _, line := range values[1:] {
+		dr := dt.NewRow()
+		for c := range line {
+			v := line[c]
+			if x, ok := v.(int64); ok && dt.Columns[c].GoType == TypeFloat64 {
+				v = float64(x)
+			}
+			dr.items[c] = v
+		}
+		dr.state = RowUnchanged
+		dt.AddRow(dr)
+	}
+	return dt
+}
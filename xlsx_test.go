@@ -0,0 +1,166 @@
+// Copyright (C) 2016-2017 Stephane Potelle <stephane.potelle@gmail.com>.
+//
+// Use of me source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sedi
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// xlsxTestTable returns a table whose values are kept by a workbook
+func xlsxTestTable() *DataTable {
+	dt := &DataTable{Columns: []DataColumn{
+		{Name: "name", GoType: TypeString},
+		{Name: "qty", GoType: TypeInt64},
+		{Name: "rate", GoType: TypeFloat64},
+		{Name: "ok", GoType: TypeBool},
+		{Name: "day", GoType: TypeTime},
+		{Name: "at", GoType: TypeTime},
+	}}
+	rows := [][]interface{}{
+		{"<a & \"b\">", int64(-3), 2.5, true, time.Date(2017, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2017, 3, 4, 5, 6, 7, 8e6, time.UTC)},
+		{" é ", nil, 3.0, false, nil, time.Date(1900, 3, 1, 23, 59, 59, 0, time.UTC)},
+		{nil, int64(1) << 40, nil, nil, time.Date(2100, 12, 31, 0, 0, 0, 0, time.UTC), nil},
+	}
+	for _, r := range rows {
+		dr := dt.NewRow()
+		copy(dr.items, r)
+		dt.AddRow(dr)
+	}
+	dt.AcceptChanges()
+	return dt
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	dt := xlsxTestTable()
+	var b bytes.Buffer
+	if err := dt.WriteXLSX(&b, "Data"); err != nil {
+		t.Fatal(err)
+	}
+	var got DataTable
+	if err := got.ReadXLSX(bytes.NewReader(b.Bytes()), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.ColumnNames(), dt.ColumnNames()) {
+		t.Errorf("columns %v", got.ColumnNames())
+	}
+	for c := range dt.Columns {
+		if got.Columns[c].GoType != dt.Columns[c].GoType {
+			t.Errorf("column %s is %s", got.Columns[c].Name, got.Columns[c].GoType)
+		}
+	}
+	sameRows(t, &got, dt)
+	if v, _ := got.Rows[0].GetFloat64("rate"); v != 2.5 {
+		t.Errorf("rate %v", v)
+	}
+	if got.ColumnIndex("ok") != 3 {
+		t.Error("column map not set")
+	}
+}
+
+func TestXLSXText(t *testing.T) {
+	// Values without cell type are written as text
+	dt := &DataTable{Columns: []DataColumn{{Name: "v"}}}
+	for _, v := range []interface{}{[]byte{1, 2}, time.Date(1850, 1, 2, 0, 0, 0, 0, time.UTC), math.NaN(), int64(4)} {
+		dr := dt.NewRow()
+		dr.items[0] = v
+		dt.AddRow(dr)
+	}
+	var b bytes.Buffer
+	if err := dt.WriteXLSX(&b, ""); err != nil {
+		t.Fatal(err)
+	}
+	var got DataTable
+	if err := got.ReadXLSX(&b, "Sheet1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"AQI=", "1850-01-02T00:00:00Z", "NaN", int64(4)}
+	for r, w := range want {
+		if got.Rows[r].items[0] != w {
+			t.Errorf("row %d: %#v, want %#v", r, got.Rows[r].items[0], w)
+		}
+	}
+	if got.Columns[0].GoType != "" {
+		t.Errorf("mixed column has type %s", got.Columns[0].GoType)
+	}
+}
+
+func TestWorkbook(t *testing.T) {
+	a, b := xlsxTestTable(), &DataTable{Columns: []DataColumn{{Name: "x", GoType: TypeInt64}}}
+	dr := b.NewRow()
+	dr.items[0] = int64(1)
+	b.AddRow(dr)
+	fn := filepath.Join(t.TempDir(), "book.xlsx")
+	if err := SaveWorkbook(fn, XLSXSheet{Table: a, ColumnWidths: []float64{20}}, XLSXSheet{Name: "B & co", Table: b, NoHeader: true}); err != nil {
+		t.Fatal(err)
+	}
+	var got DataTable
+	if err := got.LoadFromXLSX(fn, "B & co"); err != nil {
+		t.Fatal(err)
+	}
+	// Without header, the first row is taken as the column names
+	if got.ColumnNames()[0] != "1" || len(got.Rows) != 0 {
+		t.Errorf("sheet B: %v, %d rows", got.ColumnNames(), len(got.Rows))
+	}
+	if err := got.LoadFromXLSX(fn, "Sheet1"); err != nil || len(got.Rows) != 3 {
+		t.Errorf("Sheet1: %d rows, %v", len(got.Rows), err)
+	}
+	if err := got.LoadFromXLSX(fn, "nope"); err == nil {
+		t.Error("missing sheet")
+	}
+
+	var w bytes.Buffer
+	for _, sheets := range [][]XLSXSheet{
+		{},
+		{{Name: "a"}},
+		{{Name: "a", Table: a}, {Name: "A", Table: b}},
+		{{Name: "a/b", Table: a}},
+		{{Name: "0123456789012345678901234567890123", Table: a}},
+	} {
+		if err := WriteWorkbook(&w, sheets...); err == nil {
+			t.Errorf("no error for %+v", sheets)
+		}
+	}
+	if err := got.ReadXLSX(bytes.NewReader([]byte("not a zip")), ""); err == nil {
+		t.Error("invalid file")
+	}
+}
+
+func TestXLSXCellRef(t *testing.T) {
+	for col, ref := range map[int]string{0: "A1", 25: "Z1", 26: "AA1", 701: "ZZ1", 702: "AAA1"} {
+		if got := xlsxCellRef(col, 1); got != ref {
+			t.Errorf("xlsxCellRef(%d) = %s, want %s", col, got, ref)
+		}
+		if got := xlsxColumn(ref); got != col {
+			t.Errorf("xlsxColumn(%s) = %d, want %d", ref, got, col)
+		}
+	}
+	if xlsxColumn("1") != -1 {
+		t.Error("invalid reference")
+	}
+}
+
+func TestExcelSerial(t *testing.T) {
+	tm := time.Date(2017, 3, 4, 12, 0, 0, 0, time.FixedZone("X", -3600))
+	if s := excelSerial(tm); s != 42798.5 {
+		t.Errorf("excelSerial = %v", s)
+	}
+	if got := excelTime(42798.5, false); !got.Equal(time.Date(2017, 3, 4, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("excelTime = %v", got)
+	}
+	if got := excelTime(0, true); !got.Equal(excelEpoch1904) {
+		t.Errorf("excelTime 1904 = %v", got)
+	}
+	for code, want := range map[string]bool{"yyyy-mm-dd": true, "h:mm": true, "0.00": false, `"day"0`: false, "[Red]0": false, "General": false} {
+		if isDateFormat(code) != want {
+			t.Errorf("isDateFormat(%s) = %v", code, !want)
+		}
+	}
+}